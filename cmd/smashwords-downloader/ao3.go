@@ -0,0 +1,472 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/epub2text"
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"golang.org/x/net/html"
+)
+
+const (
+	ao3URL string = "https://archiveofourown.org"
+	// how many times a request turned down with 429 Too Many Requests is
+	// tried again, after waiting as long as AO3 asks
+	ao3MaxRetries int = 5
+	// how long to wait after a 429 that doesn't say
+	ao3DefaultRetryAfter time.Duration = time.Minute
+)
+
+// the formats AO3 exports works in that can be converted
+var ao3Formats = map[string]bool{"epub": true, "html": true}
+
+var ao3WorkRegex = regexp.MustCompile(`(?:^|/works/)(\d+)(?:[/?#]|$)`)
+
+// AO3WorkID returns the id of a work from its url or the id itself, or "".
+func AO3WorkID(work string) string {
+	if m := ao3WorkRegex.FindStringSubmatch(strings.TrimSpace(work)); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// MakeAO3DocumentID returns the stable id of a work of AO3 in a format, like
+// ao3-123456-epub.
+func MakeAO3DocumentID(workID string, format string) string {
+	return fmt.Sprintf("ao3-%s-%s", workID, format)
+}
+
+// findElements returns the elements under n that match, in document order.
+func findElements(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			found = append(found, c)
+		}
+		found = append(found, findElements(c, match)...)
+	}
+	return found
+}
+
+// tagged returns a matcher of the elements with a tag and a class.
+func tagged(tag string, class string) func(*html.Node) bool {
+	return func(n *html.Node) bool {
+		return n.Data == tag && (class == "" || hasClass(n, []string{class}))
+	}
+}
+
+// nodeAttr returns the value of an attribute of an element, or "".
+func nodeAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// ao3Work is a work page of AO3: what it says about the work, and the urls
+// of its downloads by format.
+type ao3Work struct {
+	Book      pipeline.BookInfo
+	Downloads map[string]string
+}
+
+// parseAO3Work reads a work page.
+func parseAO3Work(workID string, doc *html.Node, base *url.URL) (*ao3Work, error) {
+	info := &pipeline.AO3Info{WorkID: workID}
+	work := &ao3Work{
+		Book: pipeline.BookInfo{
+			URL: base.ResolveReference(&url.URL{Path: "/works/" + workID}).String(),
+			AO3: info,
+		},
+		Downloads: make(map[string]string),
+	}
+	if titles := findElements(doc, tagged("h2", "title")); len(titles) > 0 {
+		work.Book.Title = nodeText(titles[0])
+	}
+	var authors []string
+	for _, a := range findElements(doc, func(n *html.Node) bool { return n.Data == "a" && nodeAttr(n, "rel") == "author" }) {
+		authors = append(authors, nodeText(a))
+	}
+	work.Book.Author = strings.Join(authors, ", ")
+
+	// the metadata is a list of dd, each with the class of what it is
+	metas := findElements(doc, tagged("dl", "meta"))
+	if len(metas) == 0 {
+		return nil, fmt.Errorf("work %s has no metadata, is it a work page?", workID)
+	}
+	tags := func(class string) []string {
+		var names []string
+		for _, dd := range findElements(metas[0], tagged("dd", class)) {
+			for _, a := range findElements(dd, tagged("a", "tag")) {
+				names = append(names, nodeText(a))
+			}
+		}
+		return names
+	}
+	text := func(class string) string {
+		if dds := findElements(metas[0], tagged("dd", class)); len(dds) > 0 {
+			return nodeText(dds[0])
+		}
+		return ""
+	}
+	if ratings := tags("rating"); len(ratings) > 0 {
+		info.Rating = ratings[0]
+	}
+	info.Warnings = tags("warning")
+	info.Categories = tags("category")
+	info.Fandoms = tags("fandom")
+	info.Relationships = tags("relationship")
+	info.Characters = tags("character")
+	info.Tags = tags("freeform")
+	info.Language = text("language")
+	info.Chapters = text("chapters")
+	work.Book.Published = text("published")
+	// the fandoms are what the work is listed under
+	work.Book.Categories = info.Fandoms
+
+	for _, download := range findElements(doc, tagged("li", "download")) {
+		for _, a := range findElements(download, tagged("a", "")) {
+			ref, err := base.Parse(nodeAttr(a, "href"))
+			if err != nil || !strings.Contains(ref.Path, "/downloads/") {
+				continue
+			}
+			format := strings.TrimPrefix(path.Ext(ref.Path), ".")
+			if _, ok := work.Downloads[format]; !ok {
+				work.Downloads[format] = ref.String()
+			}
+		}
+	}
+	return work, nil
+}
+
+// ao3Client gets pages and downloads of AO3, keeping its requests apart and
+// waiting out its rate limits.
+type ao3Client struct {
+	base  *url.URL
+	delay time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// wait keeps the requests at least delay apart, or returns the error of ctx
+// if it is done first.
+func (c *ao3Client) wait(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if wait := c.delay - time.Since(c.last); wait > 0 {
+		sleep(ctx, wait)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	c.last = time.Now()
+	return nil
+}
+
+// get gets a page of AO3, waiting as long as AO3 says and trying again when
+// it turns the request down for coming too fast.
+func (c *ao3Client) get(ctx context.Context, location string) (*http.Response, error) {
+	for retries := 0; ; retries++ {
+		if err := c.wait(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := httpGet(ctx, location)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && retries < ao3MaxRetries {
+			resp.Body.Close()
+			retryAfter := ao3DefaultRetryAfter
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				retryAfter = time.Duration(seconds) * time.Second
+			}
+			slog.Info("AO3 asked to slow down", "url", location, "wait", retryAfter)
+			sleep(ctx, retryAfter)
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("getting %s: %s", location, resp.Status)
+		}
+		// works only for logged in users redirect to the login page
+		if resp.Request.URL.Path == "/users/login" {
+			resp.Body.Close()
+			return nil, fmt.Errorf("getting %s: only for logged in users", location)
+		}
+		return resp, nil
+	}
+}
+
+func (c *ao3Client) getPage(ctx context.Context, location string) (*html.Node, error) {
+	resp, err := c.get(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	doc, err := html.Parse(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", location, err)
+	}
+	return doc, nil
+}
+
+// Search returns the ids of the works listed on a tag, fandom or search page
+// of AO3 and the pages after it, up to limit of them if it isn't 0.
+func (c *ao3Client) Search(ctx context.Context, location string, limit int) ([]string, error) {
+	var ids []string
+	seen := make(map[string]bool)
+	for next := location; next != "" && !seen[next]; {
+		seen[next] = true
+		base, err := url.Parse(next)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := c.getPage(ctx, next)
+		if err != nil {
+			return nil, err
+		}
+		for _, li := range findElements(doc, tagged("li", "blurb")) {
+			if id := strings.TrimPrefix(nodeAttr(li, "id"), "work_"); id != nodeAttr(li, "id") {
+				ids = append(ids, id)
+				if limit > 0 && len(ids) == limit {
+					return ids, nil
+				}
+			}
+		}
+		next = ""
+		for _, a := range findElements(doc, func(n *html.Node) bool { return n.Data == "a" && nodeAttr(n, "rel") == "next" }) {
+			if ref, err := base.Parse(nodeAttr(a, "href")); err == nil {
+				next = ref.String()
+			}
+		}
+	}
+	return ids, nil
+}
+
+// Work gets the work page of a work, with the works for adults shown without
+// asking.
+func (c *ao3Client) Work(ctx context.Context, workID string) (*ao3Work, error) {
+	location := c.base.ResolveReference(&url.URL{Path: "/works/" + workID, RawQuery: "view_adult=true"}).String()
+	doc, err := c.getPage(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	return parseAO3Work(workID, doc, c.base)
+}
+
+// ao3ExportText returns the text of the chapters of the HTML export of a
+// work, without the preface and afterword AO3 puts the metadata in.
+func ao3ExportText(export string, opts pipeline.ConvertOptions) (string, map[string]bool, error) {
+	doc, err := html.Parse(strings.NewReader(export))
+	if err != nil {
+		return "", nil, err
+	}
+	chapters := findElements(doc, func(n *html.Node) bool { return nodeAttr(n, "id") == "chapters" })
+	if len(chapters) == 0 {
+		return "", nil, fmt.Errorf("the export has no chapters")
+	}
+	var sb strings.Builder
+	if err := html.Render(&sb, chapters[0]); err != nil {
+		return "", nil, err
+	}
+	epubOpts := opts.EpubOptions("", "")
+	epubOpts.Images = pipeline.ImagesDrop
+	converted, err := epub2text.ConvertHTML(strings.NewReader(sb.String()), epubOpts)
+	if err != nil {
+		return "", nil, err
+	}
+	return converted.Text, pipeline.HeadingKeys(converted.Headings), nil
+}
+
+// download downloads the export of a work in a format and converts it:
+// epubs once they are all downloaded, like Smashwords epubs, and HTML right
+// away. A work ctx stopped is left for the next run.
+func (c *ao3Client) download(ctx context.Context, workID string, format string, dataDir string, manifest *pipeline.Manifest, sink pipeline.Sink, opts pipeline.ConvertOptions) {
+	docID := MakeAO3DocumentID(workID, format)
+	textFileName := docID + ".txt"
+	fileName := docID + "." + format
+	if manifest.Get(textFileName) != nil {
+		opts.Report.Skip(workID, textFileName, "exists: "+format)
+		return
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, fileName)); err == nil {
+		opts.Report.Skip(workID, fileName, "exists: "+format)
+		return
+	}
+
+	work, err := c.Work(ctx, workID)
+	if ctx.Err() != nil {
+		return
+	} else if err != nil {
+		pipeline.BookLogger(docID, "", pipeline.LogPhaseDownload).Warn("Could not get work", "work", workID, "error", err)
+		opts.Report.Fail(workID, fileName, "metadata", err.Error())
+		if err := manifest.LogError(fileName, "metadata", err.Error()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	info := work.Book
+	link := work.Downloads[format]
+	if link == "" {
+		pipeline.BookLogger(docID, info.URL, pipeline.LogPhaseDownload).Debug("Skipping work without a download in the format",
+			"title", info.Title, "format", format)
+		opts.Report.Skip(info.Title, fileName, "no "+format)
+		return
+	}
+
+	partialFilePath := filepath.Join(dataDir, fileName+".part")
+	err = downloadFile(ctx, c.get, link, partialFilePath)
+	if err == nil && format == "epub" {
+		err = pipeline.ValidateEpub(partialFilePath)
+	}
+	if err != nil {
+		os.Remove(partialFilePath)
+		if ctx.Err() != nil {
+			return
+		}
+		pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Warn("Could not download work", "title", info.Title, "error", err)
+		opts.Report.Fail(info.Title, fileName, "download", err.Error())
+		if err := manifest.LogError(fileName, "download", err.Error()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	downloadedAt := time.Now().UTC()
+
+	if format == "epub" {
+		manifest.Put(&pipeline.ManifestRecord{
+			File:         textFileName,
+			ID:           docID,
+			Source:       fileName,
+			Format:       format,
+			BookInfo:     info,
+			DownloadedAt: downloadedAt,
+			Provenance:   opts.Provenance.ForSource(link),
+		})
+		if err := os.Rename(partialFilePath, filepath.Join(dataDir, fileName)); err != nil {
+			log.Fatal(err)
+		}
+		pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Info("Downloaded work", "title", info.Title)
+		return
+	}
+
+	export, err := os.ReadFile(partialFilePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	os.Remove(partialFilePath)
+	document, headings, err := ao3ExportText(string(export), opts)
+	if err != nil {
+		pipeline.BookLogger(docID, link, pipeline.LogPhaseConvert).Warn("Could not convert work", "title", info.Title, "error", err)
+		opts.Report.Fail(info.Title, fileName, "convert", err.Error())
+		if err := manifest.LogError(fileName, "convert", err.Error()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	text, report := pipeline.CleanText(document, opts, headings)
+	record := &pipeline.ManifestRecord{
+		File:         textFileName,
+		ID:           docID,
+		Format:       format,
+		BookInfo:     info,
+		DownloadedAt: downloadedAt,
+		ConvertedAt:  time.Now().UTC(),
+		Provenance:   opts.Provenance.ForSource(link),
+	}
+	if err := pipeline.WriteCleanText(ctx, record, text, report, dataDir, manifest, sink, opts); err != nil {
+		return
+	}
+	pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Info("Downloaded work", "title", info.Title)
+}
+
+// runAO3 is the ao3 command. It downloads the works of Archive of Our Own
+// listed on a tag, fandom or search page, or named as arguments, in the
+// export AO3 makes of them. Their stable ids are ao3-<work id>-<format>.
+func runAO3(args []string) {
+	flags := flag.NewFlagSet("ao3", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	urlPtr := flags.String("url", "",
+		"Tag, fandom or search page of the works to download, e.g. "+ao3URL+"/tags/Sherlock%20(TV)/works")
+	textFormatPtr := flags.String("format", "epub",
+		"Which export of the works to download. Options are 'epub' or 'html'")
+	limitPtr := flags.Int("limit", 100,
+		"The number of works to download. 0 for every work listed")
+	delayPtr := flags.Duration("delay", 5*time.Second,
+		"Time to wait between requests. AO3 turns down clients that go too fast")
+	minWordsPtr := flags.Int("min-words", 0,
+		"Reject works with fewer words than this after conversion. 0 for no minimum")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: smashwords-downloader ao3 [-url search page] [work id or url]...")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	format := *textFormatPtr
+	if !ao3Formats[format] {
+		log.Fatalf("Unsupported format %s", format)
+	}
+	dataDir := *dataDirPtr
+
+	base, _ := url.Parse(ao3URL)
+	if *urlPtr != "" {
+		search, err := url.Parse(*urlPtr)
+		if err != nil || search.Host == "" {
+			log.Fatalf("Invalid -url %s", *urlPtr)
+		}
+		base = &url.URL{Scheme: search.Scheme, Host: search.Host}
+	}
+	client := &ao3Client{base: base, delay: *delayPtr}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var workIDs []string
+	for _, arg := range flags.Args() {
+		id := AO3WorkID(arg)
+		if id == "" {
+			log.Fatalf("%s is not a work of AO3", arg)
+		}
+		workIDs = append(workIDs, id)
+	}
+	if *urlPtr != "" {
+		ids, err := client.Search(ctx, *urlPtr, *limitPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		workIDs = append(workIDs, ids...)
+	}
+	if len(workIDs) == 0 {
+		flags.Usage()
+		log.Fatal("Set -url or give the works to download")
+	}
+	slog.Info("Downloading works of AO3", "works", len(workIDs), "data_dir", dataDir)
+
+	run := startSourceRun(dataDir, pipeline.OutputTxt, flags)
+	run.Opts.MinWords = *minWordsPtr
+	for _, workID := range workIDs {
+		if ctx.Err() != nil {
+			abortRun(run, ctx.Err())
+		}
+		client.download(ctx, workID, format, dataDir, run.Manifest, run.Sink, run.Opts)
+	}
+	finishRun(ctx, run, format == "epub")
+}
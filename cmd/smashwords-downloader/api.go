@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// the statuses of a job that hasn't ended. A job that has ends with the
+// status of its notification, like NotifyCompleted, or JobCanceled.
+const (
+	JobQueued   string = "queued"
+	JobRunning  string = "running"
+	JobCanceled string = "canceled"
+)
+
+const (
+	// the most books a page of /books has
+	apiMaxPageSize = 1000
+	// how long a canceled job has to save its manifest before it is killed
+	apiCancelGrace = 30 * time.Second
+)
+
+// Job is a crawl submitted to the api command, which runs a command of the
+// tool with its args against the data directory of the server.
+type Job struct {
+	ID       string     `json:"id"`
+	Command  string     `json:"command"`
+	Args     []string   `json:"args"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	// the events of the run so far by type, like EventDownloadComplete
+	Events map[string]int `json:"events"`
+	// the book the run last had an event of
+	LastBook string `json:"last_book,omitempty"`
+	Error    string `json:"error,omitempty"`
+
+	cancel context.CancelFunc
+	// closed and replaced every time the job changes
+	changed chan struct{}
+}
+
+// ended is whether the job has a status it doesn't leave.
+func (j *Job) ended() bool {
+	return j.Status != JobQueued && j.Status != JobRunning
+}
+
+// the errors of the operations of the job server, which the HTTP and gRPC
+// APIs turn into their status codes
+var (
+	errNoJob          = errors.New("no such job")
+	errNoBook         = errors.New("no such book")
+	errJobEnded       = errors.New("the job has already ended")
+	errQueueFull      = errors.New("too many jobs are waiting to run")
+	errUnknownCommand = errors.New("unsupported command")
+)
+
+// jobRequest is the body of a POST to /jobs.
+type jobRequest struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+}
+
+// jobServer runs the jobs of the api command one at a time, since they
+// share the manifest of the data directory, and serves the catalog of their
+// books.
+type jobServer struct {
+	dataDir    string
+	executable string
+	// args every job gets, like -config
+	shared  []string
+	catalog *pipeline.Catalog
+	// the daily epub quota of Smashwords
+	quota int
+
+	mu     sync.Mutex
+	jobs   []*Job
+	nextID int
+	queue  chan *Job
+	// what the jobs did lately, for the dashboard
+	activity *jobActivity
+}
+
+// jobCommands are the commands a job can run: scrape and the sources.
+func jobCommands() []string {
+	return append([]string{"scrape"}, source.Names()...)
+}
+
+// writeJSON writes v as the JSON response with status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		slog.Error("Could not write the response", "error", err)
+	}
+}
+
+// writeError writes an error as the JSON response with status.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// job returns the job with an id, nil if there is none. The caller holds
+// the lock.
+func (s *jobServer) job(id string) *Job {
+	for _, job := range s.jobs {
+		if job.ID == id {
+			return job
+		}
+	}
+	return nil
+}
+
+// touch tells the watchers of a job that it changed. The caller holds the
+// lock.
+func (s *jobServer) touch(job *Job) {
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// snapshot is a copy of a job to write out of the lock.
+func (s *jobServer) snapshot(job *Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.copyJob(job)
+}
+
+// copyJob is a copy of a job. The caller holds the lock.
+func (s *jobServer) copyJob(job *Job) Job {
+	copied := *job
+	copied.Events = make(map[string]int, len(job.Events))
+	for event, count := range job.Events {
+		copied.Events[event] = count
+	}
+	return copied
+}
+
+// list returns copies of every job, in the order they were submitted.
+func (s *jobServer) list() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		list = append(list, s.copyJob(job))
+	}
+	return list
+}
+
+// submit queues a job to run a command with args.
+func (s *jobServer) submit(command string, args []string) (Job, error) {
+	if command == "" {
+		command = "scrape"
+	}
+	if !slices.Contains(jobCommands(), command) {
+		return Job{}, fmt.Errorf("%w %s, options are %v", errUnknownCommand, command, jobCommands())
+	}
+	if args == nil {
+		args = []string{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == cap(s.queue) {
+		return Job{}, errQueueFull
+	}
+	s.nextID++
+	job := &Job{
+		ID:      strconv.Itoa(s.nextID),
+		Command: command,
+		Args:    args,
+		Status:  JobQueued,
+		Created: time.Now().UTC(),
+		Events:  make(map[string]int),
+		changed: make(chan struct{}),
+	}
+	s.jobs = append(s.jobs, job)
+	s.queue <- job
+	slog.Info("Queued job", "job", job.ID, "command", job.Command, "args", job.Args)
+	return s.copyJob(job), nil
+}
+
+// get returns a copy of the job with an id.
+func (s *jobServer) get(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.job(id)
+	if job == nil {
+		return Job{}, fmt.Errorf("%w %s", errNoJob, id)
+	}
+	return s.copyJob(job), nil
+}
+
+// cancel cancels a job that hasn't ended. A running job is interrupted, so
+// it saves its manifest for the next run to pick up from.
+func (s *jobServer) cancel(id string) (Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := s.job(id)
+	if job == nil {
+		return Job{}, fmt.Errorf("%w %s", errNoJob, id)
+	}
+	switch job.Status {
+	case JobQueued:
+		job.Status = JobCanceled
+		finished := time.Now().UTC()
+		job.Finished = &finished
+		s.touch(job)
+	case JobRunning:
+		job.cancel()
+	default:
+		return Job{}, fmt.Errorf("%w: job %s", errJobEnded, id)
+	}
+	slog.Info("Canceled job", "job", job.ID)
+	return s.copyJob(job), nil
+}
+
+// watch calls send with the job with an id as it is, then every time it
+// changes, until it ends or ctx is done. Changes that come faster than
+// send returns are sent as one.
+func (s *jobServer) watch(ctx context.Context, id string, send func(Job) error) error {
+	for {
+		s.mu.Lock()
+		job := s.job(id)
+		if job == nil {
+			s.mu.Unlock()
+			return fmt.Errorf("%w %s", errNoJob, id)
+		}
+		copied, changed := s.copyJob(job), job.changed
+		s.mu.Unlock()
+		if err := send(copied); err != nil {
+			return err
+		}
+		if copied.ended() {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// searchBooks returns a page of the books of the catalog, as
+// Catalog.Search, with limit brought between 1 and apiMaxPageSize.
+func (s *jobServer) searchBooks(query string, language string, offset int, limit int) ([]*pipeline.CatalogBook, int, error) {
+	if offset < 0 {
+		offset = 0
+	}
+	if limit < 1 {
+		limit = servePageSize
+	} else if limit > apiMaxPageSize {
+		limit = apiMaxPageSize
+	}
+	books, total, err := s.catalog.Search(query, language, offset, limit)
+	if books == nil {
+		books = []*pipeline.CatalogBook{}
+	}
+	return books, total, err
+}
+
+// book returns the book of the catalog with a stable id.
+func (s *jobServer) book(id string) (*pipeline.CatalogBook, error) {
+	book, err := s.catalog.Book(id)
+	if err == nil && book == nil {
+		err = fmt.Errorf("%w %s", errNoBook, id)
+	}
+	return book, err
+}
+
+// stats are the statistics of the dataset as it is now.
+func (s *jobServer) stats() (*CorpusStats, error) {
+	manifest, err := pipeline.LoadManifest(s.dataDir)
+	if err != nil {
+		return nil, err
+	}
+	return ComputeStats(manifest), nil
+}
+
+// httpStatus is the HTTP status code of an error of the job server.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, errNoJob), errors.Is(err, errNoBook):
+		return http.StatusNotFound
+	case errors.Is(err, errJobEnded):
+		return http.StatusConflict
+	case errors.Is(err, errQueueFull):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errUnknownCommand):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *jobServer) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"jobs": s.list()})
+}
+
+func (s *jobServer) handleSubmitJob(w http.ResponseWriter, r *http.Request) {
+	var request jobRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	job, err := s.submit(request.Command, request.Args)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *jobServer) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *jobServer) handleCancelJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.cancel(r.PathValue("id"))
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job)
+}
+
+func (s *jobServer) handleListBooks(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	books, total, err := s.searchBooks(query.Get("q"), query.Get("language"), offset, limit)
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"total": total, "offset": max(offset, 0), "books": books})
+}
+
+func (s *jobServer) handleGetBook(w http.ResponseWriter, r *http.Request) {
+	book, err := s.book(r.PathValue("id"))
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+func (s *jobServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.stats()
+	if err != nil {
+		writeError(w, httpStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// work runs the queued jobs one after another until ctx is done.
+func (s *jobServer) work(ctx context.Context) {
+	for {
+		select {
+		case job := <-s.queue:
+			s.run(ctx, job)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run runs a job in a process of its own, following its progress through
+// the event stream of the run.
+func (s *jobServer) run(ctx context.Context, job *Job) {
+	s.mu.Lock()
+	if job.Status != JobQueued {
+		s.mu.Unlock()
+		return
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	job.cancel = cancel
+	job.Status = JobRunning
+	started := time.Now().UTC()
+	job.Started = &started
+	s.touch(job)
+	s.mu.Unlock()
+	slog.Info("Running job", "job", job.ID, "command", job.Command)
+
+	status, err := s.exec(jobCtx, job)
+	if errors.Is(jobCtx.Err(), context.Canceled) {
+		status, err = JobCanceled, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.Status = status
+	if err != nil {
+		job.Error = err.Error()
+	}
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	s.touch(job)
+	if status == pipeline.NotifyRateLimited {
+		s.rateLimited(finished)
+	}
+	if err != nil {
+		s.activity.record(job.ID, pipeline.Event{Type: pipeline.EventError, Time: finished, Error: err.Error()})
+		slog.Warn("Job did not complete", "job", job.ID, "status", status, "error", err)
+	} else {
+		slog.Info("Job ended", "job", job.ID, "status", status)
+	}
+}
+
+// exec runs the command of a job with its events on a pipe, and returns how
+// it went. The data directory of the server comes last, so a job can't
+// write anywhere else.
+func (s *jobServer) exec(ctx context.Context, job *Job) (string, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return pipeline.NotifyFailed, err
+	}
+	defer reader.Close()
+	args := append([]string{job.Command}, job.Args...)
+	args = append(args, s.shared...)
+	// the pipe is the first of ExtraFiles, so it is fd 3 of the job
+	args = append(args, "-data_dir", s.dataDir, "-events", "fd:3", "-progress", pipeline.ProgressLog)
+	cmd := exec.CommandContext(ctx, s.executable, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{writer}
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = apiCancelGrace
+	if err := cmd.Start(); err != nil {
+		writer.Close()
+		return pipeline.NotifyFailed, err
+	}
+	writer.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var event pipeline.Event
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				continue
+			}
+			s.activity.record(job.ID, event)
+			s.mu.Lock()
+			job.Events[event.Type]++
+			if event.Title != "" {
+				job.LastBook = event.Title
+			}
+			s.touch(job)
+			s.mu.Unlock()
+		}
+	}()
+	err = cmd.Wait()
+	<-done
+	return commandStatus(err)
+}
+
+// runAPI is the api command. It serves an HTTP API to run crawls against a
+// data directory and query what they downloaded, for platforms that drive
+// the tool rather than people, and with -grpc-addr the same as the gRPC
+// service of jobspb. The dashboard at / is built on it:
+//
+//	POST   /jobs       submits a job, {"command": "scrape", "args": ["-id", "1245"]}
+//	GET    /jobs       lists the jobs
+//	GET    /jobs/{id}  the status and progress of a job
+//	DELETE /jobs/{id}  cancels a job
+//	GET    /books      searches the catalog, with q, language, offset and limit
+//	GET    /books/{id} a book of the catalog
+//	GET    /stats      the statistics of the dataset, as stats -json prints them
+//	GET    /throughput books downloaded and converted a minute, the last hour
+//	GET    /errors     the latest errors of the jobs
+//	GET    /quota      the epub quota of Smashwords left
+func runAPI(args []string) {
+	flags := flag.NewFlagSet("api", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory the jobs download to, with the manifest and catalog of the books")
+	addrPtr := flags.String("addr", "localhost:8081",
+		"Address to listen on, e.g. :8081 for every interface")
+	tokenPtr := flags.String("token", "",
+		"Token clients have to send as 'Authorization: Bearer TOKEN'. Empty for none, which is only safe"+
+			" on localhost")
+	queuePtr := flags.Int("queue", 100,
+		"The number of jobs that can wait to run")
+	quotaPtr := flags.Int("quota", smashwordsDailyLimit,
+		"Epub downloads a day Smashwords allows, for the quota the dashboard shows")
+	grpcAddrPtr := flags.String("grpc-addr", "",
+		"Address to serve the gRPC service of the API on as well, like localhost:9091, with the progress of"+
+			" jobs streamed by WatchJob. Empty for none")
+	parseFlags(flags, args)
+
+	if *queuePtr < 1 {
+		log.Fatal("-queue has to be at least 1")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.MkdirAll(*dataDirPtr, 0700); err != nil {
+		log.Fatal(err)
+	}
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	catalog, err := pipeline.OpenCatalog(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer catalog.Close()
+	if err := catalog.Sync(manifest.Records()); err != nil {
+		log.Fatal(err)
+	}
+
+	server := &jobServer{
+		dataDir:    *dataDirPtr,
+		executable: executable,
+		catalog:    catalog,
+		queue:      make(chan *Job, *queuePtr),
+		activity:   newJobActivity(),
+		quota:      *quotaPtr,
+	}
+	// the jobs get the values of the config file for every command
+	if config := flags.Lookup("config").Value.String(); config != "" {
+		server.shared = append(server.shared, "-config", config)
+	}
+	go server.work(context.Background())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs", server.handleListJobs)
+	mux.HandleFunc("POST /jobs", server.handleSubmitJob)
+	mux.HandleFunc("GET /jobs/{id}", server.handleGetJob)
+	mux.HandleFunc("DELETE /jobs/{id}", server.handleCancelJob)
+	mux.HandleFunc("GET /books", server.handleListBooks)
+	mux.HandleFunc("GET /books/{id}", server.handleGetBook)
+	mux.HandleFunc("GET /stats", server.handleStats)
+	mux.HandleFunc("GET /throughput", server.handleThroughput)
+	mux.HandleFunc("GET /errors", server.handleErrors)
+	mux.HandleFunc("GET /quota", server.handleQuota)
+	// the dashboard asks for the token itself, so the page is left open
+	dashboard := http.HandlerFunc(server.handleDashboard)
+
+	if *grpcAddrPtr != "" {
+		listener, err := net.Listen("tcp", *grpcAddrPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		grpcServer := newGRPCServer(server, *tokenPtr)
+		go func() {
+			log.Fatal(grpcServer.Serve(listener))
+		}()
+		slog.Info("Serving the gRPC API", "addr", listener.Addr().String())
+	}
+
+	var handler http.Handler = mux
+	if *tokenPtr != "" {
+		expected := []byte("Bearer " + *tokenPtr)
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/" {
+				dashboard.ServeHTTP(w, r)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("missing or wrong token"))
+				return
+			}
+			mux.ServeHTTP(w, r)
+		})
+	}
+	mux.Handle("GET /{$}", dashboard)
+	slog.Info("Serving the API", "data_dir", *dataDirPtr, "url", "http://"+*addrPtr+"/")
+	log.Fatal(http.ListenAndServe(*addrPtr, handler))
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/coreweave/dataset-downloader/cmd/smashwords-downloader/jobspb"
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// grpcJobServer serves the jobs and catalog of a job server as the
+// JobControl service of jobspb.
+type grpcJobServer struct {
+	jobspb.UnimplementedJobControlServer
+	jobs *jobServer
+}
+
+// newGRPCServer returns a gRPC server with the JobControl service of jobs.
+// With a token every call needs it as 'authorization: Bearer TOKEN'
+// metadata, like the HTTP API.
+func newGRPCServer(jobs *jobServer, token string) *grpc.Server {
+	var options []grpc.ServerOption
+	if token != "" {
+		expected := []byte("Bearer " + token)
+		authorized := func(ctx context.Context) error {
+			md, _ := metadata.FromIncomingContext(ctx)
+			values := md.Get("authorization")
+			if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), expected) != 1 {
+				return status.Error(codes.Unauthenticated, "missing or wrong token")
+			}
+			return nil
+		}
+		options = append(options,
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+				handler grpc.UnaryHandler) (interface{}, error) {
+				if err := authorized(ctx); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo,
+				handler grpc.StreamHandler) error {
+				if err := authorized(stream.Context()); err != nil {
+					return err
+				}
+				return handler(srv, stream)
+			}),
+		)
+	}
+	server := grpc.NewServer(options...)
+	jobspb.RegisterJobControlServer(server, &grpcJobServer{jobs: jobs})
+	return server
+}
+
+// grpcError is the gRPC status of an error of the job server.
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, errNoJob), errors.Is(err, errNoBook):
+		code = codes.NotFound
+	case errors.Is(err, errJobEnded):
+		code = codes.FailedPrecondition
+	case errors.Is(err, errQueueFull):
+		code = codes.ResourceExhausted
+	case errors.Is(err, errUnknownCommand):
+		code = codes.InvalidArgument
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+	return status.Error(code, err.Error())
+}
+
+// grpcTime is a time of a job as a timestamp, nil if it hasn't come yet.
+func grpcTime(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// grpcCounts is a count by name in int64s.
+func grpcCounts(counts map[string]int) map[string]int64 {
+	converted := make(map[string]int64, len(counts))
+	for name, count := range counts {
+		converted[name] = int64(count)
+	}
+	return converted
+}
+
+func grpcJob(job Job) *jobspb.Job {
+	return &jobspb.Job{
+		Id:       job.ID,
+		Command:  job.Command,
+		Args:     job.Args,
+		Status:   job.Status,
+		Created:  timestamppb.New(job.Created),
+		Started:  grpcTime(job.Started),
+		Finished: grpcTime(job.Finished),
+		Events:   grpcCounts(job.Events),
+		LastBook: job.LastBook,
+		Error:    job.Error,
+	}
+}
+
+func grpcBook(book *pipeline.CatalogBook) *jobspb.Book {
+	return &jobspb.Book{
+		File:        book.File,
+		Id:          book.ID,
+		Source:      book.Source,
+		Title:       book.Title,
+		Author:      book.Author,
+		Url:         book.URL,
+		Categories:  book.Categories,
+		Language:    book.Language,
+		License:     book.License,
+		LicenseType: book.LicenseType,
+		Published:   book.Published,
+		Words:       int64(book.Words),
+		Tokens:      int64(book.Tokens),
+		Dataset:     book.Dataset,
+	}
+}
+
+func grpcPercentiles(p LengthPercentiles) *jobspb.Percentiles {
+	return &jobspb.Percentiles{
+		Min: int64(p.Min),
+		P10: int64(p.P10),
+		P25: int64(p.P25),
+		P50: int64(p.P50),
+		P75: int64(p.P75),
+		P90: int64(p.P90),
+		P99: int64(p.P99),
+		Max: int64(p.Max),
+	}
+}
+
+func (s *grpcJobServer) SubmitJob(ctx context.Context, req *jobspb.SubmitJobRequest) (*jobspb.Job, error) {
+	job, err := s.jobs.submit(req.Command, req.Args)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcJob(job), nil
+}
+
+func (s *grpcJobServer) ListJobs(ctx context.Context, req *jobspb.ListJobsRequest) (*jobspb.ListJobsResponse, error) {
+	response := &jobspb.ListJobsResponse{}
+	for _, job := range s.jobs.list() {
+		response.Jobs = append(response.Jobs, grpcJob(job))
+	}
+	return response, nil
+}
+
+func (s *grpcJobServer) GetJob(ctx context.Context, req *jobspb.GetJobRequest) (*jobspb.Job, error) {
+	job, err := s.jobs.get(req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcJob(job), nil
+}
+
+func (s *grpcJobServer) CancelJob(ctx context.Context, req *jobspb.CancelJobRequest) (*jobspb.Job, error) {
+	job, err := s.jobs.cancel(req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcJob(job), nil
+}
+
+func (s *grpcJobServer) WatchJob(req *jobspb.WatchJobRequest, stream jobspb.JobControl_WatchJobServer) error {
+	err := s.jobs.watch(stream.Context(), req.Id, func(job Job) error {
+		return stream.Send(grpcJob(job))
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (s *grpcJobServer) SearchBooks(ctx context.Context, req *jobspb.SearchBooksRequest) (*jobspb.SearchBooksResponse, error) {
+	books, total, err := s.jobs.searchBooks(req.Query, req.Language, int(req.Offset), int(req.Limit))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	response := &jobspb.SearchBooksResponse{Total: int32(total), Offset: max(req.Offset, 0)}
+	for _, book := range books {
+		response.Books = append(response.Books, grpcBook(book))
+	}
+	return response, nil
+}
+
+func (s *grpcJobServer) GetBook(ctx context.Context, req *jobspb.GetBookRequest) (*jobspb.Book, error) {
+	book, err := s.jobs.book(req.Id)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return grpcBook(book), nil
+}
+
+func (s *grpcJobServer) GetStats(ctx context.Context, req *jobspb.GetStatsRequest) (*jobspb.Stats, error) {
+	stats, err := s.jobs.stats()
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return &jobspb.Stats{
+		Documents:        int64(stats.Documents),
+		Bytes:            stats.Bytes,
+		Words:            int64(stats.Words),
+		Tokens:           stats.Tokens,
+		Languages:        grpcCounts(stats.Languages),
+		Licenses:         grpcCounts(stats.Licenses),
+		Rejected:         int64(stats.Rejected),
+		Rejections:       grpcCounts(stats.Rejections),
+		Categories:       grpcCounts(stats.Categories),
+		WordPercentiles:  grpcPercentiles(stats.WordPercentiles),
+		TokenPercentiles: grpcPercentiles(stats.TokenPercentiles),
+		BytePercentiles:  grpcPercentiles(stats.BytePercentiles),
+	}, nil
+}
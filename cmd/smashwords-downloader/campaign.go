@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/smashwords"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// quotaPacer keeps the epub downloads of a campaign within the daily limit
+// of Smashwords: at most quota of them in any window, counting the ones the
+// manifest got before the campaign started, and with spread one every
+// window/quota, so they are spread over the day instead of bunched at its
+// start.
+type quotaPacer struct {
+	quota  int
+	window time.Duration
+	spread bool
+
+	mu sync.Mutex
+	// when the downloads in the window started, or are to start, oldest
+	// first
+	starts []time.Time
+	// when the next download may start, with spread
+	next time.Time
+}
+
+// newQuotaPacer returns a pacer of quota downloads a window, which the
+// downloads that started at before count against.
+func newQuotaPacer(quota int, window time.Duration, spread bool, before []time.Time) *quotaPacer {
+	starts := append([]time.Time(nil), before...)
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	return &quotaPacer{quota: quota, window: window, spread: spread, starts: starts}
+}
+
+// Wait waits for the turn of the download of url, or gives the turn back and
+// returns the error of ctx if it is done first. Once the quota of the window
+// is used up it returns a *source.RateLimitError with when it frees up
+// instead.
+func (p *quotaPacer) Wait(ctx context.Context, url string) error {
+	p.mu.Lock()
+	now := time.Now()
+	for len(p.starts) > 0 && now.Sub(p.starts[0]) >= p.window {
+		p.starts = p.starts[1:]
+	}
+	if len(p.starts) >= p.quota {
+		// rounded up, as a RetryAfter of 0 means the site didn't say
+		retryAfter := (p.starts[0].Add(p.window).Sub(now) + time.Second - 1).Truncate(time.Second)
+		p.mu.Unlock()
+		return &source.RateLimitError{URL: url, RetryAfter: retryAfter}
+	}
+	start := now
+	if p.spread && p.next.After(now) {
+		start = p.next
+	}
+	before := p.next
+	p.starts = append(p.starts, start)
+	p.next = start.Add(p.window / time.Duration(p.quota))
+	next := p.next
+	p.mu.Unlock()
+
+	sleep(ctx, time.Until(start))
+	if err := ctx.Err(); err != nil {
+		p.release(start, before, next)
+		return err
+	}
+	return nil
+}
+
+// release gives back the slot at start that a Wait reserved and never
+// used, and the turn after it if no other download took the one after.
+func (p *quotaPacer) release(start time.Time, before time.Time, next time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for i := len(p.starts) - 1; i >= 0; i-- {
+		if p.starts[i].Equal(start) {
+			p.starts = append(p.starts[:i], p.starts[i+1:]...)
+			break
+		}
+	}
+	if p.next.Equal(next) {
+		p.next = before
+	}
+}
+
+// usedUp counts the quota of the window as used up from now, for when
+// Smashwords rate limits the campaign before the pacer thinks it should.
+func (p *quotaPacer) usedUp() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.starts = p.starts[:0]
+	for i := 0; i < p.quota; i++ {
+		p.starts = append(p.starts, now)
+	}
+}
+
+// pacedSource is a source whose epub downloads wait for their turn of the
+// quota of the campaign.
+type pacedSource struct {
+	source.Source
+	pacer *quotaPacer
+}
+
+func (s *pacedSource) Fetch(ctx context.Context, book *source.Book) (io.ReadCloser, error) {
+	if book.Format == "epub" {
+		if err := s.pacer.Wait(ctx, book.DownloadURL); err != nil {
+			return nil, err
+		}
+	}
+	return s.Source.Fetch(ctx, book)
+}
+
+// epubDownloadsInWindow returns when the epubs the manifest downloaded in
+// the window of the daily limit of Smashwords were downloaded.
+func epubDownloadsInWindow(manifest *pipeline.Manifest, now time.Time) []time.Time {
+	var downloads []time.Time
+	for _, record := range manifest.Records() {
+		if record.Format == "epub" && now.Sub(record.DownloadedAt) < smashwordsQuotaWindow {
+			downloads = append(downloads, record.DownloadedAt)
+		}
+	}
+	return downloads
+}
+
+// campaignBooks is how many Smashwords books the manifest has, in any
+// format and state, for the progress of a campaign.
+func campaignBooks(manifest *pipeline.Manifest) int {
+	books := make(map[string]bool)
+	for _, record := range manifest.Records() {
+		if record.BookID != "" {
+			books[record.BookID] = true
+		}
+	}
+	return len(books)
+}
+
+// runCampaign is the campaign command. It downloads the first -target books
+// of a category of Smashwords, however many days the daily limit of epubs
+// makes that take: it crawls the category until the quota is used up, waits
+// until it frees up and crawls again, skipping the books it has, until a
+// crawl gets through every book of the target.
+func runCampaign(args []string) {
+	flags := flag.NewFlagSet("campaign", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	categoryPtr := flags.Int("id", 1245,
+		"The id of the Smashwords category to download (in https://www.smashwords.com/books/category/1245)")
+	targetPtr := flags.Int("target", 0,
+		"The number of books of the category to download, the most downloaded first, like 12000 for all of a"+
+			" category of 12,000 books")
+	itemsPerPagePtr := flags.Int("pageitems", smashwordsPageItems,
+		"The number of books on a page")
+	formatPtr := flags.String("format", "epub",
+		"The format of the books to download: 'all', 'txt' or 'epub'")
+	quotaPtr := flags.Int("quota", smashwordsDailyLimit,
+		"Epub downloads a day Smashwords allows, which the campaign stays within")
+	spreadPtr := flags.Bool("spread", true,
+		"Spread the epub downloads evenly over the day, one every 24 hours divided by -quota, instead of"+
+			" downloading the quota as fast as -rate allows and waiting for the next day")
+	ratePtr := flags.Float64("rate", smashwords.DefaultRate,
+		"Requests a second to Smashwords at most, for the pages and the downloads. 0 for no limit")
+	conv := addConvertFlags(flags)
+	out := addOutputFlags(flags)
+	streamPtr := addStreamFlag(flags)
+	parseFlags(flags, args)
+
+	if *targetPtr <= 0 {
+		fatalUsage(errors.New("set -target to the number of books to download"))
+	}
+	if *itemsPerPagePtr <= 0 {
+		fatalUsage(errors.New("-pageitems has to be at least 1"))
+	}
+	if *quotaPtr <= 0 {
+		fatalUsage(errors.New("-quota has to be at least 1"))
+	}
+	if *formatPtr != "all" && *formatPtr != "txt" && *formatPtr != "epub" {
+		fatalUsage(errors.New("unsupported format " + *formatPtr))
+	}
+	var limiter source.RateLimiter = source.NoLimit
+	if *ratePtr > 0 {
+		limiter = source.NewTokenBucket(*ratePtr, smashwords.DefaultBurst)
+	}
+	slog.Info("Saving files", "data_dir", *dataDirPtr)
+
+	run := openDatasetRun(*dataDirPtr, flags, conv, out)
+	run.Opts.Progress = conv.startProgress(pipeline.PhaseListed, pipeline.PhaseDiscovered, pipeline.PhaseDownloaded, pipeline.PhaseConverted)
+	run.Opts.Stream = parseStreamFlag(*streamPtr)
+	pacer := newQuotaPacer(*quotaPtr, smashwordsQuotaWindow, *spreadPtr, epubDownloadsInWindow(run.Manifest, time.Now()))
+	src := &pacedSource{
+		Source: &smashwords.Smashwords{
+			CategoryID:   *categoryPtr,
+			ItemsPerPage: *itemsPerPagePtr,
+			Pages:        (*targetPtr + *itemsPerPagePtr - 1) / *itemsPerPagePtr,
+			Format:       *formatPtr,
+			Sort:         smashwords.SortDownloads,
+			RateLimiter:  limiter,
+		},
+		pacer: pacer,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	started := time.Now()
+	for round := 1; ; round++ {
+		setSmashwordsQuota(run)
+		slog.Info("Crawling the category of the campaign", "category_id", *categoryPtr, "round", round,
+			"books", campaignBooks(run.Manifest), "target", *targetPtr)
+		epubs, err := run.Crawl(ctx, src)
+		var limited *source.RateLimitError
+		switch {
+		case ctx.Err() != nil:
+			abortRun(run, ctx.Err())
+		case err == nil:
+			slog.Info("Campaign complete", "category_id", *categoryPtr, "took", time.Since(started).Round(time.Minute),
+				"books", campaignBooks(run.Manifest), "target", *targetPtr)
+			finishRun(ctx, run, epubs)
+			return
+		case errors.As(err, &limited) && limited.RetryAfter > 0:
+			// the quota of the pacer, or Smashwords saying how long to wait
+		case errors.Is(err, source.ErrRateLimited):
+			// Smashwords counts differently than the pacer, so its window
+			// starts over
+			pacer.usedUp()
+			limited = &source.RateLimitError{RetryAfter: smashwordsQuotaWindow}
+		default:
+			abortRun(run, err)
+		}
+		// the books of the round go into the dataset before the wait
+		if epubs && run.Opts.Stream != pipeline.StreamMemory {
+			if err := pipeline.ConvertEpubGo(ctx, run.DataDir, run.Opts, run.Manifest, run.Sink); err != nil {
+				abortRun(run, err)
+			}
+		}
+		if err := run.Manifest.Save(); err != nil {
+			log.Fatal(err)
+		}
+		resume := time.Now().Add(limited.RetryAfter)
+		slog.Info("Waiting for the epub quota to free up", "round", round, "books", campaignBooks(run.Manifest),
+			"target", *targetPtr, "resume_at", resume.Format(time.RFC3339))
+		sleep(ctx, limited.RetryAfter)
+		if ctx.Err() != nil {
+			abortRun(run, ctx.Err())
+		}
+	}
+}
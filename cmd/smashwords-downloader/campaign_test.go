@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+func TestQuotaPacerWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	// one download half an hour ago counts, one from yesterday doesn't
+	p := newQuotaPacer(2, time.Hour, false, []time.Time{now.Add(-30 * time.Minute), now.Add(-25 * time.Hour)})
+	if err := p.Wait(ctx, "https://example.com/1.epub"); err != nil {
+		t.Fatal(err)
+	}
+	err := p.Wait(ctx, "https://example.com/2.epub")
+	var rateLimit *source.RateLimitError
+	if !errors.As(err, &rateLimit) {
+		t.Fatalf("got %v once the quota was used up, want a RateLimitError", err)
+	}
+	if rateLimit.RetryAfter < 29*time.Minute || rateLimit.RetryAfter > 30*time.Minute {
+		t.Errorf("retry after %s, want 30m when the oldest download leaves the window", rateLimit.RetryAfter)
+	}
+}
+
+func TestQuotaPacerSpread(t *testing.T) {
+	ctx := context.Background()
+	// a download every 100ms
+	p := newQuotaPacer(4, 400*time.Millisecond, true, nil)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := p.Wait(ctx, "https://example.com/book.epub"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("3 downloads started in %s, want them 100ms apart", elapsed)
+	}
+}
+
+func TestQuotaPacerUsedUp(t *testing.T) {
+	p := newQuotaPacer(500, 24*time.Hour, false, nil)
+	p.usedUp()
+	var rateLimit *source.RateLimitError
+	if err := p.Wait(context.Background(), "https://example.com/book.epub"); !errors.As(err, &rateLimit) {
+		t.Fatalf("got %v after the quota was used up, want a RateLimitError", err)
+	}
+	if rateLimit.RetryAfter != 24*time.Hour {
+		t.Errorf("retry after %s, want a whole window", rateLimit.RetryAfter)
+	}
+}
+
+func TestQuotaPacerCanceled(t *testing.T) {
+	p := newQuotaPacer(2, time.Hour, true, nil)
+	if err := p.Wait(context.Background(), "https://example.com/1.epub"); err != nil {
+		t.Fatal(err)
+	}
+	next := p.next
+	// the next turn is half an hour away, so the wait is canceled first
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := p.Wait(ctx, "https://example.com/2.epub"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want the error of the context", err)
+	}
+	if len(p.starts) != 1 || !p.next.Equal(next) {
+		t.Errorf("the canceled download kept its turn: %d downloads in the window, next at %s, want 1 at %s",
+			len(p.starts), p.next, next)
+	}
+}
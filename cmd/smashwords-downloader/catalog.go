@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	_ "modernc.org/sqlite"
+)
+
+// runQuery is the query command. It runs SQL against the catalog of a data
+// directory, brought up to date with the manifest first, and prints the
+// rows as a table.
+func runQuery(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the manifest of the books")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: smashwords-downloader query [-data_dir ./data] 'SELECT ...'")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	catalog, err := pipeline.OpenCatalog(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer catalog.Close()
+	if err := catalog.Sync(manifest.Records()); err != nil {
+		log.Fatal(err)
+	}
+
+	rows, err := catalog.DB.Query(strings.Join(flags.Args(), " "))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(columns, "\t"))
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	cells := make([]string, len(columns))
+	// long text like license notes stays on one line of the table
+	flatten := strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			log.Fatal(err)
+		}
+		for i, value := range values {
+			switch v := value.(type) {
+			case nil:
+				cells[i] = "NULL"
+			case []byte:
+				cells[i] = flatten.Replace(string(v))
+			default:
+				cells[i] = flatten.Replace(fmt.Sprint(v))
+			}
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	w.Flush()
+}
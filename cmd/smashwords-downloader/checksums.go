@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// runVerify is the verify command. It hashes every file of a data directory
+// again and reports the files listed in SHA256SUMS that are missing or whose
+// contents changed, and the files that aren't listed. It exits with status 1
+// if there are any.
+func runVerify(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the SHA256SUMS to verify")
+	parseFlags(flags, args)
+
+	checksums, err := pipeline.ReadChecksums(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	files, err := pipeline.ListChecksumFiles(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	present := make(map[string]bool)
+	var listed []string
+	extra := 0
+	for _, file := range files {
+		present[file] = true
+		if _, ok := checksums[file]; ok {
+			listed = append(listed, file)
+		} else {
+			extra++
+			fmt.Printf("extra      %s\n", file)
+		}
+	}
+
+	missing := 0
+	var expected []string
+	for file := range checksums {
+		expected = append(expected, file)
+	}
+	sort.Strings(expected)
+	for _, file := range expected {
+		if !present[file] {
+			missing++
+			fmt.Printf("missing    %s\n", file)
+		}
+	}
+
+	corrupted := 0
+	sums, errs := pipeline.HashFiles(*dataDirPtr, listed)
+	for i, file := range listed {
+		if errs[i] != nil {
+			corrupted++
+			fmt.Printf("unreadable %s (%s)\n", file, errs[i])
+		} else if sums[i] != checksums[file] {
+			corrupted++
+			fmt.Printf("corrupted  %s\n", file)
+		}
+	}
+
+	slog.Info("Verified files", "files", len(listed)+missing, "ok", len(listed)-corrupted,
+		"corrupted", corrupted, "missing", missing, "extra", extra)
+	if corrupted+missing+extra > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/smashwords"
+)
+
+// runClean is the clean command. It removes what runs leave behind in a
+// data directory that the dataset doesn't need: the partial downloads of
+// interrupted runs, and the epubs of books that were converted already, like
+// with -overwriteSource=false. With -rejects the rejected books go too. The
+// manifest keeps their records, so none of them is downloaded again.
+func runClean(args []string) {
+	flags := flag.NewFlagSet("clean", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory to clean up")
+	rejectsPtr := flags.Bool("rejects", false,
+		"Also remove the rejects folder with the books that were rejected")
+	dryRunPtr := flags.Bool("dry-run", false,
+		"Only print what would be removed")
+	parseFlags(flags, args)
+
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	converted := make(map[string]bool)
+	for _, record := range manifest.Records() {
+		if record.Source != "" && pipeline.BookStatus(record) != pipeline.StatusDownloaded {
+			converted[record.Source] = true
+		}
+	}
+
+	files, err := os.ReadDir(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var remove []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() {
+			if *rejectsPtr && name == pipeline.RejectsDirName {
+				remove = append(remove, name)
+			}
+			continue
+		}
+		if strings.HasSuffix(name, ".part") || converted[name] {
+			remove = append(remove, name)
+		}
+	}
+
+	for _, name := range remove {
+		if *dryRunPtr {
+			fmt.Println(name)
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(*dataDirPtr, name)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *dryRunPtr || len(remove) == 0 {
+		return
+	}
+	slog.Info("Removed files and folders", "removed", len(remove), "data_dir", *dataDirPtr)
+	if _, err := os.Stat(filepath.Join(*dataDirPtr, pipeline.ChecksumsFileName)); err == nil {
+		if err := pipeline.WriteChecksums(*dataDirPtr); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// runCache is the cache command. It prints the size of the cache of the
+// Smashwords pages a crawl reads, and with -clear removes it so the next
+// crawl sees the books added to the category since.
+func runCache(args []string) {
+	flags := flag.NewFlagSet("cache", flag.ExitOnError)
+	dirPtr := flags.String("dir", smashwords.CacheDir,
+		"directory of the cache")
+	clearPtr := flags.Bool("clear", false,
+		"Remove the cached pages")
+	parseFlags(flags, args)
+
+	if *clearPtr {
+		if err := os.RemoveAll(*dirPtr); err != nil {
+			log.Fatal(err)
+		}
+		slog.Info("Cleared the cache", "path", *dirPtr)
+		return
+	}
+	var pages int
+	var size int64
+	err := filepath.WalkDir(*dirPtr, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		pages++
+		size += info.Size()
+		return nil
+	})
+	if os.IsNotExist(err) {
+		fmt.Printf("%s: empty\n", *dirPtr)
+		return
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d pages, %s\n", *dirPtr, pages, pipeline.FormatSize(size))
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// the shells completion scripts are written for
+const (
+	ShellBash string = "bash"
+	ShellZsh  string = "zsh"
+	ShellFish string = "fish"
+)
+
+// listingFlags is set while commandFlags runs a command for its flags:
+// parseFlags then stops the command with flagsListed instead of parsing.
+var listingFlags bool
+
+// flagsListed is what parseFlags panics with when listingFlags is set.
+type flagsListed struct {
+	flags *flag.FlagSet
+}
+
+// commandFlags returns the flag set of a command, without running it.
+func commandFlags(run func(args []string)) (flags *flag.FlagSet) {
+	listingFlags = true
+	defer func() {
+		listingFlags = false
+		r := recover()
+		if listed, ok := r.(flagsListed); ok {
+			flags = listed.flags
+		} else if r != nil {
+			panic(r)
+		}
+	}()
+	run(nil)
+	return nil
+}
+
+// completionFlag is a flag as the completion scripts offer it.
+type completionFlag struct {
+	name string
+	// the first sentence of the usage
+	summary string
+	// the flag takes a value, it isn't a bool
+	value bool
+}
+
+// completionCommand is a command and its flags.
+type completionCommand struct {
+	name  string
+	doc   string
+	flags []completionFlag
+	// the words its arguments are one of, nil for files
+	words []string
+}
+
+// flagSummary cuts the usage of a flag down to its first sentence.
+func flagSummary(usage string) string {
+	if i := strings.Index(usage, ". "); i >= 0 {
+		usage = usage[:i]
+	}
+	const maxLen = 80
+	if len(usage) > maxLen {
+		if i := strings.LastIndex(usage[:maxLen], " "); i > 0 {
+			usage = usage[:i]
+		}
+		usage += "..."
+	}
+	return strings.TrimSpace(usage)
+}
+
+// completionFlags lists the flags of a flag set, sorted by name.
+func completionFlags(flags *flag.FlagSet) []completionFlag {
+	var list []completionFlag
+	flags.VisitAll(func(f *flag.Flag) {
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		list = append(list, completionFlag{
+			name:    f.Name,
+			summary: flagSummary(f.Usage),
+			value:   !ok || !boolFlag.IsBoolFlag(),
+		})
+	})
+	return list
+}
+
+// completionCommands lists the commands with their flags, then the sources
+// registered from a package of their own.
+func completionCommands() []completionCommand {
+	var list []completionCommand
+	for _, command := range commands {
+		list = append(list, completionCommand{
+			name:  command.name,
+			doc:   command.doc,
+			flags: completionFlags(commandFlags(command.run)),
+		})
+	}
+	for _, name := range source.Names() {
+		if name == "smashwords" {
+			continue
+		}
+		factory, _ := source.Lookup(name)
+		run := func(args []string) { runSource(name, factory, args) }
+		list = append(list, completionCommand{
+			name:  name,
+			doc:   "download books from " + name,
+			flags: completionFlags(commandFlags(run)),
+		})
+	}
+	list = append(list, completionCommand{
+		name:  "completion",
+		doc:   "print a bash, zsh or fish completion script",
+		words: []string{ShellBash, ShellZsh, ShellFish},
+	})
+	return list
+}
+
+// flagNames is the flags of a command as the words they are typed as.
+func flagNames(flags []completionFlag) string {
+	var names []string
+	for _, f := range flags {
+		names = append(names, "-"+f.name)
+	}
+	return strings.Join(names, " ")
+}
+
+// WriteBashCompletion writes a completion script for bash. Without a
+// command the flags are those of scrape.
+func WriteBashCompletion(w io.Writer, program string, commands []completionCommand) {
+	function := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(program)
+	var names []string
+	for _, command := range commands {
+		names = append(names, command.name)
+	}
+	fmt.Fprintf(w, "# bash completion for %s\n", program)
+	fmt.Fprintf(w, "%s() {\n", function)
+	fmt.Fprintf(w, "\tlocal cur=\"${COMP_WORDS[COMP_CWORD]}\" flags=\"\"\n")
+	fmt.Fprintf(w, "\tcase \"${COMP_WORDS[1]}\" in\n")
+	for _, command := range commands {
+		if command.words != nil {
+			fmt.Fprintf(w, "\t%s) [[ $COMP_CWORD -eq 2 ]] && COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n",
+				command.name, strings.Join(command.words, " "))
+			continue
+		}
+		fmt.Fprintf(w, "\t%s) flags=%q ;;\n", command.name, flagNames(command.flags))
+		if command.name == "scrape" {
+			fmt.Fprintf(w, "\t-*) flags=%q ;;\n", flagNames(command.flags))
+		}
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\tif [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintf(w, "\telif [[ $cur == -* ]]; then\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W \"$flags\" -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\telse\n")
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -f -- \"$cur\"))\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "}\n")
+	fmt.Fprintf(w, "complete -o filenames -F %s %s\n", function, program)
+}
+
+// zshQuote escapes text for a single quoted spec of _arguments or
+// _describe.
+func zshQuote(text string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(text)
+}
+
+// zshFlags writes the flags of a command as the specs of _arguments.
+func zshFlags(w io.Writer, flags []completionFlag) {
+	fmt.Fprintf(w, "\t\tflags=(\n")
+	for _, f := range flags {
+		spec := fmt.Sprintf("-%s[%s]", f.name, zshQuote(f.summary))
+		if f.value {
+			spec += ":" + f.name + ":_files"
+		}
+		fmt.Fprintf(w, "\t\t\t'%s'\n", spec)
+	}
+	fmt.Fprintf(w, "\t\t)\n")
+}
+
+// WriteZshCompletion writes a completion script for zsh.
+func WriteZshCompletion(w io.Writer, program string, commands []completionCommand) {
+	function := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(program)
+	fmt.Fprintf(w, "#compdef %s\n\n", program)
+	fmt.Fprintf(w, "%s() {\n", function)
+	fmt.Fprintf(w, "\tlocal -a commands flags\n")
+	fmt.Fprintf(w, "\tcommands=(\n")
+	for _, command := range commands {
+		fmt.Fprintf(w, "\t\t'%s:%s'\n", zshQuote(command.name), zshQuote(command.doc))
+	}
+	fmt.Fprintf(w, "\t)\n")
+	fmt.Fprintf(w, "\tif (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then\n")
+	fmt.Fprintf(w, "\t\t_describe command commands\n")
+	fmt.Fprintf(w, "\t\treturn\n")
+	fmt.Fprintf(w, "\tfi\n")
+	fmt.Fprintf(w, "\tcase $words[2] in\n")
+	for _, command := range commands {
+		fmt.Fprintf(w, "\t%s)\n", command.name)
+		if command.words != nil {
+			fmt.Fprintf(w, "\t\t(( CURRENT == 3 )) && compadd %s\n", strings.Join(command.words, " "))
+			fmt.Fprintf(w, "\t\treturn\n\t\t;;\n")
+			continue
+		}
+		fmt.Fprintf(w, "\t\tshift words\n\t\t(( CURRENT-- ))\n")
+		zshFlags(w, command.flags)
+		fmt.Fprintf(w, "\t\t;;\n")
+		if command.name == "scrape" {
+			// without a command the flags are those of scrape
+			fmt.Fprintf(w, "\t-*)\n")
+			zshFlags(w, command.flags)
+			fmt.Fprintf(w, "\t\t;;\n")
+		}
+	}
+	fmt.Fprintf(w, "\tesac\n")
+	fmt.Fprintf(w, "\t_arguments $flags '*:file:_files'\n")
+	fmt.Fprintf(w, "}\n\n")
+	fmt.Fprintf(w, "%s \"$@\"\n", function)
+}
+
+// fishQuote escapes text for a single quoted fish string.
+func fishQuote(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(text)
+}
+
+// WriteFishCompletion writes a completion script for fish.
+func WriteFishCompletion(w io.Writer, program string, commands []completionCommand) {
+	fmt.Fprintf(w, "# fish completion for %s\n", program)
+	for _, command := range commands {
+		fmt.Fprintf(w, "complete -c %s -f -n '__fish_use_subcommand' -a '%s' -d '%s'\n",
+			program, fishQuote(command.name), fishQuote(command.doc))
+	}
+	for _, command := range commands {
+		condition := fmt.Sprintf("__fish_seen_subcommand_from %s", command.name)
+		if command.words != nil {
+			fmt.Fprintf(w, "complete -c %s -f -n '%s' -a '%s'\n",
+				program, condition, fishQuote(strings.Join(command.words, " ")))
+		}
+		if command.name == "scrape" {
+			// without a command the flags are those of scrape
+			condition = fmt.Sprintf("__fish_use_subcommand; or %s", condition)
+		}
+		for _, f := range command.flags {
+			requires := ""
+			if f.value {
+				requires = " -r"
+			}
+			fmt.Fprintf(w, "complete -c %s -n '%s' -o '%s' -d '%s'%s\n",
+				program, condition, fishQuote(f.name), fishQuote(f.summary), requires)
+		}
+	}
+}
+
+// runCompletion is the completion command. It prints a script that
+// completes the commands and their flags in bash, zsh or fish, like
+//
+//	source <(smashwords-downloader completion bash)
+func runCompletion(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("Usage: %s completion bash|zsh|fish", filepath.Base(os.Args[0]))
+	}
+	program := filepath.Base(os.Args[0])
+	commands := completionCommands()
+	switch args[0] {
+	case ShellBash:
+		WriteBashCompletion(os.Stdout, program, commands)
+	case ShellZsh:
+		WriteZshCompletion(os.Stdout, program, commands)
+	case ShellFish:
+		WriteFishCompletion(os.Stdout, program, commands)
+	default:
+		log.Fatalf("Unsupported shell %s, options are bash, zsh or fish", args[0])
+	}
+}
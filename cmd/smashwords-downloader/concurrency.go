@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"runtime"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// concurrencyFlags are the flags of how much of a run is done at once.
+type concurrencyFlags struct {
+	list     *int
+	download *int
+	convert  *int
+}
+
+// addConcurrencyFlags adds the flags of the concurrency of a run to flags.
+func addConcurrencyFlags(flags *flag.FlagSet) *concurrencyFlags {
+	return &concurrencyFlags{
+		list: flags.Int("list-concurrency", source.DefaultConcurrency,
+			"The number of pages that list books to crawl at once"),
+		download: flags.Int("download-concurrency", pipeline.DefaultDownloadConcurrency,
+			"The number of books to download at once"),
+		convert: flags.Int("convert-concurrency", runtime.NumCPU(),
+			"The number of epubs to convert at once. The books converted at once are written to the dataset in"+
+				" the order they finish in"),
+	}
+}
+
+// concurrency is the Concurrency of the parsed flags.
+func (f *concurrencyFlags) concurrency() pipeline.Concurrency {
+	return pipeline.Concurrency{List: *f.list, Download: *f.download, Convert: *f.convert}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// the key of the named profiles in a config file
+	configProfilesKey string = "profiles"
+	// the prefix of the environment variables that set flags
+	envPrefix string = "SMASHDL_"
+)
+
+// Config is a config file of the commands: values of their flags for every
+// run, sections with values for one command, and named profiles with more
+// of them, like
+//
+//	data_dir: ./data
+//	output: jsonl
+//	convert:
+//	  notes: inline
+//	profiles:
+//	  romance-en:
+//	    id: 1245
+//	    keep-languages: en
+//	  scifi-all-formats:
+//	    id: 1250
+//	    format: all
+//
+// Keys are the names of the flags. Lists are joined with commas, for flags
+// like -keep-languages that take comma separated values.
+type Config struct {
+	// values for every command that has the flag
+	Flags map[string]string
+	// values by command
+	Commands map[string]map[string]string
+	Profiles map[string]map[string]string
+}
+
+// configValue is the value of a key of a config file as a flag value.
+func configValue(key string, value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			s, err := configValue(key, item)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, s)
+		}
+		return strings.Join(values, ","), nil
+	case map[string]interface{}:
+		return "", fmt.Errorf("%s is not a flag value", key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// configValues are the values of the keys of a section of a config file.
+func configValues(section map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string)
+	for key, value := range section {
+		s, err := configValue(key, value)
+		if err != nil {
+			return nil, err
+		}
+		values[key] = s
+	}
+	return values, nil
+}
+
+// LoadConfig reads a YAML config file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file map[string]interface{}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	config := &Config{
+		Commands: make(map[string]map[string]string),
+		Profiles: make(map[string]map[string]string),
+	}
+	if profiles, ok := file[configProfilesKey]; ok {
+		sections, ok := profiles.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("reading %s: %s is not a map of profiles", path, configProfilesKey)
+		}
+		for name, section := range sections {
+			values, ok := section.(map[string]interface{})
+			if !ok && section != nil {
+				return nil, fmt.Errorf("reading %s: profile %s is not a map of flags", path, name)
+			}
+			if config.Profiles[name], err = configValues(values); err != nil {
+				return nil, fmt.Errorf("reading %s: profile %s: %w", path, name, err)
+			}
+		}
+		delete(file, configProfilesKey)
+	}
+	for name, value := range file {
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if config.Commands[name], err = configValues(section); err != nil {
+			return nil, fmt.Errorf("reading %s: %s: %w", path, name, err)
+		}
+		delete(file, name)
+	}
+	if config.Flags, err = configValues(file); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Values returns the flag values of a run of the command of a flag set with
+// a profile, or with none if profile is empty: the values for every run the
+// command has flags for, those of its section over them and those of the
+// profile over both.
+func (c *Config) Values(flags *flag.FlagSet, profile string) (map[string]string, error) {
+	values := make(map[string]string)
+	for name, value := range c.Flags {
+		if flags.Lookup(name) != nil {
+			values[name] = value
+		}
+	}
+	for name, value := range c.Commands[flags.Name()] {
+		values[name] = value
+	}
+	if profile == "" {
+		return values, nil
+	}
+	profileValues, ok := c.Profiles[profile]
+	if !ok {
+		var names []string
+		for name := range c.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("no profile %s, the profiles are %s", profile, strings.Join(names, ", "))
+	}
+	for name, value := range profileValues {
+		values[name] = value
+	}
+	return values, nil
+}
+
+// ApplyConfig sets the flags of a flag set to values, except the flags set
+// on the command line, which win over the config file.
+func ApplyConfig(flags *flag.FlagSet, values map[string]string) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if flags.Lookup(name) == nil {
+			return fmt.Errorf("-%s in the config file is not a flag of %s", name, flags.Name())
+		}
+		if set[name] {
+			continue
+		}
+		if err := flags.Set(name, values[name]); err != nil {
+			return fmt.Errorf("-%s in the config file: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// EnvName is the environment variable that sets a flag: SMASHDL_ and its
+// name in upper case with underscores, like SMASHDL_KEEP_LANGUAGES for
+// -keep-languages.
+func EnvName(flag string) string {
+	name := strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(flag))
+	return envPrefix + name
+}
+
+// ApplyEnv sets the flags of a flag set that have an environment variable,
+// except the flags set on the command line, which win over the environment.
+func ApplyEnv(flags *flag.FlagSet) error {
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var err error
+	flags.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(EnvName(f.Name))
+		if !ok || set[f.Name] || err != nil {
+			return
+		}
+		if setErr := flags.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("%s: %w", EnvName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// parseFlags parses the flags of a command. Flags not on the command line are
+// taken from SMASHDL_ environment variables, then from the -config file and
+// its -profile, in that order.
+func parseFlags(flags *flag.FlagSet, args []string) {
+	configPtr := flags.String("config", "",
+		"YAML file with the values of the flags of the command, which flags on the command line override")
+	profilePtr := flags.String("profile", "",
+		"Profile of the -config file to use, on top of the values of the file for every run")
+	logFormatPtr := flags.String("log-format", LogText,
+		"Format of the log lines: 'text' (key=value fields) or 'json' (a JSON object per line, to query the logs"+
+			" of large crawls)")
+	logLevelPtr := flags.String("log-level", "info",
+		"Log from this level on: 'trace', 'debug', 'info', 'warn' or 'error'")
+	quietPtr := flags.Bool("quiet", false,
+		"Only log warnings and errors, like -log-level warn")
+	verbosePtr := flags.Bool("v", false,
+		"Also log the books that are skipped and the pages that are listed, like -log-level debug")
+	veryVerbosePtr := flags.Bool("vv", false,
+		"Also log every HTTP request, like -log-level trace")
+	logFilePtr := flags.String("log-file", "",
+		"File to append the log lines to. Only warnings and errors go to stderr as well")
+	// the completion command only wants to know the flags
+	if listingFlags {
+		panic(flagsListed{flags})
+	}
+	flags.Parse(args)
+	if err := ApplyEnv(flags); err != nil {
+		fatalUsage(err)
+	}
+	// the config file may set how to log, so the logging is set up last
+	defer func() {
+		level, err := logLevel(*logLevelPtr, *quietPtr, *verbosePtr, *veryVerbosePtr)
+		if err != nil {
+			fatalUsage(err)
+		}
+		if err := setupLogging(*logFormatPtr, level, *logFilePtr); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	if *configPtr == "" {
+		if *profilePtr != "" {
+			fatalUsage("-profile needs a -config file")
+		}
+		return
+	}
+	config, err := LoadConfig(*configPtr)
+	if err != nil {
+		fatalUsage(err)
+	}
+	values, err := config.Values(flags, *profilePtr)
+	if err != nil {
+		fatalUsage(err)
+	}
+	if err := ApplyConfig(flags, values); err != nil {
+		fatalUsage(err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// lines of a JSONL corpus can hold a whole book
+const maxCorpusLine int = 256 * 1024 * 1024
+
+var sha256Regex = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// LoadCorpusHashes collects the content hashes (see ContentHash) of the
+// books in existing corpora, so they can be left out of this one. Each path
+// can be
+//   - a data directory of this tool: every .txt file in it is hashed,
+//     compressed or not
+//   - a list of hashes, one per line
+//   - a JSONL file, like manifest.jsonl or a Pile shard: the "sha256" field
+//     of every line is used, or the hash of its "text" field
+func LoadCorpusHashes(paths []string) (map[string]bool, error) {
+	hashes := make(map[string]bool)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			err = hashCorpusDir(path, hashes)
+		} else {
+			err = readCorpusFile(path, hashes)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hashes, nil
+}
+
+func hashCorpusDir(dir string, hashes map[string]bool) error {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || !pipeline.IsTextFile(file.Name()) {
+			continue
+		}
+		data, err := pipeline.ReadTextFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return err
+		}
+		hashes[pipeline.ContentHash(string(data))] = true
+	}
+	return nil
+}
+
+func readCorpusFile(path string, hashes map[string]bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxCorpusLine)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if sha256Regex.MatchString(strings.ToLower(line)) {
+			hashes[strings.ToLower(line)] = true
+			continue
+		}
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var doc struct {
+			SHA256   string `json:"sha256"`
+			Text     string `json:"text"`
+			Rejected string `json:"rejected"`
+		}
+		if err := json.Unmarshal([]byte(line), &doc); err != nil {
+			return err
+		}
+		switch {
+		case doc.Rejected != "":
+			// rejected books of a manifest aren't in that corpus
+		case doc.SHA256 != "":
+			hashes[doc.SHA256] = true
+		case doc.Text != "":
+			hashes[pipeline.ContentHash(doc.Text)] = true
+		}
+	}
+	return scanner.Err()
+}
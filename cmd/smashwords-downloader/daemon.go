@@ -0,0 +1,408 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+const (
+	// the state of the daemon in the data directory, kept between runs
+	daemonStateFileName string = "daemon.json"
+	// the window of the daily limit of Smashwords
+	smashwordsQuotaWindow = 24 * time.Hour
+)
+
+// the shorthands of schedules
+var scheduleAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+}
+
+// Schedule is a cron schedule: minute, hour, day of the month, month and day
+// of the week, each a *, a number, a range like 1-5, a list like 1,15 or any
+// of those with a step like */15.
+type Schedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// a day matches either field when both are restricted, as in cron
+	anyDay, anyWeekday bool
+}
+
+// parseScheduleField reads a field of a schedule with values from min to
+// max into a set of bits.
+func parseScheduleField(field string, min int, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+		low, high := min, max
+		if rangePart != "*" {
+			first, last, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid range in %q", part)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// ParseSchedule reads a cron schedule of five fields, or a shorthand like
+// @daily.
+func ParseSchedule(spec string) (*Schedule, error) {
+	if alias, ok := scheduleAliases[strings.TrimSpace(spec)]; ok {
+		spec = alias
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q should have 5 fields: minute hour day month weekday", spec)
+	}
+	s := &Schedule{anyDay: fields[2] == "*", anyWeekday: fields[4] == "*"}
+	var err error
+	if s.minutes, err = parseScheduleField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hours, err = parseScheduleField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.days, err = parseScheduleField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.months, err = parseScheduleField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.weekdays, err = parseScheduleField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	// Sunday is 0 or 7
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays |= 1
+	}
+	return s, nil
+}
+
+// dayMatches tells whether the schedule runs on the day of t.
+func (s *Schedule) dayMatches(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+	switch {
+	case s.anyDay && s.anyWeekday:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// Next is the first time after t the schedule runs, or the zero time if it
+// doesn't in the next five years, like on February 30.
+func (s *Schedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hours&(1<<t.Hour()) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// daemonCategory is how the last sync of a category went.
+type daemonCategory struct {
+	LastSync time.Time `json:"last_sync"`
+	// completed, failed or rate_limited, like the notifications
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// daemonState is what the daemon remembers between runs.
+type daemonState struct {
+	LastSync time.Time `json:"last_sync,omitempty"`
+	// when Smashwords last stopped serving epubs
+	RateLimitedAt time.Time                  `json:"rate_limited_at,omitempty"`
+	Categories    map[string]*daemonCategory `json:"categories"`
+}
+
+// loadDaemonState reads the state of the daemon in a data directory, which
+// is empty before its first run.
+func loadDaemonState(dataDir string) (*daemonState, error) {
+	state := &daemonState{Categories: make(map[string]*daemonCategory)}
+	data, err := os.ReadFile(filepath.Join(dataDir, daemonStateFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("%s: %w", daemonStateFileName, err)
+	}
+	if state.Categories == nil {
+		state.Categories = make(map[string]*daemonCategory)
+	}
+	return state, nil
+}
+
+// save writes the state to the data directory.
+func (s *daemonState) save(dataDir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dataDir, daemonStateFileName)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// epubQuotaLeft is how many epubs can be downloaded from Smashwords before
+// its daily limit: the quota less the epubs the manifest has downloaded in
+// the window, by this run of the daemon or any other, and none for the
+// window after Smashwords last rate limited.
+func epubQuotaLeft(dataDir string, state *daemonState, quota int, now time.Time) (int, error) {
+	if now.Sub(state.RateLimitedAt) < smashwordsQuotaWindow {
+		return 0, nil
+	}
+	manifest, err := pipeline.LoadManifest(dataDir)
+	if err != nil {
+		return 0, err
+	}
+	return max(0, quota-epubsInQuotaWindow(manifest, now)), nil
+}
+
+// epubsInQuotaWindow is how many epubs the manifest has downloaded in the
+// window of the daily limit of Smashwords.
+func epubsInQuotaWindow(manifest *pipeline.Manifest, now time.Time) int {
+	epubs := 0
+	for _, record := range manifest.Records() {
+		if record.Format == "epub" && now.Sub(record.DownloadedAt) < smashwordsQuotaWindow {
+			epubs++
+		}
+	}
+	return epubs
+}
+
+// setSmashwordsQuota sets the daily limit of Smashwords for the metrics of a
+// run, and shows what is left of it in its progress, by the epubs its
+// manifest downloaded in the window.
+func setSmashwordsQuota(run *pipeline.Session) {
+	run.Opts.Metrics.SetQuota(smashwordsDailyLimit)
+	run.Opts.Progress.QuotaLeft(source.Quota{
+		Remaining: max(0, smashwordsDailyLimit-epubsInQuotaWindow(run.Manifest, time.Now())),
+		Limit:     smashwordsDailyLimit,
+	})
+}
+
+// syncCategory runs scrape for a category of Smashwords in a process of its
+// own, so a crawl that fails stops only itself, and returns how it went.
+func syncCategory(ctx context.Context, executable string, args []string) (string, error) {
+	cmd := exec.CommandContext(ctx, executable, append([]string{"scrape"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return commandStatus(cmd.Run())
+}
+
+// commandStatus is how a command run in a process of its own went, from the
+// error it exited with: NotifyCompleted, NotifyPartial, NotifyRateLimited or
+// NotifyFailed.
+func commandStatus(err error) (string, error) {
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return pipeline.NotifyCompleted, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == ExitRateLimited:
+		return pipeline.NotifyRateLimited, err
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == ExitPartialFailure:
+		return pipeline.NotifyPartial, nil
+	}
+	return pipeline.NotifyFailed, err
+}
+
+// parseCategories reads the comma separated category ids of -categories.
+func parseCategories(spec string) []string {
+	var categories []string
+	for _, category := range strings.Split(spec, ",") {
+		if category = strings.TrimSpace(category); category != "" {
+			if _, err := strconv.Atoi(category); err != nil {
+				log.Fatalf("Invalid category id %s", category)
+			}
+			categories = append(categories, category)
+		}
+	}
+	if len(categories) == 0 {
+		log.Fatal("Set -categories to the ids of the categories")
+	}
+	return categories
+}
+
+// runDaemon is the daemon command. It stays up and syncs categories of
+// Smashwords on a schedule, running scrape for each, which downloads only
+// the books that are new since the last sync. Epub downloads are kept
+// within the daily limit of Smashwords across syncs and restarts: once it
+// is used up, or Smashwords rate limits a sync, categories are synced in
+// plain text only until it frees up.
+func runDaemon(args []string) {
+	flags := flag.NewFlagSet("daemon", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	schedulePtr := flags.String("schedule", "0 3 * * *",
+		"When to sync, as a cron schedule of minute, hour, day, month and weekday in local time, or @hourly,"+
+			" @daily or @weekly")
+	categoriesPtr := flags.String("categories", "",
+		"Comma separated ids of the Smashwords categories to sync, like 1245,1250")
+	pagesPtr := flags.Int("pages", 7,
+		"The number of pages of every category to sync")
+	formatPtr := flags.String("format", "txt",
+		"The format of the books to download: 'all', 'txt' or 'epub'")
+	quotaPtr := flags.Int("quota", smashwordsDailyLimit,
+		"Epub downloads a day Smashwords allows, which the syncs stay within")
+	nowPtr := flags.Bool("now", false,
+		"Sync once right away, before waiting for the schedule")
+	shardPtr := addShardFlag(flags)
+	parseFlags(flags, args)
+
+	schedule, err := ParseSchedule(*schedulePtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	categories := parseCategories(*categoriesPtr)
+	if *formatPtr != "all" && *formatPtr != "txt" && *formatPtr != "epub" {
+		log.Fatalf("Unsupported format %s", *formatPtr)
+	}
+	if err := os.MkdirAll(*dataDirPtr, 0700); err != nil {
+		log.Fatal(err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// the syncs get the values of the config file for every command
+	var shared []string
+	if config := flags.Lookup("config").Value.String(); config != "" {
+		shared = append(shared, "-config", config)
+	}
+	if _, err := pipeline.ParseShard(*shardPtr); err != nil {
+		log.Fatal(err)
+	} else if *shardPtr != "" {
+		shared = append(shared, "-shard", *shardPtr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	syncAll := func() {
+		state, err := loadDaemonState(*dataDirPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, category := range categories {
+			if ctx.Err() != nil {
+				return
+			}
+			format := *formatPtr
+			if format != "txt" {
+				left, err := epubQuotaLeft(*dataDirPtr, state, *quotaPtr, time.Now())
+				if err != nil {
+					log.Fatal(err)
+				}
+				if left == 0 && format == "epub" {
+					slog.Info("Skipping category until the epub quota frees up", "category_id", category)
+					continue
+				} else if left == 0 {
+					slog.Info("Syncing category in plain text only until the epub quota frees up",
+						"category_id", category)
+					format = "txt"
+				}
+			}
+			slog.Info("Syncing category", "category_id", category, "format", format)
+			syncArgs := append([]string{"-data_dir", *dataDirPtr, "-id", category,
+				"-pages", strconv.Itoa(*pagesPtr), "-format", format, "-progress", pipeline.ProgressLog}, shared...)
+			status, err := syncCategory(ctx, executable, syncArgs)
+			result := &daemonCategory{LastSync: time.Now().UTC(), Status: status}
+			if err != nil {
+				result.Error = err.Error()
+				slog.Warn("Sync of category did not complete", "category_id", category, "status", status,
+					"error", err)
+			}
+			if status == pipeline.NotifyRateLimited {
+				state.RateLimitedAt = result.LastSync
+			}
+			state.Categories[category] = result
+			if err := state.save(*dataDirPtr); err != nil {
+				log.Fatal(err)
+			}
+		}
+		state.LastSync = time.Now().UTC()
+		if err := state.save(*dataDirPtr); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *nowPtr {
+		syncAll()
+	}
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Fatalf("Schedule %q never runs", *schedulePtr)
+		}
+		slog.Info("Waiting for the next sync", "at", next.Format(time.RFC3339))
+		select {
+		case <-time.After(time.Until(next)):
+			syncAll()
+		case <-ctx.Done():
+			slog.Info("Stopped the daemon")
+			return
+		}
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+const (
+	// the minutes of throughput the dashboard graphs
+	activityMinutes = 60
+	// the errors the dashboard lists at most
+	activityMaxErrors = 50
+)
+
+// ThroughputMinute is the books the jobs downloaded and converted in a
+// minute.
+type ThroughputMinute struct {
+	Time       time.Time `json:"time"`
+	Downloaded int       `json:"downloaded"`
+	Converted  int       `json:"converted"`
+}
+
+// JobError is an error of a job, of a book or of the whole run.
+type JobError struct {
+	Time  time.Time `json:"time"`
+	Job   string    `json:"job"`
+	Title string    `json:"title,omitempty"`
+	URL   string    `json:"url,omitempty"`
+	Phase string    `json:"phase,omitempty"`
+	Error string    `json:"error"`
+}
+
+// jobActivity keeps the throughput of the last hour and the latest errors
+// of the jobs of the api command, from their events.
+type jobActivity struct {
+	mu sync.Mutex
+	// by the minute they started, oldest first
+	minutes []ThroughputMinute
+	// newest first
+	errors []JobError
+}
+
+func newJobActivity() *jobActivity {
+	return &jobActivity{}
+}
+
+// record counts an event of a job.
+func (a *jobActivity) record(job string, event pipeline.Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch event.Type {
+	case pipeline.EventDownloadComplete:
+		a.minute(event.Time).Downloaded++
+	case pipeline.EventConvertComplete:
+		a.minute(event.Time).Converted++
+	case pipeline.EventError:
+		a.errors = append([]JobError{{
+			Time:  event.Time,
+			Job:   job,
+			Title: event.Title,
+			URL:   event.URL,
+			Phase: event.Phase,
+			Error: event.Error,
+		}}, a.errors...)
+		if len(a.errors) > activityMaxErrors {
+			a.errors = a.errors[:activityMaxErrors]
+		}
+	}
+}
+
+// minute returns the minute of t, dropping the minutes that fell out of
+// the last hour. The caller holds the lock.
+func (a *jobActivity) minute(t time.Time) *ThroughputMinute {
+	t = t.Truncate(time.Minute)
+	if n := len(a.minutes); n > 0 && a.minutes[n-1].Time.Equal(t) {
+		return &a.minutes[n-1]
+	}
+	a.minutes = append(a.minutes, ThroughputMinute{Time: t})
+	for len(a.minutes) > 0 && t.Sub(a.minutes[0].Time) >= activityMinutes*time.Minute {
+		a.minutes = a.minutes[1:]
+	}
+	return &a.minutes[len(a.minutes)-1]
+}
+
+// throughput is every minute of the last hour up to now, oldest first,
+// with the minutes nothing happened in as zeros.
+func (a *jobActivity) throughput(now time.Time) []ThroughputMinute {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	end := now.UTC().Truncate(time.Minute)
+	minutes := make([]ThroughputMinute, activityMinutes)
+	for i := range minutes {
+		minutes[i].Time = end.Add(time.Duration(i-activityMinutes+1) * time.Minute)
+	}
+	for _, m := range a.minutes {
+		if i := activityMinutes - 1 - int(end.Sub(m.Time)/time.Minute); i >= 0 && i < activityMinutes {
+			minutes[i] = m
+		}
+	}
+	return minutes
+}
+
+// latestErrors are the latest errors, newest first.
+func (a *jobActivity) latestErrors() []JobError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]JobError{}, a.errors...)
+}
+
+// rateLimited records in the state of the daemon that Smashwords rate
+// limited a job, so the quota is used up for the daemon as well.
+func (s *jobServer) rateLimited(at time.Time) {
+	state, err := loadDaemonState(s.dataDir)
+	if err == nil {
+		state.RateLimitedAt = at
+		err = state.save(s.dataDir)
+	}
+	if err != nil {
+		slog.Warn("Could not record the rate limit", "error", err)
+	}
+}
+
+func (s *jobServer) handleThroughput(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"minutes": s.activity.throughput(time.Now())})
+}
+
+func (s *jobServer) handleErrors(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"errors": s.activity.latestErrors()})
+}
+
+// handleQuota has the epub downloads left of the daily quota of Smashwords,
+// counted as the daemon does.
+func (s *jobServer) handleQuota(w http.ResponseWriter, r *http.Request) {
+	state, err := loadDaemonState(s.dataDir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	now := time.Now()
+	left, err := epubQuotaLeft(s.dataDir, state, s.quota, now)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	quota := map[string]any{"quota": s.quota, "left": left}
+	if until := state.RateLimitedAt.Add(smashwordsQuotaWindow); until.After(now) {
+		quota["rate_limited_until"] = until.UTC()
+	}
+	writeJSON(w, http.StatusOK, quota)
+}
+
+func (s *jobServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardPage))
+}
+
+// dashboardPage is the dashboard of the api command, which polls the API
+// for the jobs, the throughput, the quota and the errors, and searches the
+// catalog. With -token it asks for the token once and keeps it in the
+// browser.
+const dashboardPage string = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>smashwords-downloader</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 70em; padding: 0 1em; }
+h2 { margin-top: 1.5em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+td.n { text-align: right; }
+.muted { color: #777; }
+.bar { background: #eee; height: 1.2em; width: 100%; }
+.bar div { background: #4a7; height: 100%; }
+.failed, .rate_limited { color: #b33; }
+.running { color: #37a; }
+svg rect.d { fill: #4a7; }
+svg rect.c { fill: #37a; }
+</style>
+</head>
+<body>
+<h1>smashwords-downloader</h1>
+
+<h2>Jobs</h2>
+<form id="submit">
+<input id="command" value="scrape" size="12">
+<input id="args" placeholder="-id 1245 -pages 2" size="50">
+<button type="submit">Run</button>
+</form>
+<table id="jobs"></table>
+
+<h2>Throughput</h2>
+<p class="muted">Books a minute over the last hour: <span style="color:#4a7">downloaded</span> and
+<span style="color:#37a">converted</span>.</p>
+<svg id="throughput" width="100%" height="120" viewBox="0 0 600 120" preserveAspectRatio="none"></svg>
+
+<h2>Epub quota</h2>
+<div class="bar"><div id="quota-bar"></div></div>
+<p id="quota" class="muted"></p>
+
+<h2>Recent errors</h2>
+<table id="errors"></table>
+
+<h2>Catalog</h2>
+<form id="search">
+<input id="q" type="search" placeholder="Title, author or category" size="40">
+<button type="submit">Search</button>
+</form>
+<p id="total" class="muted"></p>
+<table id="books"></table>
+
+<script>
+function esc(s) {
+  return String(s == null ? "" : s).replace(/[&<>"']/g, c => "&#" + c.charCodeAt(0) + ";");
+}
+
+// the refreshes that get a 401 at once ask for the token once
+let tokenPrompt = null;
+
+async function api(path, options) {
+  options = options || {};
+  const token = localStorage.getItem("token");
+  if (token) {
+    options.headers = Object.assign({}, options.headers, {Authorization: "Bearer " + token});
+  }
+  const resp = await fetch(path, options);
+  if (resp.status === 401) {
+    if (!tokenPrompt) {
+      tokenPrompt = new Promise(resolve => setTimeout(() => {
+        const entered = prompt("Token of the API");
+        if (entered !== null) {
+          localStorage.setItem("token", entered);
+        }
+        tokenPrompt = null;
+        resolve(entered);
+      }));
+    }
+    if (await tokenPrompt === null) {
+      throw new Error("no token");
+    }
+    return api(path, options);
+  }
+  const body = await resp.json();
+  if (!resp.ok) {
+    throw new Error(body.error || resp.statusText);
+  }
+  return body;
+}
+
+async function refreshJobs() {
+  const {jobs} = await api("/jobs");
+  let rows = "<tr><th>id</th><th>command</th><th>status</th><th>discovered</th><th>downloaded</th>" +
+    "<th>converted</th><th>errors</th><th>last book</th><th></th></tr>";
+  for (const job of jobs.slice().reverse()) {
+    const e = job.events;
+    rows += "<tr><td>" + esc(job.id) + "</td><td>" + esc(job.command + " " + job.args.join(" ")) +
+      "</td><td class=\"" + esc(job.status) + "\">" + esc(job.status) +
+      (job.error ? "<br><span class=\"muted\">" + esc(job.error) + "</span>" : "") +
+      "</td><td class=\"n\">" + (e.book_discovered || 0) + "</td><td class=\"n\">" + (e.download_complete || 0) +
+      "</td><td class=\"n\">" + (e.convert_complete || 0) + "</td><td class=\"n\">" + (e.error || 0) +
+      "</td><td>" + esc(job.last_book) + "</td><td>" +
+      (job.status === "queued" || job.status === "running" ?
+        "<button onclick=\"cancelJob('" + esc(job.id) + "')\">cancel</button>" : "") + "</td></tr>";
+  }
+  document.getElementById("jobs").innerHTML = jobs.length ? rows : "<tr><td class=\"muted\">No jobs yet</td></tr>";
+}
+
+async function refreshThroughput() {
+  const {minutes} = await api("/throughput");
+  const most = Math.max(1, ...minutes.map(m => Math.max(m.downloaded, m.converted)));
+  const width = 600 / minutes.length;
+  let bars = "";
+  minutes.forEach((m, i) => {
+    const d = m.downloaded / most * 110, c = m.converted / most * 110;
+    bars += "<rect class=\"d\" x=\"" + (i * width) + "\" y=\"" + (120 - d) + "\" width=\"" + (width / 2) +
+      "\" height=\"" + d + "\"><title>" + esc(m.time) + ": " + m.downloaded + " downloaded</title></rect>";
+    bars += "<rect class=\"c\" x=\"" + (i * width + width / 2) + "\" y=\"" + (120 - c) + "\" width=\"" +
+      (width / 2) + "\" height=\"" + c + "\"><title>" + esc(m.time) + ": " + m.converted + " converted</title></rect>";
+  });
+  document.getElementById("throughput").innerHTML = bars;
+}
+
+async function refreshQuota() {
+  const quota = await api("/quota");
+  document.getElementById("quota-bar").style.width = (quota.left / Math.max(1, quota.quota) * 100) + "%";
+  let text = quota.left + " of " + quota.quota + " epub downloads left in the last 24 hours";
+  if (quota.rate_limited_until) {
+    text += ", rate limited until " + new Date(quota.rate_limited_until).toLocaleString();
+  }
+  document.getElementById("quota").textContent = text;
+}
+
+async function refreshErrors() {
+  const {errors} = await api("/errors");
+  let rows = "<tr><th>time</th><th>job</th><th>book</th><th>phase</th><th>error</th></tr>";
+  for (const e of errors) {
+    rows += "<tr><td>" + esc(new Date(e.time).toLocaleString()) + "</td><td>" + esc(e.job) + "</td><td>" +
+      (e.url ? "<a href=\"" + esc(e.url) + "\">" + esc(e.title || e.url) + "</a>" : esc(e.title)) +
+      "</td><td>" + esc(e.phase) + "</td><td>" + esc(e.error) + "</td></tr>";
+  }
+  document.getElementById("errors").innerHTML = errors.length ? rows : "<tr><td class=\"muted\">No errors</td></tr>";
+}
+
+async function search() {
+  const q = document.getElementById("q").value;
+  const {books, total} = await api("/books?q=" + encodeURIComponent(q));
+  document.getElementById("total").textContent = total + " books";
+  let rows = "<tr><th>title</th><th>author</th><th>language</th><th>words</th></tr>";
+  for (const book of books) {
+    rows += "<tr><td>" + (book.url ? "<a href=\"" + esc(book.url) + "\">" + esc(book.title || book.file) + "</a>" :
+      esc(book.title || book.file)) + "</td><td>" + esc(book.author) + "</td><td>" + esc(book.language) +
+      "</td><td class=\"n\">" + book.words + "</td></tr>";
+  }
+  document.getElementById("books").innerHTML = rows;
+}
+
+async function cancelJob(id) {
+  try {
+    await api("/jobs/" + encodeURIComponent(id), {method: "DELETE"});
+  } catch (err) {
+    alert(err.message);
+  }
+  refreshJobs();
+}
+
+document.getElementById("submit").addEventListener("submit", async event => {
+  event.preventDefault();
+  const args = document.getElementById("args").value.trim();
+  try {
+    await api("/jobs", {method: "POST", body: JSON.stringify({
+      command: document.getElementById("command").value.trim(),
+      args: args ? args.split(/\s+/) : [],
+    })});
+    document.getElementById("args").value = "";
+  } catch (err) {
+    alert(err.message);
+  }
+  refreshJobs();
+});
+
+document.getElementById("search").addEventListener("submit", event => {
+  event.preventDefault();
+  search();
+});
+
+function refresh() {
+  for (const f of [refreshJobs, refreshThroughput, refreshQuota, refreshErrors]) {
+    f().catch(err => console.error(err));
+  }
+}
+
+refresh();
+search();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+const nearDuplicatesFileName string = "near_duplicates.jsonl"
+
+// runDedupe is the dedupe command. It hashes every text in a data directory
+// and moves all but the first of each set of exact duplicates to the rejects
+// directory, marking them in the manifest. With -near it also clusters near
+// duplicates and writes them to near_duplicates.jsonl.
+func runDedupe(args []string) {
+	flags := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the book files to deduplicate")
+	dryRunPtr := flags.Bool("dry-run", false,
+		"Only print the duplicates instead of moving them")
+	nearPtr := flags.Float64("near", 0,
+		"Also find near duplicates: books whose estimated Jaccard similarity or containment (0-1) is at least"+
+			" this. 0 only looks for exact duplicates")
+	keepOnePtr := flags.Bool("keep-one", false,
+		"Move every near duplicate but the longest book of its cluster to the rejects folder")
+	parseFlags(flags, args)
+
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	journal, err := pipeline.LoadJournal(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	manifest.UseJournal(journal)
+
+	// os.ReadDir sorts by name, so which copy is kept doesn't depend on the
+	// order the books were downloaded in
+	files, err := os.ReadDir(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	kept := make(map[string]string)
+	minHashes := make(map[string]*MinHash)
+	// books go by the name of their .txt file, whether or not it was
+	// written compressed
+	paths := make(map[string]string)
+	duplicates := 0
+	for _, file := range files {
+		if file.IsDir() || !pipeline.IsTextFile(file.Name()) {
+			continue
+		}
+		name := pipeline.TrimCompressionExt(file.Name())
+		paths[name] = file.Name()
+		data, err := pipeline.ReadTextFile(filepath.Join(*dataDirPtr, file.Name()))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		record := manifest.Get(name)
+		if record == nil {
+			record = &pipeline.ManifestRecord{File: name, Format: "txt", Chars: len(data), Words: pipeline.CountWords(string(data))}
+		}
+		record.SHA256 = pipeline.ContentHash(string(data))
+
+		original, ok := kept[record.SHA256]
+		if !ok {
+			kept[record.SHA256] = name
+			manifest.Put(record)
+			if *nearPtr > 0 {
+				minHashes[name] = NewMinHash(string(data))
+			}
+			continue
+		}
+
+		duplicates++
+		slog.Info("Found a duplicate", "file", name, "original", original)
+		if *dryRunPtr {
+			continue
+		}
+		if err := moveToRejects(*dataDirPtr, file.Name()); err != nil {
+			log.Fatal(err)
+		}
+		record.Rejected = "duplicate: " + original
+		manifest.Put(record)
+	}
+
+	if *nearPtr > 0 {
+		clusters := FindNearDuplicates(minHashes, *nearPtr)
+		if err := writeNearDuplicates(filepath.Join(*dataDirPtr, nearDuplicatesFileName), clusters); err != nil {
+			log.Fatal(err)
+		}
+		slog.Info("Found clusters of near duplicates", "clusters", len(clusters), "file", nearDuplicatesFileName)
+
+		for _, cluster := range clusters {
+			for _, member := range cluster.Members {
+				if member == cluster.Representative || !*keepOnePtr {
+					continue
+				}
+				duplicates++
+				slog.Info("Found a near duplicate", "file", member, "original", cluster.Representative)
+				if *dryRunPtr {
+					continue
+				}
+				if err := moveToRejects(*dataDirPtr, paths[member]); err != nil {
+					log.Fatal(err)
+				}
+				record := manifest.Get(member)
+				record.Rejected = "near_duplicate: " + cluster.Representative
+				manifest.Put(record)
+			}
+		}
+	}
+
+	if *dryRunPtr {
+		slog.Info("Found duplicates", "duplicates", duplicates)
+		return
+	}
+	if err := manifest.Save(); err != nil {
+		log.Fatal(err)
+	}
+	if err := pipeline.WriteChecksums(*dataDirPtr); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Moved duplicates", "duplicates", duplicates, "path", filepath.Join(*dataDirPtr, pipeline.RejectsDirName))
+}
+
+func moveToRejects(dataDir string, file string) error {
+	if err := os.MkdirAll(filepath.Join(dataDir, pipeline.RejectsDirName), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(filepath.Join(dataDir, file), filepath.Join(dataDir, pipeline.RejectsDirName, file)); err != nil {
+		return err
+	}
+	// the metadata of the book goes along with it
+	sidecar := pipeline.SidecarName(pipeline.TrimCompressionExt(file))
+	err := os.Rename(filepath.Join(dataDir, sidecar), filepath.Join(dataDir, pipeline.RejectsDirName, sidecar))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// writeNearDuplicates writes the clusters of near duplicates as JSON lines.
+func writeNearDuplicates(path string, clusters []NearDuplicateCluster) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, cluster := range clusters {
+		if err := encoder.Encode(cluster); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
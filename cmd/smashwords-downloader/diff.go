@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// DatasetDiff is how the datasets of two data directories differ, by the
+// stable ids of their books.
+type DatasetDiff struct {
+	// books only in one of the datasets
+	OnlyA []*pipeline.ManifestRecord
+	OnlyB []*pipeline.ManifestRecord
+	// books in both whose text differs, as they are in a and in b
+	Changed [][2]*pipeline.ManifestRecord
+	// books with the same text under a different id, like a book downloaded
+	// before there were ids and again after
+	Renamed [][2]*pipeline.ManifestRecord
+	// books that are the same in both
+	Same int
+}
+
+// DiffManifests compares the books in the datasets of two manifests.
+func DiffManifests(a *pipeline.Manifest, b *pipeline.Manifest) *DatasetDiff {
+	booksA, booksB := datasetBooks(a), datasetBooks(b)
+	diff := &DatasetDiff{}
+	var onlyA, onlyB []*pipeline.ManifestRecord
+	for _, id := range sortedIDs(booksA) {
+		recordA := booksA[id]
+		recordB, ok := booksB[id]
+		switch {
+		case !ok:
+			onlyA = append(onlyA, recordA)
+		case recordA.SHA256 != recordB.SHA256:
+			diff.Changed = append(diff.Changed, [2]*pipeline.ManifestRecord{recordA, recordB})
+		default:
+			diff.Same++
+		}
+	}
+	for _, id := range sortedIDs(booksB) {
+		if _, ok := booksA[id]; !ok {
+			onlyB = append(onlyB, booksB[id])
+		}
+	}
+
+	// the books only on one side may be on the other under another id
+	hashesB := make(map[string]*pipeline.ManifestRecord)
+	for _, record := range onlyB {
+		if record.SHA256 != "" {
+			hashesB[record.SHA256] = record
+		}
+	}
+	renamedB := make(map[*pipeline.ManifestRecord]bool)
+	for _, record := range onlyA {
+		if other, ok := hashesB[record.SHA256]; ok && record.SHA256 != "" && !renamedB[other] {
+			diff.Renamed = append(diff.Renamed, [2]*pipeline.ManifestRecord{record, other})
+			renamedB[other] = true
+		} else {
+			diff.OnlyA = append(diff.OnlyA, record)
+		}
+	}
+	for _, record := range onlyB {
+		if !renamedB[record] {
+			diff.OnlyB = append(diff.OnlyB, record)
+		}
+	}
+	return diff
+}
+
+// datasetBooks returns the books in the dataset of a manifest by id.
+func datasetBooks(manifest *pipeline.Manifest) map[string]*pipeline.ManifestRecord {
+	books := make(map[string]*pipeline.ManifestRecord)
+	for _, record := range manifest.Records() {
+		if pipeline.InDataset(record) {
+			books[record.DocumentID()] = record
+		}
+	}
+	return books
+}
+
+func sortedIDs(books map[string]*pipeline.ManifestRecord) []string {
+	ids := make([]string, 0, len(books))
+	for id := range books {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Empty reports whether the datasets have the same books.
+func (d *DatasetDiff) Empty() bool {
+	return len(d.OnlyA)+len(d.OnlyB)+len(d.Changed)+len(d.Renamed) == 0
+}
+
+// runDiff is the diff command. It compares the datasets of two data
+// directories by their manifests, like a re-crawl against an earlier
+// snapshot, and exits with status 1 if they differ.
+func runDiff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: smashwords-downloader diff <data_dir a> <data_dir b>")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+
+	dirA, dirB := flags.Arg(0), flags.Arg(1)
+	a, err := pipeline.LoadManifest(dirA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := pipeline.LoadManifest(dirB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	diff := DiffManifests(a, b)
+
+	for _, record := range diff.OnlyA {
+		fmt.Printf("- %s  %s\n", record.DocumentID(), record.File)
+	}
+	for _, record := range diff.OnlyB {
+		fmt.Printf("+ %s  %s\n", record.DocumentID(), record.File)
+	}
+	for _, pair := range diff.Changed {
+		fmt.Printf("~ %s  %s (%d words) -> %s (%d words)\n",
+			pair[0].DocumentID(), pair[0].File, pair[0].Words, pair[1].File, pair[1].Words)
+	}
+	for _, pair := range diff.Renamed {
+		fmt.Printf("= %s -> %s\n", pair[0].DocumentID(), pair[1].DocumentID())
+	}
+	slog.Info("Compared datasets", "a", dirA, "b", dirB, "only_a", len(diff.OnlyA), "only_b", len(diff.OnlyB),
+		"changed", len(diff.Changed), "renamed", len(diff.Renamed), "same", diff.Same)
+	if !diff.Empty() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// how long a worker waits for a task before it checks whether to stop
+const workerPollInterval = 5 * time.Second
+
+// addQueueFlags adds the flags of the work queue a distributed crawl
+// shares to flags.
+func addQueueFlags(flags *flag.FlagSet) (queueURL *string, queueName *string) {
+	queueURL = flags.String("queue", "",
+		"URL of the work queue the coordinator and workers share, like redis://:password@host:6379/0"+
+			" (rediss:// for TLS)")
+	queueName = flags.String("queue-name", "smashdl",
+		"Name of the crawl in the queue, so crawls can share it. Books queued under a name once aren't"+
+			" queued again")
+	return queueURL, queueName
+}
+
+// runCoordinator is the coordinator command. It runs the discovery of a
+// source, like scrape does, but pushes the books it finds to the work queue
+// for workers on other hosts instead of downloading them.
+func runCoordinator(args []string) {
+	name := "smashwords"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	factory, ok := source.Lookup(name)
+	if !ok {
+		log.Fatalf("Unknown source %s, options are %s", name, strings.Join(source.Names(), ", "))
+	}
+	flags := flag.NewFlagSet("coordinator", flag.ExitOnError)
+	newSource := factory(flags)
+	queueURL, queueName := addQueueFlags(flags)
+	progressPtr := flags.String("progress", pipeline.ProgressAuto,
+		"How to show the progress of the discovery. Options are 'bars', 'log', 'auto' or 'none'")
+	parseFlags(flags, args)
+
+	if *queueURL == "" {
+		log.Fatal("Set -queue to the URL of the work queue")
+	}
+	src, err := newSource()
+	if err != nil {
+		fatalUsage(err)
+	}
+	queue, err := OpenWorkQueue(*queueURL, *queueName, "coordinator")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer queue.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	progress, err := pipeline.NewProgress(*progressPtr, pipeline.PhaseListed, pipeline.PhaseDiscovered)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if progress != nil {
+		ctx = source.WithProgress(ctx, progress)
+	}
+	var mu sync.Mutex
+	queued, known := 0, 0
+	err = src.Discover(ctx, func(book *source.Book) error {
+		progress.Done(pipeline.PhaseDiscovered, 1)
+		added, err := queue.Push(ctx, &Task{Source: name, Book: book, Queued: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if added {
+			queued++
+			slog.Debug("Queued book", "book_id", book.ID, "title", book.Title)
+		} else {
+			known++
+		}
+		return nil
+	})
+	progress.Stop()
+	if err != nil {
+		log.Fatal(err)
+	}
+	waiting, err := queue.Len(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Queued the books of the source", "queued", queued, "queued_before", known, "waiting", waiting)
+}
+
+// runWorker is the worker command. It pulls books from the work queue and
+// downloads and converts them into its data directory, like scrape does,
+// until it is stopped, or the queue is empty with -drain. A worker that is
+// rate limited puts the book back for the other workers and stops.
+func runWorker(args []string) {
+	flags := flag.NewFlagSet("worker", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	queueURL, queueName := addQueueFlags(flags)
+	hostname, _ := os.Hostname()
+	workerPtr := flags.String("worker", hostname,
+		"Name of the worker, unique among the workers of the crawl. A worker restarted under the same name"+
+			" picks up the books it didn't finish")
+	drainPtr := flags.Bool("drain", false,
+		"Stop once the queue is empty, instead of waiting for more books")
+	conv := addConvertFlags(flags)
+	out := addOutputFlags(flags)
+	streamPtr := addStreamFlag(flags)
+	parseFlags(flags, args)
+
+	if *queueURL == "" {
+		log.Fatal("Set -queue to the URL of the work queue")
+	}
+	if *workerPtr == "" {
+		log.Fatal("Set -worker to the name of the worker")
+	}
+	queue, err := OpenWorkQueue(*queueURL, *queueName, *workerPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer queue.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if recovered, err := queue.Recover(ctx); err != nil {
+		log.Fatal(err)
+	} else if recovered > 0 {
+		slog.Info("Put back the books of the worker it didn't finish", "books", recovered)
+	}
+	slog.Info("Saving files", "data_dir", *dataDirPtr)
+
+	run := openDatasetRun(*dataDirPtr, flags, conv, out)
+	run.Opts.Progress = conv.startProgress(pipeline.PhaseDownloaded, pipeline.PhaseConverted)
+	setSmashwordsQuota(run)
+	run.Opts.Stream = parseStreamFlag(*streamPtr)
+	// the sources are made with the defaults of their flags, which is all
+	// their Fetch needs
+	sources := make(map[string]source.Source)
+	sourceOf := func(name string) (source.Source, error) {
+		if src, ok := sources[name]; ok {
+			return src, nil
+		}
+		factory, ok := source.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown source %s", name)
+		}
+		newSource := factory(flag.NewFlagSet(name, flag.ContinueOnError))
+		src, err := newSource()
+		if err != nil {
+			return nil, err
+		}
+		sources[name] = src
+		return src, nil
+	}
+
+	epubs := false
+	for ctx.Err() == nil {
+		task, err := queue.Pop(ctx, workerPollInterval)
+		if err != nil && ctx.Err() == nil {
+			slog.Warn("Could not take a book from the queue", "error", err)
+			time.Sleep(workerPollInterval)
+			continue
+		}
+		if task == nil {
+			if *drainPtr && ctx.Err() == nil {
+				if waiting, err := queue.Len(ctx); err == nil && waiting == 0 {
+					slog.Info("The queue is empty")
+					break
+				}
+			}
+			continue
+		}
+		src, err := sourceOf(task.Source)
+		if err != nil {
+			slog.Warn("Dropping a book the worker can't download", "book_id", task.Book.ID, "error", err)
+			queue.Ack(ctx, task)
+			continue
+		}
+		if task.Book.Format == "epub" {
+			epubs = true
+		}
+		run.Opts.Progress.Add(pipeline.PhaseDownloaded, 1)
+		err = run.Fetch(ctx, src, task.Book)
+		run.Opts.Progress.Done(pipeline.PhaseDownloaded, 1)
+		if err != nil || ctx.Err() != nil {
+			// another worker downloads the book
+			if returnErr := queue.Return(context.Background(), task); returnErr != nil {
+				slog.Error("Could not put the book back in the queue", "book_id", task.Book.ID, "error", returnErr)
+			}
+			if errors.Is(err, source.ErrRateLimited) {
+				abortRun(run, err)
+			}
+			continue
+		}
+		if err := queue.Ack(ctx, task); err != nil {
+			slog.Warn("Could not acknowledge the book", "book_id", task.Book.ID, "error", err)
+		}
+	}
+	finishRun(ctx, run, epubs)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// the exit statuses of the commands, so the scripts and schedulers that run
+// them can tell what to do next
+const (
+	// the run completed and every book made it
+	ExitOK = 0
+	// an error stopped the run
+	ExitFatal = 1
+	// the command line or the config file is wrong, running it again won't
+	// help
+	ExitUsage = 2
+	// the site stopped the run with its rate limit, running it again later
+	// picks up where it stopped
+	ExitRateLimited = 3
+	// the run completed, but some books failed and are in the errors of the
+	// catalog
+	ExitPartialFailure = 4
+)
+
+// fatalUsage stops a command whose flags or config file are wrong with
+// ExitUsage.
+func fatalUsage(v ...any) {
+	log.Print(v...)
+	os.Exit(ExitUsage)
+}
+
+// exitRateLimited stops a run the site rate limited with ExitRateLimited.
+func exitRateLimited(message string) {
+	log.Print(message)
+	os.Exit(ExitRateLimited)
+}
+
+// exitPartialFailure ends a run that completed with books that failed with
+// ExitPartialFailure.
+func exitPartialFailure(failed int) {
+	slog.Warn("Completed with books that failed, see the errors of the catalog", "failed", failed)
+	os.Exit(ExitPartialFailure)
+}
+
+// finishRun finishes a run, converting its epubs until ctx is done, and
+// exits with ExitPartialFailure if books failed in it. A run whose ctx is
+// done already was interrupted, and is aborted instead.
+func finishRun(ctx context.Context, run *pipeline.Session, convertEpubs bool) {
+	if err := ctx.Err(); err != nil {
+		abortRun(run, err)
+	}
+	exitOn(run, run.Finish(ctx, convertEpubs))
+}
+
+// abortRun ends a run a crawl error stopped, and the command with it.
+func abortRun(run *pipeline.Session, err error) {
+	exitOn(run, run.Abort(err))
+}
+
+// exitOn stops the command of a run with the exit status of an error it
+// ended with, if any.
+func exitOn(run *pipeline.Session, err error) {
+	var partial *pipeline.PartialFailure
+	switch {
+	case err == nil:
+		return
+	case errors.As(err, &partial):
+		exitPartialFailure(partial.Failed)
+	case errors.Is(err, context.Canceled):
+		log.Fatal("Interrupted, running it again picks up where it stopped")
+	case errors.Is(err, source.ErrRateLimited):
+		if name := run.Flags.Name(); name == "scrape" || name == "smashwords" {
+			var limited *source.RateLimitError
+			if errors.As(err, &limited) && limited.RetryAfter > 0 {
+				exitRateLimited(fmt.Sprintf("Rate limited by smashwords. Please try again in %s. (up to 500/24 hours)",
+					limited.RetryAfter))
+			}
+			exitRateLimited("Rate limited by smashwords. Please try again later. (up to 500/24 hours)")
+		}
+		exitRateLimited(err.Error())
+	}
+	log.Fatal(err)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+// runExport is the export command. It writes the books of a data directory
+// that made it into the dataset to shards, in order of their file names or
+// shuffled with a seed, so the same data directory always gives the same
+// shards.
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the book files to export")
+	outDirPtr := flags.String("out_dir", "",
+		"directory or s3://, gs://, az://, sftp://, dav:// or davs:// URL to write the shards to (default <data_dir>/export)")
+	formatPtr := flags.String("format", pipeline.OutputJSONL,
+		"Format of the shards. Options are 'jsonl', 'jsonl.zst', 'parquet', 'tar.gz' or 'zip'")
+	shardSizePtr := flags.String("shard-size", "1GB",
+		"Start a new shard before one grows past this much uncompressed text (e.g. 1GB, 500MB). 0 for one shard")
+	splitsPtr := flags.String("splits", "",
+		"Weights of the train, validation and test splits (e.g. 98,1,1), each written to a folder of its own."+
+			" Empty writes every book to one set of shards")
+	splitSeedPtr := flags.Int64("split-seed", 0,
+		"Seed for assigning books to splits")
+	shufflePtr := flags.Bool("shuffle", false,
+		"Shuffle the order of the books in the shards instead of ordering them by file name")
+	shuffleSeedPtr := flags.Int64("shuffle-seed", 0,
+		"Seed for shuffling the books")
+	sidecarsPtr := flags.Bool("sidecars", false,
+		"Put a <book>.json file with the metadata of every book next to its .txt file in tar.gz and zip shards")
+	yamlHeaderPtr := flags.Bool("yaml-header", false,
+		"Start the .txt file of every book in tar.gz and zip shards with a YAML header with its metadata")
+	parseFlags(flags, args)
+
+	switch *formatPtr {
+	case pipeline.OutputJSONL, pipeline.OutputJSONLZstd, pipeline.OutputParquet, pipeline.OutputTarGz, pipeline.OutputZip:
+	default:
+		log.Fatalf("Unsupported export format %s", *formatPtr)
+	}
+	shardSize, err := pipeline.ParseSize(*shardSizePtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sinkOpts := pipeline.SinkOptions{ShardSize: shardSize, Sidecars: *sidecarsPtr, YAMLHeader: *yamlHeaderPtr}
+	outDir := *outDirPtr
+	if outDir == "" {
+		outDir = filepath.Join(*dataDirPtr, "export")
+	}
+
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// every split gets a sink of its own
+	var splits []Split
+	sinks := make(map[string]pipeline.Sink)
+	if *splitsPtr != "" {
+		splits, err = ParseSplits(*splitsPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, split := range splits {
+			sinks[split.Name] = openExportSink(storage.Join(outDir, split.Name), *formatPtr, sinkOpts)
+		}
+	} else {
+		sinks[""] = openExportSink(outDir, *formatPtr, sinkOpts)
+	}
+
+	records := manifest.Records()
+	if *shufflePtr {
+		ShuffleRecords(records, *shuffleSeedPtr)
+	}
+
+	books := make(map[string]int)
+	for _, record := range records {
+		text, err := readBook(*dataDirPtr, record)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if text == "" {
+			continue
+		}
+		split := ""
+		if splits != nil {
+			split = AssignSplit(splits, pipeline.NewDocument(record, "").ID, *splitSeedPtr)
+		}
+		if err := sinks[split].Write(record, text); err != nil {
+			log.Fatal(err)
+		}
+		books[split]++
+	}
+	for split, sink := range sinks {
+		if err := sink.Close(); err != nil {
+			log.Fatal(err)
+		}
+		slog.Info("Exported books", "books", books[split], "path", storage.Join(outDir, split))
+	}
+
+	// an export outside the data directory gets checksums of its own
+	if err := pipeline.WriteChecksums(*dataDirPtr); err != nil {
+		log.Fatal(err)
+	}
+	if rel, err := filepath.Rel(*dataDirPtr, outDir); !storage.IsURL(outDir) && (err != nil || strings.HasPrefix(rel, "..")) {
+		if err := pipeline.WriteChecksums(outDir); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// openExportSink opens the sink for the shards of an export, refusing to mix
+// them with the shards of an earlier export.
+func openExportSink(location string, format string, opts pipeline.SinkOptions) pipeline.Sink {
+	store, err := storage.Open(location)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if exists, err := store.Exists(pipeline.ShardIndexFileName); err != nil {
+		log.Fatal(err)
+	} else if exists {
+		log.Fatalf("%s already has an export in it, remove it first", location)
+	}
+	sink, err := pipeline.NewSink(format, store, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return sink
+}
+
+// readBook reads the text of a book in the dataset from its .txt file, or
+// its compressed .txt.gz or .txt.zst file. It returns "" for books that were
+// rejected or have no file of their own.
+func readBook(dataDir string, record *pipeline.ManifestRecord) (string, error) {
+	if record.Rejected != "" || record.Dataset != "" || filepath.Ext(record.File) != ".txt" {
+		return "", nil
+	}
+	path, err := pipeline.FindTextFile(filepath.Join(dataDir, record.File))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	data, err := pipeline.ReadTextFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", record.File, err)
+	}
+	return string(data), nil
+}
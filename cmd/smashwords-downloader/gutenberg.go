@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+const (
+	// the offline catalog of Project Gutenberg, updated every week
+	gutenbergCatalogURL string = "https://www.gutenberg.org/cache/epub/feeds/pg_catalog.csv"
+	gutenbergMirror     string = "https://www.gutenberg.org"
+
+	// Project Gutenberg only has books in the public domain in the USA; its
+	// catalog doesn't say which are copyrighted elsewhere
+	gutenbergLicense string = "This ebook is in the public domain in the USA."
+)
+
+// GutenbergBook is a row of the offline catalog of Project Gutenberg.
+type GutenbergBook struct {
+	// the ebook number, like 1342
+	Number   string
+	Type     string
+	Title    string
+	Language []string
+	// "Last, First, 1775-1817" separated by semicolons
+	Authors     string
+	Subjects    []string
+	Bookshelves []string
+}
+
+// splitCatalogList splits a field of the catalog that lists several values.
+func splitCatalogList(field string) []string {
+	var values []string
+	for _, value := range strings.Split(field, ";") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// ReadGutenbergCatalog reads pg_catalog.csv, finding its columns by the
+// header.
+func ReadGutenbergCatalog(r io.Reader) ([]GutenbergBook, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimPrefix(name, "\ufeff")] = i
+	}
+	if _, ok := columns["Text#"]; !ok {
+		return nil, fmt.Errorf("no Text# column in the catalog")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	var books []GutenbergBook
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		var shelves []string
+		for _, shelf := range splitCatalogList(field(row, "Bookshelves")) {
+			shelves = append(shelves, strings.TrimPrefix(shelf, "Category: "))
+		}
+		books = append(books, GutenbergBook{
+			Number:      field(row, "Text#"),
+			Type:        field(row, "Type"),
+			Title:       strings.Join(strings.Fields(field(row, "Title")), " "),
+			Language:    splitCatalogList(field(row, "Language")),
+			Authors:     field(row, "Authors"),
+			Subjects:    splitCatalogList(field(row, "Subjects")),
+			Bookshelves: shelves,
+		})
+	}
+	return books, nil
+}
+
+// the life dates and role of an author in the catalog
+var gutenbergAuthorExtrasRegex = regexp.MustCompile(`(,\s*[0-9?]*\s*(BCE|CE|BC|AD)?\s*-\s*[0-9?]*\s*(BCE|CE|BC|AD)?|\s*\[[^\]]*\])+\s*$`)
+
+// gutenbergAuthor returns the first author of a book as "First Last".
+func gutenbergAuthor(authors string) string {
+	list := splitCatalogList(authors)
+	if len(list) == 0 {
+		return ""
+	}
+	author := gutenbergAuthorExtrasRegex.ReplaceAllString(list[0], "")
+	if last, first, ok := strings.Cut(author, ", "); ok {
+		return strings.TrimSpace(first) + " " + strings.TrimSpace(last)
+	}
+	return author
+}
+
+// BookInfo is what the catalog says about a book, like the book page of a
+// Smashwords book. Its bookshelves are its categories, or its subjects if it
+// is on none.
+func (b GutenbergBook) BookInfo() pipeline.BookInfo {
+	categories := b.Bookshelves
+	if len(categories) == 0 {
+		categories = b.Subjects
+	}
+	return pipeline.BookInfo{
+		Title:      b.Title,
+		Author:     gutenbergAuthor(b.Authors),
+		URL:        gutenbergMirror + "/ebooks/" + b.Number,
+		Categories: categories,
+		Price:      "0",
+		License:    gutenbergLicense,
+	}
+}
+
+// MakeGutenbergDocumentID returns the stable id of a Project Gutenberg book
+// in a format, like gutenberg-1342-txt.
+func MakeGutenbergDocumentID(number string, format string) string {
+	return fmt.Sprintf("gutenberg-%s-%s", number, format)
+}
+
+// gutenbergDownloadURL is where a mirror has a book in a format, in the
+// layout of gutenberg.org/cache/epub.
+func gutenbergDownloadURL(mirror string, number string, format string) string {
+	return fmt.Sprintf("%s/cache/epub/%s/pg%s.%s", strings.TrimSuffix(mirror, "/"), number, number, format)
+}
+
+// openGutenbergCatalog opens the catalog at a path or URL.
+func openGutenbergCatalog(ctx context.Context, location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return pipeline.OpenFile(location)
+	}
+	resp, err := httpGet(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("getting %s: %s", location, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// downloadGutenbergBook downloads a book in a format and converts it like a
+// Smashwords download: plain text right away, epubs once they are all
+// downloaded. A download ctx stopped is left for the next run.
+func downloadGutenbergBook(ctx context.Context, book GutenbergBook, mirror string, format string, dataDir string, manifest *pipeline.Manifest, sink pipeline.Sink, opts pipeline.ConvertOptions) {
+	info := book.BookInfo()
+	docID := MakeGutenbergDocumentID(book.Number, format)
+	textFileName := docID + ".txt"
+	fileName := docID + "." + format
+	for _, known := range []string{textFileName, textFileName + pipeline.UndecodableSuffix} {
+		if record := manifest.Get(known); record != nil {
+			opts.Report.Skip(info.Title, known, "exists: "+format)
+			return
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, fileName)); err == nil {
+		opts.Report.Skip(info.Title, fileName, "exists: "+format)
+		return
+	}
+
+	url := gutenbergDownloadURL(mirror, book.Number, format)
+	partialFilePath := filepath.Join(dataDir, fileName+".part")
+	err := downloadFile(ctx, httpGet, url, partialFilePath)
+	if err == nil && format == "epub" {
+		err = pipeline.ValidateEpub(partialFilePath)
+	}
+	if err != nil {
+		os.Remove(partialFilePath)
+		if ctx.Err() != nil {
+			return
+		}
+		pipeline.BookLogger(docID, url, pipeline.LogPhaseDownload).Warn("Could not download book", "title", info.Title, "error", err)
+		opts.Report.Fail(info.Title, fileName, "download", err.Error())
+		if err := manifest.LogError(fileName, "download", err.Error()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	downloadedAt := time.Now().UTC()
+
+	if format == "txt" {
+		if err := pipeline.ConvertTextDownload(ctx, info, docID, fileName, partialFilePath, url, downloadedAt, dataDir, manifest, sink, opts); err != nil {
+			return
+		}
+	} else {
+		manifest.Put(&pipeline.ManifestRecord{
+			File:         textFileName,
+			ID:           docID,
+			Source:       fileName,
+			Format:       format,
+			BookInfo:     info,
+			DownloadedAt: downloadedAt,
+			Provenance:   opts.Provenance.ForSource(url),
+		})
+		if err := os.Rename(partialFilePath, filepath.Join(dataDir, fileName)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	pipeline.BookLogger(docID, url, pipeline.LogPhaseDownload).Info("Downloaded book", "title", info.Title)
+}
+
+// runGutenberg is the gutenberg command. It picks books out of the offline
+// catalog of Project Gutenberg, downloads them from a mirror and puts them
+// through the same conversion, filters and manifest as Smashwords books.
+// Their stable ids are gutenberg-<number>-<format>.
+func runGutenberg(args []string) {
+	flags := flag.NewFlagSet("gutenberg", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	catalogPtr := flags.String("catalog", gutenbergCatalogURL,
+		"Path or URL of pg_catalog.csv, the offline catalog of Project Gutenberg (.gz or .zst compressed files work too)")
+	mirrorPtr := flags.String("mirror", gutenbergMirror,
+		"Project Gutenberg mirror to download the books from, with the layout of gutenberg.org/cache/epub")
+	languagePtr := flags.String("language", "en",
+		"Comma separated languages of the catalog to download books in (e.g. en,fr). Empty for every language")
+	idsPtr := flags.String("ids", "",
+		"Comma separated ebook numbers to download (e.g. 1342,84) instead of every book in the languages")
+	limitPtr := flags.Int("limit", 100,
+		"The number of books to download. 0 for every book of the catalog")
+	textFormatPtr := flags.String("format", "txt",
+		"The format of the books to download. Options are 'txt' or 'epub'")
+	delayPtr := flags.Duration("delay", 2*time.Second,
+		"Time to wait between downloads, which Project Gutenberg asks of robots")
+	minWordsPtr := flags.Int("min-words", 0,
+		"Reject books with fewer words than this after conversion. 0 for no minimum")
+	dedupePtr := flags.Bool("dedupe", true,
+		"Reject books whose text is an exact duplicate of a book already in the data directory")
+	parseFlags(flags, args)
+	if *textFormatPtr != "txt" && *textFormatPtr != "epub" {
+		log.Fatalf("Unsupported format %s", *textFormatPtr)
+	}
+	dataDir := *dataDirPtr
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	r, err := openGutenbergCatalog(ctx, *catalogPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	books, err := ReadGutenbergCatalog(r)
+	r.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, id := range strings.Split(*idsPtr, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[id] = true
+		}
+	}
+	languages := pipeline.ParseLanguages(*languagePtr)
+	var selected []GutenbergBook
+	for _, book := range books {
+		if len(ids) > 0 {
+			if ids[book.Number] {
+				selected = append(selected, book)
+			}
+			continue
+		}
+		// the catalog also lists audio books and music
+		if book.Type != "Text" || !anyLanguage(book.Language, languages) {
+			continue
+		}
+		selected = append(selected, book)
+	}
+	if *limitPtr > 0 && len(selected) > *limitPtr {
+		selected = selected[:*limitPtr]
+	}
+	slog.Info("Downloading books of the catalog", "books", len(selected), "catalog_books", len(books), "data_dir", dataDir)
+
+	run := startSourceRun(dataDir, pipeline.OutputTxt, flags)
+	run.Opts.MinWords = *minWordsPtr
+	run.Opts.Dedupe = *dedupePtr
+	for i, book := range selected {
+		if i > 0 {
+			sleep(ctx, *delayPtr)
+		}
+		if ctx.Err() != nil {
+			abortRun(run, ctx.Err())
+		}
+		downloadGutenbergBook(ctx, book, *mirrorPtr, *textFormatPtr, dataDir, run.Manifest, run.Sink, run.Opts)
+	}
+	finishRun(ctx, run, *textFormatPtr == "epub")
+}
+
+// anyLanguage reports whether a book in several languages is in any of the
+// languages to keep, see KeepLanguage.
+func anyLanguage(book []string, keep []string) bool {
+	for _, language := range book {
+		if pipeline.KeepLanguage(language, keep) {
+			return true
+		}
+	}
+	return len(keep) == 0
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+// hookFlags are the flags of the hooks of a run.
+type hookFlags struct {
+	perBook *string
+	end     *string
+}
+
+// addHookFlags adds the flags of the hooks to a flag set.
+func addHookFlags(flags *flag.FlagSet) *hookFlags {
+	return &hookFlags{
+		perBook: flags.String("exec-per-book", "",
+			"Shell command to run for every book written to the dataset, like 'cmd {path} {id}'. {path} is the"+
+				" file of the book (the dataset shard it was added to with the jsonl, parquet and archive outputs,"+
+				" or its URL with remote storage), {id} its stable id and {title} its title"),
+		end: flags.String("exec-end", "",
+			"Shell command to run when the run ends, like 'cmd {status} {data_dir}'. {status} is how it ended"+
+				" (completed, completed_with_failures, failed or rate_limited) and {data_dir} the data directory"),
+	}
+}
+
+// hooks is the hooks of the parsed flags for a run writing to store, or nil
+// without commands.
+func (f *hookFlags) hooks(dataDir string, store storage.Writer, compression string) *pipeline.Hooks {
+	if *f.perBook == "" && *f.end == "" {
+		return nil
+	}
+	return pipeline.NewHooks(*f.perBook, *f.end, dataDir, store, compression)
+}
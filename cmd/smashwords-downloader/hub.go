@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+const defaultHubEndpoint string = "https://huggingface.co"
+
+// HubClient talks to the Hugging Face Hub HTTP API, just enough to create a
+// dataset repo and commit files to it.
+type HubClient struct {
+	Endpoint string
+	Token    string
+	Client   *http.Client
+}
+
+// HubFile is a file to commit to a repo. Small files are sent in the commit
+// itself, the shards go to LFS storage first.
+type HubFile struct {
+	// path in the repo
+	Path string
+	// file on disk, or the content of the file if LocalPath is empty
+	LocalPath string
+	Content   []byte
+	LFS       bool
+}
+
+// NewHubClient returns a client for the Hub at $HF_ENDPOINT, or
+// huggingface.co.
+func NewHubClient(token string) *HubClient {
+	endpoint := os.Getenv("HF_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultHubEndpoint
+	}
+	return &HubClient{Endpoint: strings.TrimSuffix(endpoint, "/"), Token: token, Client: http.DefaultClient}
+}
+
+// CreateDatasetRepo creates a dataset repo named owner/name. It is not an
+// error for the repo to exist already.
+func (h *HubClient) CreateDatasetRepo(repo string, private bool) error {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok {
+		return fmt.Errorf("repo %q should be owner/name", repo)
+	}
+	body := map[string]interface{}{
+		"type":         "dataset",
+		"name":         name,
+		"organization": owner,
+		"private":      private,
+	}
+	resp, err := h.do("POST", h.Endpoint+"/api/repos/create", "application/json", body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return nil
+	}
+	return pipeline.CheckResponse(resp)
+}
+
+// Commit uploads files to a dataset repo in a single commit on revision.
+func (h *HubClient) Commit(repo string, revision string, message string, files []HubFile) error {
+	var lines []interface{}
+	lines = append(lines, map[string]interface{}{
+		"key":   "header",
+		"value": map[string]string{"summary": message, "description": ""},
+	})
+	for _, file := range files {
+		if !file.LFS {
+			content := file.Content
+			if file.LocalPath != "" {
+				var err error
+				if content, err = os.ReadFile(file.LocalPath); err != nil {
+					return err
+				}
+			}
+			lines = append(lines, map[string]interface{}{
+				"key": "file",
+				"value": map[string]string{
+					"path":     file.Path,
+					"content":  base64.StdEncoding.EncodeToString(content),
+					"encoding": "base64",
+				},
+			})
+			continue
+		}
+
+		oid, size, err := h.uploadLFS(repo, revision, file.LocalPath)
+		if err != nil {
+			return fmt.Errorf("uploading %s: %w", file.Path, err)
+		}
+		lines = append(lines, map[string]interface{}{
+			"key": "lfsFile",
+			"value": map[string]interface{}{
+				"path": file.Path,
+				"algo": "sha256",
+				"oid":  oid,
+				"size": size,
+			},
+		})
+	}
+
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, line := range lines {
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+	url := fmt.Sprintf("%s/api/datasets/%s/commit/%s", h.Endpoint, repo, revision)
+	resp, err := h.do("POST", url, "application/x-ndjson", &body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return pipeline.CheckResponse(resp)
+}
+
+// lfsAction is where and how to upload or verify an LFS object.
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+// uploadLFS uploads a file to the LFS storage of a repo, unless it is there
+// already, and returns its oid and size.
+func (h *HubClient) uploadLFS(repo string, revision string, path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return "", 0, err
+	}
+	oid := hex.EncodeToString(hash.Sum(nil))
+
+	batch := map[string]interface{}{
+		"operation": "upload",
+		"transfers": []string{"basic", "multipart"},
+		"objects":   []map[string]interface{}{{"oid": oid, "size": size}},
+		"hash_algo": "sha256",
+		"ref":       map[string]string{"name": revision},
+	}
+	url := fmt.Sprintf("%s/datasets/%s.git/info/lfs/objects/batch", h.Endpoint, repo)
+	headers := map[string]string{"Accept": "application/vnd.git-lfs+json"}
+	resp, err := h.do("POST", url, "application/vnd.git-lfs+json", batch, headers)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if err := pipeline.CheckResponse(resp); err != nil {
+		return "", 0, err
+	}
+	var result struct {
+		Objects []struct {
+			Actions struct {
+				Upload *lfsAction `json:"upload"`
+				Verify *lfsAction `json:"verify"`
+			} `json:"actions"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", 0, err
+	}
+	if len(result.Objects) != 1 {
+		return "", 0, fmt.Errorf("unexpected LFS batch response")
+	}
+	object := result.Objects[0]
+	if object.Error != nil {
+		return "", 0, fmt.Errorf("%s", object.Error.Message)
+	}
+	// no upload action means the Hub has the file already
+	if object.Actions.Upload == nil {
+		return oid, size, nil
+	}
+
+	if _, ok := object.Actions.Upload.Header["chunk_size"]; ok {
+		err = h.uploadMultipart(f, oid, size, object.Actions.Upload)
+	} else {
+		err = h.uploadBasic(f, size, object.Actions.Upload)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	if verify := object.Actions.Verify; verify != nil {
+		resp, err := h.do("POST", verify.Href, "application/vnd.git-lfs+json",
+			map[string]interface{}{"oid": oid, "size": size}, verify.Header)
+		if err != nil {
+			return "", 0, err
+		}
+		defer resp.Body.Close()
+		if err := pipeline.CheckResponse(resp); err != nil {
+			return "", 0, err
+		}
+	}
+	return oid, size, nil
+}
+
+// uploadBasic puts the whole file in one request.
+func (h *HubClient) uploadBasic(f *os.File, size int64, action *lfsAction) error {
+	req, err := http.NewRequest("PUT", action.Href, io.NewSectionReader(f, 0, size))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	for key, value := range action.Header {
+		req.Header.Set(key, value)
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return pipeline.CheckResponse(resp)
+}
+
+// uploadMultipart puts the file in chunks to the numbered part urls in the
+// action header, then tells the Hub the upload is complete.
+func (h *HubClient) uploadMultipart(f *os.File, oid string, size int64, action *lfsAction) error {
+	chunkSize, err := strconv.ParseInt(action.Header["chunk_size"], 10, 64)
+	if err != nil || chunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %q", action.Header["chunk_size"])
+	}
+	// the part urls are under zero padded part numbers, "00001" and so on
+	partURLs := make(map[int]string)
+	var partNumbers []int
+	for key, value := range action.Header {
+		if n, err := strconv.Atoi(key); err == nil {
+			partURLs[n] = value
+			partNumbers = append(partNumbers, n)
+		}
+	}
+	sort.Ints(partNumbers)
+
+	type part struct {
+		PartNumber int    `json:"partNumber"`
+		ETag       string `json:"etag"`
+	}
+	var parts []part
+	for i, n := range partNumbers {
+		offset := int64(i) * chunkSize
+		length := chunkSize
+		if offset+length > size {
+			length = size - offset
+		}
+		req, err := http.NewRequest("PUT", partURLs[n], io.NewSectionReader(f, offset, length))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = length
+		resp, err := h.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if err := pipeline.CheckResponse(resp); err != nil {
+			return err
+		}
+		parts = append(parts, part{PartNumber: n, ETag: resp.Header.Get("ETag")})
+	}
+
+	body := map[string]interface{}{"oid": oid, "parts": parts}
+	resp, err := h.do("POST", action.Href, "application/vnd.git-lfs+json", body, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return pipeline.CheckResponse(resp)
+}
+
+// do sends a request with the access token. A body that isn't a reader is
+// encoded as JSON.
+func (h *HubClient) do(method string, url string, contentType string, body interface{}, headers map[string]string) (*http.Response, error) {
+	reader, ok := body.(io.Reader)
+	if !ok {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return h.Client.Do(req)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/smashwords"
+)
+
+const (
+	// Fiction, whose subcategories the interactive mode starts from
+	smashwordsFiction int = 1
+	// books on a page of a category, the default of -pageitems
+	smashwordsPageItems int = 20
+	// Smashwords throttles users past this many epub downloads a day
+	smashwordsDailyLimit int = 500
+)
+
+// the rough size of a downloaded book by format, for the estimate of the
+// download volume of a crawl
+var estimatedBookSize = map[string]int64{
+	"txt":  300 << 10,
+	"epub": 500 << 10,
+}
+
+// crawlPlan is the crawl the user set up in the interactive mode.
+type crawlPlan struct {
+	Categories []smashwords.Category
+	// comma separated ISO 639-1 codes, empty for every language
+	Languages string
+	// txt, epub or all
+	Format string
+	// pages of every category to crawl
+	Pages int
+}
+
+// formats are the formats the books of the plan are downloaded in.
+func (p *crawlPlan) formats() []string {
+	if p.Format == "all" {
+		return []string{"txt", "epub"}
+	}
+	return []string{p.Format}
+}
+
+// Books is the most books the crawl of a category can download, in every
+// format: the books on its pages, or in it if there are fewer.
+func (p *crawlPlan) Books(category smashwords.Category) int {
+	books := p.Pages * smashwordsPageItems
+	if category.Books > 0 && category.Books < books {
+		books = category.Books
+	}
+	return books
+}
+
+// Estimate returns the number of downloads of the crawl at most, and
+// roughly how much they add up to.
+func (p *crawlPlan) Estimate() (downloads int, size int64) {
+	for _, category := range p.Categories {
+		for _, format := range p.formats() {
+			downloads += p.Books(category)
+			size += int64(p.Books(category)) * estimatedBookSize[format]
+		}
+	}
+	return downloads, size
+}
+
+// Args are the flags of scrape for a category of the plan.
+func (p *crawlPlan) Args(dataDir string, category smashwords.Category) []string {
+	args := []string{"-data_dir", dataDir, "-id", strconv.Itoa(category.ID),
+		"-pages", strconv.Itoa(p.Pages), "-format", p.Format}
+	if p.Languages != "" {
+		args = append(args, "-keep-languages", p.Languages)
+	}
+	return args
+}
+
+// prompter asks the user questions on the terminal.
+type prompter struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+// ask prints a question and returns the answer, or def if the answer is
+// empty. The run stops if the input ends.
+func (p *prompter) ask(question string, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	if !p.in.Scan() {
+		if err := p.in.Err(); err != nil {
+			log.Fatal(err)
+		}
+		log.Fatal("Stopped without starting a crawl")
+	}
+	answer := strings.TrimSpace(p.in.Text())
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// parseChoices reads comma separated numbers of the items of a list of n,
+// counting from 1, into their indexes.
+func parseChoices(answer string, n int) ([]int, error) {
+	var choices []int
+	for _, field := range strings.Split(answer, ",") {
+		choice, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || choice < 1 || choice > n {
+			return nil, fmt.Errorf("%q is not a number from 1 to %d", strings.TrimSpace(field), n)
+		}
+		choices = append(choices, choice-1)
+	}
+	return choices, nil
+}
+
+// pickCategories lists the categories under root and lets the user pick
+// some, or go into the subcategories of one with >N and back with <.
+func pickCategories(p *prompter, list func(parent int) ([]smashwords.Category, error), root int) []smashwords.Category {
+	parents := []int{root}
+	for {
+		categories, err := list(parents[len(parents)-1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(categories) == 0 {
+			fmt.Fprintln(p.out, "No categories found.")
+		}
+		for i, category := range categories {
+			if category.Books > 0 {
+				fmt.Fprintf(p.out, "%4d) %s (%d books)\n", i+1, category.Name, category.Books)
+			} else {
+				fmt.Fprintf(p.out, "%4d) %s\n", i+1, category.Name)
+			}
+		}
+		answer := p.ask("Categories to crawl by number (e.g. 1,3), >N for the subcategories of N, < to go back", "")
+		switch {
+		case answer == "":
+			continue
+		case answer == "<":
+			if len(parents) > 1 {
+				parents = parents[:len(parents)-1]
+			}
+			continue
+		case strings.HasPrefix(answer, ">"):
+			choices, err := parseChoices(strings.TrimPrefix(answer, ">"), len(categories))
+			if err != nil || len(choices) != 1 {
+				fmt.Fprintf(p.out, "Pick one category to go into, like >2\n")
+				continue
+			}
+			parents = append(parents, categories[choices[0]].ID)
+			continue
+		}
+		choices, err := parseChoices(answer, len(categories))
+		if err != nil {
+			fmt.Fprintln(p.out, err)
+			continue
+		}
+		var picked []smashwords.Category
+		for _, choice := range choices {
+			picked = append(picked, categories[choice])
+		}
+		return picked
+	}
+}
+
+// planCrawl walks the user through the setup of a crawl: the categories,
+// languages, format and number of pages.
+func planCrawl(p *prompter, list func(parent int) ([]smashwords.Category, error), root int) *crawlPlan {
+	plan := &crawlPlan{Categories: pickCategories(p, list, root)}
+	plan.Languages = p.ask("Languages to keep as ISO 639-1 codes (e.g. en,es), empty for all", "")
+	for {
+		plan.Format = p.ask("Format: txt, epub or all", "txt")
+		if _, ok := estimatedBookSize[plan.Format]; ok || plan.Format == "all" {
+			break
+		}
+		fmt.Fprintf(p.out, "%s is not a format\n", plan.Format)
+	}
+	for {
+		pages, err := strconv.Atoi(p.ask(fmt.Sprintf("Pages of %d books to crawl in every category", smashwordsPageItems), "7"))
+		if err == nil && pages > 0 {
+			plan.Pages = pages
+			break
+		}
+		fmt.Fprintln(p.out, "The number of pages is a number above 0")
+	}
+	return plan
+}
+
+// previewCrawl prints what a crawl will download, and the commands that
+// run it without the interactive mode.
+func previewCrawl(w io.Writer, plan *crawlPlan, dataDir string) {
+	fmt.Fprintf(w, "\nThe crawl of %d categories:\n", len(plan.Categories))
+	for _, category := range plan.Categories {
+		if category.Books > 0 {
+			fmt.Fprintf(w, "  %s: up to %d of its %d books\n", category.Name, plan.Books(category), category.Books)
+		} else {
+			fmt.Fprintf(w, "  %s: up to %d books\n", category.Name, plan.Books(category))
+		}
+	}
+	downloads, size := plan.Estimate()
+	fmt.Fprintf(w, "Up to %d downloads, roughly %s before the language and other filters.\n", downloads, pipeline.FormatSize(size))
+	epubs := 0
+	if plan.Format != "txt" {
+		for _, category := range plan.Categories {
+			epubs += plan.Books(category)
+		}
+	}
+	if epubs > smashwordsDailyLimit {
+		fmt.Fprintf(w, "Smashwords throttles epub downloads past %d a day, so the crawl will stop before it is done;"+
+			" run it again the next day to go on.\n", smashwordsDailyLimit)
+	}
+	fmt.Fprintf(w, "The same crawl without the interactive mode:\n")
+	for _, category := range plan.Categories {
+		fmt.Fprintf(w, "  %s scrape %s\n", filepath.Base(os.Args[0]), strings.Join(plan.Args(dataDir, category), " "))
+	}
+}
+
+// runInteractive is the interactive command. It lists the categories of
+// Smashwords with their number of books, asks which to crawl, in what
+// languages and formats, previews the download volume and then crawls them
+// one after the other.
+func runInteractive(args []string) {
+	flags := flag.NewFlagSet("interactive", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	rootPtr := flags.Int("category", smashwordsFiction,
+		"The category whose subcategories are listed first (1 is Fiction)")
+	parseFlags(flags, args)
+
+	p := &prompter{in: bufio.NewScanner(os.Stdin), out: os.Stdout}
+	list := func(parent int) ([]smashwords.Category, error) {
+		return smashwords.Categories(context.Background(), parent)
+	}
+	plan := planCrawl(p, list, *rootPtr)
+	previewCrawl(os.Stdout, plan, *dataDirPtr)
+	if answer := strings.ToLower(p.ask("Start the crawl? (y/n)", "y")); answer != "y" && answer != "yes" {
+		return
+	}
+	for _, category := range plan.Categories {
+		slog.Info("Crawling category", "category", category.Name, "category_id", category.ID)
+		runScrape(plan.Args(*dataDirPtr, category))
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+const (
+	internetArchiveURL string = "https://archive.org"
+	// items per page of the scrape API, which takes 100 at the least
+	internetArchivePageSize int = 1000
+)
+
+// the formats Internet Archive lists the files of an item in, in the order
+// they are tried for -format txt and epub. Plain text uploads are better
+// than the text of the OCR of scans.
+var internetArchiveFormats = map[string][]string{
+	"txt":  {"Text", "DjVuTXT"},
+	"epub": {"EPUB"},
+}
+
+// internetArchiveStrings reads a metadata field of Internet Archive, which is
+// a string or a list of strings.
+func internetArchiveStrings(raw json.RawMessage) []string {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil && one != "" {
+		return []string{one}
+	}
+	return nil
+}
+
+// InternetArchiveItem is the metadata of an item of Internet Archive and
+// the files it has.
+type InternetArchiveItem struct {
+	Metadata map[string]json.RawMessage `json:"metadata"`
+	Files    []struct {
+		Name   string `json:"name"`
+		Format string `json:"format"`
+	} `json:"files"`
+}
+
+// field returns the values of a metadata field of the item.
+func (item *InternetArchiveItem) field(name string) []string {
+	return internetArchiveStrings(item.Metadata[name])
+}
+
+// BookInfo is what the metadata of an item says about its book. Its
+// subjects are its categories, and its license url and rights statement are
+// its license.
+func (item *InternetArchiveItem) BookInfo(identifier string) pipeline.BookInfo {
+	book := pipeline.BookInfo{
+		Title:      strings.Join(item.field("title"), " "),
+		Author:     strings.Join(item.field("creator"), ", "),
+		URL:        internetArchiveURL + "/details/" + identifier,
+		Categories: item.field("subject"),
+	}
+	if date := item.field("date"); len(date) > 0 {
+		book.Published = date[0]
+	}
+	var license []string
+	license = append(license, item.field("licenseurl")...)
+	license = append(license, item.field("rights")...)
+	book.License = strings.Join(license, " ")
+	return book
+}
+
+// file returns the name of the file of the item in a format, by the order
+// of internetArchiveFormats, or "".
+func (item *InternetArchiveItem) file(format string) string {
+	for _, wanted := range internetArchiveFormats[format] {
+		for _, file := range item.Files {
+			if file.Format == wanted {
+				return file.Name
+			}
+		}
+	}
+	return ""
+}
+
+// MakeInternetArchiveDocumentID returns the stable id of an item of
+// Internet Archive in a format, like archive-prideprejudice00aust-txt.
+func MakeInternetArchiveDocumentID(identifier string, format string) string {
+	return fmt.Sprintf("archive-%s-%s", identifier, format)
+}
+
+// internetArchiveClient talks to the APIs of Internet Archive.
+type internetArchiveClient struct {
+	baseURL string
+}
+
+func (c *internetArchiveClient) getJSON(ctx context.Context, location string, v interface{}) error {
+	resp, err := httpGet(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting %s: %s", location, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("reading %s: %w", location, err)
+	}
+	return nil
+}
+
+// Search returns the identifiers of the items that match a query of the
+// advanced search, up to limit of them if it isn't 0. It pages through the
+// results with the scrape API, which has no cap on their number.
+func (c *internetArchiveClient) Search(ctx context.Context, query string, limit int) ([]string, error) {
+	var identifiers []string
+	cursor := ""
+	for {
+		params := url.Values{
+			"q":      {query},
+			"fields": {"identifier"},
+			"count":  {fmt.Sprint(internetArchivePageSize)},
+		}
+		if cursor != "" {
+			params.Set("cursor", cursor)
+		}
+		var page struct {
+			Items []struct {
+				Identifier string `json:"identifier"`
+			} `json:"items"`
+			Cursor string `json:"cursor"`
+		}
+		if err := c.getJSON(ctx, c.baseURL+"/services/search/v1/scrape?"+params.Encode(), &page); err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			identifiers = append(identifiers, item.Identifier)
+			if limit > 0 && len(identifiers) == limit {
+				return identifiers, nil
+			}
+		}
+		if page.Cursor == "" || len(page.Items) == 0 {
+			return identifiers, nil
+		}
+		cursor = page.Cursor
+	}
+}
+
+// Item returns the metadata of an item and the files it has.
+func (c *internetArchiveClient) Item(ctx context.Context, identifier string) (*InternetArchiveItem, error) {
+	item := &InternetArchiveItem{}
+	if err := c.getJSON(ctx, c.baseURL+"/metadata/"+url.PathEscape(identifier), item); err != nil {
+		return nil, err
+	}
+	if len(item.Metadata) == 0 {
+		return nil, fmt.Errorf("no item %s", identifier)
+	}
+	return item, nil
+}
+
+// download downloads the text or epub of an item and converts it like a
+// Smashwords download: plain text right away, epubs once they are all
+// downloaded. An item ctx stopped is left for the next run.
+func (c *internetArchiveClient) download(ctx context.Context, identifier string, format string, dataDir string, manifest *pipeline.Manifest, sink pipeline.Sink, opts pipeline.ConvertOptions) {
+	docID := MakeInternetArchiveDocumentID(identifier, format)
+	textFileName := docID + ".txt"
+	fileName := docID + "." + format
+	for _, known := range []string{textFileName, textFileName + pipeline.UndecodableSuffix} {
+		if record := manifest.Get(known); record != nil {
+			opts.Report.Skip(identifier, known, "exists: "+format)
+			return
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, fileName)); err == nil {
+		opts.Report.Skip(identifier, fileName, "exists: "+format)
+		return
+	}
+
+	item, err := c.Item(ctx, identifier)
+	if ctx.Err() != nil {
+		return
+	} else if err != nil {
+		pipeline.BookLogger(docID, "", pipeline.LogPhaseDownload).Warn("Could not get the metadata of the item", "identifier", identifier,
+			"error", err)
+		opts.Report.Fail(identifier, fileName, "metadata", err.Error())
+		if err := manifest.LogError(fileName, "metadata", err.Error()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	info := item.BookInfo(identifier)
+	if info.Title == "" {
+		info.Title = identifier
+	}
+	name := item.file(format)
+	if name == "" {
+		pipeline.BookLogger(docID, info.URL, pipeline.LogPhaseDownload).Debug("Skipping item without the format", "title", info.Title,
+			"format", format)
+		opts.Report.Skip(info.Title, fileName, "no "+format)
+		return
+	}
+
+	// access restricted items, like the books of the lending library, fail
+	// here with a 403
+	link := c.baseURL + "/download/" + url.PathEscape(identifier) + "/" + (&url.URL{Path: name}).EscapedPath()
+	partialFilePath := filepath.Join(dataDir, fileName+".part")
+	err = downloadFile(ctx, httpGet, link, partialFilePath)
+	if err == nil && format == "epub" {
+		err = pipeline.ValidateEpub(partialFilePath)
+	}
+	if err != nil {
+		os.Remove(partialFilePath)
+		if ctx.Err() != nil {
+			return
+		}
+		pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Warn("Could not download item", "title", info.Title, "error", err)
+		opts.Report.Fail(info.Title, fileName, "download", err.Error())
+		if err := manifest.LogError(fileName, "download", err.Error()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	downloadedAt := time.Now().UTC()
+
+	if format == "txt" {
+		if err := pipeline.ConvertTextDownload(ctx, info, docID, fileName, partialFilePath, link, downloadedAt, dataDir, manifest, sink, opts); err != nil {
+			return
+		}
+	} else {
+		manifest.Put(&pipeline.ManifestRecord{
+			File:         textFileName,
+			ID:           docID,
+			Source:       fileName,
+			Format:       format,
+			BookInfo:     info,
+			DownloadedAt: downloadedAt,
+			Provenance:   opts.Provenance.ForSource(link),
+		})
+		if err := os.Rename(partialFilePath, filepath.Join(dataDir, fileName)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Info("Downloaded item", "title", info.Title)
+}
+
+// runInternetArchive is the archive.org command. It downloads the texts of
+// the items of Internet Archive that match a search or are in a collection,
+// and puts them through the same conversion, filters and manifest as
+// Smashwords books. Their stable ids are archive-<identifier>-<format>.
+func runInternetArchive(args []string) {
+	flags := flag.NewFlagSet("archive.org", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	queryPtr := flags.String("query", "",
+		"Advanced search query of the items to download, e.g. 'subject:poetry AND language:eng'")
+	collectionPtr := flags.String("collection", "",
+		"Collection to download the items of, e.g. gutenberg. Combined with -query if both are set")
+	idsPtr := flags.String("ids", "",
+		"Comma separated identifiers of items to download instead of searching")
+	limitPtr := flags.Int("limit", 100,
+		"The number of items to download. 0 for every item that matches")
+	textFormatPtr := flags.String("format", "txt",
+		"What to download of every item. Options are 'txt' (its plain text, or else the text of its OCR)"+
+			" or 'epub'")
+	delayPtr := flags.Duration("delay", time.Second,
+		"Time to wait between items")
+	minWordsPtr := flags.Int("min-words", 0,
+		"Reject books with fewer words than this after conversion. 0 for no minimum")
+	baseURLPtr := flags.String("base_url", internetArchiveURL,
+		"URL of Internet Archive")
+	parseFlags(flags, args)
+	if _, ok := internetArchiveFormats[*textFormatPtr]; !ok {
+		log.Fatalf("Unsupported format %s", *textFormatPtr)
+	}
+	dataDir := *dataDirPtr
+	client := &internetArchiveClient{baseURL: strings.TrimSuffix(*baseURLPtr, "/")}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var identifiers []string
+	for _, id := range strings.Split(*idsPtr, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			identifiers = append(identifiers, id)
+		}
+	}
+	if len(identifiers) == 0 {
+		var terms []string
+		if *collectionPtr != "" {
+			terms = append(terms, "collection:("+*collectionPtr+")")
+		}
+		if *queryPtr != "" {
+			terms = append(terms, "("+*queryPtr+")")
+		}
+		if len(terms) == 0 {
+			log.Fatal("Set -query, -collection or -ids to pick the items to download")
+		}
+		// only items of books and other texts have any
+		terms = append(terms, "mediatype:texts")
+		var err error
+		identifiers, err = client.Search(ctx, strings.Join(terms, " AND "), *limitPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	slog.Info("Downloading items of Internet Archive", "items", len(identifiers), "data_dir", dataDir)
+
+	run := startSourceRun(dataDir, pipeline.OutputTxt, flags)
+	run.Opts.MinWords = *minWordsPtr
+	for i, identifier := range identifiers {
+		if i > 0 {
+			sleep(ctx, *delayPtr)
+		}
+		if ctx.Err() != nil {
+			abortRun(run, ctx.Err())
+		}
+		client.download(ctx, identifier, *textFormatPtr, dataDir, run.Manifest, run.Sink, run.Opts)
+	}
+	finishRun(ctx, run, *textFormatPtr == "epub")
+}
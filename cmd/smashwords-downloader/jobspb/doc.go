@@ -0,0 +1,6 @@
+// Package jobspb is the gRPC service of the api command, JobControl, which
+// runs crawl jobs and queries the catalog like its HTTP API. jobs.proto is
+// the source of the Go code here and of the clients of other languages.
+package jobspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative jobs.proto
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// runChanges is the changes command. It prints the books that went in or out
+// of the dataset between two versions of the journal.
+func runChanges(args []string) {
+	flags := flag.NewFlagSet("changes", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the journal of the dataset")
+	fromPtr := flags.Int("from", -1,
+		"Version to compare from, 0 for the empty dataset (default the version before -to)")
+	toPtr := flags.Int("to", -1,
+		"Version to compare to (default the latest version)")
+	listPtr := flags.Bool("list", false,
+		"List the versions instead, with when they were made and how many books they changed")
+	parseFlags(flags, args)
+
+	journal, err := pipeline.LoadJournal(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	latest := journal.Latest()
+	if latest == 0 {
+		log.Fatalf("No %s in %s yet", pipeline.JournalFileName, *dataDirPtr)
+	}
+
+	if *listPtr {
+		for _, version := range journalVersions(journal.Entries) {
+			fmt.Printf("version %d  %s  %s\n", version.Version, version.Time.Format(time.RFC3339), changeCounts(version.ops))
+		}
+		return
+	}
+
+	to, from := *toPtr, *fromPtr
+	if to < 0 {
+		to = latest
+	}
+	if from < 0 {
+		from = to - 1
+	}
+	if to > latest || from > to {
+		log.Fatalf("Versions go from 0 to %d, and -from must come before -to", latest)
+	}
+
+	// the net changes, a book added and removed in between didn't change
+	before, after := journal.Books(from), journal.Books(to)
+	removed := make(map[string]pipeline.JournalEntry)
+	for _, entry := range journal.Entries {
+		if entry.Version > from && entry.Version <= to && entry.Op == pipeline.JournalRemove {
+			removed[entry.ID] = entry
+		}
+	}
+	var ids []string
+	for id := range before {
+		ids = append(ids, id)
+	}
+	for id := range after {
+		if _, ok := before[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	fmt.Printf("Changes from version %d to %d:\n", from, to)
+	ops := make(map[string]int)
+	for _, id := range ids {
+		old, wasIn := before[id]
+		book, isIn := after[id]
+		switch {
+		case !wasIn:
+			ops[pipeline.JournalAdd]++
+			fmt.Printf("+ %s  %s\n", id, book.File)
+		case !isIn:
+			ops[pipeline.JournalRemove]++
+			fmt.Printf("- %s  %s (%s)\n", id, old.File, removed[id].Reason)
+		case old.SHA256 != book.SHA256:
+			ops[pipeline.JournalRedownload]++
+			fmt.Printf("~ %s  %s\n", id, book.File)
+		}
+	}
+	fmt.Println(changeCounts(ops))
+}
+
+// journalVersion is a version of the journal, for changes -list.
+type journalVersion struct {
+	Version int
+	Time    time.Time
+	ops     map[string]int
+}
+
+// journalVersions counts the changes of each version of a journal.
+func journalVersions(entries []pipeline.JournalEntry) []*journalVersion {
+	var versions []*journalVersion
+	for _, entry := range entries {
+		if len(versions) == 0 || versions[len(versions)-1].Version != entry.Version {
+			versions = append(versions, &journalVersion{Version: entry.Version, Time: entry.Time, ops: make(map[string]int)})
+		}
+		versions[len(versions)-1].ops[entry.Op]++
+	}
+	return versions
+}
+
+// changeCounts describes how many books were added, removed and
+// re-downloaded.
+func changeCounts(ops map[string]int) string {
+	return fmt.Sprintf("%d added, %d removed, %d re-downloaded", ops[pipeline.JournalAdd], ops[pipeline.JournalRemove], ops[pipeline.JournalRedownload])
+}
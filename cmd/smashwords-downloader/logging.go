@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// the formats of -log-format
+const (
+	LogText string = "text"
+	LogJSON string = "json"
+)
+
+// LevelTrace is the level of -vv and -log-level trace, below debug: every
+// HTTP request of a run.
+const LevelTrace = slog.LevelDebug - 4
+
+// parseLogLevel reads a level of -log-level: trace, debug, info, warn or
+// error.
+func parseLogLevel(level string) (slog.Level, error) {
+	if strings.EqualFold(level, "trace") {
+		return LevelTrace, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("unsupported log level %s", level)
+	}
+	return l, nil
+}
+
+// logLevel is the level to log from: that of -log-level, unless -quiet, -v
+// or -vv asks for fewer or more.
+func logLevel(level string, quiet bool, verbose bool, veryVerbose bool) (slog.Level, error) {
+	switch {
+	case veryVerbose:
+		return LevelTrace, nil
+	case verbose:
+		return slog.LevelDebug, nil
+	case quiet:
+		return slog.LevelWarn, nil
+	}
+	return parseLogLevel(level)
+}
+
+// replaceLevel names LevelTrace in the log lines, which slog would call
+// DEBUG-4.
+func replaceLevel(groups []string, a slog.Attr) slog.Attr {
+	if a.Key == slog.LevelKey && len(groups) == 0 {
+		if level, ok := a.Value.Any().(slog.Level); ok && level == LevelTrace {
+			a.Value = slog.StringValue("TRACE")
+		}
+	}
+	return a
+}
+
+// teeHandler sends the records to all of its handlers that take them.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			if hErr := h.Handle(ctx, r.Clone()); hErr != nil && err == nil {
+				err = hErr
+			}
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	with := make(teeHandler, len(t))
+	for i, h := range t {
+		with[i] = h.WithAttrs(attrs)
+	}
+	return with
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	with := make(teeHandler, len(t))
+	for i, h := range t {
+		with[i] = h.WithGroup(name)
+	}
+	return with
+}
+
+// traceTransport logs every request made through it at LevelTrace.
+type traceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		slog.Log(req.Context(), LevelTrace, "Request", "method", req.Method, "url", req.URL.String(), "error", err)
+		return resp, err
+	}
+	slog.Log(req.Context(), LevelTrace, "Request", "method", req.Method, "url", req.URL.String(),
+		"status", resp.StatusCode)
+	return resp, err
+}
+
+// setupLogging logs in a format of -log-format from a level on. What the
+// log package logs, the fatal errors, is logged at the error level. With a
+// file the log lines are appended to it, and only the warnings and errors go
+// to stderr as well, so the progress stays readable in long runs.
+func setupLogging(format string, level slog.Level, file string) error {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: replaceLevel}
+	var out io.Writer = pipeline.LogOut
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case LogText:
+		handler = slog.NewTextHandler(out, opts)
+	case LogJSON:
+		handler = slog.NewJSONHandler(out, opts)
+	default:
+		return fmt.Errorf("unsupported log format %s", format)
+	}
+	if file != "" {
+		console := slog.NewTextHandler(pipeline.LogOut, &slog.HandlerOptions{Level: max(level, slog.LevelWarn)})
+		handler = teeHandler{handler, console}
+	}
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(slog.LevelError)
+	if level <= LevelTrace {
+		if _, ok := http.DefaultTransport.(*traceTransport); !ok {
+			http.DefaultTransport = &traceTransport{base: http.DefaultTransport}
+		}
+	}
+	return nil
+}
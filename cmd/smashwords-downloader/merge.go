@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+// mergeCandidate is a copy of a book in one of the inputs of a merge.
+type mergeCandidate struct {
+	record *pipeline.ManifestRecord
+	// where its text is, a text file or a JSONL shard
+	path  string
+	shard bool
+	// order of the input it is in, later inputs win ties
+	input int
+}
+
+// updated is when the copy of a book was last downloaded or converted.
+func (c *mergeCandidate) updated() time.Time {
+	if c.record.ConvertedAt.After(c.record.DownloadedAt) {
+		return c.record.ConvertedAt
+	}
+	return c.record.DownloadedAt
+}
+
+// preferCandidate reports whether copy a of a book is better than copy b:
+// newer, or as new and cleaner, with less repeated text.
+func preferCandidate(a *mergeCandidate, b *mergeCandidate) bool {
+	if !a.updated().Equal(b.updated()) {
+		return a.updated().After(b.updated())
+	}
+	if a.record.Repetition != b.record.Repetition {
+		return a.record.Repetition < b.record.Repetition
+	}
+	return a.input > b.input
+}
+
+// isShardFile tells whether a file is a JSONL shard, like
+// dataset-00000.jsonl.zst.
+func isShardFile(name string) bool {
+	name = pipeline.TrimCompressionExt(name)
+	return strings.HasSuffix(name, ".jsonl") && name != pipeline.ManifestFileName && name != pipeline.ShardIndexFileName &&
+		name != pipeline.JournalFileName && name != pipeline.RemovedFileName && name != nearDuplicatesFileName
+}
+
+// scanShard calls fn with every document of a JSONL shard.
+func scanShard(path string, fn func(doc *pipeline.Document) error) error {
+	r, err := pipeline.OpenFile(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxCorpusLine)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		doc := &pipeline.Document{}
+		if err := json.Unmarshal(scanner.Bytes(), doc); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if err := fn(doc); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// recordFromDocument makes a record for a document of a shard without a
+// manifest.
+func recordFromDocument(doc *pipeline.Document) *pipeline.ManifestRecord {
+	return &pipeline.ManifestRecord{
+		File:       doc.ID + ".txt",
+		ID:         doc.ID,
+		BookInfo:   pipeline.BookInfo{Title: doc.Title, Author: doc.Author, URL: doc.URL},
+		Language:   doc.Language,
+		Provenance: doc.Provenance,
+		Chars:      len(doc.Text),
+		Words:      pipeline.CountWords(doc.Text),
+		SHA256:     pipeline.ContentHash(doc.Text),
+	}
+}
+
+// collectMergeInput finds the books of an input of a merge: a data directory,
+// with its books in text files or JSONL shards, or a JSONL shard. It returns
+// the copies of the books in the dataset and the records of the rejected
+// books.
+func collectMergeInput(path string, input int) ([]*mergeCandidate, []*pipeline.ManifestRecord, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var candidates []*mergeCandidate
+	fromShard := func(records map[string]*pipeline.ManifestRecord, shard string) error {
+		return scanShard(shard, func(doc *pipeline.Document) error {
+			record := records[doc.ID]
+			if record == nil {
+				record = recordFromDocument(doc)
+			}
+			delete(records, doc.ID)
+			candidates = append(candidates, &mergeCandidate{record: record, path: shard, shard: true, input: input})
+			return nil
+		})
+	}
+	if !info.IsDir() {
+		return candidates, nil, fromShard(nil, path)
+	}
+
+	manifest, err := pipeline.LoadManifest(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var rejected []*pipeline.ManifestRecord
+	inShards := make(map[string]*pipeline.ManifestRecord)
+	for _, record := range manifest.Records() {
+		switch {
+		case record.Rejected != "":
+			rejected = append(rejected, record)
+		case !pipeline.InDataset(record):
+		case record.Dataset != "":
+			inShards[record.DocumentID()] = record
+		case filepath.Ext(record.File) == ".txt":
+			file, err := pipeline.FindTextFile(filepath.Join(path, record.File))
+			if os.IsNotExist(err) {
+				slog.Warn("Skipping book whose text is missing", "file", record.File, "dataset", path)
+				continue
+			} else if err != nil {
+				return nil, nil, err
+			}
+			candidates = append(candidates, &mergeCandidate{record: record, path: file, input: input})
+		}
+	}
+	files, err := os.ReadDir(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range files {
+		if !file.IsDir() && isShardFile(file.Name()) {
+			if err := fromShard(inShards, filepath.Join(path, file.Name())); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	// like the books written to object storage
+	if len(inShards) > 0 {
+		slog.Warn("Skipping books whose shards are missing", "books", len(inShards), "dataset", path)
+	}
+	return candidates, rejected, nil
+}
+
+// runMerge is the merge command. It combines the datasets of data
+// directories and JSONL shards into a new data directory with a manifest of
+// them all. Copies of a book are matched by its stable id and the newest,
+// cleanest one is kept; books with the same text under different ids are
+// deduplicated the same way.
+func runMerge(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	outDirPtr := flags.String("out_dir", "./merged",
+		"data directory to write the merged dataset to. It must not have a manifest yet")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: smashwords-downloader merge [-out_dir ./merged] <data_dir or shard>...")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(ExitUsage)
+	}
+	outDir := *outDirPtr
+	if _, err := os.Stat(filepath.Join(outDir, pipeline.ManifestFileName)); err == nil {
+		log.Fatalf("%s already has a manifest, merge into a new directory", outDir)
+	}
+
+	// the best copy of every book by id, and the rejected books by id
+	best := make(map[string]*mergeCandidate)
+	rejected := make(map[string]*pipeline.ManifestRecord)
+	copies := 0
+	for i, path := range flags.Args() {
+		candidates, rejects, err := collectMergeInput(path, i)
+		if err != nil {
+			log.Fatal(err)
+		}
+		copies += len(candidates)
+		for _, candidate := range candidates {
+			id := candidate.record.DocumentID()
+			if other, ok := best[id]; !ok || preferCandidate(candidate, other) {
+				best[id] = candidate
+			}
+		}
+		for _, record := range rejects {
+			rejected[record.DocumentID()] = record
+		}
+		slog.Info("Found books", "books", len(candidates), "rejected", len(rejects), "dataset", path)
+	}
+
+	// the same text under different ids is one book too
+	var kept []*mergeCandidate
+	for _, candidate := range best {
+		kept = append(kept, candidate)
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		if preferCandidate(kept[i], kept[j]) {
+			return true
+		} else if preferCandidate(kept[j], kept[i]) {
+			return false
+		}
+		return kept[i].record.DocumentID() < kept[j].record.DocumentID()
+	})
+	hashes := make(map[string]string)
+	var books []*mergeCandidate
+	var duplicates []*pipeline.ManifestRecord
+	for _, candidate := range kept {
+		record := *candidate.record
+		candidate.record = &record
+		id := record.DocumentID()
+		record.ID = id
+		record.File = id + ".txt"
+		record.Dataset = ""
+		if original, ok := hashes[record.SHA256]; ok && record.SHA256 != "" {
+			record.Rejected = "duplicate: " + original
+			duplicates = append(duplicates, &record)
+			continue
+		}
+		if record.SHA256 != "" {
+			hashes[record.SHA256] = record.File
+		}
+		books = append(books, candidate)
+	}
+
+	manifest, err := pipeline.LoadManifest(outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	journal, err := pipeline.LoadJournal(outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	manifest.UseJournal(journal)
+	store, err := storage.Open(outDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sink, err := pipeline.NewSink(pipeline.OutputTxt, store, pipeline.SinkOptions{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// books in text files are copied one by one, and the shards they are in
+	// read once each
+	byShard := make(map[string]map[string]*pipeline.ManifestRecord)
+	for _, candidate := range books {
+		if candidate.shard {
+			if byShard[candidate.path] == nil {
+				byShard[candidate.path] = make(map[string]*pipeline.ManifestRecord)
+			}
+			byShard[candidate.path][candidate.record.ID] = candidate.record
+			continue
+		}
+		text, err := pipeline.ReadTextFile(candidate.path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := sink.Write(candidate.record, string(text)); err != nil {
+			log.Fatal(err)
+		}
+		manifest.Put(candidate.record)
+	}
+	for shard, records := range byShard {
+		err := scanShard(shard, func(doc *pipeline.Document) error {
+			record, ok := records[doc.ID]
+			if !ok {
+				return nil
+			}
+			// a shard could have two copies of a book, only the first is kept
+			delete(records, doc.ID)
+			if err := sink.Write(record, doc.Text); err != nil {
+				return err
+			}
+			manifest.Put(record)
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	// the rejected books stay rejected, unless another input has them
+	for _, record := range duplicates {
+		manifest.Put(record)
+	}
+	for id, record := range rejected {
+		if _, ok := best[id]; !ok {
+			manifest.Put(record)
+		}
+	}
+	if err := manifest.Save(); err != nil {
+		log.Fatal(err)
+	}
+	summary := pipeline.SummarizeDataset(manifest, pipeline.FlagParameters(flags))
+	if err := pipeline.WriteDatasetCard(&storage.Local{Dir: outDir}, summary); err != nil {
+		log.Fatal(err)
+	}
+	if err := pipeline.WriteChecksums(outDir); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Merged datasets", "copies", copies, "books", len(books), "duplicates", len(duplicates), "path", outDir)
+}
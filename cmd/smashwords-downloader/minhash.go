@@ -0,0 +1,212 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	// books are compared on runs of this many words
+	shingleSize int = 5
+	// number of hash functions in a signature
+	minHashPermutations int = 256
+	// LSH band size. Small bands catch pairs with a low Jaccard similarity
+	// too, which a book has with an omnibus it is part of.
+	minHashBandRows int = 2
+)
+
+// MinHash is the signature of the set of word shingles of a book.
+type MinHash struct {
+	Signature []uint64
+	// number of distinct shingles
+	Shingles int
+}
+
+// NewMinHash computes the MinHash signature of a text.
+func NewMinHash(text string) *MinHash {
+	var words []string
+	for _, field := range strings.Fields(strings.ToLower(text)) {
+		word := strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if word != "" {
+			words = append(words, word)
+		}
+	}
+
+	shingles := make(map[uint64]bool)
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		shingles[h.Sum64()] = true
+	}
+
+	m := &MinHash{Signature: make([]uint64, minHashPermutations), Shingles: len(shingles)}
+	for i := range m.Signature {
+		m.Signature[i] = math.MaxUint64
+	}
+	for shingle := range shingles {
+		for i := range m.Signature {
+			if h := mix64(shingle ^ uint64(i+1)*0x9e3779b97f4a7c15); h < m.Signature[i] {
+				m.Signature[i] = h
+			}
+		}
+	}
+	return m
+}
+
+// mix64 is the splitmix64 finalizer, it makes a different hash function out
+// of the shingle hash for every position of the signature.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Jaccard estimates the Jaccard similarity of the shingle sets of two books.
+func (m *MinHash) Jaccard(other *MinHash) float64 {
+	if m.Shingles == 0 || other.Shingles == 0 {
+		return 0
+	}
+	same := 0
+	for i := range m.Signature {
+		if m.Signature[i] == other.Signature[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(m.Signature))
+}
+
+// Containment estimates the share of the shingles of the smaller book that
+// are in the larger one, which is high for a book and an omnibus it is part
+// of even though their Jaccard similarity is low.
+func (m *MinHash) Containment(other *MinHash) float64 {
+	j := m.Jaccard(other)
+	smaller := m.Shingles
+	if other.Shingles < smaller {
+		smaller = other.Shingles
+	}
+	if smaller == 0 {
+		return 0
+	}
+	// |A ∩ B| = J (|A| + |B|) / (1 + J)
+	c := j * float64(m.Shingles+other.Shingles) / ((1 + j) * float64(smaller))
+	return math.Min(c, 1)
+}
+
+// NearDuplicatePair is a pair of books found to be near duplicates.
+type NearDuplicatePair struct {
+	A           string  `json:"a"`
+	B           string  `json:"b"`
+	Jaccard     float64 `json:"jaccard"`
+	Containment float64 `json:"containment"`
+}
+
+// NearDuplicateCluster is a group of books that are near duplicates of each
+// other, directly or through other books of the group.
+type NearDuplicateCluster struct {
+	// the longest book, which has the most of the text of the others
+	Representative string              `json:"representative"`
+	Members        []string            `json:"members"`
+	Pairs          []NearDuplicatePair `json:"pairs"`
+}
+
+// FindNearDuplicates clusters books whose estimated Jaccard similarity or
+// containment is at least threshold. Candidate pairs are found with
+// locality sensitive hashing on bands of the signatures.
+func FindNearDuplicates(hashes map[string]*MinHash, threshold float64) []NearDuplicateCluster {
+	files := make([]string, 0, len(hashes))
+	for file := range hashes {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	candidates := make(map[[2]int]bool)
+	for band := 0; band < minHashPermutations/minHashBandRows; band++ {
+		buckets := make(map[[minHashBandRows]uint64][]int)
+		for i, file := range files {
+			m := hashes[file]
+			if m.Shingles == 0 {
+				continue
+			}
+			var key [minHashBandRows]uint64
+			copy(key[:], m.Signature[band*minHashBandRows:])
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, bucket := range buckets {
+			for x := 0; x < len(bucket); x++ {
+				for y := x + 1; y < len(bucket); y++ {
+					candidates[[2]int{bucket[x], bucket[y]}] = true
+				}
+			}
+		}
+	}
+
+	// union find over the pairs that pass the threshold
+	parent := make([]int, len(files))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	var pairs []NearDuplicatePair
+	for c := range candidates {
+		a, b := hashes[files[c[0]]], hashes[files[c[1]]]
+		pair := NearDuplicatePair{A: files[c[0]], B: files[c[1]], Jaccard: a.Jaccard(b), Containment: a.Containment(b)}
+		if pair.Jaccard < threshold && pair.Containment < threshold {
+			continue
+		}
+		pairs = append(pairs, pair)
+		parent[find(c[0])] = find(c[1])
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].A != pairs[j].A {
+			return pairs[i].A < pairs[j].A
+		}
+		return pairs[i].B < pairs[j].B
+	})
+
+	index := make(map[string]int, len(files))
+	for i, file := range files {
+		index[file] = i
+	}
+	byRoot := make(map[int]*NearDuplicateCluster)
+	var roots []int
+	for _, pair := range pairs {
+		root := find(index[pair.A])
+		cluster, ok := byRoot[root]
+		if !ok {
+			cluster = &NearDuplicateCluster{}
+			byRoot[root] = cluster
+			roots = append(roots, root)
+		}
+		cluster.Pairs = append(cluster.Pairs, pair)
+	}
+	for i, file := range files {
+		if cluster, ok := byRoot[find(i)]; ok {
+			cluster.Members = append(cluster.Members, file)
+			if cluster.Representative == "" || hashes[file].Shingles > hashes[cluster.Representative].Shingles {
+				cluster.Representative = file
+			}
+		}
+	}
+
+	sort.Ints(roots)
+	clusters := make([]NearDuplicateCluster, 0, len(roots))
+	for _, root := range roots {
+		clusters = append(clusters, *byRoot[root])
+	}
+	return clusters
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testBook makes up a book of n words, the same for the same seed.
+func testBook(seed int64, n int) string {
+	r := rand.New(rand.NewSource(seed))
+	words := make([]string, n)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", r.Intn(2000))
+	}
+	return strings.Join(words, " ")
+}
+
+func TestMinHash(t *testing.T) {
+	a, b, c := testBook(1, 3000), testBook(2, 3000), testBook(3, 3000)
+	// a with a word changed every hundred
+	edited := strings.Fields(a)
+	for i := 0; i < len(edited); i += 100 {
+		edited[i] = "changed"
+	}
+	omnibus := a + " " + b + " " + c
+
+	tests := []struct {
+		name                           string
+		x, y                           string
+		minJaccard, maxJaccard         float64
+		minContainment, maxContainment float64
+	}{
+		{"the same book", a, a, 1, 1, 1, 1},
+		{"the same book in other case and punctuation", a, strings.ToUpper(strings.ReplaceAll(a, " ", ", ")), 1, 1, 1, 1},
+		{"an edited copy", a, strings.Join(edited, " "), 0.8, 0.98, 0.8, 1},
+		{"different books", a, b, 0, 0.05, 0, 0.1},
+		{"a book and an omnibus it is in", a, omnibus, 0.2, 0.45, 0.9, 1},
+	}
+	for _, tt := range tests {
+		x, y := NewMinHash(tt.x), NewMinHash(tt.y)
+		if j := x.Jaccard(y); j < tt.minJaccard || j > tt.maxJaccard {
+			t.Errorf("%s: Jaccard is %.2f, want %.2f to %.2f", tt.name, j, tt.minJaccard, tt.maxJaccard)
+		}
+		if c := x.Containment(y); c < tt.minContainment || c > tt.maxContainment {
+			t.Errorf("%s: containment is %.2f, want %.2f to %.2f", tt.name, c, tt.minContainment, tt.maxContainment)
+		}
+	}
+
+	if empty := NewMinHash("too short"); empty.Shingles != 0 || empty.Jaccard(empty) != 0 {
+		t.Errorf("a text shorter than a shingle has %d shingles", empty.Shingles)
+	}
+}
+
+func TestFindNearDuplicates(t *testing.T) {
+	a, b, c, d := testBook(1, 3000), testBook(2, 3000), testBook(3, 3000), testBook(4, 3000)
+	hashes := map[string]*MinHash{
+		"a.txt":        NewMinHash(a),
+		"a-copy.txt":   NewMinHash(a),
+		"b.txt":        NewMinHash(b),
+		"c.txt":        NewMinHash(c),
+		"omnibus.txt":  NewMinHash(b + " " + c),
+		"d.txt":        NewMinHash(d),
+		"short.txt":    NewMinHash("too short"),
+		"short-2.txt":  NewMinHash("too short"),
+		"unrelated.md": NewMinHash(testBook(5, 3000)),
+	}
+	clusters := FindNearDuplicates(hashes, 0.8)
+
+	var got []string
+	for _, cluster := range clusters {
+		got = append(got, cluster.Representative+": "+strings.Join(cluster.Members, " "))
+	}
+	slices.Sort(got)
+	want := []string{
+		"a-copy.txt: a-copy.txt a.txt",
+		"omnibus.txt: b.txt c.txt omnibus.txt",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("clusters are %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// notifyFlags are the flags of where the summary of a run is sent.
+type notifyFlags struct {
+	url          *string
+	slack        *string
+	discord      *string
+	email        *string
+	smtpAddr     *string
+	smtpFrom     *string
+	smtpUser     *string
+	smtpPassword *string
+}
+
+// addNotifyFlags adds the flags of the notifications of a run to flags.
+func addNotifyFlags(flags *flag.FlagSet) *notifyFlags {
+	return &notifyFlags{
+		url: flags.String("notify-url", "",
+			"URL to POST a JSON summary of the run to (its status, the books by outcome, the first errors and"+
+				" its duration) when it completes, fails or is rate limited, so unattended crawls can alert"+
+				" their owners"),
+		slack: flags.String("notify-slack", "",
+			"Slack incoming webhook URL to post the summary of the run to"),
+		discord: flags.String("notify-discord", "",
+			"Discord webhook URL to post the summary of the run to"),
+		email: flags.String("notify-email", "",
+			"Comma separated addresses to mail the summary of the run to, through -smtp-addr"),
+		smtpAddr: flags.String("smtp-addr", "localhost:25",
+			"host:port of the SMTP server of -notify-email"),
+		smtpFrom: flags.String("smtp-from", "",
+			"Sender of the mails of -notify-email. Defaults to -smtp-user"),
+		smtpUser: flags.String("smtp-user", "",
+			"User to log in to the SMTP server as. Empty not to log in"),
+		smtpPassword: flags.String("smtp-password", os.Getenv("SMTP_PASSWORD"),
+			"Password of -smtp-user. Defaults to $SMTP_PASSWORD"),
+	}
+}
+
+// notifier is the notifier of the targets of the parsed flags, which counts
+// the books of the run in report, or nil without targets.
+func (f *notifyFlags) notifier(flags *flag.FlagSet, report *pipeline.RunReport) *pipeline.Notifier {
+	var targets []pipeline.NotifyTarget
+	if *f.url != "" {
+		targets = append(targets, &pipeline.WebhookTarget{URL: *f.url})
+	}
+	if *f.slack != "" {
+		targets = append(targets, &pipeline.SlackTarget{URL: *f.slack})
+	}
+	if *f.discord != "" {
+		targets = append(targets, &pipeline.DiscordTarget{URL: *f.discord})
+	}
+	if *f.email != "" {
+		from := *f.smtpFrom
+		if from == "" {
+			from = *f.smtpUser
+		}
+		if from == "" {
+			log.Fatal("-notify-email needs -smtp-from or -smtp-user")
+		}
+		var to []string
+		for _, address := range strings.Split(*f.email, ",") {
+			to = append(to, strings.TrimSpace(address))
+		}
+		targets = append(targets, &pipeline.EmailTarget{
+			Addr:     *f.smtpAddr,
+			From:     from,
+			To:       to,
+			User:     *f.smtpUser,
+			Password: *f.smtpPassword,
+		})
+	}
+	return pipeline.NewNotifier(targets, flags, report)
+}
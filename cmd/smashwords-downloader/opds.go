@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+const (
+	// the feeds are written to <data_dir>/opds, starting at index.xml
+	opdsDirName       string = "opds"
+	opdsIndexFileName string = "index.xml"
+
+	opdsNavigationType  string = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	opdsAcquisitionType string = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	// the books are free to download, so every link is open access
+	opdsOpenAccessRel string = "http://opds-spec.org/acquisition/open-access"
+)
+
+// opdsFeed is an Atom feed of OPDS 1.2, a catalog e-reader apps can browse.
+type opdsFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	XmlnsDC string      `xml:"xmlns:dc,attr"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  *opdsAuthor `xml:"author,omitempty"`
+	Links   []opdsLink  `xml:"link"`
+	Entries []opdsEntry `xml:"entry"`
+}
+
+type opdsAuthor struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type opdsLink struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr,omitempty"`
+}
+
+type opdsCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+// opdsEntry is a book of an acquisition feed, or a feed of a navigation feed.
+type opdsEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Updated    string         `xml:"updated"`
+	Authors    []opdsAuthor   `xml:"author"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Rights     string         `xml:"rights,omitempty"`
+	Categories []opdsCategory `xml:"category"`
+	Content    *opdsContent   `xml:"content,omitempty"`
+	Links      []opdsLink     `xml:"link"`
+}
+
+type opdsContent struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+// opdsFileType is the media type of a file of a book, as e-readers know them.
+func opdsFileType(name string) string {
+	switch {
+	case strings.HasSuffix(name, pipeline.CompressionExts[pipeline.CompressZstd]):
+		return "application/zstd"
+	case strings.HasSuffix(name, pipeline.CompressionExts[pipeline.CompressGzip]):
+		return "application/gzip"
+	case strings.HasSuffix(name, ".epub"):
+		return "application/epub+zip"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// opdsSlug turns a category or language into the name of its feed file.
+func opdsSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// opdsTime formats a time the way Atom wants it.
+func opdsTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// OPDSCatalog is the OPDS catalog of the books of a data directory: a
+// navigation feed with an acquisition feed of all the books, and one for
+// every category and language.
+type OPDSCatalog struct {
+	Title string
+	// url the data directory is served at, empty to link with relative urls
+	BaseURL string
+	Updated time.Time
+
+	entries []opdsEntry
+	// indices of the entries in every category and language
+	categories map[string][]int
+	languages  map[string][]int
+}
+
+// NewOPDSCatalog makes the catalog of the books in the dataset of a
+// manifest whose files are in dataDir. Books in JSONL shards have no file of
+// their own to download, so they are left out.
+func NewOPDSCatalog(manifest *pipeline.Manifest, dataDir string, title string, baseURL string) (*OPDSCatalog, error) {
+	c := &OPDSCatalog{
+		Title:      title,
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		categories: make(map[string][]int),
+		languages:  make(map[string][]int),
+	}
+	records := manifest.Records()
+	sort.Slice(records, func(i, j int) bool {
+		return strings.ToLower(records[i].Title) < strings.ToLower(records[j].Title)
+	})
+	skipped := 0
+	for _, record := range records {
+		if !pipeline.InDataset(record) {
+			continue
+		}
+		if record.Dataset != "" {
+			skipped++
+			continue
+		}
+		entry, err := c.entry(record, dataDir)
+		if os.IsNotExist(err) {
+			skipped++
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		i := len(c.entries)
+		c.entries = append(c.entries, *entry)
+		for _, category := range record.Categories {
+			c.categories[category] = append(c.categories[category], i)
+		}
+		if record.Language != "" {
+			c.languages[record.Language] = append(c.languages[record.Language], i)
+		}
+		if record.ConvertedAt.After(c.Updated) {
+			c.Updated = record.ConvertedAt
+		}
+	}
+	if skipped > 0 {
+		slog.Warn("Leaving books without a file out of the catalog", "books", skipped, "data_dir", dataDir)
+	}
+	if c.Updated.IsZero() {
+		c.Updated = time.Now()
+	}
+	return c, nil
+}
+
+// href links to a file relative to the data directory from a feed.
+func (c *OPDSCatalog) href(file string) string {
+	if c.BaseURL != "" {
+		return c.BaseURL + "/" + file
+	}
+	return "../" + file
+}
+
+// feedHref links to a feed from another feed, both in the opds directory.
+func (c *OPDSCatalog) feedHref(name string) string {
+	if c.BaseURL != "" {
+		return c.BaseURL + "/" + opdsDirName + "/" + name
+	}
+	return name
+}
+
+// entry makes the entry of a book, linking to its text and to the epub it
+// was converted from, if it was kept.
+func (c *OPDSCatalog) entry(record *pipeline.ManifestRecord, dataDir string) (*opdsEntry, error) {
+	text, err := pipeline.FindTextFile(filepath.Join(dataDir, record.File))
+	if err != nil {
+		return nil, err
+	}
+	text = filepath.Base(text)
+	entry := &opdsEntry{
+		ID:        "urn:smashwords:" + record.DocumentID(),
+		Title:     record.Title,
+		Updated:   opdsTime(record.ConvertedAt),
+		Language:  record.Language,
+		Publisher: record.Publisher,
+		Issued:    record.Published,
+		Rights:    record.LicenseType,
+	}
+	if entry.Title == "" {
+		entry.Title = strings.TrimSuffix(record.File, path.Ext(record.File))
+	}
+	if record.Author != "" {
+		entry.Authors = []opdsAuthor{{Name: record.Author}}
+	}
+	for _, category := range record.Categories {
+		entry.Categories = append(entry.Categories, opdsCategory{Term: opdsSlug(category), Label: category})
+	}
+	if record.License != "" {
+		entry.Content = &opdsContent{Type: "text", Text: record.License}
+	}
+	if record.Source != "" && filepath.Ext(record.Source) == ".epub" {
+		if _, err := os.Stat(filepath.Join(dataDir, record.Source)); err == nil {
+			entry.Links = append(entry.Links, opdsLink{Rel: opdsOpenAccessRel, Href: c.href(record.Source), Type: opdsFileType(record.Source)})
+		}
+	}
+	entry.Links = append(entry.Links, opdsLink{Rel: opdsOpenAccessRel, Href: c.href(text), Type: opdsFileType(text)})
+	if record.URL != "" {
+		entry.Links = append(entry.Links, opdsLink{Rel: "alternate", Href: record.URL, Type: "text/html", Title: "Smashwords"})
+	}
+	return entry, nil
+}
+
+// feed makes a feed with the links every feed of the catalog has.
+func (c *OPDSCatalog) feed(name string, title string, kind string) *opdsFeed {
+	feed := &opdsFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		XmlnsDC: "http://purl.org/dc/terms/",
+		ID:      "urn:smashwords-downloader:" + strings.TrimSuffix(name, ".xml"),
+		Title:   title,
+		Updated: opdsTime(c.Updated),
+		Author:  &opdsAuthor{Name: pipeline.ToolName},
+		Links: []opdsLink{
+			{Rel: "self", Href: c.feedHref(name), Type: kind},
+			{Rel: "start", Href: c.feedHref(opdsIndexFileName), Type: opdsNavigationType},
+		},
+	}
+	if name != opdsIndexFileName {
+		feed.Links = append(feed.Links, opdsLink{Rel: "up", Href: c.feedHref(opdsIndexFileName), Type: opdsNavigationType})
+	}
+	return feed
+}
+
+// acquisitionFeed makes a feed of some of the books.
+func (c *OPDSCatalog) acquisitionFeed(name string, title string, books []int) *opdsFeed {
+	feed := c.feed(name, title, opdsAcquisitionType)
+	for _, i := range books {
+		feed.Entries = append(feed.Entries, c.entries[i])
+	}
+	return feed
+}
+
+// navigationEntry is the entry of a navigation feed that leads to another
+// feed.
+func (c *OPDSCatalog) navigationEntry(name string, title string, books int) opdsEntry {
+	return opdsEntry{
+		ID:      "urn:smashwords-downloader:" + strings.TrimSuffix(name, ".xml"),
+		Title:   title,
+		Updated: opdsTime(c.Updated),
+		Content: &opdsContent{Type: "text", Text: fmt.Sprintf("%d books", books)},
+		Links:   []opdsLink{{Rel: "subsection", Href: c.feedHref(name), Type: opdsAcquisitionType}},
+	}
+}
+
+// Feeds returns the feeds of the catalog by file name.
+func (c *OPDSCatalog) Feeds() map[string]*opdsFeed {
+	feeds := make(map[string]*opdsFeed)
+	index := c.feed(opdsIndexFileName, c.Title, opdsNavigationType)
+	feeds[opdsIndexFileName] = index
+
+	all := make([]int, len(c.entries))
+	for i := range all {
+		all[i] = i
+	}
+	feeds["all.xml"] = c.acquisitionFeed("all.xml", "All books", all)
+	index.Entries = append(index.Entries, c.navigationEntry("all.xml", "All books", len(all)))
+
+	add := func(prefix string, label string, books map[string][]int) {
+		var keys []string
+		for key := range books {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := prefix + "-" + opdsSlug(key) + ".xml"
+			if feed, ok := feeds[name]; ok {
+				// categories with the same slug share a feed
+				feed.Entries = append(feed.Entries, c.acquisitionFeed(name, "", books[key]).Entries...)
+				continue
+			}
+			title := label + ": " + key
+			feeds[name] = c.acquisitionFeed(name, title, books[key])
+			index.Entries = append(index.Entries, c.navigationEntry(name, title, len(books[key])))
+		}
+	}
+	add("language", "Language", c.languages)
+	add("category", "Category", c.categories)
+	return feeds
+}
+
+// Write writes the feeds of the catalog to <data_dir>/opds, replacing the
+// ones written before.
+func (c *OPDSCatalog) Write(dataDir string) error {
+	dir := filepath.Join(dataDir, opdsDirName)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	for name, feed := range c.Feeds() {
+		data, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append([]byte(xml.Header), append(data, '\n')...)
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runOPDS is the opds command. It writes an OPDS catalog of the books of a
+// data directory, so e-reader apps can browse and download them when the
+// directory is served by any static file server.
+func runOPDS(args []string) {
+	flags := flag.NewFlagSet("opds", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the manifest and the files of the books")
+	titlePtr := flags.String("title", "Smashwords books",
+		"Title of the catalog")
+	baseURLPtr := flags.String("base_url", "",
+		"URL the data directory is served at, e.g. http://192.168.1.2:8000. Empty links with relative URLs,"+
+			" which works when the catalog is opened at its URL")
+	parseFlags(flags, args)
+
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	catalog, err := NewOPDSCatalog(manifest, *dataDirPtr, *titlePtr, *baseURLPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := catalog.Write(*dataDirPtr); err != nil {
+		log.Fatal(err)
+	}
+	if err := pipeline.WriteChecksums(*dataDirPtr); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Wrote the OPDS catalog", "books", len(catalog.entries),
+		"path", filepath.Join(*dataDirPtr, opdsDirName, opdsIndexFileName))
+}
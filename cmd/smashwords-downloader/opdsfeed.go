@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+const (
+	// the acquisition link of a book that is downloaded as is, rather than
+	// bought, borrowed or sampled
+	opdsAcquisitionRel string = "http://opds-spec.org/acquisition"
+	// entries of navigation feeds are feeds, linked with these media types
+	opdsAtomType string = "application/atom+xml"
+)
+
+// the media types of the formats that can be downloaded from an OPDS feed
+var opdsFeedFormats = map[string]string{
+	"epub": "application/epub+zip",
+	"txt":  "text/plain",
+}
+
+// opdsSourceFeed is a page of an OPDS feed read from a catalog, as opposed to
+// the opdsFeed the opds command writes.
+type opdsSourceFeed struct {
+	Links   []opdsLink        `xml:"link"`
+	Entries []opdsSourceEntry `xml:"entry"`
+}
+
+// opdsSourceEntry is a book of an acquisition feed, or a feed of a navigation
+// feed. Catalogs give the language and date of a book with Dublin Core terms
+// or elements.
+type opdsSourceEntry struct {
+	// the url of the book page, or a urn like urn:uuid:...
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Authors []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+	Language   string         `xml:"http://purl.org/dc/terms/ language"`
+	DCLanguage string         `xml:"http://purl.org/dc/elements/1.1/ language"`
+	Issued     string         `xml:"http://purl.org/dc/terms/ issued"`
+	Published  string         `xml:"published"`
+	Rights     string         `xml:"rights"`
+	DCRights   string         `xml:"http://purl.org/dc/terms/ rights"`
+	Categories []opdsCategory `xml:"category"`
+	Links      []opdsLink     `xml:"link"`
+}
+
+// language returns the language of a book, in lowercase.
+func (e *opdsSourceEntry) language() string {
+	if e.Language != "" {
+		return strings.ToLower(strings.TrimSpace(e.Language))
+	}
+	return strings.ToLower(strings.TrimSpace(e.DCLanguage))
+}
+
+// keepLanguage reports whether a book is in one of languages, where en keeps
+// books in en-US too.
+func (e *opdsSourceEntry) keepLanguage(languages []string) bool {
+	language := e.language()
+	base, _, _ := strings.Cut(language, "-")
+	return pipeline.KeepLanguage(language, languages) || pipeline.KeepLanguage(base, languages)
+}
+
+// BookInfo is what the feed says about a book. Its url is its book page, and
+// its license the rights statement of the entry if it has one.
+func (e *opdsSourceEntry) BookInfo() pipeline.BookInfo {
+	book := pipeline.BookInfo{
+		Title:   strings.Join(strings.Fields(e.Title), " "),
+		License: strings.Join(strings.Fields(e.Rights+" "+e.DCRights), " "),
+	}
+	for _, link := range e.Links {
+		if link.Rel == "alternate" && strings.HasPrefix(link.Type, "text/html") {
+			book.URL = link.Href
+			break
+		}
+	}
+	if book.URL == "" && (strings.HasPrefix(e.ID, "http://") || strings.HasPrefix(e.ID, "https://")) {
+		book.URL = e.ID
+	}
+	var authors []string
+	for _, author := range e.Authors {
+		authors = append(authors, author.Name)
+	}
+	book.Author = strings.Join(authors, ", ")
+	for _, date := range []string{e.Issued, e.Published} {
+		if date = strings.TrimSpace(date); date != "" {
+			if len(date) > len("2006-01-02") {
+				date = date[:len("2006-01-02")]
+			}
+			book.Published = date
+			break
+		}
+	}
+	seen := make(map[string]bool)
+	for _, category := range e.Categories {
+		name := category.Label
+		if name == "" {
+			name = category.Term
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			book.Categories = append(book.Categories, name)
+		}
+	}
+	return book
+}
+
+// acquisitionLink returns the url of the first of formats the book can be
+// downloaded in for free, and that format, or "" if it has none of them.
+func (e *opdsSourceEntry) acquisitionLink(formats []string) (string, string) {
+	for _, format := range formats {
+		for _, link := range e.Links {
+			mediaType, _, _ := strings.Cut(link.Type, ";")
+			if (link.Rel == opdsAcquisitionRel || link.Rel == opdsOpenAccessRel) &&
+				strings.TrimSpace(mediaType) == opdsFeedFormats[format] {
+				return link.Href, format
+			}
+		}
+	}
+	return "", ""
+}
+
+// feedLinks returns the urls of the feeds an entry of a navigation feed
+// links to, or nil if the entry is a book, with acquisition links of any
+// kind.
+func (e *opdsSourceEntry) feedLinks() []string {
+	var links []string
+	for _, link := range e.Links {
+		if strings.HasPrefix(link.Rel, opdsAcquisitionRel) {
+			return nil
+		}
+		if strings.HasPrefix(link.Type, opdsAtomType) {
+			links = append(links, link.Href)
+		}
+	}
+	return links
+}
+
+// opdsClient reads the feeds of an OPDS catalog and downloads its books, with
+// HTTP basic authentication if there is a user.
+type opdsClient struct {
+	user     string
+	password string
+	// what to say when the catalog turns the user down
+	authHint string
+}
+
+func (c *opdsClient) get(ctx context.Context, location string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && c.authHint != "" {
+			return nil, fmt.Errorf("getting %s: %s, %s", location, resp.Status, c.authHint)
+		}
+		return nil, fmt.Errorf("getting %s: %s", location, resp.Status)
+	}
+	return resp, nil
+}
+
+// Entries reads the books of a feed and the pages after it, resolving their
+// links against the page they are on. The feeds that entries of navigation
+// feeds link to are read too, depth levels down, and a book found in more
+// than one of them is only returned once.
+func (c *opdsClient) Entries(ctx context.Context, feedURL string, depth int) ([]*opdsSourceEntry, error) {
+	var entries []*opdsSourceEntry
+	seenFeeds := make(map[string]bool)
+	seenEntries := make(map[string]bool)
+	var read func(feedURL string, depth int) error
+	read = func(feedURL string, depth int) error {
+		for next := feedURL; next != "" && !seenFeeds[next]; {
+			seenFeeds[next] = true
+			base, err := url.Parse(next)
+			if err != nil {
+				return err
+			}
+			resp, err := c.get(ctx, next)
+			if err != nil {
+				return err
+			}
+			feed := &opdsSourceFeed{}
+			err = xml.NewDecoder(resp.Body).Decode(feed)
+			resp.Body.Close()
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", next, err)
+			}
+			var subfeeds []string
+			for i := range feed.Entries {
+				entry := &feed.Entries[i]
+				for j, link := range entry.Links {
+					if ref, err := base.Parse(link.Href); err == nil {
+						entry.Links[j].Href = ref.String()
+					}
+				}
+				if links := entry.feedLinks(); len(links) > 0 {
+					subfeeds = append(subfeeds, links...)
+					continue
+				}
+				key := entry.ID
+				if key == "" {
+					key = entry.Title
+				}
+				if !seenEntries[key] {
+					seenEntries[key] = true
+					entries = append(entries, entry)
+				}
+			}
+			if depth > 0 {
+				for _, subfeed := range subfeeds {
+					if err := read(subfeed, depth-1); err != nil {
+						return err
+					}
+				}
+			}
+			next = ""
+			for _, link := range feed.Links {
+				if link.Rel == "next" {
+					if ref, err := base.Parse(link.Href); err == nil {
+						next = ref.String()
+					}
+				}
+			}
+		}
+		return nil
+	}
+	return entries, read(feedURL, depth)
+}
+
+// MakeOPDSDocumentID returns the stable id of a book of an OPDS catalog in a
+// format, by the host of the catalog and the id of the entry of the book,
+// like opds-www-feedbooks-com-book-1234-epub. The scheme and host of ids that
+// are urls, and the urn:uuid: of uuids, are left out.
+func MakeOPDSDocumentID(feedURL string, entryID string, format string) string {
+	catalog, err := url.Parse(feedURL)
+	if err != nil || catalog.Host == "" {
+		return ""
+	}
+	id := strings.TrimPrefix(entryID, "urn:uuid:")
+	if u, err := url.Parse(entryID); err == nil && u.Host != "" {
+		id = u.Path
+		if u.RawQuery != "" {
+			id += "-" + u.RawQuery
+		}
+	}
+	if opdsSlug(id) == "" {
+		return ""
+	}
+	return fmt.Sprintf("opds-%s-%s-%s", opdsSlug(catalog.Host), opdsSlug(id), format)
+}
+
+// download downloads a book of a feed in the first of formats it has and
+// converts it like a Smashwords download: plain text right away, epubs once
+// they are all downloaded. A download ctx stopped is left for the next run.
+func (c *opdsClient) download(ctx context.Context, feedURL string, entry *opdsSourceEntry, formats []string, dataDir string,
+	manifest *pipeline.Manifest, sink pipeline.Sink, opts pipeline.ConvertOptions) {
+	info := entry.BookInfo()
+	link, format := entry.acquisitionLink(formats)
+	if link == "" {
+		slog.Debug("Skipping book without a download in the formats", "phase", pipeline.LogPhaseDownload, "title", info.Title,
+			"formats", strings.Join(formats, ","))
+		opts.Report.Skip(info.Title, "", "no "+strings.Join(formats, ", "))
+		return
+	}
+	docID := MakeOPDSDocumentID(feedURL, entry.ID, format)
+	if docID == "" {
+		slog.Debug("Skipping book without an id", "phase", pipeline.LogPhaseDownload, "title", info.Title)
+		opts.Report.Skip(info.Title, "", "no id")
+		return
+	}
+	textFileName := docID + ".txt"
+	fileName := docID + "." + format
+	for _, known := range []string{textFileName, textFileName + pipeline.UndecodableSuffix} {
+		if manifest.Get(known) != nil {
+			opts.Report.Skip(info.Title, known, "exists: "+format)
+			return
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, fileName)); err == nil {
+		opts.Report.Skip(info.Title, fileName, "exists: "+format)
+		return
+	}
+
+	partialFilePath := filepath.Join(dataDir, fileName+".part")
+	err := downloadFile(ctx, c.get, link, partialFilePath)
+	if err == nil && format == "epub" {
+		err = pipeline.ValidateEpub(partialFilePath)
+	}
+	if err != nil {
+		os.Remove(partialFilePath)
+		if ctx.Err() != nil {
+			return
+		}
+		pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Warn("Could not download book", "title", info.Title, "error", err)
+		opts.Report.Fail(info.Title, fileName, "download", err.Error())
+		if err := manifest.LogError(fileName, "download", err.Error()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	downloadedAt := time.Now().UTC()
+
+	if format == "txt" {
+		if err := pipeline.ConvertTextDownload(ctx, info, docID, fileName, partialFilePath, link, downloadedAt, dataDir, manifest, sink, opts); err != nil {
+			return
+		}
+	} else {
+		manifest.Put(&pipeline.ManifestRecord{
+			File:         textFileName,
+			ID:           docID,
+			Source:       fileName,
+			Format:       format,
+			BookInfo:     info,
+			DownloadedAt: downloadedAt,
+			Provenance:   opts.Provenance.ForSource(link),
+		})
+		if err := os.Rename(partialFilePath, filepath.Join(dataDir, fileName)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Info("Downloaded book", "title", info.Title)
+}
+
+// runOPDSFeed is the opds-feed command. It downloads the free books of any
+// OPDS catalog, like Feedbooks, ManyBooks or a Calibre-Web library, and
+// converts them like Smashwords books. Their stable ids are
+// opds-<host>-<entry id>-<format>.
+func runOPDSFeed(args []string) {
+	flags := flag.NewFlagSet("opds-feed", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	feedPtr := flags.String("feed", "",
+		"URL of the OPDS feed to download the books of, e.g. https://catalog.feedbooks.com/publicdomain/browse/top.atom")
+	formatsPtr := flags.String("formats", "epub,txt",
+		"Comma separated formats to download, in order of preference. Options are 'epub' and 'txt'")
+	languagePtr := flags.String("language", "",
+		"Comma separated languages of the books to download (e.g. en-US,en-GB or en). Empty for every language")
+	depthPtr := flags.Int("depth", 0,
+		"How many levels of navigation feeds to follow from -feed, e.g. 1 for the root of a Calibre-Web library")
+	limitPtr := flags.Int("limit", 0,
+		"The number of books to download. 0 for every book of the feed")
+	userPtr := flags.String("user", "",
+		"User to log in to the catalog as, for catalogs that need one")
+	passwordPtr := flags.String("password", os.Getenv("OPDS_PASSWORD"),
+		"Password of -user (default $OPDS_PASSWORD)")
+	delayPtr := flags.Duration("delay", time.Second,
+		"Time to wait between downloads")
+	minWordsPtr := flags.Int("min-words", 0,
+		"Reject books with fewer words than this after conversion. 0 for no minimum")
+	parseFlags(flags, args)
+	if *feedPtr == "" {
+		log.Fatal("Set -feed to the URL of an OPDS feed")
+	}
+	var formats []string
+	for _, format := range strings.Split(*formatsPtr, ",") {
+		format = strings.TrimSpace(format)
+		if _, ok := opdsFeedFormats[format]; !ok {
+			log.Fatalf("Unsupported format %s", format)
+		}
+		formats = append(formats, format)
+	}
+	dataDir := *dataDirPtr
+
+	client := &opdsClient{user: *userPtr, password: *passwordPtr,
+		authHint: "-user and -password must be of an account of the catalog"}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	entries, err := client.Entries(ctx, *feedPtr, *depthPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	languages := pipeline.ParseLanguages(*languagePtr)
+	var selected []*opdsSourceEntry
+	for _, entry := range entries {
+		if entry.keepLanguage(languages) {
+			selected = append(selected, entry)
+		}
+	}
+	if *limitPtr > 0 && len(selected) > *limitPtr {
+		selected = selected[:*limitPtr]
+	}
+	slog.Info("Downloading books of the feed", "books", len(selected), "feed_books", len(entries), "data_dir", dataDir)
+
+	run := startSourceRun(dataDir, pipeline.OutputTxt, flags)
+	run.Opts.MinWords = *minWordsPtr
+	for i, entry := range selected {
+		if i > 0 {
+			sleep(ctx, *delayPtr)
+		}
+		if ctx.Err() != nil {
+			abortRun(run, ctx.Err())
+		}
+		client.download(ctx, *feedPtr, entry, formats, dataDir, run.Manifest, run.Sink, run.Opts)
+	}
+	finishRun(ctx, run, true)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/postprocess"
+)
+
+// the prefix of the -postprocess stages that are external programs
+const postprocessExecPrefix = "exec:"
+
+// postprocessFlags are the flags of the post-processing, with the flags of
+// every registered processor.
+type postprocessFlags struct {
+	stages *string
+	// what makes a registered processor once the flags are parsed, by name
+	makers map[string]func() (postprocess.Processor, error)
+}
+
+// addPostprocessFlags adds the flags of the post-processing to a flag set.
+func addPostprocessFlags(flags *flag.FlagSet) *postprocessFlags {
+	f := &postprocessFlags{makers: make(map[string]func() (postprocess.Processor, error))}
+	for _, name := range postprocess.Names() {
+		factory, _ := postprocess.Lookup(name)
+		f.makers[name] = factory(flags)
+	}
+	options := "registered processors"
+	if names := postprocess.Names(); len(names) > 0 {
+		options = strings.Join(names, ", ")
+	}
+	f.stages = flags.String("postprocess", "",
+		"Comma separated stages to put every converted book through before the filters, in order: "+options+
+			", or exec:COMMAND for a program that gets every book as a line of JSON on its standard input and"+
+			" answers with the book, changed or not, or why to reject it on its standard output")
+	return f
+}
+
+// postProcessors starts the stages of the parsed flags, nil without any.
+func (f *postprocessFlags) postProcessors() (*pipeline.PostProcessors, error) {
+	if *f.stages == "" {
+		return nil, nil
+	}
+	p := &pipeline.PostProcessors{}
+	for _, stage := range strings.Split(*f.stages, ",") {
+		stage = strings.TrimSpace(stage)
+		var processor postprocess.Processor
+		var err error
+		if command, ok := strings.CutPrefix(stage, postprocessExecPrefix); ok {
+			processor, err = postprocess.Exec(command)
+		} else if maker, ok := f.makers[stage]; ok {
+			processor, err = maker()
+		} else {
+			options := append(postprocess.Names(), postprocessExecPrefix+"COMMAND")
+			err = fmt.Errorf("unknown post-processor %s, options are %s", stage, strings.Join(options, ", "))
+		}
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.Add(stage, processor)
+	}
+	return p, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+// ShardSet is the shards of one split of a dataset, as listed in its
+// shards.jsonl.
+type ShardSet struct {
+	Split  string
+	Dir    string
+	Shards []pipeline.ShardInfo
+}
+
+// runPublish is the publish command. It uploads the shards of an export,
+// along with a dataset card, to a dataset repo on the Hugging Face Hub.
+func runPublish(args []string) {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the manifest of the books")
+	dirPtr := flags.String("dir", "",
+		"directory with the shards to publish, written by export (default <data_dir>/export)")
+	repoPtr := flags.String("repo", "",
+		"Hugging Face dataset repo to push to, as owner/name")
+	tokenPtr := flags.String("token", os.Getenv("HF_TOKEN"),
+		"Hugging Face access token with write access to the repo (default $HF_TOKEN)")
+	privatePtr := flags.Bool("private", false,
+		"Make the repo private if it has to be created")
+	revisionPtr := flags.String("revision", "main",
+		"Branch to commit to")
+	messagePtr := flags.String("message", "Upload dataset",
+		"Commit message")
+	parseFlags(flags, args)
+
+	if *repoPtr == "" {
+		log.Fatal("-repo is required")
+	}
+	if *tokenPtr == "" {
+		log.Fatal("-token or $HF_TOKEN is required")
+	}
+	dir := *dirPtr
+	if dir == "" {
+		dir = filepath.Join(*dataDirPtr, "export")
+	}
+
+	sets, err := FindShardSets(dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// shards go under data/, with a folder for every split
+	var files []HubFile
+	for _, set := range sets {
+		repoDir := path.Join("data", set.Split)
+		files = append(files, HubFile{
+			Path:      path.Join(repoDir, pipeline.ShardIndexFileName),
+			LocalPath: filepath.Join(set.Dir, pipeline.ShardIndexFileName),
+		})
+		for _, shard := range set.Shards {
+			files = append(files, HubFile{
+				Path:      path.Join(repoDir, shard.File),
+				LocalPath: filepath.Join(set.Dir, shard.File),
+				LFS:       true,
+			})
+		}
+	}
+	files = append(files, HubFile{
+		Path:    "README.md",
+		Content: []byte(DatasetCard(*repoPtr, sets, manifest)),
+	})
+
+	hub := NewHubClient(*tokenPtr)
+	if err := hub.CreateDatasetRepo(*repoPtr, *privatePtr); err != nil {
+		log.Fatal(err)
+	}
+	if err := hub.Commit(*repoPtr, *revisionPtr, *messagePtr, files); err != nil {
+		log.Fatal(err)
+	}
+	slog.Info("Published dataset", "files", len(files), "url", hub.Endpoint+"/datasets/"+*repoPtr)
+}
+
+// FindShardSets reads the shard indexes of an export: dir/shards.jsonl for
+// an export without splits, or the one in each split folder.
+func FindShardSets(dir string) ([]ShardSet, error) {
+	if shards, err := readShardIndex(dir); err == nil {
+		return []ShardSet{{Split: "train", Dir: dir, Shards: shards}}, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var sets []ShardSet
+	for _, name := range splitNames[len(splitNames)-1] {
+		shards, err := readShardIndex(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sets = append(sets, ShardSet{Split: name, Dir: filepath.Join(dir, name), Shards: shards})
+	}
+	if len(sets) == 0 {
+		return nil, fmt.Errorf("no %s in %s, run export first", pipeline.ShardIndexFileName, dir)
+	}
+	return sets, nil
+}
+
+// readShardIndex reads the shards.jsonl of a directory. The error is
+// os.ErrNotExist if there is none.
+func readShardIndex(dir string) ([]pipeline.ShardInfo, error) {
+	if _, err := os.Stat(filepath.Join(dir, pipeline.ShardIndexFileName)); err != nil {
+		return nil, err
+	}
+	return pipeline.ReadShardIndexFrom(&storage.Local{Dir: dir})
+}
+
+// DatasetCard writes the README.md of a dataset repo: YAML metadata telling
+// the Hub where the splits are, followed by a description of the books and
+// the fields of the documents.
+func DatasetCard(repo string, sets []ShardSet, manifest *pipeline.Manifest) string {
+	var card strings.Builder
+
+	// the books in the dataset, and the languages they are in
+	summary := pipeline.SummarizeDataset(manifest, nil)
+	languageCodes := pipeline.SortedByCount(summary.Languages)
+
+	documents := 0
+	for _, set := range sets {
+		for _, shard := range set.Shards {
+			documents += shard.Documents
+		}
+	}
+
+	card.WriteString("---\n")
+	if len(languageCodes) > 0 {
+		card.WriteString("language:\n")
+		for _, language := range languageCodes {
+			fmt.Fprintf(&card, "- %s\n", language)
+		}
+	}
+	card.WriteString("task_categories:\n- text-generation\n")
+	fmt.Fprintf(&card, "size_categories:\n- %s\n", sizeCategory(documents))
+	card.WriteString("configs:\n- config_name: default\n  data_files:\n")
+	for _, set := range sets {
+		// every shard of an export has the same extension, like .jsonl.zst
+		pattern := pipeline.DatasetPrefix + "-*"
+		if len(set.Shards) > 0 {
+			file := set.Shards[0].File
+			pattern += file[strings.Index(file, "."):]
+		}
+		fmt.Fprintf(&card, "  - split: %s\n    path: %q\n", set.Split, path.Join("data", set.Split, pattern))
+	}
+	card.WriteString("---\n\n")
+
+	fmt.Fprintf(&card, "# %s\n\n", repo[strings.LastIndex(repo, "/")+1:])
+	card.WriteString("Books that are free to download from [Smashwords](https://www.smashwords.com/), ")
+	card.WriteString("downloaded and converted to plain text with smashwords-downloader.\n\n")
+
+	card.WriteString("## Splits\n\n| split | books | shards | size |\n|---|---|---|---|\n")
+	for _, set := range sets {
+		splitDocuments, bytes := 0, int64(0)
+		for _, shard := range set.Shards {
+			splitDocuments += shard.Documents
+			bytes += shard.Bytes
+		}
+		fmt.Fprintf(&card, "| %s | %d | %d | %s |\n", set.Split, splitDocuments, len(set.Shards), pipeline.FormatSize(bytes))
+	}
+	card.WriteString("\n")
+
+	if summary.Documents > 0 {
+		fmt.Fprintf(&card, "The data directory the dataset was exported from has %d books with %d words in total",
+			summary.Documents, summary.Words)
+		if len(languageCodes) > 0 {
+			var counts []string
+			for _, language := range languageCodes {
+				counts = append(counts, fmt.Sprintf("%s: %d", language, summary.Languages[language]))
+			}
+			fmt.Fprintf(&card, " (%s)", strings.Join(counts, ", "))
+		}
+		card.WriteString(".\n\n")
+	}
+
+	card.WriteString("## Fields\n\n")
+	card.WriteString("- `id`: the name of the book's file\n")
+	card.WriteString("- `title`, `author`: as listed on Smashwords\n")
+	card.WriteString("- `url`: the book's page on Smashwords\n")
+	card.WriteString("- `language`: detected ISO 639-1 code of the language of the text\n")
+	card.WriteString("- `provenance`: the version and commit of smashwords-downloader, the flags it ran with, ")
+	card.WriteString("when the run started and the url the book was downloaded from\n")
+	card.WriteString("- `text`: the text of the book\n\n")
+	card.WriteString("Parquet shards also have `format`, `chars`, `words`, `chapters`, `repetition` and `sha256` columns, ")
+	card.WriteString("and `source_url`, `downloaded_at`, `tool_version` and `commit` instead of `provenance`.\n\n")
+
+	card.WriteString("## License\n\n")
+	card.WriteString("The books are free to download but remain under the copyright of their authors. ")
+	card.WriteString("Check the license of every book on its Smashwords page before redistributing it.\n")
+	if len(summary.Licenses) > 0 {
+		var counts []string
+		for _, license := range pipeline.SortedByCount(summary.Licenses) {
+			counts = append(counts, fmt.Sprintf("%s: %d", license, summary.Licenses[license]))
+		}
+		fmt.Fprintf(&card, "\nBooks by the license they state: %s.\n", strings.Join(counts, ", "))
+	}
+	return card.String()
+}
+
+// sizeCategory is the Hub's size category for a number of documents.
+func sizeCategory(n int) string {
+	switch {
+	case n < 1000:
+		return "n<1K"
+	case n < 10000:
+		return "1K<n<10K"
+	case n < 100000:
+		return "10K<n<100K"
+	case n < 1000000:
+		return "100K<n<1M"
+	}
+	return "1M<n<10M"
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// Task is a book to download that the coordinator found, for a worker to
+// pull from the work queue.
+type Task struct {
+	// the registered source that found the book, like smashwords
+	Source string       `json:"source"`
+	Book   *source.Book `json:"book"`
+	// when the coordinator queued the book
+	Queued time.Time `json:"queued"`
+
+	// the task as it is in the queue, to acknowledge it by
+	raw string
+}
+
+// WorkQueue is the queue of books to download that the coordinator fills
+// and the workers of a distributed crawl empty, so their per-IP limits add
+// up.
+type WorkQueue interface {
+	// Push queues a task, unless a task for the same book was queued
+	// before. It returns whether it queued the task.
+	Push(ctx context.Context, task *Task) (bool, error)
+	// Pop takes the next task, waiting up to wait for one. It returns nil
+	// if there is none by then. The task stays with the worker until it is
+	// acknowledged or returned.
+	Pop(ctx context.Context, wait time.Duration) (*Task, error)
+	// Ack drops a task the worker is done with.
+	Ack(ctx context.Context, task *Task) error
+	// Return puts a task the worker couldn't do back at the front of the
+	// queue for another worker.
+	Return(ctx context.Context, task *Task) error
+	// Recover puts the tasks a worker of the same name took and never
+	// finished, because it crashed, back in the queue. It returns how many.
+	Recover(ctx context.Context) (int, error)
+	// Len is the number of tasks waiting.
+	Len(ctx context.Context) (int, error)
+	Close() error
+}
+
+// OpenWorkQueue opens the queue of a -queue URL, like
+// redis://:password@host:6379/0, under a name the coordinator and workers
+// of a crawl share. worker names the worker it is opened by, so its
+// unfinished tasks can be recovered.
+func OpenWorkQueue(rawURL string, name string, worker string) (WorkQueue, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis", "rediss":
+		opts, err := redis.ParseURL(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		client := redis.NewClient(opts)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("connecting to the queue: %w", err)
+		}
+		return &redisQueue{client: client, name: name, worker: worker}, nil
+	}
+	return nil, fmt.Errorf("unsupported queue %s, options are redis:// or rediss:// URLs", rawURL)
+}
+
+// redisQueue is a WorkQueue in Redis lists: the tasks wait in NAME:tasks,
+// move to NAME:processing:WORKER while a worker has them, and the ids of
+// the books ever queued are in the set NAME:queued.
+type redisQueue struct {
+	client *redis.Client
+	name   string
+	worker string
+}
+
+func (q *redisQueue) key(parts ...string) string {
+	return q.name + ":" + strings.Join(parts, ":")
+}
+
+func (q *redisQueue) Push(ctx context.Context, task *Task) (bool, error) {
+	added, err := q.client.SAdd(ctx, q.key("queued"), task.Book.ID).Result()
+	if err != nil || added == 0 {
+		return false, err
+	}
+	data, err := json.Marshal(task)
+	if err != nil {
+		return false, err
+	}
+	err = q.client.LPush(ctx, q.key("tasks"), data).Err()
+	return err == nil, err
+}
+
+func (q *redisQueue) Pop(ctx context.Context, wait time.Duration) (*Task, error) {
+	// redis blocks for whole seconds, and forever for 0
+	if wait < time.Second {
+		wait = time.Second
+	}
+	raw, err := q.client.BRPopLPush(ctx, q.key("tasks"), q.key("processing", q.worker), wait).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	task := &Task{raw: raw}
+	if err := json.Unmarshal([]byte(task.raw), task); err != nil {
+		// a task no worker can do is dropped
+		q.Ack(ctx, task)
+		return nil, fmt.Errorf("invalid task in the queue: %w", err)
+	}
+	return task, nil
+}
+
+func (q *redisQueue) Ack(ctx context.Context, task *Task) error {
+	return q.client.LRem(ctx, q.key("processing", q.worker), 1, task.raw).Err()
+}
+
+func (q *redisQueue) Return(ctx context.Context, task *Task) error {
+	// the tasks are popped from the right
+	if err := q.client.RPush(ctx, q.key("tasks"), task.raw).Err(); err != nil {
+		return err
+	}
+	return q.Ack(ctx, task)
+}
+
+func (q *redisQueue) Recover(ctx context.Context) (int, error) {
+	recovered := 0
+	for {
+		err := q.client.RPopLPush(ctx, q.key("processing", q.worker), q.key("tasks")).Err()
+		if errors.Is(err, redis.Nil) {
+			return recovered, nil
+		}
+		if err != nil {
+			return recovered, err
+		}
+		recovered++
+	}
+}
+
+func (q *redisQueue) Len(ctx context.Context) (int, error) {
+	n, err := q.client.LLen(ctx, q.key("tasks")).Result()
+	return int(n), err
+}
+
+func (q *redisQueue) Close() error {
+	return q.client.Close()
+}
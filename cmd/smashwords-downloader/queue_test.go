@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+func TestRedisQueue(t *testing.T) {
+	server := miniredis.RunT(t)
+	ctx := context.Background()
+	open := func(worker string) WorkQueue {
+		q, err := OpenWorkQueue("redis://"+server.Addr()+"/0", "crawl", worker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { q.Close() })
+		return q
+	}
+	coordinator, worker := open("coordinator"), open("worker-1")
+
+	for _, id := range []string{"1", "2", "1"} {
+		if _, err := coordinator.Push(ctx, &Task{Source: "smashwords", Book: &source.Book{ID: id}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := coordinator.Len(ctx); n != 2 {
+		t.Fatalf("%d tasks are queued, want 2 as a book is only queued once", n)
+	}
+
+	// the tasks come out in the order they went in
+	first, err := worker.Pop(ctx, time.Second)
+	if err != nil || first == nil || first.Book.ID != "1" {
+		t.Fatalf("popped %+v, %v, want book 1", first, err)
+	}
+	if err := worker.Return(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	// a returned task is next
+	again, _ := worker.Pop(ctx, time.Second)
+	if again == nil || again.Book.ID != "1" {
+		t.Fatalf("popped %+v after returning book 1", again)
+	}
+	if err := worker.Ack(ctx, again); err != nil {
+		t.Fatal(err)
+	}
+
+	// a worker that crashed with a task gets it back when it starts again
+	if task, _ := worker.Pop(ctx, time.Second); task == nil || task.Book.ID != "2" {
+		t.Fatalf("popped %+v, want book 2", task)
+	}
+	restarted := open("worker-1")
+	if n, err := restarted.Recover(ctx); n != 1 || err != nil {
+		t.Fatalf("recovered %d tasks, %v, want 1", n, err)
+	}
+	if n, _ := restarted.Len(ctx); n != 1 {
+		t.Fatalf("%d tasks are queued after the recovery, want 1", n)
+	}
+
+	if _, err := OpenWorkQueue("amqp://localhost", "crawl", "worker-1"); err == nil {
+		t.Error("an amqp queue was opened")
+	}
+}
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+// convertFlags are the flags of how books are converted, cleaned up and
+// filtered, shared by scrape and convert.
+type convertFlags struct {
+	overwriteSource  *bool
+	notes            *string
+	tables           *string
+	images           *string
+	whitespace       *string
+	wrap             *int
+	unicode          *string
+	punctuation      *string
+	stripBoilerplate *bool
+	stripFrontMatter *bool
+	stripBackMatter  *bool
+	chapterMarker    *string
+	dewrap           *bool
+	keepLanguages    *string
+	openLibrary      *bool
+	report           *string
+	bpeVocab         *string
+	licenseFilter    *string
+	qualityFilter    *bool
+	minWords         *int
+	maxWords         *int
+	maxRepetition    *float64
+	scrubPII         *bool
+	dedupe           *bool
+	excludeCorpus    *string
+	progress         *string
+	events           *string
+	postprocess      *postprocessFlags
+	metricsAddr      *string
+	otlpEndpoint     *string
+	notify           *notifyFlags
+	concurrency      *concurrencyFlags
+}
+
+// addConvertFlags adds the flags of the conversion to a flag set.
+func addConvertFlags(flags *flag.FlagSet) *convertFlags {
+	overwriteSourcePtr := flags.Bool("overwriteSource", true,
+		"Save the original file after converting it to the desired format")
+
+	notesPtr := flags.String("notes", pipeline.NotesKeep,
+		"What to do with footnotes and endnotes in epubs. Options are 'inline' (put the note text at the reference),"+
+			" 'append' (collect the notes at the end of the book) or 'keep' (leave them as they are)")
+
+	tablesPtr := flags.String("tables", pipeline.TablesText,
+		"How to render tables in epubs. Options are 'text' (aligned plain-text columns) or 'markdown'")
+
+	whitespacePtr := flags.String("whitespace", pipeline.WhitespacePreserve,
+		"How to normalize whitespace in converted text. Options are 'collapse' (collapse runs of spaces and tabs,"+
+			" trim lines and squeeze blank lines), 'trim' (strip trailing whitespace) or 'preserve'")
+
+	wrapPtr := flags.Int("wrap", 0,
+		"The column to wrap converted text at. 0 leaves every paragraph on a single line")
+
+	unicodePtr := flags.String("unicode", pipeline.UnicodeNone,
+		"The Unicode normalization form to put converted text in. Options are 'nfc', 'nfkc' or 'none'")
+
+	punctuationPtr := flags.String("punctuation", pipeline.PunctuationKeep,
+		"How to normalize quotes, dashes, ellipses and non-breaking spaces in converted text. Options are"+
+			" 'keep', 'ascii' (plain ASCII equivalents) or 'unicode' (one consistent Unicode form each)")
+
+	stripBoilerplatePtr := flags.Bool("strip-boilerplate", false,
+		"Remove the Smashwords license notes, \"Smashwords Edition\" lines and other distribution notices, and the"+
+			" Project Gutenberg header and license")
+
+	stripFrontMatterPtr := flags.Bool("strip-front-matter", false,
+		"Remove title pages, copyright pages, dedications and ISBN blocks from the start of books."+
+			" Removed text is logged to removed.jsonl in the data directory")
+
+	stripBackMatterPtr := flags.Bool("strip-back-matter", false,
+		"Remove \"About the Author\" sections, lists of other books, acquisition links and newsletter plugs"+
+			" from the end of books. Removed text is logged to removed.jsonl in the data directory")
+
+	chapterMarkerPtr := flags.String("chapter-marker", "",
+		"Replace chapter headings with this marker, where {n} is the chapter number and {title} the heading"+
+			" (e.g. \"## {title}\"). Empty leaves headings as they are")
+
+	dewrapPtr := flags.Bool("dewrap", false,
+		"Rejoin hard wrapped lines of txt downloads into paragraphs and repair words hyphenated at line ends")
+
+	keepLanguagesPtr := flags.String("keep-languages", "",
+		"Comma separated ISO 639-1 codes of the languages to keep (e.g. en,es). Books detected to be in any other"+
+			" language are dropped. Empty keeps every book")
+
+	openLibraryPtr := flags.Bool("openlibrary", false,
+		"Look up books with an ISBN (in their epub or on their copyright page) on Open Library, and record the"+
+			" canonical names of their authors, their subjects and publication year in the manifest")
+
+	reportPtr := flags.String("report", "",
+		"Write a report of the run to <data_dir>/reports/ for sharing: 'html' (the books attempted, succeeded,"+
+			" rejected, skipped and failed with their reasons, and the throughput of every minute) or 'csv' (a row"+
+			" per book). Empty for no report")
+
+	bpeVocabPtr := flags.String("bpe-vocab", "",
+		"Count the tokens of every book with the byte pair encoding of a vocab file in the format of tiktoken"+
+			" (like cl100k_base.tiktoken) instead of estimating them from the words and punctuation")
+
+	licenseFilterPtr := flags.String("license-filter", "",
+		"Comma separated kinds of license to keep, e.g. cc (any Creative Commons license), cc-by,"+
+			" cc-by-sa, cc0, public-domain, all-rights-reserved or unknown. The license is read from the book page,"+
+			" the epub and the copyright page. Empty keeps every book")
+
+	qualityFilterPtr := flags.Bool("quality-filter", false,
+		"Move books that look like junk (few letters, odd word lengths, lots of symbols or OCR errors)"+
+			" to the rejects folder of the data directory instead of the dataset")
+
+	minWordsPtr := flags.Int("min-words", 0,
+		"Reject books with fewer words than this after conversion. 0 for no minimum")
+
+	maxWordsPtr := flags.Int("max-words", 0,
+		"Reject books with more words than this after conversion (e.g. omnibus collections). 0 for no maximum")
+
+	maxRepetitionPtr := flags.Float64("max-repetition", 0,
+		"Reject books where more than this share of the text (0-1) is repeated lines or paragraphs. 0 keeps them all;"+
+			" the share is recorded in the manifest either way")
+
+	scrubPIIPtr := flags.Bool("scrub-pii", false,
+		"Mask email addresses, phone numbers and URLs in author contact sections with [EMAIL], [PHONE] and [URL]")
+
+	dedupePtr := flags.Bool("dedupe", false,
+		"Reject books whose text is an exact duplicate of a book already in the data directory")
+
+	excludeCorpusPtr := flags.String("exclude-corpus", "",
+		"Comma separated paths of existing corpora (data directories, hash lists or JSONL files with a sha256 or"+
+			" text field) whose books are rejected as duplicates")
+
+	imagesPtr := flags.String("images", pipeline.ImagesDrop,
+		"What to do with images in epubs. Options are 'drop', 'placeholder' (put [Image: alt text] in their place)"+
+			" or 'extract' (also save the images to the assets folder of the data directory)")
+
+	progressPtr := flags.String("progress", pipeline.ProgressAuto,
+		"How to show the progress of the run. Options are 'bars' (a progress bar per phase with its rate and ETA),"+
+			" 'log' (a log line every 30 seconds), 'auto' (bars on a terminal, log lines otherwise) or 'none'")
+	eventsPtr := flags.String("events", "",
+		"Write the events of the run as NDJSON (book_discovered, download_started, download_complete,"+
+			" convert_complete and error) to 'fd:N', a file descriptor the run was started with, or 'unix:PATH',"+
+			" a unix socket something listens on")
+	metricsAddrPtr := flags.String("metrics-addr", "",
+		"Address to serve Prometheus metrics of the run at /metrics on, like :9090: HTTP requests, bytes"+
+			" downloaded, downloads by status, conversion durations and the quota of downloads left")
+	otlpEndpointPtr := flags.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"OTLP/HTTP endpoint of an OpenTelemetry collector, like http://localhost:4318, to send spans of the"+
+			" discovery, downloads and conversions of the run to. Defaults to $OTEL_EXPORTER_OTLP_ENDPOINT")
+	notify := addNotifyFlags(flags)
+
+	return &convertFlags{
+		overwriteSource:  overwriteSourcePtr,
+		notes:            notesPtr,
+		tables:           tablesPtr,
+		images:           imagesPtr,
+		whitespace:       whitespacePtr,
+		wrap:             wrapPtr,
+		unicode:          unicodePtr,
+		punctuation:      punctuationPtr,
+		stripBoilerplate: stripBoilerplatePtr,
+		stripFrontMatter: stripFrontMatterPtr,
+		stripBackMatter:  stripBackMatterPtr,
+		chapterMarker:    chapterMarkerPtr,
+		dewrap:           dewrapPtr,
+		keepLanguages:    keepLanguagesPtr,
+		openLibrary:      openLibraryPtr,
+		report:           reportPtr,
+		bpeVocab:         bpeVocabPtr,
+		licenseFilter:    licenseFilterPtr,
+		qualityFilter:    qualityFilterPtr,
+		minWords:         minWordsPtr,
+		maxWords:         maxWordsPtr,
+		maxRepetition:    maxRepetitionPtr,
+		scrubPII:         scrubPIIPtr,
+		dedupe:           dedupePtr,
+		excludeCorpus:    excludeCorpusPtr,
+		progress:         progressPtr,
+		events:           eventsPtr,
+		postprocess:      addPostprocessFlags(flags),
+		metricsAddr:      metricsAddrPtr,
+		otlpEndpoint:     otlpEndpointPtr,
+		notify:           notify,
+		concurrency:      addConcurrencyFlags(flags),
+	}
+}
+
+// startProgress starts showing the progress of the phases of a run in the
+// mode of -progress.
+func (f *convertFlags) startProgress(phases ...string) *pipeline.Progress {
+	progress, err := pipeline.NewProgress(*f.progress, phases...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return progress
+}
+
+// options are the ConvertOptions of the parsed flags, with the corpora to
+// exclude and the vocab loaded.
+func (f *convertFlags) options(flags *flag.FlagSet) pipeline.ConvertOptions {
+	opts := pipeline.ConvertOptions{
+		OverwriteSource:  *f.overwriteSource,
+		Notes:            *f.notes,
+		Tables:           *f.tables,
+		Images:           *f.images,
+		Whitespace:       *f.whitespace,
+		Wrap:             *f.wrap,
+		Unicode:          *f.unicode,
+		Punctuation:      *f.punctuation,
+		StripBoilerplate: *f.stripBoilerplate,
+		StripFrontMatter: *f.stripFrontMatter,
+		StripBackMatter:  *f.stripBackMatter,
+		ChapterMarker:    *f.chapterMarker,
+		Dewrap:           *f.dewrap,
+		KeepLanguages:    pipeline.ParseLanguages(*f.keepLanguages),
+		Licenses:         pipeline.ParseLicenses(*f.licenseFilter),
+		QualityFilter:    *f.qualityFilter,
+		MinWords:         *f.minWords,
+		MaxWords:         *f.maxWords,
+		MaxRepetition:    *f.maxRepetition,
+		ScrubPII:         *f.scrubPII,
+		Dedupe:           *f.dedupe,
+		Provenance:       pipeline.NewProvenance(flags),
+		Tokenizer:        pipeline.WhitespaceTokenizer{},
+		Concurrency:      f.concurrency.concurrency(),
+	}
+
+	if *f.excludeCorpus != "" {
+		hashes, err := LoadCorpusHashes(strings.Split(*f.excludeCorpus, ","))
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.ExcludeHashes = hashes
+		slog.Info("Loaded hashes of books to exclude", "hashes", len(opts.ExcludeHashes))
+	}
+	if *f.openLibrary {
+		opts.OpenLibrary = pipeline.NewOpenLibraryClient()
+	}
+	if *f.report != "" {
+		if *f.report != pipeline.ReportHTML && *f.report != pipeline.ReportCSV {
+			log.Fatalf("Unsupported report format %s", *f.report)
+		}
+		opts.Report = pipeline.NewRunReport()
+	}
+	if *f.bpeVocab != "" {
+		tokenizer, err := pipeline.LoadBPETokenizer(*f.bpeVocab)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts.Tokenizer = tokenizer
+	}
+	events, err := pipeline.OpenEvents(*f.events)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Events = events
+	if opts.PostProcessors, err = f.postprocess.postProcessors(); err != nil {
+		log.Fatal(err)
+	}
+	metrics, err := pipeline.StartMetrics(*f.metricsAddr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Metrics = metrics
+	tracer, err := pipeline.NewTracer(*f.otlpEndpoint, flags.Name())
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts.Tracer = tracer
+	// the notification counts the books like the report does
+	report := opts.Report
+	if report == nil {
+		report = pipeline.NewRunReport()
+	}
+	if opts.Notifier = f.notify.notifier(flags, report); opts.Notifier != nil {
+		opts.Report = report
+	}
+	return opts
+}
+
+// outputFlags are the flags of where and how the dataset is written,
+// shared by scrape and convert.
+type outputFlags struct {
+	output       *string
+	outputFormat *string
+	shardSize    *string
+	compress     *string
+	sidecars     *bool
+	yamlHeader   *bool
+	hooks        *hookFlags
+}
+
+// addOutputFlags adds the flags of the output to a flag set.
+func addOutputFlags(flags *flag.FlagSet) *outputFlags {
+	outputPtr := flags.String("output", pipeline.OutputTxt,
+		"How to store the dataset. Options are 'txt' (a .txt file per book), 'jsonl' (a JSON object per book"+
+			" with its id, title, author, url, language, provenance and text in dataset-NNNNN.jsonl shards), 'jsonl.zst'"+
+			" (the same compressed with zstd), 'parquet', or 'tar.gz' and 'zip' (the .txt files in"+
+			" dataset-NNNNN archives). An s3://, gs:// or az://bucket/prefix URL writes the dataset to object storage instead"+
+			" of the data directory, and an sftp://user@host/path, dav:// or davs://host/path URL to a file server,"+
+			" in the format of -output-format")
+
+	outputFormatPtr := flags.String("output-format", pipeline.OutputTxt,
+		"Format of the dataset when -output is a storage URL, one of the formats of -output")
+
+	shardSizePtr := flags.String("shard-size", "0",
+		"Start a new dataset shard before one grows past this much uncompressed text (e.g. 1GB, 500MB)."+
+			" 0 puts every book of a run in one shard")
+
+	compressPtr := flags.String("compress", pipeline.CompressNone,
+		"Compress the .txt file of every book with 'gzip' (.txt.gz) or 'zstd' (.txt.zst). Options are 'none',"+
+			" 'gzip' or 'zstd'")
+
+	sidecarsPtr := flags.Bool("sidecars", false,
+		"Also write the metadata of every book to a <book>.json file next to its .txt file (in the txt, tar.gz"+
+			" and zip outputs), so the files say what they are when copied around")
+
+	yamlHeaderPtr := flags.Bool("yaml-header", false,
+		"Start the .txt file of every book with a YAML header with its title, author, url, license and the date"+
+			" it was crawled (in the txt, tar.gz and zip outputs)")
+
+	return &outputFlags{
+		output:       outputPtr,
+		outputFormat: outputFormatPtr,
+		shardSize:    shardSizePtr,
+		compress:     compressPtr,
+		sidecars:     sidecarsPtr,
+		yamlHeader:   yamlHeaderPtr,
+		hooks:        addHookFlags(flags),
+	}
+}
+
+// openDatasetRun starts a run with the conversion and output flags of scrape
+// and convert. The dataset goes to the data directory, or to remote storage
+// with the data directory only holding the downloads until they are
+// converted.
+func openDatasetRun(dataDir string, flags *flag.FlagSet, conv *convertFlags, out *outputFlags) *pipeline.Session {
+	options := pipeline.Options{
+		DataDir: dataDir,
+		Output:  *out.output,
+		Convert: conv.options(flags),
+		Flags:   flags,
+		Report:  *conv.report,
+	}
+	if storage.IsURL(*out.output) {
+		options.Output, options.OutputLocation = *out.outputFormat, *out.output
+	}
+	shardSize, err := pipeline.ParseSize(*out.shardSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	options.Sink = pipeline.SinkOptions{
+		ShardSize:   shardSize,
+		Compression: *out.compress,
+		Sidecars:    *out.sidecars,
+		YAMLHeader:  *out.yamlHeader,
+	}
+	run, err := pipeline.OpenSession(options)
+	if err != nil {
+		log.Fatal(err)
+	}
+	run.Opts.Hooks = out.hooks.hooks(dataDir, run.Store, *out.compress)
+	return run
+}
+
+// runScrape is the scrape command, which is also what runs without one: it
+// crawls a category of Smashwords and converts the books it downloads. With
+// -convert=false the epubs are left in the data directory for convert.
+func runScrape(args []string) {
+	flags := flag.NewFlagSet("scrape", flag.ExitOnError)
+	// flags used: -data_dir is the directory to save the files to, and the
+	// flags of the Smashwords source (-id is the category to scrape)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+
+	smashwordsFactory, _ := source.Lookup("smashwords")
+	newSource := smashwordsFactory(flags)
+
+	convertPtr := flags.Bool("convert", true,
+		"Convert the downloaded epubs once the crawl is done. With -convert=false they stay in the data directory"+
+			" for the convert command")
+	conv := addConvertFlags(flags)
+	out := addOutputFlags(flags)
+	shardPtr := addShardFlag(flags)
+	streamPtr := addStreamFlag(flags)
+	parseFlags(flags, args)
+
+	// log the flag parameters out to console
+	src, err := newSource()
+	if err != nil {
+		fatalUsage(err)
+	}
+	slog.Info("Saving files", "data_dir", *dataDirPtr)
+
+	run := openDatasetRun(*dataDirPtr, flags, conv, out)
+	run.Opts.Progress = conv.startProgress(pipeline.PhaseListed, pipeline.PhaseDiscovered, pipeline.PhaseDownloaded, pipeline.PhaseConverted)
+	setSmashwordsQuota(run)
+	run.Opts.Shard = parseShardFlag(*shardPtr)
+	run.Opts.Stream = parseStreamFlag(*streamPtr)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	epubs, err := run.Crawl(ctx, src)
+	if err != nil {
+		abortRun(run, err)
+	}
+	finishRun(ctx, run, epubs && *convertPtr)
+}
+
+// runConvert is the convert command: it converts the epubs downloaded to a
+// data directory, by scrape -convert=false or with -overwriteSource=false
+// and other options before, with the conversion and output flags of scrape.
+func runConvert(args []string) {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the epubs to convert")
+	conv := addConvertFlags(flags)
+	out := addOutputFlags(flags)
+	parseFlags(flags, args)
+
+	run := openDatasetRun(*dataDirPtr, flags, conv, out)
+	run.Opts.Progress = conv.startProgress(pipeline.PhaseConverted)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	finishRun(ctx, run, true)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"html/template"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// books on a page of search results
+const servePageSize int = 50
+
+var serveTemplate = template.Must(template.New("serve").Funcs(template.FuncMap{
+	"file": func(name string) string { return "/files/" + (&url.URL{Path: name}).EscapedPath() },
+	"book": func(id string) string { return "/book/" + url.PathEscape(id) },
+}).Parse(`{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border-bottom: 1px solid #ddd; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+td.n { text-align: right; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<p><a href="/">Books</a> · <a href="/files/">Files</a></p>
+{{end}}
+
+{{define "search"}}{{template "head" "Books"}}
+<form action="/" method="get">
+<input type="search" name="q" value="{{.Query}}" placeholder="Title, author or category" size="40">
+<select name="language">
+<option value="">any language</option>
+{{range .Languages}}<option value="{{.Name}}"{{if eq .Name $.Language}} selected{{end}}>{{.Name}} ({{.Count}})</option>
+{{end}}</select>
+<button type="submit">Search</button>
+</form>
+<p class="muted">{{.Total}} books{{if .Books}}, {{.From}} to {{.To}}{{end}}</p>
+{{if .Books}}<table>
+<tr><th>title</th><th>author</th><th>language</th><th>words</th><th></th></tr>
+{{range .Books}}<tr>
+<td><a href="{{book .ID}}">{{if .Title}}{{.Title}}{{else}}{{.File}}{{end}}</a></td>
+<td>{{.Author}}</td>
+<td>{{.Language}}</td>
+<td class="n">{{.Words}}</td>
+<td>{{if not .Dataset}}<a href="{{file .File}}">txt</a>{{end}}</td>
+</tr>
+{{end}}</table>{{end}}
+<p>{{if .Prev}}<a href="{{.Prev}}">previous</a>{{end}} {{if .Next}}<a href="{{.Next}}">next</a>{{end}}</p>
+</body>
+</html>
+{{end}}
+
+{{define "book"}}{{template "head" .Title}}
+<h1>{{if .Title}}{{.Title}}{{else}}{{.File}}{{end}}</h1>
+<table>
+<tr><th>author</th><td>{{.Author}}</td></tr>
+<tr><th>id</th><td>{{.ID}}</td></tr>
+<tr><th>categories</th><td>{{range $i, $c := .Categories}}{{if $i}}, {{end}}{{$c}}{{end}}</td></tr>
+<tr><th>language</th><td>{{.Language}}</td></tr>
+<tr><th>published</th><td>{{.Published}}</td></tr>
+<tr><th>words</th><td>{{.Words}}</td></tr>
+{{if .Tokens}}<tr><th>tokens</th><td>{{.Tokens}}</td></tr>{{end}}
+<tr><th>license</th><td>{{.LicenseType}}{{if .License}}<br><span class="muted">{{.License}}</span>{{end}}</td></tr>
+{{if .URL}}<tr><th>book page</th><td><a href="{{.URL}}">{{.URL}}</a></td></tr>{{end}}
+<tr><th>download</th><td>{{if .Dataset}}in <a href="{{file .Dataset}}">{{.Dataset}}</a>{{else}}<a href="{{file .File}}">{{.File}}</a>{{end}}
+{{if .Source}} · <a href="{{file .Source}}">{{.Source}}</a>{{end}}</td></tr>
+</table>
+</body>
+</html>
+{{end}}
+`))
+
+// searchPage is what the search page is made from.
+type searchPage struct {
+	Query     string
+	Language  string
+	Languages []pipeline.ReportCount
+	Books     []*pipeline.CatalogBook
+	Total     int
+	From, To  int
+	// links to the pages before and after, empty if there are none
+	Prev, Next string
+}
+
+// catalogServer serves the books of a data directory, looked up in its
+// catalog.
+type catalogServer struct {
+	dataDir string
+	catalog *pipeline.Catalog
+}
+
+func (s *catalogServer) search(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	page := &searchPage{
+		Query:    strings.TrimSpace(r.URL.Query().Get("q")),
+		Language: r.URL.Query().Get("language"),
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+	books, total, err := s.catalog.Search(page.Query, page.Language, offset, servePageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	languages, err := s.catalog.Languages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, language := range pipeline.SortedByCount(languages) {
+		page.Languages = append(page.Languages, pipeline.ReportCount{Name: language, Count: languages[language]})
+	}
+	page.Books, page.Total = books, total
+	page.From, page.To = offset+1, offset+len(books)
+	link := func(offset int) string {
+		values := url.Values{"offset": {strconv.Itoa(offset)}}
+		if page.Query != "" {
+			values.Set("q", page.Query)
+		}
+		if page.Language != "" {
+			values.Set("language", page.Language)
+		}
+		return "/?" + values.Encode()
+	}
+	if offset > 0 {
+		prev := offset - servePageSize
+		if prev < 0 {
+			prev = 0
+		}
+		page.Prev = link(prev)
+	}
+	if offset+len(books) < total {
+		page.Next = link(offset + servePageSize)
+	}
+	if err := serveTemplate.ExecuteTemplate(w, "search", page); err != nil {
+		slog.Error("Could not write the page", "path", r.URL.Path, "error", err)
+	}
+}
+
+func (s *catalogServer) book(w http.ResponseWriter, r *http.Request) {
+	book, err := s.catalog.Book(strings.TrimPrefix(r.URL.Path, "/book/"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if book == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if err := serveTemplate.ExecuteTemplate(w, "book", book); err != nil {
+		slog.Error("Could not write the page", "path", r.URL.Path, "error", err)
+	}
+}
+
+// files serves the files of the data directory, leaving out the catalog
+// itself. The text files of the manifest are found even if -compress
+// compressed them.
+func (s *catalogServer) files() http.Handler {
+	files := http.StripPrefix("/files/", http.FileServer(http.Dir(s.dataDir)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/files/"))
+		if strings.HasPrefix(path.Base(name), pipeline.CatalogFileName) {
+			http.NotFound(w, r)
+			return
+		}
+		if found, err := pipeline.FindTextFile(filepath.Join(s.dataDir, filepath.FromSlash(name))); err == nil && !strings.HasSuffix(name, "/") {
+			r.URL.Path = "/files" + filepath.ToSlash(strings.TrimPrefix(found, filepath.Clean(s.dataDir)))
+		}
+		files.ServeHTTP(w, r)
+	})
+}
+
+// runServe is the serve command. It serves a data directory over HTTP, with
+// pages to search and browse the books in its catalog and every file to
+// download, including the OPDS catalog if the opds command wrote one.
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the manifest and the files of the books")
+	addrPtr := flags.String("addr", "localhost:8080",
+		"Address to listen on, e.g. :8080 for every interface")
+	parseFlags(flags, args)
+
+	if _, err := os.Stat(*dataDirPtr); err != nil {
+		log.Fatal(err)
+	}
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	catalog, err := pipeline.OpenCatalog(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer catalog.Close()
+	if err := catalog.Sync(manifest.Records()); err != nil {
+		log.Fatal(err)
+	}
+
+	server := &catalogServer{dataDir: *dataDirPtr, catalog: catalog}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", server.search)
+	mux.HandleFunc("/book/", server.book)
+	mux.Handle("/files/", server.files())
+	slog.Info("Serving dataset", "data_dir", *dataDirPtr, "url", "http://"+*addrPtr+"/")
+	log.Fatal(http.ListenAndServe(*addrPtr, mux))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"log/slog"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// addShardFlag adds -shard to the flags of a command that crawls a source.
+func addShardFlag(flags *flag.FlagSet) *string {
+	return flags.String("shard", "",
+		"Download only the books of this shard of the crawl, like 2/8 for the second of eight, so machines"+
+			" can split a crawl between them without coordination. The books are split by a hash of their"+
+			" stable id, and the data directories of the shards merge cleanly. Empty downloads every book")
+}
+
+// parseShardFlag is the shard of the parsed -shard flag.
+func parseShardFlag(spec string) *pipeline.Shard {
+	shard, err := pipeline.ParseShard(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if shard != nil {
+		slog.Info("Downloading a shard of the books", "shard", shard.String())
+	}
+	return shard
+}
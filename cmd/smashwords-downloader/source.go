@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// startSourceRun opens a session for a command that writes its dataset to
+// the data directory in an output format like OutputTxt, with the default
+// options of a Smashwords run.
+func startSourceRun(dataDir string, output string, flags *flag.FlagSet) *pipeline.Session {
+	run, err := pipeline.OpenSession(pipeline.Options{
+		DataDir: dataDir,
+		Output:  output,
+		Convert: pipeline.DefaultConvertOptions(flags),
+		Flags:   flags,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return run
+}
+
+// httpGet is http.Get with a context.
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// downloadFile gets a url with get, like httpGet, and writes it to path.
+// Anything but a 200 is an error. It stops once ctx is done.
+func downloadFile(ctx context.Context, get func(ctx context.Context, url string) (*http.Response, error), url string, path string) error {
+	resp, err := get(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting %s: %s", url, resp.Status)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// runSource is the command of a registered source, like
+// smashwords-downloader smashwords. It downloads the books of the source
+// with the default options of a Smashwords run.
+func runSource(name string, factory source.Factory, args []string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	minWordsPtr := flags.Int("min-words", 0,
+		"Reject books with fewer words than this after conversion. 0 for no minimum")
+	progressPtr := flags.String("progress", pipeline.ProgressAuto,
+		"How to show the progress of the run: 'bars', 'log', 'auto' (bars on a terminal) or 'none'")
+	eventsPtr := flags.String("events", "",
+		"Write the events of the run as NDJSON to 'fd:N' (an open file descriptor) or 'unix:PATH' (a unix socket)")
+	metricsAddrPtr := flags.String("metrics-addr", "",
+		"Address to serve Prometheus metrics of the run at /metrics on, like :9090")
+	otlpEndpointPtr := flags.String("otlp-endpoint", os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		"OTLP/HTTP endpoint of an OpenTelemetry collector to send spans of the run to, like http://localhost:4318")
+	notify := addNotifyFlags(flags)
+	shardPtr := addShardFlag(flags)
+	concurrency := addConcurrencyFlags(flags)
+	streamPtr := addStreamFlag(flags)
+	newSource := factory(flags)
+	parseFlags(flags, args)
+	src, err := newSource()
+	if err != nil {
+		fatalUsage(err)
+	}
+
+	run := startSourceRun(*dataDirPtr, pipeline.OutputTxt, flags)
+	run.Opts.MinWords = *minWordsPtr
+	run.Opts.Shard = parseShardFlag(*shardPtr)
+	run.Opts.Concurrency = concurrency.concurrency()
+	run.Opts.Stream = parseStreamFlag(*streamPtr)
+	run.Opts.Progress, err = pipeline.NewProgress(*progressPtr, pipeline.PhaseListed, pipeline.PhaseDiscovered, pipeline.PhaseDownloaded, pipeline.PhaseConverted)
+	if err != nil {
+		log.Fatal(err)
+	}
+	run.Opts.Events, err = pipeline.OpenEvents(*eventsPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	run.Opts.Metrics, err = pipeline.StartMetrics(*metricsAddrPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if name == "smashwords" {
+		setSmashwordsQuota(run)
+	}
+	run.Opts.Tracer, err = pipeline.NewTracer(*otlpEndpointPtr, name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	report := pipeline.NewRunReport()
+	if run.Opts.Notifier = notify.notifier(flags, report); run.Opts.Notifier != nil {
+		run.Opts.Report = report
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	epubs, err := run.Crawl(ctx, src)
+	if err != nil {
+		abortRun(run, err)
+	}
+	finishRun(ctx, run, epubs)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// Split is a part of the dataset, like the training set.
+type Split struct {
+	Name   string
+	Weight float64
+}
+
+// names of the splits for 1, 2 or 3 weights
+var splitNames = [][]string{
+	{"train"},
+	{"train", "test"},
+	{"train", "validation", "test"},
+}
+
+// ParseSplits parses split weights like 98,1,1 into train, validation and
+// test splits. The weights don't have to add up to 100.
+func ParseSplits(weights string) ([]Split, error) {
+	parts := strings.Split(weights, ",")
+	if len(parts) > len(splitNames) {
+		return nil, fmt.Errorf("at most %d splits are supported", len(splitNames))
+	}
+	splits := make([]Split, len(parts))
+	total := 0.0
+	for i, part := range parts {
+		weight, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("invalid split weight %q", part)
+		}
+		splits[i] = Split{Name: splitNames[len(parts)-1][i], Weight: weight}
+		total += weight
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("split weights add up to 0")
+	}
+	for i := range splits {
+		splits[i].Weight /= total
+	}
+	return splits, nil
+}
+
+// AssignSplit picks the split of a book from a hash of its id and the seed,
+// so a book always lands in the same split no matter which other books are
+// in the dataset, and a whole book is always in one split.
+func AssignSplit(splits []Split, id string, seed int64) string {
+	x := float64(seededHash(id, seed)>>11) / float64(1<<53)
+	for _, split := range splits {
+		if x < split.Weight {
+			return split.Name
+		}
+		x -= split.Weight
+	}
+	return splits[len(splits)-1].Name
+}
+
+// ShuffleRecords puts records in an order that only depends on the seed and
+// the file names of the books, so shards aren't ordered by how popular the
+// books are on Smashwords but can still be made again.
+func ShuffleRecords(records []*pipeline.ManifestRecord, seed int64) {
+	keys := make(map[*pipeline.ManifestRecord]uint64, len(records))
+	for _, record := range records {
+		keys[record] = seededHash(record.File, seed)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return keys[records[i]] < keys[records[j]]
+	})
+}
+
+// seededHash hashes a book id together with a seed.
+func seededHash(id string, seed int64) uint64 {
+	sum := sha256.Sum256([]byte(strconv.FormatInt(seed, 10) + ":" + id))
+	return binary.BigEndian.Uint64(sum[:8])
+}
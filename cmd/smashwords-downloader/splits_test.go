@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestParseSplits(t *testing.T) {
+	tests := []struct {
+		weights string
+		want    []Split
+		wantErr bool
+	}{
+		{weights: "98,1,1", want: []Split{{"train", 0.98}, {"validation", 0.01}, {"test", 0.01}}},
+		{weights: "8, 2", want: []Split{{"train", 0.8}, {"test", 0.2}}},
+		{weights: "1", want: []Split{{"train", 1}}},
+		{weights: "1,1,0", want: []Split{{"train", 0.5}, {"validation", 0.5}, {"test", 0}}},
+		{weights: "90,5,3,2", wantErr: true},
+		{weights: "0,0", wantErr: true},
+		{weights: "90,-10", wantErr: true},
+		{weights: "ninety", wantErr: true},
+		{weights: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		splits, err := ParseSplits(tt.weights)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSplits(%q) returned %v", tt.weights, err)
+			continue
+		}
+		if len(splits) != len(tt.want) {
+			t.Errorf("ParseSplits(%q) = %v, want %v", tt.weights, splits, tt.want)
+			continue
+		}
+		for i := range splits {
+			if splits[i].Name != tt.want[i].Name || math.Abs(splits[i].Weight-tt.want[i].Weight) > 1e-9 {
+				t.Errorf("ParseSplits(%q) = %v, want %v", tt.weights, splits, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAssignSplit(t *testing.T) {
+	splits, err := ParseSplits("80,10,10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const books = 20000
+	counts := make(map[string]int)
+	moved := 0
+	for i := 0; i < books; i++ {
+		id := fmt.Sprintf("smashwords-%d", i)
+		split := AssignSplit(splits, id, 42)
+		if again := AssignSplit(splits, id, 42); again != split {
+			t.Fatalf("%s went to %s and then to %s with the same seed", id, split, again)
+		}
+		if AssignSplit(splits, id, 7) != split {
+			moved++
+		}
+		counts[split]++
+	}
+	for _, split := range splits {
+		share := float64(counts[split.Name]) / books
+		if math.Abs(share-split.Weight) > 0.01 {
+			t.Errorf("%s got %.3f of the books, want %.2f", split.Name, share, split.Weight)
+		}
+	}
+	// another seed makes another assignment
+	if moved < books/5 {
+		t.Errorf("only %d of %d books changed split with another seed", moved, books)
+	}
+
+	// every book goes to the only split
+	only, _ := ParseSplits("1")
+	if split := AssignSplit(only, "smashwords-1", 42); split != "train" {
+		t.Errorf("the book went to %s with a single split", split)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+const (
+	// the OPDS feed of every Standard Ebooks book, which needs the email of a
+	// member of their Patrons Circle
+	standardEbooksFeedURL string = "https://standardebooks.org/feeds/opds/all"
+
+	// Standard Ebooks only publishes books in the public domain in the USA,
+	// and dedicates its editions to the public domain too
+	standardEbooksLicense string = "This ebook is in the public domain in the USA."
+)
+
+// StandardEbooksDocumentID returns the stable id of a Standard Ebooks book:
+// the path of its book page, like
+// standardebooks-jane-austen_pride-and-prejudice-epub.
+func StandardEbooksDocumentID(bookURL string) string {
+	u, err := url.Parse(bookURL)
+	if err != nil {
+		return ""
+	}
+	slug := strings.Trim(strings.TrimPrefix(u.Path, "/ebooks/"), "/")
+	if slug == "" {
+		return ""
+	}
+	return "standardebooks-" + strings.ReplaceAll(slug, "/", "_") + "-epub"
+}
+
+// standardEbookInfo is what the feed says about a book, with its book page
+// as its url. Its categories are its subjects, like Fiction, and then its
+// Library of Congress subjects.
+func standardEbookInfo(e *opdsSourceEntry) pipeline.BookInfo {
+	book := e.BookInfo()
+	book.URL = e.ID
+	book.Price = "0"
+	book.License = standardEbooksLicense
+	return book
+}
+
+// standardEbookLink returns the url of the epub of a book, preferring the
+// compatible epub that works with every reader over the advanced one.
+func standardEbookLink(e *opdsSourceEntry) string {
+	href := ""
+	for _, link := range e.Links {
+		if !strings.HasPrefix(link.Type, "application/epub+zip") || !strings.HasPrefix(link.Rel, "http://opds-spec.org/acquisition") {
+			continue
+		}
+		if href == "" || strings.Contains(strings.ToLower(link.Title), "compatible") {
+			href = link.Href
+		}
+	}
+	return href
+}
+
+// standardEbooksClient gets the feed and the epubs of Standard Ebooks, as a
+// member of their Patrons Circle if there is an email.
+type standardEbooksClient struct {
+	*opdsClient
+}
+
+func newStandardEbooksClient(email string) *standardEbooksClient {
+	return &standardEbooksClient{&opdsClient{user: email,
+		authHint: "-email must be the email of a Patrons Circle member"}}
+}
+
+// download downloads the epub of a book to the data directory, where it
+// waits to be converted with the other epubs. A download ctx stopped is
+// left for the next run.
+func (c *standardEbooksClient) download(ctx context.Context, entry *opdsSourceEntry, dataDir string, manifest *pipeline.Manifest, opts pipeline.ConvertOptions) {
+	info := standardEbookInfo(entry)
+	docID := StandardEbooksDocumentID(entry.ID)
+	if docID == "" {
+		slog.Debug("Skipping book without a book page", "phase", pipeline.LogPhaseDownload, "title", info.Title)
+		opts.Report.Skip(info.Title, "", "no id")
+		return
+	}
+	textFileName := docID + ".txt"
+	fileName := docID + ".epub"
+	if manifest.Get(textFileName) != nil {
+		opts.Report.Skip(info.Title, textFileName, "exists: epub")
+		return
+	}
+	if _, err := os.Stat(filepath.Join(dataDir, fileName)); err == nil {
+		opts.Report.Skip(info.Title, fileName, "exists: epub")
+		return
+	}
+	link := standardEbookLink(entry)
+	if link == "" {
+		pipeline.BookLogger(docID, info.URL, pipeline.LogPhaseDownload).Debug("Skipping book without an epub", "title", info.Title)
+		opts.Report.Skip(info.Title, fileName, "no epub")
+		return
+	}
+
+	partialFilePath := filepath.Join(dataDir, fileName+".part")
+	err := downloadFile(ctx, c.get, link, partialFilePath)
+	if err == nil {
+		err = pipeline.ValidateEpub(partialFilePath)
+	}
+	if err != nil {
+		os.Remove(partialFilePath)
+		if ctx.Err() != nil {
+			return
+		}
+		pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Warn("Could not download book", "title", info.Title, "error", err)
+		opts.Report.Fail(info.Title, fileName, "download", err.Error())
+		if err := manifest.LogError(fileName, "download", err.Error()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	manifest.Put(&pipeline.ManifestRecord{
+		File:         textFileName,
+		ID:           docID,
+		Source:       fileName,
+		Format:       "epub",
+		BookInfo:     info,
+		DownloadedAt: time.Now().UTC(),
+		Provenance:   opts.Provenance.ForSource(link),
+	})
+	if err := os.Rename(partialFilePath, filepath.Join(dataDir, fileName)); err != nil {
+		log.Fatal(err)
+	}
+	pipeline.BookLogger(docID, link, pipeline.LogPhaseDownload).Info("Downloaded book", "title", info.Title)
+}
+
+// runStandardEbooks is the standardebooks command. It downloads the epubs
+// of the OPDS feed of Standard Ebooks and converts them like Smashwords
+// epubs. Their stable ids are standardebooks-<author>_<title>-epub.
+func runStandardEbooks(args []string) {
+	flags := flag.NewFlagSet("standardebooks", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	feedPtr := flags.String("feed", standardEbooksFeedURL,
+		"URL of the OPDS acquisition feed to download the books of")
+	emailPtr := flags.String("email", os.Getenv("STANDARD_EBOOKS_EMAIL"),
+		"Email of the Patrons Circle member the feed is read as (default $STANDARD_EBOOKS_EMAIL)")
+	languagePtr := flags.String("language", "",
+		"Comma separated languages of the books to download (e.g. en-US,en-GB or en). Empty for every language")
+	limitPtr := flags.Int("limit", 0,
+		"The number of books to download. 0 for every book of the feed")
+	delayPtr := flags.Duration("delay", time.Second,
+		"Time to wait between downloads")
+	parseFlags(flags, args)
+	dataDir := *dataDirPtr
+
+	client := newStandardEbooksClient(*emailPtr)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	entries, err := client.Entries(ctx, *feedPtr, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	languages := pipeline.ParseLanguages(*languagePtr)
+	var selected []*opdsSourceEntry
+	for _, entry := range entries {
+		if entry.keepLanguage(languages) {
+			selected = append(selected, entry)
+		}
+	}
+	if *limitPtr > 0 && len(selected) > *limitPtr {
+		selected = selected[:*limitPtr]
+	}
+	slog.Info("Downloading books of the feed", "books", len(selected), "feed_books", len(entries), "data_dir", dataDir)
+
+	run := startSourceRun(dataDir, pipeline.OutputTxt, flags)
+	for i, entry := range selected {
+		if i > 0 {
+			sleep(ctx, *delayPtr)
+		}
+		if ctx.Err() != nil {
+			abortRun(run, ctx.Err())
+		}
+		client.download(ctx, entry, dataDir, run.Manifest, run.Opts)
+	}
+	finishRun(ctx, run, true)
+}
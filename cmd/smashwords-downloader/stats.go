@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// CorpusStats are the statistics the stats command prints: the summary of the
+// dataset, with the categories of the books and how long they are.
+type CorpusStats struct {
+	*pipeline.DatasetSummary
+	// books in each category they are listed in, so a book counts once for
+	// every one of its categories
+	Categories map[string]int `json:"categories"`
+	// percentiles of the length of the books
+	WordPercentiles  LengthPercentiles `json:"word_percentiles"`
+	TokenPercentiles LengthPercentiles `json:"token_percentiles"`
+	BytePercentiles  LengthPercentiles `json:"byte_percentiles"`
+}
+
+// LengthPercentiles are percentiles of the lengths of the books.
+type LengthPercentiles struct {
+	Min int `json:"min"`
+	P10 int `json:"p10"`
+	P25 int `json:"p25"`
+	P50 int `json:"p50"`
+	P75 int `json:"p75"`
+	P90 int `json:"p90"`
+	P99 int `json:"p99"`
+	Max int `json:"max"`
+}
+
+// NewLengthPercentiles returns the percentiles of lengths by the nearest
+// rank, all zero if there are none.
+func NewLengthPercentiles(lengths []int) LengthPercentiles {
+	if len(lengths) == 0 {
+		return LengthPercentiles{}
+	}
+	sorted := append([]int(nil), lengths...)
+	sort.Ints(sorted)
+	rank := func(p int) int {
+		i := (p*len(sorted)+99)/100 - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return LengthPercentiles{
+		Min: sorted[0],
+		P10: rank(10),
+		P25: rank(25),
+		P50: rank(50),
+		P75: rank(75),
+		P90: rank(90),
+		P99: rank(99),
+		Max: sorted[len(sorted)-1],
+	}
+}
+
+// ComputeStats computes the statistics of the books in the dataset of a
+// manifest.
+func ComputeStats(manifest *pipeline.Manifest) *CorpusStats {
+	stats := &CorpusStats{
+		DatasetSummary: pipeline.SummarizeDataset(manifest, nil),
+		Categories:     make(map[string]int),
+	}
+	var words, tokens, bytes []int
+	for _, record := range manifest.Records() {
+		if !pipeline.InDataset(record) {
+			continue
+		}
+		words = append(words, record.Words)
+		if record.Tokenizer != "" {
+			tokens = append(tokens, record.Tokens)
+		}
+		bytes = append(bytes, record.Chars)
+		for _, category := range record.Categories {
+			stats.Categories[category]++
+		}
+	}
+	stats.WordPercentiles = NewLengthPercentiles(words)
+	stats.TokenPercentiles = NewLengthPercentiles(tokens)
+	stats.BytePercentiles = NewLengthPercentiles(bytes)
+	return stats
+}
+
+// WriteTable prints the statistics as tables, with the top most common
+// categories, or all of them if top is 0.
+func (s *CorpusStats) WriteTable(out io.Writer, top int) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "books\twords\ttokens\tbytes\tsize\trejected\t")
+	fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%s\t%d\t\n", s.Documents, s.Words, s.Tokens, s.Bytes, pipeline.FormatSize(s.Bytes), s.Rejected)
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "length\tmin\tp10\tp25\tp50\tp75\tp90\tp99\tmax\t")
+	for _, row := range []struct {
+		name string
+		p    LengthPercentiles
+	}{{"words", s.WordPercentiles}, {"tokens", s.TokenPercentiles}, {"bytes", s.BytePercentiles}} {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t\n",
+			row.name, row.p.Min, row.p.P10, row.p.P25, row.p.P50, row.p.P75, row.p.P90, row.p.P99, row.p.Max)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	writeCounts := func(heading string, counts map[string]int, top int) error {
+		if len(counts) == 0 {
+			return nil
+		}
+		fmt.Fprintln(out)
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "%s\tbooks\tshare\n", heading)
+		keys := pipeline.SortedByCount(counts)
+		if top > 0 && len(keys) > top {
+			keys = keys[:top]
+		}
+		for _, key := range keys {
+			fmt.Fprintf(w, "%s\t%d\t%.1f%%\n", key, counts[key], 100*float64(counts[key])/float64(s.Documents))
+		}
+		return w.Flush()
+	}
+	if err := writeCounts("tokenizer", s.Tokenizers, 0); err != nil {
+		return err
+	}
+	if err := writeCounts("language", s.Languages, 0); err != nil {
+		return err
+	}
+	return writeCounts("category", s.Categories, top)
+}
+
+// runStats is the stats command. It prints statistics of the books in the
+// dataset of a data directory, as read from its manifest.
+func runStats(args []string) {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory with the manifest of the books")
+	jsonPtr := flags.Bool("json", false,
+		"Print the statistics as JSON instead of tables")
+	topPtr := flags.Int("top", 20,
+		"Number of categories to list in the table, 0 for all of them")
+	parseFlags(flags, args)
+
+	manifest, err := pipeline.LoadManifest(*dataDirPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stats := ComputeStats(manifest)
+
+	if *jsonPtr {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(stats); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if err := stats.WriteTable(os.Stdout, *topPtr); err != nil {
+		log.Fatal(err)
+	}
+}
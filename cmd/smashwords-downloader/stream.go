@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+)
+
+// addStreamFlag adds -stream to the flags of a command that crawls a source.
+func addStreamFlag(flags *flag.FlagSet) *string {
+	return flags.String("stream", pipeline.StreamOff,
+		"When to convert the downloaded epubs. Options are 'off' (all of them once the crawl is done), 'file'"+
+			" (each right after it is downloaded, deleting the epub once it is converted) or 'memory' (each"+
+			" right after it is downloaded, without writing the epub to the data directory)")
+}
+
+// parseStreamFlag checks the parsed -stream flag.
+func parseStreamFlag(mode string) string {
+	switch mode {
+	case pipeline.StreamOff, pipeline.StreamFile, pipeline.StreamMemory:
+		return mode
+	}
+	log.Fatalf("Unsupported stream mode %s", mode)
+	return ""
+}
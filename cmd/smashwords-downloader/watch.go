@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"github.com/coreweave/dataset-downloader/pkg/smashwords"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// runWatch is the watch command. It stays up and polls the newest books of
+// categories of Smashwords every -interval, downloading the ones that
+// appeared since the last poll into the dataset and the manifest. With
+// notifications set up, every poll that added books is notified.
+func runWatch(args []string) {
+	flags := flag.NewFlagSet("watch", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory that the book files will download to")
+	intervalPtr := flags.Duration("interval", 6*time.Hour,
+		"How long to wait between polls, like 30m or 6h")
+	categoriesPtr := flags.String("categories", "",
+		"Comma separated ids of the Smashwords categories to watch, like 1245,1250")
+	pagesPtr := flags.Int("pages", 1,
+		"The number of pages of the newest books of every category to poll")
+	itemsPerPagePtr := flags.Int("pageitems", 20,
+		"The number of books on a page")
+	formatPtr := flags.String("format", "txt",
+		"The format of the books to download: 'all', 'txt' or 'epub'")
+	conv := addConvertFlags(flags)
+	out := addOutputFlags(flags)
+	shardPtr := addShardFlag(flags)
+	streamPtr := addStreamFlag(flags)
+	parseFlags(flags, args)
+
+	if *intervalPtr < time.Minute {
+		log.Fatalf("Interval %s is too short, poll at most once a minute", *intervalPtr)
+	}
+	categories := parseCategories(*categoriesPtr)
+	if *formatPtr != "all" && *formatPtr != "txt" && *formatPtr != "epub" {
+		log.Fatalf("Unsupported format %s", *formatPtr)
+	}
+	var sources []source.Source
+	// the categories are crawled one after the other, at the same pace
+	limiter := source.NewTokenBucket(smashwords.DefaultRate, smashwords.DefaultBurst)
+	for _, category := range categories {
+		id, _ := strconv.Atoi(category)
+		sources = append(sources, &smashwords.Smashwords{
+			CategoryID:   id,
+			ItemsPerPage: *itemsPerPagePtr,
+			Pages:        *pagesPtr,
+			Format:       *formatPtr,
+			Sort:         smashwords.SortNewest,
+			RateLimiter:  limiter,
+		})
+	}
+	slog.Info("Saving files", "data_dir", *dataDirPtr)
+
+	run := openDatasetRun(*dataDirPtr, flags, conv, out)
+	run.Opts.Progress = conv.startProgress(pipeline.PhaseListed, pipeline.PhaseDiscovered, pipeline.PhaseDownloaded, pipeline.PhaseConverted)
+	setSmashwordsQuota(run)
+	run.Opts.Shard = parseShardFlag(*shardPtr)
+	run.Opts.Stream = parseStreamFlag(*streamPtr)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	poll := func() {
+		started := time.Now()
+		convertEpubs := false
+		for i, src := range sources {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Info("Polling category for new books", "category_id", categories[i])
+			epubs, err := run.Crawl(ctx, src)
+			convertEpubs = convertEpubs || epubs
+			if errors.Is(err, source.ErrRateLimited) {
+				// the next poll tries again
+				slog.Warn("Rate limited by smashwords, waiting for the next poll", "category_id", categories[i])
+				break
+			} else if err != nil && ctx.Err() == nil {
+				run.Opts.Events.Emit(pipeline.Event{Type: pipeline.EventError, Error: err.Error()})
+				slog.Warn("Poll of category did not complete", "category_id", categories[i], "error", err)
+			}
+		}
+		if convertEpubs {
+			if err := pipeline.ConvertEpubGo(ctx, run.DataDir, run.Opts, run.Manifest, run.Sink); err != nil {
+				abortRun(run, err)
+			}
+		}
+		if err := run.Manifest.Save(); err != nil {
+			log.Fatal(err)
+		}
+		run.Opts.Notifier.NotifyNewBooks(started)
+	}
+
+	for {
+		poll()
+		slog.Info("Waiting for the next poll", "at", time.Now().Add(*intervalPtr).Format(time.RFC3339))
+		select {
+		case <-time.After(*intervalPtr):
+		case <-ctx.Done():
+			slog.Info("Stopped watching")
+			finishRun(context.Background(), run, false)
+			return
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/epub2text"
+	"github.com/coreweave/dataset-downloader/pkg/pipeline"
+	"golang.org/x/net/html"
+)
+
+const (
+	// subpages of subpages, like Book/Part I/Chapter 1, are followed this
+	// deep
+	wikisourceMaxDepth int = 2
+	// pages of a work fetched before giving up on it, for pages that link
+	// to far more subpages than a book has
+	wikisourceMaxPages int = 500
+)
+
+// classes Wikisource puts on what isn't the text of a work: the header and
+// navigation of every page, the license banner, page numbers, edit links and
+// footnote markers. WS Export leaves the same out.
+var wikisourceSkipClasses = []string{"ws-noexport", "noprint", "mw-editsection", "ws-pagenum", "pagenum", "reference",
+	"mw-references-wrap", "licenseContainer", "ws-header", "wst-header"}
+
+// WikisourcePage is a page of Wikisource, as the parse API of MediaWiki
+// returns it.
+type WikisourcePage struct {
+	Title      string `json:"title"`
+	PageID     int    `json:"pageid"`
+	Text       string `json:"text"`
+	Categories []struct {
+		Category string `json:"category"`
+		Hidden   bool   `json:"hidden"`
+	} `json:"categories"`
+}
+
+// wikisourceClient talks to the MediaWiki API of a Wikisource.
+type wikisourceClient struct {
+	// like https://en.wikisource.org
+	baseURL string
+}
+
+func (c *wikisourceClient) api(ctx context.Context, params url.Values, v interface{}) error {
+	params.Set("format", "json")
+	params.Set("formatversion", "2")
+	location := c.baseURL + "/w/api.php?" + params.Encode()
+	resp, err := httpGet(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("getting %s: %s", location, resp.Status)
+	}
+	var body struct {
+		Error *struct {
+			Info string `json:"info"`
+		} `json:"error"`
+	}
+	data := new(bytes.Buffer)
+	if _, err := data.ReadFrom(resp.Body); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data.Bytes(), &body); err == nil && body.Error != nil {
+		return fmt.Errorf("getting %s: %s", location, body.Error.Info)
+	}
+	return json.Unmarshal(data.Bytes(), v)
+}
+
+// Page returns the HTML of a page and its categories, following redirects.
+func (c *wikisourceClient) Page(ctx context.Context, title string) (*WikisourcePage, error) {
+	var body struct {
+		Parse WikisourcePage `json:"parse"`
+	}
+	err := c.api(ctx, url.Values{
+		"action":    {"parse"},
+		"page":      {title},
+		"prop":      {"text|categories"},
+		"redirects": {"1"},
+	}, &body)
+	return &body.Parse, err
+}
+
+// CategoryMembers returns the titles of the pages of the main namespace in a
+// category, up to limit of them if it isn't 0.
+func (c *wikisourceClient) CategoryMembers(ctx context.Context, category string, limit int) ([]string, error) {
+	if !strings.Contains(category, ":") {
+		category = "Category:" + category
+	}
+	var titles []string
+	params := url.Values{
+		"action":      {"query"},
+		"list":        {"categorymembers"},
+		"cmtitle":     {category},
+		"cmnamespace": {"0"},
+		"cmtype":      {"page"},
+		"cmlimit":     {"500"},
+	}
+	for {
+		var body struct {
+			Query struct {
+				Members []struct {
+					Title string `json:"title"`
+				} `json:"categorymembers"`
+			} `json:"query"`
+			Continue map[string]string `json:"continue"`
+		}
+		if err := c.api(ctx, params, &body); err != nil {
+			return nil, err
+		}
+		for _, member := range body.Query.Members {
+			titles = append(titles, member.Title)
+			if limit > 0 && len(titles) == limit {
+				return titles, nil
+			}
+		}
+		if body.Continue["cmcontinue"] == "" {
+			return titles, nil
+		}
+		params.Set("cmcontinue", body.Continue["cmcontinue"])
+	}
+}
+
+// hasClass reports whether an element has any of classes.
+func hasClass(n *html.Node, classes []string) bool {
+	for _, a := range n.Attr {
+		if a.Key != "class" {
+			continue
+		}
+		for _, class := range strings.Fields(a.Val) {
+			for _, skip := range classes {
+				if class == skip {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// nodeText returns the text in a node, with its whitespace collapsed.
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// elementText returns the text of the first element with an id, or "".
+func elementText(n *html.Node, id string) string {
+	if n.Type == html.ElementNode {
+		for _, a := range n.Attr {
+			if a.Key == "id" && a.Val == id {
+				return nodeText(n)
+			}
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if text := elementText(c, id); text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
+// wikisourcePageContent is what a page of a work has: its text, with the
+// header and navigation taken out, and the subpages it links to in order.
+type wikisourcePageContent struct {
+	HTML     string
+	Subpages []string
+	// what the header of the page says, and the license banner
+	Author  string
+	Year    string
+	License string
+}
+
+// readWikisourcePage takes the text of a page out of its HTML.
+func readWikisourcePage(title string, pageHTML string) (*wikisourcePageContent, error) {
+	doc, err := html.Parse(strings.NewReader(pageHTML))
+	if err != nil {
+		return nil, err
+	}
+	content := &wikisourcePageContent{
+		Author: elementText(doc, "ws-author"),
+		Year:   elementText(doc, "ws-year"),
+	}
+	seen := make(map[string]bool)
+	prefix := strings.ReplaceAll(title, " ", "_") + "/"
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			if c.Type == html.ElementNode && hasClass(c, []string{"licenseContainer", "licenseBanner"}) && content.License == "" {
+				content.License = nodeText(c)
+			}
+			if c.Type == html.ElementNode && hasClass(c, wikisourceSkipClasses) {
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+			if c.Type == html.ElementNode && c.Data == "a" {
+				for _, a := range c.Attr {
+					if a.Key != "href" || !strings.HasPrefix(a.Val, "/wiki/") {
+						continue
+					}
+					target, _, _ := strings.Cut(strings.TrimPrefix(a.Val, "/wiki/"), "#")
+					if target, err := url.PathUnescape(target); err == nil && strings.HasPrefix(target, prefix) && !seen[target] {
+						seen[target] = true
+						content.Subpages = append(content.Subpages, strings.ReplaceAll(target, "_", " "))
+					}
+				}
+			}
+			walk(c)
+			c = next
+		}
+	}
+	walk(doc)
+	var sb strings.Builder
+	if err := html.Render(&sb, doc); err != nil {
+		return nil, err
+	}
+	content.HTML = sb.String()
+	return content, nil
+}
+
+// WikisourceWork is a work of Wikisource put together from its page and its
+// subpages.
+type WikisourceWork struct {
+	Page    *WikisourcePage
+	Book    pipeline.BookInfo
+	Text    string
+	Pages   int
+	Updated time.Time
+}
+
+// Work puts together a work from its main page and the subpages it links to
+// in order, which hold the chapters of most books. The main page of a book
+// with chapters is only its table of contents, so its text is left out.
+func (c *wikisourceClient) Work(ctx context.Context, page *WikisourcePage, opts pipeline.ConvertOptions) (*WikisourceWork, map[string]bool, error) {
+	title := page.Title
+	main, err := readWikisourcePage(page.Title, page.Text)
+	if err != nil {
+		return nil, nil, err
+	}
+	work := &WikisourceWork{Page: page, Updated: time.Now().UTC()}
+	work.Book = pipeline.BookInfo{
+		Title:     page.Title,
+		Author:    main.Author,
+		URL:       c.baseURL + "/wiki/" + url.PathEscape(strings.ReplaceAll(page.Title, " ", "_")),
+		Published: main.Year,
+		License:   main.License,
+	}
+	for _, category := range page.Categories {
+		if !category.Hidden {
+			work.Book.Categories = append(work.Book.Categories, strings.ReplaceAll(category.Category, "_", " "))
+		}
+	}
+
+	// the text of the pages, in order, headings and all. Images are left on
+	// Wikimedia Commons.
+	epubOpts := opts.EpubOptions("", "")
+	epubOpts.Images = pipeline.ImagesDrop
+	var document strings.Builder
+	var headings []string
+	add := func(content *wikisourcePageContent) error {
+		converted, err := epub2text.ConvertHTML(strings.NewReader(content.HTML), epubOpts)
+		if err != nil {
+			return err
+		}
+		document.WriteString(converted.Text)
+		document.WriteString("\n\n")
+		headings = append(headings, converted.Headings...)
+		work.Pages++
+		return nil
+	}
+	var addPages func(content *wikisourcePageContent, depth int) error
+	addPages = func(content *wikisourcePageContent, depth int) error {
+		if len(content.Subpages) == 0 || depth > wikisourceMaxDepth {
+			return add(content)
+		}
+		for _, subtitle := range content.Subpages {
+			if work.Pages >= wikisourceMaxPages {
+				return fmt.Errorf("%s has more than %d pages", title, wikisourceMaxPages)
+			}
+			subpage, err := c.Page(ctx, subtitle)
+			if err != nil {
+				return err
+			}
+			sub, err := readWikisourcePage(subpage.Title, subpage.Text)
+			if err != nil {
+				return err
+			}
+			if err := addPages(sub, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := addPages(main, 1); err != nil {
+		return nil, nil, err
+	}
+	work.Text = document.String()
+	return work, pipeline.HeadingKeys(headings), nil
+}
+
+// MakeWikisourceDocumentID returns the stable id of a work of a Wikisource,
+// by the language of the Wikisource and the id of the main page of the work,
+// like wikisource-en-12345. Titles of works get moved, ids don't.
+func MakeWikisourceDocumentID(language string, pageID int) string {
+	return fmt.Sprintf("wikisource-%s-%d", language, pageID)
+}
+
+// runWikisource is the wikisource command. It exports works of Wikisource,
+// the ones in a category or the ones named as arguments, putting together
+// every work from its pages into a book of the dataset.
+func runWikisource(args []string) {
+	flags := flag.NewFlagSet("wikisource", flag.ExitOnError)
+	dataDirPtr := flags.String("data_dir", "./data",
+		"directory to write the books to")
+	languagePtr := flags.String("lang", "en",
+		"Language of the Wikisource to export from, as in en.wikisource.org")
+	categoryPtr := flags.String("category", "",
+		"Category of the works to export, e.g. 'Novels'. The titles of works can also be given as arguments")
+	limitPtr := flags.Int("limit", 100,
+		"The number of works of the category to export. 0 for all of them")
+	outputPtr := flags.String("output", pipeline.OutputTxt,
+		"How to store the dataset, one of the formats of -output of a Smashwords run")
+	delayPtr := flags.Duration("delay", time.Second,
+		"Time to wait between works")
+	baseURLPtr := flags.String("base_url", "",
+		"URL of the wiki, instead of the Wikisource of -lang")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: smashwords-downloader wikisource [-lang en] [-category Novels] [title]...")
+		flags.PrintDefaults()
+	}
+	parseFlags(flags, args)
+
+	baseURL := strings.TrimSuffix(*baseURLPtr, "/")
+	if baseURL == "" {
+		baseURL = "https://" + *languagePtr + ".wikisource.org"
+	}
+	client := &wikisourceClient{baseURL: baseURL}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	titles := flags.Args()
+	if *categoryPtr != "" {
+		members, err := client.CategoryMembers(ctx, *categoryPtr, *limitPtr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		titles = append(titles, members...)
+	}
+	if len(titles) == 0 {
+		flags.Usage()
+		log.Fatal("Set -category or give the titles of works to export")
+	}
+	slog.Info("Exporting works", "works", len(titles), "url", baseURL, "data_dir", *dataDirPtr)
+
+	run := startSourceRun(*dataDirPtr, *outputPtr, flags)
+	for i, title := range titles {
+		if i > 0 {
+			sleep(ctx, *delayPtr)
+		}
+		if ctx.Err() != nil {
+			abortRun(run, ctx.Err())
+		}
+		page, err := client.Page(ctx, title)
+		if ctx.Err() != nil {
+			continue
+		} else if err != nil {
+			slog.Warn("Could not get work", "phase", pipeline.LogPhaseDownload, "title", title, "error", err)
+			run.Opts.Report.Fail(title, "", "download", err.Error())
+			continue
+		}
+		docID := MakeWikisourceDocumentID(*languagePtr, page.PageID)
+		fileName := docID + ".txt"
+		if run.Manifest.Get(fileName) != nil {
+			run.Opts.Report.Skip(page.Title, fileName, "exists: html")
+			continue
+		}
+		work, headings, err := client.Work(ctx, page, run.Opts)
+		if ctx.Err() != nil {
+			continue
+		} else if err != nil {
+			pipeline.BookLogger(docID, "", pipeline.LogPhaseDownload).Warn("Could not export work", "title", title, "error", err)
+			run.Opts.Report.Fail(page.Title, fileName, "download", err.Error())
+			if err := run.Manifest.LogError(fileName, "download", err.Error()); err != nil {
+				log.Fatal(err)
+			}
+			continue
+		}
+		text, report := pipeline.CleanText(work.Text, run.Opts, headings)
+		record := &pipeline.ManifestRecord{
+			File:         fileName,
+			ID:           docID,
+			Format:       "html",
+			BookInfo:     work.Book,
+			DownloadedAt: work.Updated,
+			ConvertedAt:  work.Updated,
+			Provenance:   run.Opts.Provenance.ForSource(work.Book.URL),
+		}
+		if err := pipeline.WriteCleanText(ctx, record, text, report, run.DataDir, run.Manifest, run.Sink, run.Opts); err != nil {
+			continue
+		}
+		pipeline.BookLogger(docID, work.Book.URL, pipeline.LogPhaseDownload).Info("Exported work", "title", work.Book.Title,
+			"pages", work.Pages)
+	}
+	finishRun(ctx, run, false)
+}
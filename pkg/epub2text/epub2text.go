@@ -0,0 +1,206 @@
+// Package epub2text converts epubs, and the html of their chapters, to plain
+// text: the running text of the spine with footnotes and endnotes put in
+// place or collected at the end, tables lined up, images dropped or replaced
+// with their alt text, and what the epub says about itself. It knows nothing
+// of where the epub came from, and leaves the cleanup of the text (license
+// notes, front and back matter, Unicode) to its callers.
+package epub2text
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/taylorskalyo/goreader/epub"
+	"golang.org/x/net/html"
+)
+
+// Options controls how an epub is converted.
+type Options struct {
+	// one of NotesKeep, NotesInline or NotesAppend
+	Notes string
+	// one of TablesText or TablesMarkdown
+	Tables string
+	// one of ImagesDrop, ImagesPlaceholder or ImagesExtract
+	Images string
+	// one of WhitespaceCollapse, WhitespaceTrim or WhitespacePreserve. Only
+	// collapse changes the text here, the others are left to the caller
+	Whitespace string
+	// column to wrap paragraphs at, 0 for no wrapping
+	Wrap int
+	// directory images are extracted to with ImagesExtract, and the path
+	// the placeholders in the text point at them by
+	ImagesDir  string
+	ImagesPath string
+}
+
+// Document is a converted epub.
+type Document struct {
+	// the text of the chapters in reading order, paragraphs separated by a
+	// blank line. Empty from ConvertTo, which writes it out instead
+	Text string
+	// the notes section of NotesAppend, which goes after Text, empty
+	// without notes
+	Notes string
+
+	// the metadata of the package document. Author is the first creator
+	Title       string
+	Author      string
+	Rights      string
+	Creators    []string
+	Languages   []string
+	Subjects    []string
+	Publishers  []string
+	Identifiers map[string]string
+
+	// the documents of the spine, in reading order
+	Chapters []Chapter
+	// the text of the paragraphs that came from h1-h3 tags, in order
+	Headings []string
+	// the images of the text, in order
+	Images []Image
+	// what went wrong without failing the conversion, like images that
+	// could not be extracted
+	Warnings []string
+}
+
+// Chapter is a document of the spine of an epub.
+type Chapter struct {
+	// the path of the document in the epub
+	Href string
+	// where its text starts in the Text of the Document, or in what
+	// ConvertTo wrote
+	Offset int
+}
+
+// Image is an image of the text.
+type Image struct {
+	// the src of the img tag and its alt text
+	Src string
+	Alt string
+	// the path of the image under ImagesDir once extracted, empty if it
+	// wasn't
+	Path string
+}
+
+// Convert converts the epub of size bytes read from r.
+func Convert(r io.ReaderAt, size int64, opts Options) (Document, error) {
+	return ConvertContext(context.Background(), r, size, opts)
+}
+
+// ConvertContext is Convert, which stops between chapters once ctx is done
+// and returns its error.
+func ConvertContext(ctx context.Context, r io.ReaderAt, size int64, opts Options) (Document, error) {
+	var text strings.Builder
+	doc, err := ConvertTo(ctx, &text, r, size, opts)
+	if err != nil {
+		return Document{}, err
+	}
+	doc.Text = text.String()
+	return doc, nil
+}
+
+// ConvertTo is ConvertContext, which writes the text of the chapters to w
+// as it goes, through a buffer, instead of returning it in Text. The book
+// takes as much memory as its longest paragraph, whatever its size, and the
+// notes of NotesAppend, which are still returned in Notes to go after it.
+func ConvertTo(ctx context.Context, w io.Writer, r io.ReaderAt, size int64, opts Options) (Document, error) {
+	rc, err := epub.NewReader(r, size)
+	if err != nil {
+		return Document{}, err
+	}
+	if len(rc.Rootfiles) == 0 {
+		return Document{}, fmt.Errorf("no rootfile in the epub")
+	}
+	// there may be more than one rootfile (content.opf), although there is
+	// hardly ever
+	book := rc.Rootfiles[0]
+
+	// footnotes and endnotes are looked up before parsing since the
+	// references can come before the notes
+	notes, err := collectNotes(book, opts.Notes)
+	if err != nil {
+		return Document{}, err
+	}
+
+	doc := &Document{
+		Title:  book.Title,
+		Author: book.Creator,
+		Rights: book.Rights,
+	}
+	state := &bookContext{
+		Items: book.Manifest.Items,
+		Notes: notes,
+		Opts:  opts,
+		doc:   doc,
+	}
+
+	out := newTextWriter(w)
+	for _, itemref := range book.Spine.Itemrefs {
+		if err := ctx.Err(); err != nil {
+			return Document{}, err
+		}
+		f, err := itemref.Open()
+		if err != nil {
+			return Document{}, fmt.Errorf("opening %s: %w", itemref.HREF, err)
+		}
+		doc.Chapters = append(doc.Chapters, Chapter{Href: itemref.HREF, Offset: out.Len()})
+		err = parseText(f, out, itemref.HREF, state)
+		f.Close()
+		if err != nil {
+			return Document{}, fmt.Errorf("parsing %s: %w", itemref.HREF, err)
+		}
+		if out.err != nil {
+			return Document{}, out.err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return Document{}, err
+	}
+	doc.Notes = notes.Appendix()
+
+	// the package document knows more than goreader keeps of it
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return Document{}, err
+	}
+	metadata, err := readOPFMetadata(z, book.FullPath)
+	if err != nil {
+		doc.Warnings = append(doc.Warnings, err.Error())
+	} else {
+		metadata.apply(doc)
+	}
+	return *doc, nil
+}
+
+// ConvertHTML converts an html document, like a chapter of an epub or a
+// page of a site, that has no notes and no images to extract.
+func ConvertHTML(r io.Reader, opts Options) (Document, error) {
+	opts.Notes = NotesKeep
+	if opts.Images == ImagesExtract {
+		opts.Images = ImagesPlaceholder
+	}
+	doc := &Document{}
+	var text strings.Builder
+	out := newTextWriter(&text)
+	err := parseText(r, out, "", &bookContext{Opts: opts, doc: doc})
+	if err == nil {
+		err = out.Flush()
+	}
+	if err != nil {
+		return Document{}, err
+	}
+	doc.Text = text.String()
+	return *doc, nil
+}
+
+// parseText renders the html of a chapter at path in the epub to text.
+func parseText(r io.Reader, out *textWriter, path string, book *bookContext) error {
+	out.StartChapter()
+	p := parser{tokenizer: html.NewTokenizer(r), out: out, path: path, book: book}
+	err := p.Parse()
+	p.flushParagraph()
+	return err
+}
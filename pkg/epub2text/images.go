@@ -0,0 +1,111 @@
+package epub2text
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/taylorskalyo/goreader/epub"
+)
+
+const (
+	ImagesDrop        string = "drop"
+	ImagesPlaceholder string = "placeholder"
+	ImagesExtract     string = "extract"
+)
+
+// bookContext holds the state shared by every chapter of a book while it is
+// being parsed.
+type bookContext struct {
+	Items []epub.Item
+	Notes *noteIndex
+	Opts  Options
+
+	// the document the chapters are converted into
+	doc *Document
+
+	// manifest hrefs of the images extracted so far
+	extracted map[string]bool
+}
+
+// ImagePlaceholder returns the text to put in place of an image, extracting
+// the image to the images directory first when asked to.
+func (b *bookContext) ImagePlaceholder(chapterPath string, src string, alt string) string {
+	if b.Opts.Images == ImagesDrop {
+		return ""
+	}
+
+	alt = strings.Join(strings.Fields(alt), " ")
+	image := Image{Src: src, Alt: alt}
+	placeholder := "[Image]"
+	if alt != "" {
+		placeholder = fmt.Sprintf("[Image: %s]", alt)
+	}
+	if b.Opts.Images == ImagesExtract && src != "" {
+		href, err := b.extractImage(chapterPath, src)
+		if err != nil {
+			b.doc.Warnings = append(b.doc.Warnings, fmt.Sprintf("could not extract image %s: %s", src, err))
+		} else {
+			image.Path = href
+			placeholder = fmt.Sprintf("%s(%s)", placeholder, path.Join(b.Opts.ImagesPath, href))
+		}
+	}
+	b.doc.Images = append(b.doc.Images, image)
+	return placeholder
+}
+
+// extractImage copies the manifest item an img src points at into the assets
+// directory, keeping its path inside the epub so names don't collide.
+func (b *bookContext) extractImage(chapterPath string, src string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	href := path.Join(path.Dir(chapterPath), u.Path)
+
+	var item *epub.Item
+	for i := range b.Items {
+		if path.Clean(b.Items[i].HREF) == href {
+			item = &b.Items[i]
+			break
+		}
+	}
+	if item == nil {
+		return "", fmt.Errorf("not in the manifest")
+	}
+	if strings.HasPrefix(href, "../") {
+		return "", fmt.Errorf("outside of the epub")
+	}
+	if b.extracted[href] {
+		return href, nil
+	}
+
+	f, err := item.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	outputPath := filepath.Join(b.Opts.ImagesDir, filepath.FromSlash(href))
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0700); err != nil {
+		return "", err
+	}
+	out, err := os.Create(outputPath)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, f); err != nil {
+		return "", err
+	}
+
+	if b.extracted == nil {
+		b.extracted = make(map[string]bool)
+	}
+	b.extracted[href] = true
+	return href, nil
+}
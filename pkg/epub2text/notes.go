@@ -0,0 +1,435 @@
+package epub2text
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/taylorskalyo/goreader/epub"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	NotesKeep   string = "keep"
+	NotesInline string = "inline"
+	NotesAppend string = "append"
+
+	// notes longer than this are probably not notes at all (e.g. a table of
+	// contents link with a number as its text pointing at a whole chapter)
+	maxNoteLength int = 2000
+)
+
+// link texts that look like a note marker: 1, [12], (iv), *, a
+var noteMarkerRegex = regexp.MustCompile(`^[\[(]?([0-9]{1,3}|[ivxlc]{1,6}|\*{1,3}|[a-z])[\])]?$`)
+
+// noteIndex holds the footnotes and endnotes of a book, keyed by the
+// chapter path and element id the note references point at.
+type noteIndex struct {
+	mode string
+
+	// note text, keyed by "path#id"
+	notes map[string]string
+
+	// start tag indices of the elements holding the note text, keyed by
+	// chapter path, so we can leave them out of the text
+	bodies map[string]map[int]bool
+
+	// numbers given to notes in append mode, in reference order
+	numbers  map[string]int
+	appendix []string
+}
+
+// collectNotes walks every chapter of the book twice: once to find the links
+// that look like note references, and once to pull out the text of the
+// elements those links point at.
+//
+// The notes of most books link back to their references, with the number of
+// the note as the text of the link, so those backlinks look like note
+// references too. A link that sits in an element another link points at, at
+// the start of it, is taken for one and left out, so the paragraph of the
+// reference doesn't become a note of the note.
+func collectNotes(book *epub.Rootfile, mode string) (*noteIndex, error) {
+	notes := &noteIndex{
+		mode:    mode,
+		notes:   make(map[string]string),
+		bodies:  make(map[string]map[int]bool),
+		numbers: make(map[string]int),
+	}
+	if mode == NotesKeep {
+		return notes, nil
+	}
+
+	var refs []noteRef
+	for _, itemref := range book.Spine.Itemrefs {
+		err := walkChapter(itemref, func(r io.Reader) {
+			refs = append(refs, collectNoteRefs(r, itemref.HREF)...)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	targets := noteTargets(refs)
+	if len(targets) == 0 {
+		return notes, nil
+	}
+
+	for _, itemref := range book.Spine.Itemrefs {
+		err := walkChapter(itemref, func(r io.Reader) {
+			notes.collectBodies(r, itemref.HREF, targets)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return notes, nil
+}
+
+func walkChapter(itemref epub.Itemref, walk func(io.Reader)) error {
+	f, err := itemref.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	walk(f)
+	return nil
+}
+
+// resolveHref turns a link in the chapter at chapterPath into a "path#id"
+// key. Links without a fragment or pointing outside the book return "".
+func resolveHref(chapterPath string, href string) string {
+	u, err := url.Parse(href)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Fragment == "" {
+		return ""
+	}
+	target := chapterPath
+	if u.Path != "" {
+		target = path.Join(path.Dir(chapterPath), u.Path)
+	}
+	return target + "#" + u.Fragment
+}
+
+func noteKey(chapterPath string, id string) string {
+	return chapterPath + "#" + id
+}
+
+// noteRef is a link that looks like a note reference.
+type noteRef struct {
+	// the "path#id" key the link points at
+	target string
+	// the keys of the block the link sits in: its id and those of the
+	// inline elements in it, which collectBodies would take it for the note
+	// of
+	block *noteBlock
+	// whether the link comes before any text of its block, like the
+	// backlink of a note
+	leading bool
+}
+
+type noteBlock struct {
+	keys    []string
+	hasText bool
+}
+
+// in reports whether the block is the note of one of targets.
+func (b *noteBlock) in(targets map[string]bool) bool {
+	for _, key := range b.keys {
+		if targets[key] {
+			return true
+		}
+	}
+	return false
+}
+
+// noteTargets picks the notes out of the targets of refs. The refs that lead
+// a block another ref points at, which are the backlinks of two-way notes,
+// are taken after all the others, and a ref in a block that is a note by
+// then is left out.
+func noteTargets(refs []noteRef) map[string]bool {
+	candidates := make(map[string]bool)
+	for _, ref := range refs {
+		candidates[ref.target] = true
+	}
+	var first, last []noteRef
+	for _, ref := range refs {
+		if ref.leading && ref.block.in(candidates) {
+			last = append(last, ref)
+		} else {
+			first = append(first, ref)
+		}
+	}
+
+	targets := make(map[string]bool)
+	for _, ref := range append(first, last...) {
+		if !ref.block.in(targets) {
+			targets[ref.target] = true
+		}
+	}
+	return targets
+}
+
+// collectNoteRefs returns the links of a chapter that are either marked up as
+// a note reference or whose text looks like a note marker.
+func collectNoteRefs(r io.Reader, chapterPath string) []noteRef {
+	type element struct {
+		tag   atom.Atom
+		block *noteBlock
+	}
+	// closest returns the block the element on top of the stack is in
+	closest := func(stack []element) *noteBlock {
+		for i := len(stack) - 1; i >= 0; i-- {
+			if stack[i].block != nil {
+				return stack[i].block
+			}
+		}
+		return nil
+	}
+
+	tokenizer := html.NewTokenizer(r)
+	var refs []noteRef
+	var stack []element
+	var ref *noteRef
+	isNoteRef := false
+	var text strings.Builder
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return refs
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			e := element{tag: token.DataAtom}
+			if isBlock(token.DataAtom) {
+				e.block = &noteBlock{}
+			}
+			block := e.block
+			if block == nil {
+				block = closest(stack)
+			}
+			if id := attr(token, "id"); id != "" && block != nil {
+				block.keys = append(block.keys, noteKey(chapterPath, id))
+			}
+			if token.DataAtom == atom.A {
+				if target := resolveHref(chapterPath, attr(token, "href")); target != "" && block != nil {
+					ref = &noteRef{target: target, block: block, leading: !block.hasText}
+					isNoteRef = strings.Contains(attr(token, "epub:type"), "noteref") ||
+						attr(token, "role") == "doc-noteref"
+					text.Reset()
+				}
+			}
+			if tokenType == html.SelfClosingTagToken || isVoid(token.DataAtom) {
+				continue
+			}
+			stack = append(stack, e)
+		case html.TextToken:
+			if ref != nil {
+				text.WriteString(token.Data)
+			}
+			if block := closest(stack); block != nil && strings.TrimSpace(token.Data) != "" {
+				block.hasText = true
+			}
+		case html.EndTagToken:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if token.DataAtom != atom.A || ref == nil {
+				continue
+			}
+			if isNoteRef || noteMarkerRegex.MatchString(strings.TrimSpace(text.String())) {
+				refs = append(refs, *ref)
+			}
+			ref = nil
+		}
+	}
+}
+
+// collectBodies finds the elements referenced by targets in a chapter and
+// stores their text. When the id sits on an inline element (usually an empty
+// anchor or a backlink) the note is its closest block-level ancestor. An
+// element with a reference to a note in it is running text, never a note.
+func (n *noteIndex) collectBodies(r io.Reader, chapterPath string, targets map[string]bool) {
+	type element struct {
+		tag   atom.Atom
+		index int
+		key   string
+		text  strings.Builder
+		// whether it has a reference to another note in it
+		hasRef bool
+	}
+
+	tokenizer := html.NewTokenizer(r)
+	var stack []*element
+	index := 0
+	link := 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			return
+		}
+		token := tokenizer.Token()
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			index++
+			key := ""
+			if id := attr(token, "id"); id != "" && targets[noteKey(chapterPath, id)] {
+				key = noteKey(chapterPath, id)
+			}
+			if key != "" && !isBlock(token.DataAtom) {
+				// hand the note over to the enclosing block
+				for i := len(stack) - 1; i >= 0; i-- {
+					if isBlock(stack[i].tag) {
+						if stack[i].key == "" {
+							stack[i].key = key
+						}
+						break
+					}
+				}
+				key = ""
+			}
+			if tokenType == html.SelfClosingTagToken || isVoid(token.DataAtom) {
+				continue
+			}
+			if token.DataAtom == atom.A && attr(token, "href") != "" {
+				link++
+				if target := resolveHref(chapterPath, attr(token, "href")); targets[target] {
+					for _, e := range stack {
+						if e.key != target {
+							e.hasRef = true
+						}
+					}
+				}
+			}
+			stack = append(stack, &element{tag: token.DataAtom, index: index, key: key})
+		case html.TextToken:
+			// backlinks inside the note are not part of its text
+			if link > 0 {
+				continue
+			}
+			for _, e := range stack {
+				if e.key != "" {
+					e.text.WriteString(token.Data)
+				}
+			}
+		case html.EndTagToken:
+			if len(stack) == 0 {
+				continue
+			}
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if e.tag == atom.A && link > 0 {
+				link--
+			}
+			if e.key == "" || e.hasRef || !isNoteContainer(e.tag) {
+				continue
+			}
+			text := strings.Join(strings.Fields(e.text.String()), " ")
+			if text == "" || len(text) > maxNoteLength {
+				continue
+			}
+			n.notes[e.key] = text
+			if n.bodies[chapterPath] == nil {
+				n.bodies[chapterPath] = make(map[int]bool)
+			}
+			n.bodies[chapterPath][e.index] = true
+		}
+	}
+}
+
+// IsBody reports whether the start tag at index in the chapter holds the
+// text of a note, and should be left out of the running text.
+func (n *noteIndex) IsBody(chapterPath string, index int) bool {
+	return n != nil && n.bodies[chapterPath][index]
+}
+
+// Reference returns the text to put in place of a link to a note, and false
+// when the link does not point at a known note.
+func (n *noteIndex) Reference(chapterPath string, href string) (string, bool) {
+	if n == nil {
+		return "", false
+	}
+	key := resolveHref(chapterPath, href)
+	text, ok := n.notes[key]
+	if !ok {
+		return "", false
+	}
+
+	if n.mode == NotesInline {
+		return fmt.Sprintf(" [Note: %s]", text), true
+	}
+
+	number, ok := n.numbers[key]
+	if !ok {
+		number = len(n.appendix) + 1
+		n.numbers[key] = number
+		n.appendix = append(n.appendix, text)
+	}
+	return fmt.Sprintf("[%d]", number), true
+}
+
+// Appendix returns the notes section to append to the end of the book in
+// append mode.
+func (n *noteIndex) Appendix() string {
+	if n == nil || len(n.appendix) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\nNotes\n\n")
+	for i, text := range n.appendix {
+		fmt.Fprintf(&sb, "[%d] %s\n", i+1, text)
+	}
+	return sb.String()
+}
+
+func attr(token html.Token, key string) string {
+	for _, a := range token.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func isVoid(a atom.Atom) bool {
+	switch a {
+	case atom.Area, atom.Base, atom.Br, atom.Col, atom.Embed, atom.Hr, atom.Img,
+		atom.Input, atom.Link, atom.Meta, atom.Source, atom.Track, atom.Wbr:
+		return true
+	}
+	return false
+}
+
+func isBlock(a atom.Atom) bool {
+	switch a {
+	case atom.P, atom.Div, atom.Li, atom.Dd, atom.Dt, atom.Aside, atom.Section,
+		atom.Blockquote, atom.Td, atom.Body, atom.Article, atom.Footer, atom.Table,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return true
+	}
+	return false
+}
+
+// isListBlock reports the block-level elements that group other blocks.
+func isListBlock(a atom.Atom) bool {
+	switch a {
+	case atom.Ul, atom.Ol, atom.Dl, atom.Pre, atom.Header, atom.Figure,
+		atom.Figcaption, atom.Nav, atom.Main:
+		return true
+	}
+	return false
+}
+
+// isNoteContainer filters out targets that are whole chapters or headings
+// rather than notes.
+func isNoteContainer(a atom.Atom) bool {
+	switch a {
+	case atom.Body, atom.Section, atom.Article, atom.Table,
+		atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return false
+	}
+	return true
+}
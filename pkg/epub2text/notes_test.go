@@ -0,0 +1,149 @@
+package epub2text
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testEpub builds an epub with a chapter for every body, named ch1.html,
+// ch2.html and so on, in spine order.
+func testEpub(t *testing.T, bodies ...string) *bytes.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	add := func(name, content string) {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("mimetype", "application/epub+zip")
+	add("META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`)
+	var items, itemrefs strings.Builder
+	for i, body := range bodies {
+		name := fmt.Sprintf("ch%d.html", i+1)
+		fmt.Fprintf(&items, `<item id="ch%d" href="%s" media-type="application/xhtml+xml"/>`, i+1, name)
+		fmt.Fprintf(&itemrefs, `<itemref idref="ch%d"/>`, i+1)
+		add("OEBPS/"+name, `<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops"><body>`+
+			body+`</body></html>`)
+	}
+	add("OEBPS/content.opf", `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Notes</dc:title></metadata>
+<manifest>`+items.String()+`</manifest>
+<spine>`+itemrefs.String()+`</spine>
+</package>`)
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+func TestNotes(t *testing.T) {
+	twoWay := []string{
+		`<p>Before the note.</p>
+<p>She said it <a id="ref1" href="ch2.html#fn1">1</a> twice.</p>
+<p>After the note.</p>`,
+		`<p id="fn1"><a href="ch1.html#ref1">1</a> The first time was a joke.</p>`,
+	}
+	oneWay := []string{
+		`<p>It rained<a epub:type="noteref" href="#n1">*</a> all day.</p>
+<aside epub:type="footnote" id="n1"><p>In June.</p></aside>`,
+	}
+	// the backlink comes first in the book, with the notes before the text
+	notesFirst := []string{
+		`<p id="fn1"><a href="ch2.html#ref1">[1]</a> The first time was a joke.</p>`,
+		`<p>She said it<a id="ref1" href="ch1.html#fn1">[1]</a> twice.</p>`,
+	}
+	tests := []struct {
+		name     string
+		bodies   []string
+		mode     string
+		want     []string
+		wantNot  []string
+		appendix string
+	}{
+		{
+			name:     "two-way append",
+			bodies:   twoWay,
+			mode:     NotesAppend,
+			want:     []string{"Before the note.", "She said it [1] twice.", "After the note."},
+			wantNot:  []string{"The first time"},
+			appendix: "[1] The first time was a joke.",
+		},
+		{
+			name:    "two-way inline",
+			bodies:  twoWay,
+			mode:    NotesInline,
+			want:    []string{"Before the note.", "She said it [Note: The first time was a joke.] twice.", "After the note."},
+			wantNot: []string{"1 The first time"},
+		},
+		{
+			name:   "two-way keep",
+			bodies: twoWay,
+			mode:   NotesKeep,
+			want:   []string{"She said it 1 twice.", "1 The first time was a joke."},
+		},
+		{
+			name:     "one-way append",
+			bodies:   oneWay,
+			mode:     NotesAppend,
+			want:     []string{"It rained[1] all day."},
+			wantNot:  []string{"In June."},
+			appendix: "[1] In June.",
+		},
+		{
+			name:    "notes first inline",
+			bodies:  notesFirst,
+			mode:    NotesInline,
+			want:    []string{"She said it [Note: The first time was a joke.] twice."},
+			wantNot: []string{"[1] The first time"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testEpub(t, tt.bodies...)
+			doc, err := Convert(r, r.Size(), Options{Notes: tt.mode})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the paragraphs, without the spacing around the notes
+			text := strings.Join(strings.Fields(doc.Text), " ")
+			for _, want := range tt.want {
+				if !strings.Contains(text, want) {
+					t.Errorf("text is missing %q:\n%s", want, doc.Text)
+				}
+			}
+			for _, wantNot := range tt.wantNot {
+				if strings.Contains(text, wantNot) {
+					t.Errorf("text has %q:\n%s", wantNot, doc.Text)
+				}
+			}
+			if !strings.Contains(doc.Notes, tt.appendix) {
+				t.Errorf("notes are %q, want %q", doc.Notes, tt.appendix)
+			}
+		})
+	}
+}
+
+func TestNoteTargets(t *testing.T) {
+	text := &noteBlock{keys: []string{"ch1.html#ref1"}, hasText: true}
+	note := &noteBlock{keys: []string{"notes.html#fn1"}}
+	refs := []noteRef{
+		{target: "ch1.html#ref1", block: note, leading: true},
+		{target: "notes.html#fn1", block: text},
+	}
+	targets := noteTargets(refs)
+	if !targets["notes.html#fn1"] || targets["ch1.html#ref1"] {
+		t.Errorf("targets are %v, want only the note", targets)
+	}
+}
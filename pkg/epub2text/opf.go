@@ -0,0 +1,98 @@
+package epub2text
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// isbnRegex matches ISBN-10 and ISBN-13 numbers, with or without hyphens.
+var isbnRegex = regexp.MustCompile(`^(97[89])?[0-9]{9}[0-9xX]$`)
+
+// opfMetadata is the Dublin Core metadata in the package document
+// (content.opf) of an epub. goreader only keeps one value of every field,
+// and misses the identifiers.
+type opfMetadata struct {
+	Creators    []string        `xml:"metadata>creator"`
+	Languages   []string        `xml:"metadata>language"`
+	Subjects    []string        `xml:"metadata>subject"`
+	Publishers  []string        `xml:"metadata>publisher"`
+	Identifiers []opfIdentifier `xml:"metadata>identifier"`
+}
+
+// opfIdentifier is an identifier of an epub, like its ISBN.
+type opfIdentifier struct {
+	// opf:scheme of EPUB 2, like ISBN or UUID
+	Scheme string `xml:"scheme,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// readOPFMetadata reads the metadata of the package document at opfPath in
+// an epub.
+func readOPFMetadata(z *zip.Reader, opfPath string) (*opfMetadata, error) {
+	f, err := z.Open(opfPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", opfPath, err)
+	}
+	defer f.Close()
+	metadata := &opfMetadata{}
+	if err := xml.NewDecoder(f).Decode(metadata); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", opfPath, err)
+	}
+	return metadata, nil
+}
+
+// identifierMap returns the identifiers by their lowercased scheme. The
+// scheme of identifiers without one is told from the value: urn:isbn: and
+// urn:uuid: prefixes, or the digits of an ISBN. Others are left out.
+func (m *opfMetadata) identifierMap() map[string]string {
+	ids := make(map[string]string)
+	for _, id := range m.Identifiers {
+		value := strings.TrimSpace(id.Value)
+		scheme := strings.ToLower(strings.TrimSpace(id.Scheme))
+		lowered := strings.ToLower(value)
+		switch {
+		case strings.HasPrefix(lowered, "urn:isbn:"):
+			scheme, value = "isbn", value[len("urn:isbn:"):]
+		case strings.HasPrefix(lowered, "urn:uuid:"):
+			scheme, value = "uuid", value[len("urn:uuid:"):]
+		case scheme == "" && isbnRegex.MatchString(strings.ReplaceAll(value, "-", "")):
+			scheme = "isbn"
+		}
+		if scheme == "isbn" {
+			value = strings.ToUpper(strings.ReplaceAll(value, "-", ""))
+		}
+		if scheme != "" && value != "" {
+			if _, ok := ids[scheme]; !ok {
+				ids[scheme] = value
+			}
+		}
+	}
+	return ids
+}
+
+// apply adds the metadata to a converted epub.
+func (m *opfMetadata) apply(doc *Document) {
+	doc.Creators = trimAll(m.Creators)
+	for _, language := range trimAll(m.Languages) {
+		doc.Languages = append(doc.Languages, strings.ToLower(language))
+	}
+	doc.Subjects = trimAll(m.Subjects)
+	doc.Publishers = trimAll(m.Publishers)
+	if ids := m.identifierMap(); len(ids) > 0 {
+		doc.Identifiers = ids
+	}
+}
+
+// trimAll trims the spaces around strings, leaving out the empty ones.
+func trimAll(values []string) []string {
+	var trimmed []string
+	for _, value := range values {
+		if value = strings.Join(strings.Fields(value), " "); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return trimmed
+}
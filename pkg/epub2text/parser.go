@@ -0,0 +1,239 @@
+package epub2text
+
+import (
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// parser is a part of the goreader repo for parsing epubs
+type parser struct {
+	tagStack  []atom.Atom
+	tokenizer *html.Tokenizer
+	out       *textWriter
+	path      string
+	book      *bookContext
+	tagIndex  int
+	skipDepth int
+	table     *tableBuffer
+
+	// the paragraph being parsed, kept apart from out so it can be wrapped
+	// as a whole once it ends
+	para        strings.Builder
+	paraPre     bool
+	paraHeading bool
+}
+
+// parse walks an html document and renders elements to the parser output.
+func (p *parser) Parse() (err error) {
+	for {
+		tokenType := p.tokenizer.Next()
+		token := p.tokenizer.Token()
+		switch tokenType {
+		case html.ErrorToken:
+			err = p.tokenizer.Err()
+		case html.StartTagToken:
+			p.tagIndex++
+			if isVoid(token.DataAtom) {
+				p.HandleStartTag(token)
+				break
+			}
+			p.tagStack = append(p.tagStack, token.DataAtom) // push element
+			if p.skipDepth == 0 && p.book.Notes.IsBody(p.path, p.tagIndex) {
+				// the note text is put in at the reference or at the end
+				p.skipDepth = len(p.tagStack)
+			}
+			p.HandleStartTag(token)
+		case html.SelfClosingTagToken:
+			p.tagIndex++
+			p.HandleStartTag(token)
+		case html.TextToken:
+			p.HandleText(token)
+		case html.EndTagToken:
+			if len(p.tagStack) == 0 {
+				break
+			}
+			p.tagStack = p.tagStack[:len(p.tagStack)-1] // pop element
+			if len(p.tagStack) < p.skipDepth {
+				p.skipDepth = 0
+			}
+			p.HandleEndTag(token)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// handleText appends text elements to the parser buffer. It filters elements
+// that should not be displayed as text (e.g. style blocks).
+func (p *parser) HandleText(token html.Token) {
+	// Skip the contents of notes and note references
+	if p.skipDepth > 0 {
+		return
+	}
+	// Skip style tags
+	if len(p.tagStack) > 0 && p.tagStack[len(p.tagStack)-1] == atom.Style {
+		return
+	}
+	text := string(token.Data)
+	if p.book.Opts.Whitespace == WhitespaceCollapse && !p.inTag(atom.Pre) {
+		text = collapseWhitespace(text)
+		if p.atLineStart() {
+			text = strings.TrimLeft(text, " ")
+		}
+	}
+	p.WriteString(text)
+
+}
+
+// blockBreak ends the current paragraph. Inside tables it only separates the
+// text of the cell.
+func (p *parser) blockBreak() {
+	if p.table != nil {
+		p.table.WriteString(" ")
+		return
+	}
+	p.flushParagraph()
+	switch {
+	case p.out.Empty() || p.out.HasSuffix("\n\n"):
+	case p.out.HasSuffix("\n"):
+		p.out.WriteString("\n")
+	default:
+		p.out.WriteString("\n\n")
+	}
+}
+
+// flushParagraph moves the current paragraph to the parser output, wrapping
+// it when asked to. Preformatted text is never wrapped.
+func (p *parser) flushParagraph() {
+	text := p.para.String()
+	if heading := strings.TrimSpace(text); p.paraHeading && heading != "" {
+		p.book.doc.Headings = append(p.book.doc.Headings, heading)
+	}
+	if p.book.Opts.Wrap > 0 && !p.paraPre {
+		text = WrapText(text, p.book.Opts.Wrap)
+	}
+	p.out.WriteString(text)
+	p.para.Reset()
+	p.paraPre = false
+	p.paraHeading = false
+}
+
+func (p *parser) atLineStart() bool {
+	if p.para.Len() > 0 {
+		return strings.HasSuffix(p.para.String(), "\n")
+	}
+	return p.out.Empty() || p.out.HasSuffix("\n")
+}
+
+func (p *parser) inTag(a atom.Atom) bool {
+	for _, tag := range p.tagStack {
+		if tag == a {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteString adds text to the current paragraph, or to the current cell
+// when we are inside a table.
+func (p *parser) WriteString(s string) {
+	if p.table != nil {
+		p.table.WriteString(s)
+		return
+	}
+	if p.inTag(atom.Pre) {
+		p.paraPre = true
+	}
+	if p.inTag(atom.H1) || p.inTag(atom.H2) || p.inTag(atom.H3) {
+		p.paraHeading = true
+	}
+	p.para.WriteString(s)
+}
+
+// handleStartTag appends text representations of non-text elements (e.g. image alt
+// tags) to the parser buffer.
+func (p *parser) HandleStartTag(token html.Token) {
+	if p.skipDepth > 0 {
+		return
+	}
+	switch token.DataAtom {
+	case atom.A:
+		// Replace note markers with the note, or with a normalized marker
+		// when the notes are collected at the end of the book.
+		if ref, ok := p.book.Notes.Reference(p.path, attr(token, "href")); ok {
+			p.WriteString(ref)
+			if len(p.tagStack) > 0 && p.tagStack[len(p.tagStack)-1] == atom.A {
+				p.skipDepth = len(p.tagStack)
+			}
+		}
+	case atom.Img:
+		// Drop images, or put a placeholder with the alt text in their place
+		p.WriteString(p.book.ImagePlaceholder(p.path, attr(token, "src"), attr(token, "alt")))
+	case atom.Table:
+		if p.table != nil {
+			p.table.depth++
+			break
+		}
+		p.table = &tableBuffer{}
+	case atom.Tr:
+		if p.table != nil && p.table.depth == 0 {
+			p.table.EndCell()
+			p.table.StartRow()
+		}
+	case atom.Td, atom.Th:
+		if p.table != nil && p.table.depth == 0 {
+			p.table.EndCell()
+			p.table.StartCell()
+		} else if p.table != nil {
+			p.table.WriteString(" ")
+		}
+	case atom.Br:
+		if p.table != nil {
+			p.table.WriteString(" ")
+		} else {
+			p.WriteString("\n")
+		}
+	case atom.Title, atom.Hr:
+		p.blockBreak()
+	default:
+		if isBlock(token.DataAtom) || isListBlock(token.DataAtom) {
+			p.blockBreak()
+		}
+	}
+}
+
+// handleEndTag ends paragraphs, and finishes the cells and tables opened in
+// handleStartTag.
+func (p *parser) HandleEndTag(token html.Token) {
+	if p.skipDepth > 0 {
+		return
+	}
+	if p.table == nil {
+		if isBlock(token.DataAtom) || isListBlock(token.DataAtom) || token.DataAtom == atom.Title {
+			p.blockBreak()
+		}
+		return
+	}
+	switch token.DataAtom {
+	case atom.Td, atom.Th:
+		if p.table.depth == 0 {
+			p.table.EndCell()
+		}
+	case atom.Table:
+		if p.table.depth > 0 {
+			p.table.depth--
+			break
+		}
+		table := p.table
+		p.table = nil
+		p.blockBreak()
+		p.out.WriteString(table.Render(p.book.Opts.Tables))
+		p.blockBreak()
+	}
+}
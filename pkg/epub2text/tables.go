@@ -0,0 +1,115 @@
+package epub2text
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	TablesText     string = "text"
+	TablesMarkdown string = "markdown"
+)
+
+// tableBuffer collects the cells of a table while it is being parsed, since
+// we need to know every cell before we can line up the columns.
+type tableBuffer struct {
+	rows [][]string
+	cell *strings.Builder
+	// tables inside tables are flattened into the cell of the outer table
+	depth int
+}
+
+func (t *tableBuffer) StartRow() {
+	t.rows = append(t.rows, nil)
+}
+
+func (t *tableBuffer) StartCell() {
+	if len(t.rows) == 0 {
+		t.StartRow()
+	}
+	t.cell = &strings.Builder{}
+}
+
+func (t *tableBuffer) EndCell() {
+	if t.cell == nil {
+		return
+	}
+	row := len(t.rows) - 1
+	t.rows[row] = append(t.rows[row], strings.Join(strings.Fields(t.cell.String()), " "))
+	t.cell = nil
+}
+
+// WriteString adds text to the current cell. Text outside of cells is
+// whitespace between the table tags, so it is dropped.
+func (t *tableBuffer) WriteString(s string) {
+	if t.cell != nil {
+		t.cell.WriteString(s)
+	}
+}
+
+// Render returns the table as aligned plain-text columns, or as a Markdown
+// table with the first row as the header.
+func (t *tableBuffer) Render(mode string) string {
+	t.EndCell()
+
+	var rows [][]string
+	columns := 0
+	for _, row := range t.rows {
+		if len(row) == 0 {
+			continue
+		}
+		rows = append(rows, row)
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+
+	widths := make([]int, columns)
+	if mode == TablesMarkdown {
+		// Markdown needs at least three dashes per column in the separator
+		for i := range widths {
+			widths[i] = 3
+		}
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if mode == TablesMarkdown {
+				cell = strings.ReplaceAll(cell, "|", `\|`)
+				row[i] = cell
+			}
+			if w := utf8.RuneCountInString(cell); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for r, row := range rows {
+		cells := make([]string, columns)
+		for i := range cells {
+			cell := ""
+			if i < len(row) {
+				cell = row[i]
+			}
+			cells[i] = cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+		}
+
+		if mode == TablesMarkdown {
+			sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			if r == 0 {
+				separators := make([]string, columns)
+				for i := range separators {
+					separators[i] = strings.Repeat("-", widths[i])
+				}
+				sb.WriteString("| " + strings.Join(separators, " | ") + " |\n")
+			}
+			continue
+		}
+		sb.WriteString(strings.TrimRight(strings.Join(cells, "  "), " ") + "\n")
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,22 @@
+package epub2text
+
+import "regexp"
+
+const (
+	// collapse runs of whitespace in the html the way a browser would
+	// (except inside <pre>), strip trailing whitespace and allow at most one
+	// blank line in a row
+	WhitespaceCollapse string = "collapse"
+	// only strip trailing whitespace from every line
+	WhitespaceTrim string = "trim"
+	// leave the text as the epub had it
+	WhitespacePreserve string = "preserve"
+)
+
+var whitespaceRunRegex = regexp.MustCompile(`\s+`)
+
+// collapseWhitespace collapses every run of whitespace in an html text node
+// to a single space.
+func collapseWhitespace(text string) string {
+	return whitespaceRunRegex.ReplaceAllString(text, " ")
+}
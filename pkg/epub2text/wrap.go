@@ -0,0 +1,31 @@
+package epub2text
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// WrapText wraps every line of a paragraph at width columns, breaking between
+// words. Words longer than the width get a line of their own.
+func WrapText(text string, width int) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		var sb strings.Builder
+		col := 0
+		for _, word := range strings.Fields(line) {
+			w := utf8.RuneCountInString(word)
+			if col > 0 && col+1+w > width {
+				sb.WriteString("\n")
+				col = 0
+			}
+			if col > 0 {
+				sb.WriteString(" ")
+				col++
+			}
+			sb.WriteString(word)
+			col += w
+		}
+		lines[i] = sb.String()
+	}
+	return strings.Join(lines, "\n")
+}
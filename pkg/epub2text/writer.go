@@ -0,0 +1,76 @@
+package epub2text
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// the most the parser looks back at the text it wrote, for the blank line
+// between paragraphs
+const tailSize = 2
+
+// textWriter is where the parser writes the text of a book, through a
+// buffer to w. It keeps only the end of what it wrote in the chapter being
+// parsed, so the text is never in memory whole.
+type textWriter struct {
+	w *bufio.Writer
+	// bytes written so far
+	n    int
+	tail string
+	// the first error of w, after which nothing is written
+	err error
+}
+
+func newTextWriter(w io.Writer) *textWriter {
+	return &textWriter{w: bufio.NewWriter(w)}
+}
+
+func (t *textWriter) WriteString(s string) {
+	if s == "" || t.err != nil {
+		return
+	}
+	if _, t.err = t.w.WriteString(s); t.err != nil {
+		return
+	}
+	t.n += len(s)
+	if len(s) >= tailSize {
+		// a copy, so the tail doesn't keep the whole of s alive
+		t.tail = strings.Clone(s[len(s)-tailSize:])
+		return
+	}
+	t.tail += s
+	if len(t.tail) > tailSize {
+		t.tail = t.tail[len(t.tail)-tailSize:]
+	}
+}
+
+// StartChapter forgets the end of the chapters before, so the parser starts
+// every chapter like a document of its own.
+func (t *textWriter) StartChapter() {
+	t.tail = ""
+}
+
+// Empty reports whether nothing was written since the chapter started.
+func (t *textWriter) Empty() bool {
+	return t.tail == ""
+}
+
+// Len is the number of bytes written so far, in every chapter.
+func (t *textWriter) Len() int {
+	return t.n
+}
+
+// HasSuffix reports whether the text of the chapter so far ends in suffix,
+// which is at most tailSize bytes.
+func (t *textWriter) HasSuffix(suffix string) bool {
+	return strings.HasSuffix(t.tail, suffix)
+}
+
+// Flush writes out the buffer, and returns the first error of w.
+func (t *textWriter) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
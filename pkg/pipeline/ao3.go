@@ -0,0 +1,21 @@
+package pipeline
+
+// AO3Info is what Archive of Our Own says about a work, besides its title
+// and author: its rating, warnings and the tags it is filed under.
+type AO3Info struct {
+	WorkID string `json:"work_id"`
+	// like Teen And Up Audiences
+	Rating   string   `json:"rating,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	// the kinds of relationships in it, like F/M or Gen
+	Categories    []string `json:"categories,omitempty"`
+	Fandoms       []string `json:"fandoms,omitempty"`
+	Relationships []string `json:"relationships,omitempty"`
+	Characters    []string `json:"characters,omitempty"`
+	// the additional tags
+	Tags []string `json:"tags,omitempty"`
+	// the name AO3 gives the language, like English
+	Language string `json:"language,omitempty"`
+	// chapters posted out of the chapters planned, like 3/10 or 1/?
+	Chapters string `json:"chapters,omitempty"`
+}
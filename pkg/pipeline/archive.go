@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"io"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+const (
+	// the .txt files of the books in dataset-NNNNN.tar.gz archives, so a
+	// dataset of millions of books isn't millions of files
+	OutputTarGz string = "tar.gz"
+	// the same in dataset-NNNNN.zip archives
+	OutputZip string = "zip"
+)
+
+// archiveEncoder writes every book as a .txt file in an archive shard,
+// optionally with a YAML header and its metadata in a .json file next to it.
+type archiveEncoder struct {
+	archive *storage.Archive
+	opts    SinkOptions
+}
+
+func newTarEncoder(opts SinkOptions) func(w io.Writer) (ShardEncoder, error) {
+	return func(w io.Writer) (ShardEncoder, error) {
+		return &archiveEncoder{archive: storage.NewTarGz(w), opts: opts}, nil
+	}
+}
+
+func newZipEncoder(opts SinkOptions) func(w io.Writer) (ShardEncoder, error) {
+	return func(w io.Writer) (ShardEncoder, error) {
+		return &archiveEncoder{archive: storage.NewZip(w), opts: opts}, nil
+	}
+}
+
+func (e *archiveEncoder) Encode(record *ManifestRecord, text string) (int, error) {
+	e.archive.ModTime = archiveModTime(record)
+	f, err := e.archive.Create(record.File)
+	if err != nil {
+		return 0, err
+	}
+	if e.opts.YAMLHeader {
+		text = YAMLHeader(record) + text
+	}
+	n, err := io.WriteString(f, text)
+	if err != nil {
+		return n, err
+	}
+	if err := f.Close(); err != nil {
+		return n, err
+	}
+	if e.opts.Sidecars {
+		return n, writeSidecar(e.archive, record)
+	}
+	return n, nil
+}
+
+func (e *archiveEncoder) Close() error {
+	return e.archive.Close()
+}
+
+// archiveModTime is the time a book was converted, so the same books give
+// the same archive. Books without one, like plain text downloads, get the
+// earliest time a zip file can hold.
+func archiveModTime(record *ManifestRecord) time.Time {
+	if record.ConvertedAt.IsZero() {
+		return time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return record.ConvertedAt.UTC()
+}
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// back matter is only looked for in this many paragraphs at the end
+	maxBackMatterParagraphs int = 60
+	// a plug paragraph longer than this is probably part of the story
+	maxPlugLength int = 500
+)
+
+// Headings that start the back matter of a book.
+var backMatterHeadingRegex = regexp.MustCompile(`(?i)^\W*(about the authors?|other (books|titles|works|novels|stories) (by|from)|also (by|from)|more (books|titles|stories) (by|from)|books by|connect with (me|the author)|coming soon|excerpt from|sneak peek|preview of|a note from the author|thank you for reading|if you (enjoyed|liked) this)\b`)
+
+// "The End" marks the end of the story, everything after it is back matter.
+var theEndRegex = regexp.MustCompile(`(?i)^\W*the end\W*$`)
+
+// Paragraphs that point readers somewhere else: acquisition links, social
+// media and newsletter plugs.
+var plugRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)https?://|www\.\S+\.\S+`),
+	regexp.MustCompile(`(?i)\b(newsletter|mailing list|sign up)\b`),
+	regexp.MustCompile(`(?i)\b(leave|write|post) a review\b`),
+	regexp.MustCompile(`(?i)\b(available|buy it|purchase)\b.{0,40}\b(amazon|barnes|kobo|itunes|apple books|smashwords|google play)\b`),
+	regexp.MustCompile(`(?i)\b(follow|find|like|friend) (me|the author|us) on\b|\b(twitter|facebook|instagram|goodreads|tumblr|pinterest)\b`),
+	regexp.MustCompile(`(?i)\bvisit (me|my|the author'?s?|our)\b.{0,30}\b(website|blog|page)\b`),
+}
+
+// StripBackMatter removes "About the Author" sections, lists of other books,
+// acquisition links and newsletter plugs from the end of a book.
+func StripBackMatter(text string) (string, []Removal) {
+	paragraphs := splitParagraphs(text)
+	if len(paragraphs) == 0 {
+		return text, nil
+	}
+	first := len(paragraphs) - maxBackMatterParagraphs
+	if first < 1 {
+		// never remove the whole book
+		first = 1
+	}
+
+	cut := len(paragraphs)
+	for i := first; i < len(paragraphs); i++ {
+		p := strings.TrimSpace(text[paragraphs[i][0]:paragraphs[i][1]])
+		if theEndRegex.MatchString(p) {
+			cut = i + 1
+			break
+		}
+		if len(p) <= maxHeadingLength && backMatterHeadingRegex.MatchString(p) {
+			cut = i
+			break
+		}
+	}
+
+	// whatever is left at the end can still be plugs without a heading
+	for cut > first && isPlug(strings.TrimSpace(text[paragraphs[cut-1][0]:paragraphs[cut-1][1]])) {
+		cut--
+	}
+	if cut == len(paragraphs) {
+		return text, nil
+	}
+
+	var removed []Removal
+	for _, p := range paragraphs[cut:] {
+		removed = append(removed, Removal{Stage: "back_matter", Text: strings.TrimSpace(text[p[0]:p[1]])})
+	}
+	return text[:paragraphs[cut][0]], removed
+}
+
+func isPlug(paragraph string) bool {
+	if len(paragraph) > maxPlugLength {
+		return false
+	}
+	if backMatterHeadingRegex.MatchString(paragraph) {
+		return true
+	}
+	for _, r := range plugRegexes {
+		if r.MatchString(paragraph) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestStripBackMatter(t *testing.T) {
+	story := []string{
+		"It was the kind of morning that made the whole town want to stay in bed, and most of it did.",
+		"By noon the rain had stopped, and she walked down to the harbour to watch the boats come in.",
+	}
+	text := func(paragraphs ...string) string {
+		s := ""
+		for _, p := range append(append([]string(nil), story...), paragraphs...) {
+			s += p + "\n\n"
+		}
+		return s
+	}
+	tests := []struct {
+		name string
+		text string
+		// the paragraphs that are left, and how many were removed
+		want    []string
+		removed int
+	}{
+		{
+			name: "about the author and other books",
+			text: text("About the Author", "Jane Doe lives in Maine with two cats.",
+				"Other Books by Jane Doe", "The Short Road", "The Winding Road"),
+			want:    story,
+			removed: 5,
+		},
+		{
+			name:    "everything after the end",
+			text:    text("The End", "Thanks to my editor, Sam, and to my mother."),
+			want:    append(append([]string(nil), story...), "The End"),
+			removed: 1,
+		},
+		{
+			name: "plugs without a heading",
+			text: text("Sign up for my newsletter to hear about new releases first.",
+				"Follow me on Twitter: @janedoe", "Visit my website at www.janedoe.com"),
+			want:    story,
+			removed: 3,
+		},
+		{
+			name:    "a story that mentions a website keeps its last paragraph",
+			text:    text("She never checked the website again, and she never saw him again."),
+			want:    append(append([]string(nil), story...), "She never checked the website again, and she never saw him again."),
+			removed: 0,
+		},
+		{
+			name:    "a story without back matter",
+			text:    text(),
+			want:    story,
+			removed: 0,
+		},
+		{
+			name:    "the whole book is never removed",
+			text:    "About the Author\n\nJane Doe lives in Maine.\n",
+			want:    []string{"About the Author", "Jane Doe lives in Maine."},
+			removed: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed := StripBackMatter(tt.text)
+			if !slices.Equal(paragraphs(got), tt.want) {
+				t.Errorf("got %q, want %q", paragraphs(got), tt.want)
+			}
+			if len(removed) != tt.removed {
+				t.Errorf("removed %d paragraphs, want %d: %q", len(removed), tt.removed, removed)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+)
+
+// lines longer than this are never removed by the line based rules, so a
+// book that is one huge line can't be thrown away by accident
+const maxBoilerplateLine int = 300
+
+// Removal is a piece of text taken out of a book by one of the cleaning stages.
+type Removal struct {
+	Stage string `json:"stage"`
+	Text  string `json:"text"`
+}
+
+// Passages that Smashwords puts in nearly every book. They may be hard
+// wrapped in plain text downloads, hence \s+ between the words. A passage
+// ends at its usual last sentence, or at the end of its paragraph.
+var boilerplatePassages = []*regexp.Regexp{
+	regexp.MustCompile(`(?is)This\s+e-?book\s+is\s+licensed\s+for\s+your\s+personal\s+enjoyment\s+only.{0,800}?(hard\s+work\s+of\s+(this|the)\s+author\.?|\n[ \t]*\n|\z)`),
+	regexp.MustCompile(`(?is)Thank\s+you\s+for\s+(downloading|reading)\s+this\s+free\s+e-?book.{0,800}?(Thank\s+you\s+for\s+your\s+support\.?|\n[ \t]*\n|\z)`),
+	regexp.MustCompile(`(?is)Although\s+this\s+is\s+a\s+free\s+e-?book,?\s+it\s+remains\s+the\s+copyrighted\s+property.{0,800}?(Thank\s+you\s+for\s+your\s+support\.?|\n[ \t]*\n|\z)`),
+	regexp.MustCompile(`(?is)If\s+you\s+would\s+like\s+to\s+share\s+this\s+(e-?)?book\s+with\s+another\s+person.{0,600}?(hard\s+work\s+of\s+(this|the)\s+author\.?|\n[ \t]*\n|\z)`),
+}
+
+// Lines that only carry Smashwords distribution notices.
+var boilerplateLines = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)^\W*Smashwords\s+Edition\b`),
+	regexp.MustCompile(`(?i)^\W*(Smashwords\s+)?License\s+(Notes?|Statement)\W*$`),
+	regexp.MustCompile(`(?i)^\W*(Published|Distributed)\s+(by\s+.*\s+)?(at|on|by)\s+Smashwords\b`),
+	regexp.MustCompile(`(?i)\bDiscover\s+other\s+titles\s+by\b`),
+	regexp.MustCompile(`(?i)\breturn\s+to\s+Smashwords(\.com)?\b`),
+	regexp.MustCompile(`(?i)\b(at|on|from)\s+Smashwords(\.com)?\W*$`),
+}
+
+// StripBoilerplate removes the license notes, "Smashwords Edition" lines,
+// "Discover other titles by…" sections and other distribution notices.
+func StripBoilerplate(text string) (string, []Removal) {
+	var removed []Removal
+	for _, passage := range boilerplatePassages {
+		text = passage.ReplaceAllStringFunc(text, func(match string) string {
+			removed = append(removed, Removal{Stage: "boilerplate", Text: strings.TrimSpace(match)})
+			// keep the paragraph break the passage may have ended on
+			if strings.HasSuffix(strings.TrimRight(match, " \t"), "\n") {
+				return "\n\n"
+			}
+			return ""
+		})
+	}
+
+	lines := strings.Split(text, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if isBoilerplateLine(line) {
+			removed = append(removed, Removal{Stage: "boilerplate", Text: strings.TrimSpace(line)})
+			continue
+		}
+		kept = append(kept, line)
+	}
+
+	return strings.Join(kept, "\n"), removed
+}
+
+func isBoilerplateLine(line string) bool {
+	if len(line) > maxBoilerplateLine || strings.TrimSpace(line) == "" {
+		return false
+	}
+	for _, r := range boilerplateLines {
+		if r.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
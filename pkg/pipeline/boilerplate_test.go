@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStripBoilerplate(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		// the paragraphs that are left
+		want    []string
+		removed int
+	}{
+		{
+			name: "license notes",
+			text: "The Long Road\n\nSmashwords Edition\n\nCopyright 2012 Jane Doe\n\n" +
+				"This ebook is licensed for your personal enjoyment only. This ebook may not be re-sold or given" +
+				" away to other people. Thank you for respecting the hard work of this author.\n\n" +
+				"It was a cold morning.\n",
+			want:    []string{"The Long Road", "Copyright 2012 Jane Doe", "It was a cold morning."},
+			removed: 2,
+		},
+		{
+			name: "hard wrapped license",
+			text: "This ebook is licensed for your\npersonal enjoyment only. Thank you for respecting the\n" +
+				"hard work of this author.\n\nChapter 1\n",
+			want:    []string{"Chapter 1"},
+			removed: 1,
+		},
+		{
+			name: "free ebook notice up to its paragraph break",
+			text: "Thank you for downloading this free ebook. You are welcome to share it with your friends.\n\n" +
+				"The rain had stopped.\n",
+			want:    []string{"The rain had stopped."},
+			removed: 1,
+		},
+		{
+			name:    "discover other titles and a link back",
+			text:    "The End\n\nDiscover other titles by Jane Doe at Smashwords.com\n\nPlease return to Smashwords to leave a review.\n",
+			want:    []string{"The End"},
+			removed: 2,
+		},
+		{
+			name:    "prose about Smashwords stays when it doesn't end the line",
+			text:    "She had read about it on Smashwords, and bought it anyway.\n",
+			want:    []string{"She had read about it on Smashwords, and bought it anyway."},
+			removed: 0,
+		},
+		{
+			name:    "prose about a license stays",
+			text:    "The license plate was bent.\nHe was published by a small press.\n",
+			want:    []string{"The license plate was bent.\nHe was published by a small press."},
+			removed: 0,
+		},
+		{
+			name:    "long lines are never removed",
+			text:    strings.Repeat("word ", 70) + "at Smashwords\n",
+			want:    []string{strings.Repeat("word ", 70) + "at Smashwords"},
+			removed: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed := StripBoilerplate(tt.text)
+			if !slices.Equal(paragraphs(got), tt.want) {
+				t.Errorf("got %q, want %q", paragraphs(got), tt.want)
+			}
+			if len(removed) != tt.removed {
+				t.Errorf("removed %d passages, want %d: %q", len(removed), tt.removed, removed)
+			}
+			for _, r := range removed {
+				if r.Stage != "boilerplate" || r.Text == "" {
+					t.Errorf("removal %+v", r)
+				}
+			}
+		})
+	}
+}
+
+// paragraphs splits text at its blank lines, without the empty ones, so the
+// tests don't depend on how many newlines a stage leaves where it removed
+// something.
+func paragraphs(text string) []string {
+	var paragraphs []string
+	for _, p := range regexp.MustCompile(`\n[ \t]*\n`).Split(text, -1) {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
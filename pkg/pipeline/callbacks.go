@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// Callbacks are called as a run goes, for programs that embed the pipeline
+// to filter the books, show how far it is or keep accounts of their own.
+// They are called at the same points as the events of -events, may be
+// called from more than one goroutine at once, and hold up the run until
+// they return. Any of them can be nil, and so can the methods' receiver.
+type Callbacks struct {
+	// OnBookDiscovered is called with every book a crawl finds, before it
+	// is downloaded. Returning false leaves the book out of the run
+	OnBookDiscovered func(book *source.Book) bool
+	// OnDownloadComplete is called once a book is downloaded, with the
+	// file it was downloaded to, before it is converted. The file of a txt
+	// book is gone once it is, and an epub converted from memory has none
+	OnDownloadComplete func(book *source.Book, path string)
+	// OnConversionComplete is called once a book is converted, with its
+	// record and text. The Rejected of the record says why a filter left
+	// it out of the dataset, if one did
+	OnConversionComplete func(record *ManifestRecord, text string)
+	// OnError is called when a book fails, with its id and the phase it
+	// failed in, like LogPhaseDownload, and when the run fails, with an
+	// empty id and phase
+	OnError func(bookID string, phase string, err error)
+}
+
+func (c *Callbacks) bookDiscovered(book *source.Book) bool {
+	if c == nil || c.OnBookDiscovered == nil {
+		return true
+	}
+	return c.OnBookDiscovered(book)
+}
+
+func (c *Callbacks) downloadComplete(book *source.Book, path string) {
+	if c != nil && c.OnDownloadComplete != nil {
+		c.OnDownloadComplete(book, path)
+	}
+}
+
+func (c *Callbacks) conversionComplete(record *ManifestRecord, text string) {
+	if c != nil && c.OnConversionComplete != nil {
+		c.OnConversionComplete(record, text)
+	}
+}
+
+func (c *Callbacks) failed(bookID string, phase string, err error) {
+	if c != nil && c.OnError != nil {
+		c.OnError(bookID, phase, err)
+	}
+}
@@ -0,0 +1,197 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+const (
+	datasetCardFileName  string = "DATASET_CARD.md"
+	datasetInfosFileName string = "dataset_infos.json"
+)
+
+// DatasetSummary describes a dataset for its card: what is in it, and how
+// it was crawled and filtered.
+type DatasetSummary struct {
+	CreatedAt time.Time `json:"created_at"`
+	// the flags of the run that last added to the dataset
+	Parameters map[string]string `json:"crawl_parameters,omitempty"`
+	// books in the dataset, their text in bytes, their words and tokens
+	Documents int   `json:"documents"`
+	Bytes     int64 `json:"bytes"`
+	Words     int   `json:"words"`
+	Tokens    int64 `json:"tokens"`
+	// the tokenizers that counted the tokens, by the books they counted
+	Tokenizers map[string]int `json:"tokenizers,omitempty"`
+	// books in the dataset by language and kind of license
+	Languages map[string]int `json:"languages"`
+	Licenses  map[string]int `json:"licenses"`
+	// books left out by each filter, like language or duplicate
+	Rejected   int            `json:"rejected"`
+	Rejections map[string]int `json:"rejections"`
+}
+
+// SummarizeDataset counts the books of a manifest. parameters may be nil.
+func SummarizeDataset(manifest *Manifest, parameters map[string]string) *DatasetSummary {
+	summary := &DatasetSummary{
+		CreatedAt:  time.Now().UTC(),
+		Parameters: parameters,
+		Languages:  make(map[string]int),
+		Licenses:   make(map[string]int),
+		Rejections: make(map[string]int),
+		Tokenizers: make(map[string]int),
+	}
+	for _, record := range manifest.Records() {
+		if record.Rejected != "" {
+			summary.Rejected++
+			// reasons are "filter: details"
+			filter, _, _ := strings.Cut(record.Rejected, ":")
+			summary.Rejections[filter]++
+			continue
+		}
+		if !InDataset(record) {
+			continue
+		}
+		summary.Documents++
+		summary.Bytes += int64(record.Chars)
+		summary.Words += record.Words
+		if record.Tokenizer != "" {
+			summary.Tokens += int64(record.Tokens)
+			summary.Tokenizers[record.Tokenizer]++
+		}
+		if record.Language != "" {
+			summary.Languages[record.Language]++
+		}
+		license := record.LicenseType
+		if license == "" {
+			license = LicenseUnknown
+		}
+		summary.Licenses[license]++
+	}
+	return summary
+}
+
+// InDataset reports whether a book made it into the dataset, rather than
+// being rejected, waiting to be converted or failing to decode.
+func InDataset(record *ManifestRecord) bool {
+	return record.Rejected == "" && record.Words > 0
+}
+
+// FlagParameters returns the flags of a flag set that were set on the
+// command line, without their credentials, for the dataset card.
+func FlagParameters(flags *flag.FlagSet) map[string]string {
+	return setFlags(flags)
+}
+
+// SortedByCount returns the keys of counts, most common first.
+func SortedByCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// writeCountTable writes a markdown table of counts, most common first.
+func writeCountTable(card *strings.Builder, heading string, counts map[string]int) {
+	fmt.Fprintf(card, "| %s | books |\n|---|---|\n", heading)
+	for _, key := range SortedByCount(counts) {
+		fmt.Fprintf(card, "| %s | %d |\n", key, counts[key])
+	}
+	card.WriteString("\n")
+}
+
+// Markdown writes the summary as DATASET_CARD.md.
+func (s *DatasetSummary) Markdown() string {
+	var card strings.Builder
+	card.WriteString("# Dataset card\n\n")
+	card.WriteString("Books that are free to download from [Smashwords](https://www.smashwords.com/), ")
+	fmt.Fprintf(&card, "downloaded and converted to plain text with smashwords-downloader. Generated %s.\n\n",
+		s.CreatedAt.Format("2006-01-02 15:04 MST"))
+
+	card.WriteString("## Contents\n\n")
+	fmt.Fprintf(&card, "| books | words | tokens | size | rejected |\n|---|---|---|---|---|\n| %d | %d | %d | %s | %d |\n\n",
+		s.Documents, s.Words, s.Tokens, FormatSize(s.Bytes), s.Rejected)
+	if len(s.Tokenizers) > 0 {
+		var counts []string
+		for _, tokenizer := range SortedByCount(s.Tokenizers) {
+			counts = append(counts, fmt.Sprintf("%s: %d books", tokenizer, s.Tokenizers[tokenizer]))
+		}
+		fmt.Fprintf(&card, "Tokens are approximate, counted with %s.\n\n", strings.Join(counts, ", "))
+	}
+
+	if len(s.Languages) > 0 {
+		card.WriteString("## Languages\n\n")
+		card.WriteString("Detected ISO 639-1 code of the language of the text.\n\n")
+		writeCountTable(&card, "language", s.Languages)
+	}
+
+	if len(s.Licenses) > 0 {
+		card.WriteString("## Licenses\n\n")
+		card.WriteString("Read from the license notes on the book page, the rights in the epub and the copyright page. ")
+		card.WriteString("Check the license of a book on its Smashwords page before redistributing it.\n\n")
+		writeCountTable(&card, "license", s.Licenses)
+	}
+
+	card.WriteString("## Filters\n\n")
+	if len(s.Rejections) == 0 {
+		card.WriteString("No books were rejected.\n\n")
+	} else {
+		card.WriteString("Books left out of the dataset, by the filter that rejected them.\n\n")
+		writeCountTable(&card, "filter", s.Rejections)
+	}
+
+	if len(s.Parameters) > 0 {
+		card.WriteString("## Crawl parameters\n\n")
+		card.WriteString("The flags of the last run that added to the dataset.\n\n```\n")
+		names := make([]string, 0, len(s.Parameters))
+		for name := range s.Parameters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&card, "-%s=%s\n", name, s.Parameters[name])
+		}
+		card.WriteString("```\n")
+	}
+	return card.String()
+}
+
+// WriteDatasetCard writes DATASET_CARD.md and dataset_infos.json to a
+// storage.
+func WriteDatasetCard(store storage.Writer, summary *DatasetSummary) error {
+	infos, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{
+		datasetCardFileName:  []byte(summary.Markdown()),
+		datasetInfosFileName: append(infos, '\n'),
+	}
+	for name, content := range files {
+		w, err := store.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(content); err != nil {
+			w.Close()
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package pipeline
+
+import (
+	"database/sql"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+const CatalogFileName string = "catalog.db"
+
+// the status of a book in the catalog
+const (
+	// an epub waiting to be converted
+	StatusDownloaded string = "downloaded"
+	StatusConverted  string = "converted"
+	StatusRejected   string = "rejected"
+	// a plain text download in an encoding we couldn't detect
+	StatusUndecodable string = "undecodable"
+)
+
+// The books table is a copy of the manifest, so it is made anew with the
+// columns of this version every time the catalog is opened.
+const catalogSchema string = `
+DROP TABLE IF EXISTS books;
+CREATE TABLE books (
+	file          TEXT PRIMARY KEY,
+	id            TEXT,
+	source        TEXT,
+	format        TEXT,
+	status        TEXT,
+	title         TEXT,
+	author        TEXT,
+	url           TEXT,
+	categories    TEXT,
+	price         TEXT,
+	license       TEXT,
+	license_type  TEXT,
+	published     TEXT,
+	language      TEXT,
+	subjects      TEXT,
+	publisher     TEXT,
+	isbn          TEXT,
+	chars         INTEGER,
+	words         INTEGER,
+	tokens        INTEGER,
+	sha256        TEXT,
+	dataset       TEXT,
+	rejected      TEXT,
+	error         TEXT,
+	downloaded_at TEXT,
+	converted_at  TEXT,
+	source_url    TEXT,
+	tool_version  TEXT,
+	tool_commit   TEXT
+);
+CREATE INDEX books_id ON books (id);
+CREATE INDEX books_sha256 ON books (sha256);
+CREATE TABLE IF NOT EXISTS errors (
+	time    TEXT,
+	file    TEXT,
+	stage   TEXT,
+	message TEXT
+);
+`
+
+// Catalog is a copy of the manifest in catalog.db, a SQLite database, along
+// with the errors of every run, so a dataset can be browsed with SQL.
+type Catalog struct {
+	DB *sql.DB
+}
+
+// OpenCatalog opens the catalog of a data directory, creating it if needed.
+func OpenCatalog(dataDir string) (*Catalog, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite", filepath.Join(dataDir, CatalogFileName))
+	if err != nil {
+		return nil, err
+	}
+	// SQLite takes one writer at a time
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(catalogSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Catalog{DB: db}, nil
+}
+
+// BookStatus is where a book is at, for the status column.
+func BookStatus(record *ManifestRecord) string {
+	switch {
+	case record.Rejected != "":
+		return StatusRejected
+	case record.EncodingError != "":
+		return StatusUndecodable
+	case record.Source != "" && record.ConvertedAt.IsZero():
+		return StatusDownloaded
+	}
+	return StatusConverted
+}
+
+// catalogTime formats a time for the catalog, empty if it isn't set.
+func catalogTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// catalogList formats a list as a JSON array for the catalog, empty if it
+// has nothing in it.
+func catalogList(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+// Sync replaces the books in the catalog with the records of the manifest.
+func (c *Catalog) Sync(records []*ManifestRecord) error {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM books"); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(`INSERT INTO books VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, r := range records {
+		p := r.Provenance
+		if p == nil {
+			p = &Provenance{}
+		}
+		_, err := insert.Exec(r.File, r.DocumentID(), r.Source, r.Format, BookStatus(r), r.Title, r.Author, r.URL,
+			catalogList(r.Categories), r.Price, r.License, r.LicenseType, r.Published, r.Language,
+			catalogList(r.Subjects), r.Publisher, r.Identifiers["isbn"], r.Chars, r.Words, r.Tokens, r.SHA256, r.Dataset,
+			r.Rejected, r.EncodingError, catalogTime(r.DownloadedAt), catalogTime(r.ConvertedAt),
+			p.SourceURL, p.Version, p.Commit)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LogError records a book that failed at a stage, like download.
+func (c *Catalog) LogError(file string, stage string, message string) error {
+	_, err := c.DB.Exec("INSERT INTO errors VALUES (?, ?, ?, ?)",
+		catalogTime(time.Now()), file, stage, message)
+	return err
+}
+
+func (c *Catalog) Close() error {
+	return c.DB.Close()
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MarkChapters replaces every chapter heading with a marker made from the
+// template, where {n} is the number of the chapter and {title} the text of
+// the heading. Headings are paragraphs that came from h1-h3 tags (given as
+// keys made by HeadingKey) or that look like "Chapter 12". It returns the
+// text and the number of chapters found.
+func MarkChapters(text string, template string, headings map[string]bool) (string, int) {
+	paragraphs := splitParagraphs(text)
+
+	var sb strings.Builder
+	last := 0
+	n := 0
+	for _, p := range paragraphs {
+		paragraph := strings.TrimSpace(text[p[0]:p[1]])
+		if len(paragraph) > maxHeadingLength {
+			continue
+		}
+		if !headings[HeadingKey(paragraph)] && !IsChapterHeading(paragraph) {
+			continue
+		}
+		n++
+		title := strings.Join(strings.Fields(paragraph), " ")
+		marker := strings.NewReplacer("{n}", strconv.Itoa(n), "{title}", title).Replace(template)
+		sb.WriteString(text[last:p[0]])
+		sb.WriteString(marker)
+		last = p[1]
+	}
+	sb.WriteString(text[last:])
+
+	return sb.String(), n
+}
+
+// HeadingKey reduces a heading to its lowercased letters and digits, so it
+// still matches after the text went through the other cleaning stages.
+func HeadingKey(heading string) string {
+	heading = NormalizeUnicode(heading, UnicodeNFKC)
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, heading)
+}
+
+// HeadingKeys returns the keys of the headings of a converted epub, as
+// MarkChapters takes them.
+func HeadingKeys(headings []string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, heading := range headings {
+		keys[HeadingKey(heading)] = true
+	}
+	return keys
+}
@@ -0,0 +1,60 @@
+package pipeline
+
+import "testing"
+
+func TestMarkChapters(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		headings []string
+		want     string
+		chapters int
+	}{
+		{
+			name:     "numbered chapters",
+			text:     "Chapter 1\n\nIt rained.\n\nCHAPTER TWO: The Harbour\n\nIt stopped.\n",
+			want:     "## Chapter 1\n\nIt rained.\n\n## CHAPTER TWO: The Harbour\n\nIt stopped.\n",
+			chapters: 2,
+		},
+		{
+			name:     "headings of the epub",
+			text:     "The Storm\n\nIt rained.\n\nThe Harbour\n\nIt stopped.\n",
+			headings: []string{"The Storm", "The  Harbour"},
+			want:     "## The Storm\n\nIt rained.\n\n## The Harbour\n\nIt stopped.\n",
+			chapters: 2,
+		},
+		{
+			name: "prose that looks like a heading",
+			text: "Chapter 1\n\nPart of me wanted to scream.\n\nBook of the dead.\n\n" +
+				"Chapter and verse, he said.\n\nIntroduction over, she sat down.\n",
+			want: "## Chapter 1\n\nPart of me wanted to scream.\n\nBook of the dead.\n\n" +
+				"Chapter and verse, he said.\n\nIntroduction over, she sat down.\n",
+			chapters: 1,
+		},
+		{
+			name:     "a heading in the middle of a paragraph",
+			text:     "He opened the book at\nChapter 3\nand read.\n",
+			want:     "He opened the book at\nChapter 3\nand read.\n",
+			chapters: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, chapters := MarkChapters(tt.text, "## {title}", HeadingKeys(tt.headings))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if chapters != tt.chapters {
+				t.Errorf("found %d chapters, want %d", chapters, tt.chapters)
+			}
+		})
+	}
+}
+
+func TestMarkChaptersNumbers(t *testing.T) {
+	got, _ := MarkChapters("Prologue\n\nIt rained.\n\nChapter 1\n\nIt stopped.\n", "[{n}] {title}", nil)
+	want := "[1] Prologue\n\nIt rained.\n\n[2] Chapter 1\n\nIt stopped.\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,139 @@
+package pipeline
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+const (
+	// below this chardet is mostly guessing
+	minCharsetConfidence int = 30
+	// a decoded text with more replacement characters than this is garbage
+	maxReplacementRatio float64 = 0.01
+
+	UndecodableSuffix string = ".undecodable"
+)
+
+var errUnknownCharset = errors.New("could not detect the character encoding")
+
+// chardet names charsets after ICU, and htmlindex only knows the labels of
+// the WHATWG Encoding Standard. The names that are the same but for case
+// aren't here
+var chardetLabels = map[string]string{
+	"GB-18030": "gb18030",
+}
+
+// charsetLabel returns the WHATWG label of a charset named by chardet.
+func charsetLabel(name string) string {
+	if label, ok := chardetLabels[name]; ok {
+		return label
+	}
+	return strings.ToLower(name)
+}
+
+// lookupEncoding returns the encoding of a label of DetectCharset. UTF-32
+// isn't in the Encoding Standard, but chardet detects it.
+func lookupEncoding(label string) (encoding.Encoding, error) {
+	switch label {
+	case "utf-32be":
+		return utf32.UTF32(utf32.BigEndian, utf32.UseBOM), nil
+	case "utf-32le":
+		return utf32.UTF32(utf32.LittleEndian, utf32.UseBOM), nil
+	}
+	return htmlindex.Get(label)
+}
+
+// DetectCharset guesses the encoding of a downloaded text file. Byte order
+// marks win, then valid UTF-8, then whatever chardet is confident about.
+func DetectCharset(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8", nil
+	// the UTF-32LE BOM starts with the UTF-16LE one
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE, 0x00, 0x00}):
+		return "utf-32le", nil
+	case bytes.HasPrefix(data, []byte{0x00, 0x00, 0xFE, 0xFF}):
+		return "utf-32be", nil
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return "utf-16le", nil
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		return "utf-16be", nil
+	}
+
+	// UTF-16 without a BOM has a zero byte in every other position for
+	// mostly Latin text
+	if charset := detectUTF16(data); charset != "" {
+		return charset, nil
+	}
+
+	if utf8.Valid(data) {
+		return "utf-8", nil
+	}
+
+	result, err := chardet.NewTextDetector().DetectBest(data)
+	if err != nil || result.Confidence < minCharsetConfidence {
+		return "", errUnknownCharset
+	}
+	return charsetLabel(result.Charset), nil
+}
+
+func detectUTF16(data []byte) string {
+	if len(data) < 64 {
+		return ""
+	}
+	evenZeros, oddZeros := 0, 0
+	for i, b := range data {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			evenZeros++
+		} else {
+			oddZeros++
+		}
+	}
+	half := len(data) / 2
+	switch {
+	case evenZeros > half*3/10 && oddZeros < half/100:
+		return "utf-16be"
+	case oddZeros > half*3/10 && evenZeros < half/100:
+		return "utf-16le"
+	}
+	return ""
+}
+
+// TranscodeToUTF8 detects the encoding of data and converts it to UTF-8. It
+// returns the detected encoding along with the converted text.
+func TranscodeToUTF8(data []byte) ([]byte, string, error) {
+	charset, err := DetectCharset(data)
+	if err != nil {
+		return nil, "", err
+	}
+	if charset == "utf-8" {
+		return data, charset, nil
+	}
+
+	enc, err := lookupEncoding(charset)
+	if err != nil {
+		return nil, charset, fmt.Errorf("unsupported encoding %s", charset)
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, charset, err
+	}
+
+	replacements := bytes.Count(decoded, []byte(string(utf8.RuneError)))
+	if float64(replacements) > maxReplacementRatio*float64(utf8.RuneCount(decoded)) {
+		return nil, charset, fmt.Errorf("too many undecodable characters as %s", charset)
+	}
+
+	return decoded, charset, nil
+}
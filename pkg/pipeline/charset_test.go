@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/unicode/utf32"
+)
+
+func TestCharsetLabel(t *testing.T) {
+	// the charsets chardet detects that there is a decoder for, and the
+	// encoding each decodes as, if the Encoding Standard has it
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"UTF-8", "utf-8"},
+		{"UTF-16BE", "utf-16be"},
+		{"UTF-16LE", "utf-16le"},
+		{"UTF-32BE", ""},
+		{"UTF-32LE", ""},
+		{"ISO-8859-1", "windows-1252"},
+		{"ISO-8859-2", "iso-8859-2"},
+		{"ISO-8859-5", "iso-8859-5"},
+		{"ISO-8859-6", "iso-8859-6"},
+		{"ISO-8859-7", "iso-8859-7"},
+		{"ISO-8859-8", "iso-8859-8"},
+		{"ISO-8859-8-I", "iso-8859-8-i"},
+		{"ISO-8859-9", "windows-1254"},
+		{"windows-1250", "windows-1250"},
+		{"windows-1251", "windows-1251"},
+		{"windows-1252", "windows-1252"},
+		{"windows-1253", "windows-1253"},
+		{"windows-1254", "windows-1254"},
+		{"windows-1255", "windows-1255"},
+		{"windows-1256", "windows-1256"},
+		{"KOI8-R", "koi8-r"},
+		{"Shift_JIS", "shift_jis"},
+		{"EUC-JP", "euc-jp"},
+		{"EUC-KR", "euc-kr"},
+		{"ISO-2022-JP", "iso-2022-jp"},
+		{"Big5", "big5"},
+		{"GB-18030", "gb18030"},
+	}
+	for _, tt := range tests {
+		enc, err := lookupEncoding(charsetLabel(tt.name))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if tt.want == "" {
+			continue
+		}
+		if name, _ := htmlindex.Name(enc); name != tt.want {
+			t.Errorf("%s decodes as %s, want %s", tt.name, name, tt.want)
+		}
+	}
+}
+
+func TestTranscodeToUTF8(t *testing.T) {
+	text := strings.Repeat("我们在山上看到了一只很大的猫。它在树下睡觉，一动也不动。", 20)
+	tests := []struct {
+		name string
+		data func() ([]byte, error)
+	}{
+		{"gb18030", func() ([]byte, error) {
+			return simplifiedchinese.GB18030.NewEncoder().Bytes([]byte(text))
+		}},
+		{"utf-32be", func() ([]byte, error) {
+			return utf32.UTF32(utf32.BigEndian, utf32.UseBOM).NewEncoder().Bytes([]byte(text))
+		}},
+		{"utf-32le", func() ([]byte, error) {
+			return utf32.UTF32(utf32.LittleEndian, utf32.UseBOM).NewEncoder().Bytes([]byte(text))
+		}},
+		{"utf-8", func() ([]byte, error) { return []byte(text), nil }},
+	}
+	for _, tt := range tests {
+		data, err := tt.data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, charset, err := TranscodeToUTF8(data)
+		if err != nil {
+			t.Errorf("%s: %v (detected %s)", tt.name, err, charset)
+			continue
+		}
+		if string(decoded) != text {
+			t.Errorf("%s: decoded as %s to %q", tt.name, charset, decoded)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChecksumsFileName is in the format of sha256sum, so a data directory can
+// also be checked with sha256sum -c SHA256SUMS.
+const ChecksumsFileName string = "SHA256SUMS"
+
+// skipChecksum reports whether a file of a data directory is left out of its
+// checksums: files that are still being written, and the catalog, which
+// changes every time it is queried.
+func skipChecksum(name string) bool {
+	return name == ChecksumsFileName || strings.HasSuffix(name, ".part") || strings.HasSuffix(name, ".tmp") ||
+		strings.HasPrefix(name, CatalogFileName)
+}
+
+// ListChecksumFiles returns the files under dir that get a checksum, as
+// slash separated paths relative to dir.
+func ListChecksumFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || skipChecksum(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// hashFile returns the SHA-256 of a file.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashFiles hashes files relative to dir on every CPU. Files that can't be
+// read get their error instead.
+func HashFiles(dir string, files []string) ([]string, []error) {
+	sums := make([]string, len(files))
+	errs := make([]error, len(files))
+	next := make(chan int)
+	wg := new(sync.WaitGroup)
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				sums[i], errs[i] = hashFile(filepath.Join(dir, filepath.FromSlash(files[i])))
+			}
+		}()
+	}
+	for i := range files {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return sums, errs
+}
+
+// WriteChecksums writes the SHA-256 of every file under dir to
+// dir/SHA256SUMS.
+func WriteChecksums(dir string) error {
+	files, err := ListChecksumFiles(dir)
+	if err != nil {
+		return err
+	}
+	sums, errs := HashFiles(dir, files)
+	var checksums strings.Builder
+	for i, file := range files {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		fmt.Fprintf(&checksums, "%s  %s\n", sums[i], file)
+	}
+	path := filepath.Join(dir, ChecksumsFileName)
+	if err := os.WriteFile(path+".tmp", []byte(checksums.String()), 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ReadChecksums reads dir/SHA256SUMS into a map of file to checksum.
+func ReadChecksums(dir string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(dir, ChecksumsFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	checksums := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		// sha256sum marks files hashed in binary mode with a *
+		sum, file, ok := strings.Cut(line, " ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("malformed line in %s: %q", ChecksumsFileName, line)
+		}
+		file = strings.TrimPrefix(strings.TrimPrefix(file, " "), "*")
+		checksums[file] = strings.ToLower(sum)
+	}
+	return checksums, scanner.Err()
+}
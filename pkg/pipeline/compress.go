@@ -0,0 +1,150 @@
+package pipeline
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	CompressNone string = "none"
+	CompressGzip string = "gzip"
+	CompressZstd string = "zstd"
+)
+
+// extensions of compressed text files, book.txt.gz and book.txt.zst
+var CompressionExts = map[string]string{
+	CompressGzip: ".gz",
+	CompressZstd: ".zst",
+}
+
+// CompressionExt returns the extension added to the files of a compression,
+// "" for none.
+func CompressionExt(compression string) string {
+	return CompressionExts[compression]
+}
+
+// IsTextFile tells whether a file in the data directory is the text of a
+// book, compressed or not.
+func IsTextFile(name string) bool {
+	return strings.HasSuffix(TrimCompressionExt(name), ".txt")
+}
+
+// TrimCompressionExt returns the name of a text file without the extension
+// of its compression, the name the book has in the manifest.
+func TrimCompressionExt(name string) string {
+	for _, ext := range CompressionExts {
+		if strings.HasSuffix(name, ext) {
+			return strings.TrimSuffix(name, ext)
+		}
+	}
+	return name
+}
+
+// FindTextFile returns the path of the text of a book, which may have been
+// written compressed. The error is os.ErrNotExist if there is none.
+func FindTextFile(path string) (string, error) {
+	for _, ext := range []string{"", CompressionExts[CompressZstd], CompressionExts[CompressGzip]} {
+		if _, err := os.Stat(path + ext); err == nil {
+			return path + ext, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// ReadTextFile reads the text of a book from a text file, decompressing it
+// if its extension says it is compressed and leaving out the YAML header
+// -yaml-header put on it.
+func ReadTextFile(path string) ([]byte, error) {
+	data, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(StripYAMLHeader(string(data))), nil
+}
+
+// readFile reads a file, decompressing it if its extension says it is
+// compressed.
+func readFile(path string) ([]byte, error) {
+	r, err := OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// OpenFile opens a file for reading, decompressing it if its extension says
+// it is compressed.
+func OpenFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(path, CompressionExts[CompressGzip]):
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		return &decompressedFile{Reader: gr, close: func() {
+			gr.Close()
+			f.Close()
+		}}, nil
+	case strings.HasSuffix(path, CompressionExts[CompressZstd]):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		return &decompressedFile{Reader: zr, close: func() {
+			zr.Close()
+			f.Close()
+		}}, nil
+	}
+	return f, nil
+}
+
+// decompressedFile reads a compressed file, closing the decompressor along
+// with the file.
+type decompressedFile struct {
+	io.Reader
+	close func()
+}
+
+func (d *decompressedFile) Close() error {
+	d.close()
+	return nil
+}
+
+// WriteText writes text to w, compressed with the given compression.
+func WriteText(w io.Writer, text string, compression string) error {
+	switch compression {
+	case CompressGzip:
+		gw := gzip.NewWriter(w)
+		if _, err := io.WriteString(gw, text); err != nil {
+			return err
+		}
+		return gw.Close()
+	case CompressZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(zw, text); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	}
+	_, err := io.WriteString(w, text)
+	return err
+}
@@ -0,0 +1,35 @@
+package pipeline
+
+import (
+	"runtime"
+)
+
+// DefaultDownloadConcurrency is how many books a crawl downloads at once
+// unless its Concurrency says otherwise.
+const DefaultDownloadConcurrency = 4
+
+// Concurrency bounds how much of a run is done at once. A field of 0 is its
+// default.
+type Concurrency struct {
+	// pages of the source listed at once, source.DefaultConcurrency
+	List int
+	// books downloaded at once, DefaultDownloadConcurrency
+	Download int
+	// epubs converted at once, the number of CPUs. The books converted at
+	// once are written to the dataset in the order they finish in
+	Convert int
+}
+
+func (c Concurrency) downloads() int {
+	if c.Download > 0 {
+		return c.Download
+	}
+	return DefaultDownloadConcurrency
+}
+
+func (c Concurrency) conversions() int {
+	if c.Convert > 0 {
+		return c.Convert
+	}
+	return runtime.NumCPU()
+}
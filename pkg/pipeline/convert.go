@@ -0,0 +1,518 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/epub2text"
+	"github.com/coreweave/dataset-downloader/pkg/source"
+	"golang.org/x/sync/errgroup"
+)
+
+// BookInfo is what the book page tells us about a book.
+type BookInfo struct {
+	// id of the book on its site, like smashwords.BookID
+	BookID string `json:"book_id,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Author string `json:"author,omitempty"`
+	// url of the book page
+	URL string `json:"url,omitempty"`
+	// the categories the book is listed in, broadest first
+	Categories []string `json:"categories,omitempty"`
+	// price on the book page
+	Price string `json:"price,omitempty"`
+	// the license notes of the book
+	License string `json:"license,omitempty"`
+	// publication date, YYYY-MM-DD when the page gives one
+	Published string `json:"published,omitempty"`
+	// the rating and tags of a work of Archive of Our Own
+	AO3 *AO3Info `json:"ao3,omitempty"`
+}
+
+// ConvertTextDownload decodes a plain text download at partialFilePath and
+// puts it through the same cleanup and filters as converted epubs, into the
+// sink or the rejects folder. Downloads in an encoding we can't make sense
+// of are set aside as <fileName>.undecodable. It returns the error of ctx if
+// the run stopped before the book was written, and the download is gone
+// either way.
+func ConvertTextDownload(ctx context.Context, book BookInfo, docID string, fileName string, partialFilePath string, sourceURL string,
+	downloadedAt time.Time, dataDir string, manifest *Manifest, sink Sink, opts ConvertOptions) error {
+	filePath := filepath.Join(dataDir, fileName)
+	data, err := os.ReadFile(partialFilePath)
+	if err != nil {
+		return fmt.Errorf("reading the download: %w", err)
+	}
+	decoded, charset, err := TranscodeToUTF8(data)
+	if err != nil {
+		BookLogger(docID, sourceURL, LogPhaseConvert).Warn("Could not decode book, set it aside",
+			"title", book.Title, "file", fileName+UndecodableSuffix, "error", err)
+		opts.Report.Fail(book.Title, fileName, "decode", err.Error())
+		opts.Events.Emit(Event{Type: EventError, BookID: docID, Title: book.Title, URL: sourceURL, File: fileName,
+			Phase: LogPhaseConvert, Error: err.Error()})
+		opts.Callbacks.failed(docID, LogPhaseConvert, err)
+		if err := manifest.LogError(fileName, "decode", err.Error()); err != nil {
+			return fmt.Errorf("logging the error of %s: %w", fileName, err)
+		}
+		if err := os.Rename(partialFilePath, filePath+UndecodableSuffix); err != nil {
+			return fmt.Errorf("setting aside %s: %w", fileName, err)
+		}
+		manifest.Put(&ManifestRecord{
+			File:          fileName + UndecodableSuffix,
+			ID:            docID,
+			Format:        "txt",
+			BookInfo:      book,
+			DownloadedAt:  downloadedAt,
+			Provenance:    opts.Provenance.ForSource(sourceURL),
+			Encoding:      charset,
+			EncodingError: err.Error(),
+		})
+		return nil
+	}
+	text := NormalizeEntities(string(decoded))
+	if opts.Dewrap {
+		text = Dewrap(NormalizeControl(text))
+	}
+	text, report := CleanText(text, opts, nil)
+	record := &ManifestRecord{
+		File:         fileName,
+		ID:           docID,
+		Format:       "txt",
+		BookInfo:     book,
+		DownloadedAt: downloadedAt,
+		Provenance:   opts.Provenance.ForSource(sourceURL),
+		Encoding:     charset,
+	}
+	err = WriteCleanText(ctx, record, text, report, dataDir, manifest, sink, opts)
+	os.Remove(partialFilePath)
+	return err
+}
+
+// WriteCleanText measures the text of a book that went through CleanText
+// and writes it to the sink, or to the rejects folder if a filter rejects
+// it. record has what is known of the book so far. If ctx is done before the
+// book is decided its error is returned, and nothing is written.
+func WriteCleanText(ctx context.Context, record *ManifestRecord, text string, report CleanReport, dataDir string, manifest *Manifest, sink Sink, opts ConvertOptions) error {
+	record.Chars = len(text)
+	record.Words = CountWords(text)
+	record.Unicode = opts.Unicode
+	record.Punctuation = opts.Punctuation
+	record.Whitespace = opts.Whitespace
+	record.Removed = CountRemovals(report.Removed)
+	record.Chapters = report.Chapters
+	record.PIIMasked = report.PIIMasked
+	record.Language = DetectLanguage(text)
+	record.Quality = MeasureQuality(text)
+	record.Repetition = RepetitionRatio(text)
+	record.SHA256 = ContentHash(text)
+	countTokens(record, text, opts.Tokenizer)
+	record.LicenseType = ClassifyLicense(record.License, text)
+	if isbn := FindISBN(text); isbn != "" {
+		record.Identifiers = map[string]string{"isbn": isbn}
+	}
+	if opts.OpenLibrary != nil {
+		opts.OpenLibrary.Enrich(record)
+	}
+	reason, err := AcceptBook(ctx, record, &text, opts, manifest)
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		BookLogger(record.ID, record.URL, LogPhaseFilter).Info("Rejected book, moved it to "+RejectsDirName,
+			"title", record.Title, "reason", reason)
+		opts.Report.Reject(record.Title, record.File, reason)
+		opts.Events.Emit(Event{Type: EventConvertComplete, BookID: record.ID, Title: record.Title, URL: record.URL,
+			Format: record.Format, File: record.File, Rejected: reason, Chars: len(text)})
+		opts.Callbacks.conversionComplete(record, text)
+		if err := WriteReject(dataDir, record.File, text); err != nil {
+			return fmt.Errorf("writing the rejected %s: %w", record.File, err)
+		}
+		return nil
+	}
+	if err := manifest.LogRemovals(record.File, report.Removed); err != nil {
+		return fmt.Errorf("logging the removals of %s: %w", record.File, err)
+	}
+	if err := sink.Write(record, text); err != nil {
+		return fmt.Errorf("writing %s: %w", record.File, err)
+	}
+	opts.Report.Succeed(record.Title, record.File, len(text))
+	opts.Events.Emit(Event{Type: EventConvertComplete, BookID: record.ID, Title: record.Title, URL: record.URL,
+		Format: record.Format, File: record.File, Chars: len(text)})
+	opts.Callbacks.conversionComplete(record, text)
+	opts.Hooks.Book(record)
+	return nil
+}
+
+// the ways notes, tables and images are converted, see epub2text
+const (
+	NotesKeep   = epub2text.NotesKeep
+	NotesInline = epub2text.NotesInline
+	NotesAppend = epub2text.NotesAppend
+
+	TablesText     = epub2text.TablesText
+	TablesMarkdown = epub2text.TablesMarkdown
+
+	ImagesDrop        = epub2text.ImagesDrop
+	ImagesPlaceholder = epub2text.ImagesPlaceholder
+	ImagesExtract     = epub2text.ImagesExtract
+
+	// the folder of the data directory images are extracted to, with a
+	// folder for every book
+	assetsDirName string = "assets"
+)
+
+// ConvertOptions controls how epubs are converted to text.
+type ConvertOptions struct {
+	// delete the epub after converting it
+	OverwriteSource bool
+	// one of NotesKeep, NotesInline or NotesAppend
+	Notes string
+	// one of TablesText or TablesMarkdown
+	Tables string
+	// one of ImagesDrop, ImagesPlaceholder or ImagesExtract
+	Images string
+	// one of WhitespaceCollapse, WhitespaceTrim or WhitespacePreserve
+	Whitespace string
+	// column to wrap paragraphs at, 0 for no wrapping
+	Wrap int
+	// one of UnicodeNone, UnicodeNFC or UnicodeNFKC
+	Unicode string
+	// one of PunctuationKeep, PunctuationASCII or PunctuationUnicode
+	Punctuation string
+	// remove Smashwords license notes and distribution notices, and the
+	// Project Gutenberg header and license
+	StripBoilerplate bool
+	// remove title pages, copyright pages and dedications
+	StripFrontMatter bool
+	// remove about the author sections, lists of other books and plugs
+	StripBackMatter bool
+	// template chapter headings are replaced with, empty to leave them be
+	ChapterMarker string
+	// rejoin hard wrapped lines of txt downloads
+	Dewrap bool
+	// ISO 639-1 codes of the languages to keep, empty to keep every book
+	KeepLanguages []string
+	// kinds of license to keep, see KeepLicense. Empty keeps every book
+	Licenses []string
+	// move books that fail the quality heuristics to the rejects folder
+	QualityFilter bool
+	// bounds on the number of words of a book, 0 for no bound
+	MinWords int
+	MaxWords int
+	// share of repeated lines or paragraphs above which a book is
+	// rejected, 0 to keep every book
+	MaxRepetition float64
+	// mask contact details in author contact sections
+	ScrubPII bool
+	// reject exact duplicates of books already in the dataset
+	Dedupe bool
+	// content hashes of books in other corpora, which are rejected
+	ExcludeHashes map[string]bool
+	// looks up books with an ISBN on Open Library, nil not to
+	OpenLibrary *OpenLibraryClient
+	// the run converting the books, recorded with every book
+	Provenance *Provenance
+	// counts the tokens of every book, nil not to
+	Tokenizer Tokenizer
+	// what happened to every book, for the run report. nil not to keep track
+	Report *RunReport
+	// how far the run is, nil not to show it
+	Progress *Progress
+	// the event stream of the run, nil not to write one
+	Events *Events
+	// the commands run for the books written and at the end, nil not to
+	Hooks *Hooks
+	// the stages of -postprocess, nil for none
+	PostProcessors *PostProcessors
+	// the metrics of the run, nil not to serve them
+	Metrics *Metrics
+	// sends spans of the run to a collector, nil not to
+	Tracer *Tracer
+	// posts the summary of the run once it ends, nil not to
+	Notifier *Notifier
+	// the books a crawl downloads, nil for all of them
+	Shard *Shard
+	// called as the run goes, nil for none. Sessions set it to the
+	// Callbacks of their Options
+	Callbacks *Callbacks
+	// how many pages are listed, books downloaded and epubs converted at
+	// once
+	Concurrency Concurrency
+	// when a crawl converts its epubs, one of StreamOff, StreamFile or
+	// StreamMemory. Empty is StreamOff. Streamed epubs are converted by the
+	// downloads, up to Concurrency.Download at once
+	Stream string
+}
+
+// ConvertEpubGo converts the epubs in inputdir. The parsing is done by
+// epub2text, which builds on https://github.com/taylorskalyo/goreader.
+// It converts up to opts.Concurrency.Convert epubs at once, and stops at the
+// first error or once ctx is done, between books and between the chapters
+// of a book, and returns the error. The epubs not converted yet stay in
+// inputdir for the next run.
+func ConvertEpubGo(ctx context.Context, inputdir string, opts ConvertOptions, manifest *Manifest, sink Sink) error {
+	// get all files in directory
+	files, err := os.ReadDir(inputdir)
+	if err != nil {
+		return fmt.Errorf("listing the epubs: %w", err)
+	}
+
+	// we time the parsing
+	start := time.Now()
+
+	// we count the number of characters
+	var charMu sync.Mutex
+	charCount := 0
+
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".epub") {
+			opts.Progress.Add(PhaseConverted, 1)
+		}
+	}
+
+	ctx, span := opts.Tracer.Start(ctx, "convert_epubs")
+	defer span.End()
+
+	// the first error of a book stops the others, through convertCtx
+	g, convertCtx := errgroup.WithContext(ctx)
+	g.SetLimit(opts.Concurrency.conversions())
+
+	// for each file, if it is an epub, convert it to txt
+	for _, file := range files {
+
+		// if it is not an epub, skip it
+		if !strings.HasSuffix(file.Name(), ".epub") {
+			continue
+		}
+		if convertCtx.Err() != nil {
+			break
+		}
+		// books already appended to a dataset file would end up in it twice
+		record := manifest.Get(strings.TrimSuffix(file.Name(), ".epub") + ".txt")
+		if record != nil && record.Dataset != "" {
+			opts.Report.Skip(record.Title, record.File, "in dataset: "+record.Dataset)
+			opts.Progress.Done(PhaseConverted, 1)
+			continue
+		}
+		source.Go(g, func() error {
+			started := time.Now()
+			_, bookSpan := opts.Tracer.Start(convertCtx, "convert")
+			bookSpan.SetAttribute("book.file", file.Name())
+			chars, err := ConvertSingleEpub(convertCtx, file, inputdir, opts, manifest, sink)
+			bookSpan.End()
+			if err != nil {
+				return err
+			}
+			charMu.Lock()
+			charCount += chars
+			charMu.Unlock()
+			opts.Metrics.Converted("epub", time.Since(started))
+			opts.Progress.Done(PhaseConverted, 1)
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := manifest.Save(); err != nil {
+		return fmt.Errorf("saving the manifest: %w", err)
+	}
+
+	if charCount > 0 {
+		elapsed := time.Since(start)
+		slog.Info("Converted epubs", "phase", LogPhaseConvert, "duration", elapsed, "chars", charCount,
+			"chars_per_second", int(float64(charCount)/elapsed.Seconds()))
+	}
+	return nil
+}
+
+func ConvertSingleEpub(ctx context.Context, file os.DirEntry, inputdir string, opts ConvertOptions, manifest *Manifest, sink Sink) (int, error) {
+	filepath := inputdir + "/" + file.Name()
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return 0, fmt.Errorf("opening the epub: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("opening the epub: %w", err)
+	}
+	charCount, err := convertEpub(ctx, f, info.Size(), file.Name(), inputdir, opts, manifest, sink)
+	if err != nil {
+		return 0, err
+	}
+
+	//if overwriteSource is true, delete the original epub file
+	if opts.OverwriteSource {
+		err = os.Remove(filepath)
+		if err != nil {
+			return 0, fmt.Errorf("removing the converted epub: %w", err)
+		}
+	}
+
+	return charCount, nil
+}
+
+// convertEpub converts the epub named fileName read from r, of size bytes,
+// and writes the book to the sink or rejects it. It returns the number of
+// characters of the text. The book is converted in memory, rather than
+// streamed with epub2text.ConvertTo, as CleanText, the filters and the sink
+// all need its whole text.
+func convertEpub(ctx context.Context, r io.ReaderAt, size int64, fileName string, inputdir string, opts ConvertOptions, manifest *Manifest, sink Sink) (int, error) {
+	charCount := 0
+	bookName := strings.TrimSuffix(fileName, ".epub")
+	logger := BookLogger(bookName, "", LogPhaseConvert)
+	book, err := epub2text.ConvertContext(ctx, r, size, opts.EpubOptions(inputdir, bookName))
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	} else if err != nil {
+		return 0, fmt.Errorf("converting %s: %w", fileName, err)
+	}
+	logger.Info("Converting epub", "title", book.Title, "file", fileName)
+	for _, warning := range book.Warnings {
+		logger.Warn("Could not convert all of the epub", "file", fileName, "error", warning)
+	}
+
+	text, report := CleanText(book.Text, opts, HeadingKeys(book.Headings))
+
+	// in append mode the notes go after the last chapter. They are cleaned
+	// on their own so stripping the back matter can't take them out.
+	if appendix := book.Notes; appendix != "" {
+		notesOpts := opts
+		notesOpts.StripBoilerplate = false
+		notesOpts.StripFrontMatter = false
+		notesOpts.StripBackMatter = false
+		notesOpts.ChapterMarker = ""
+		appendix, _ = CleanText(appendix, notesOpts, nil)
+		text = strings.TrimRight(text, "\n") + "\n\n" + appendix
+	}
+	charCount += len(text)
+
+	// generate output file name
+	outputFileName := bookName + ".txt"
+	record := &ManifestRecord{
+		File:        outputFileName,
+		Source:      fileName,
+		Format:      "epub",
+		BookInfo:    BookInfo{Title: book.Title, Author: book.Author},
+		Rights:      book.Rights,
+		Chars:       len(text),
+		Words:       CountWords(text),
+		Unicode:     opts.Unicode,
+		Punctuation: opts.Punctuation,
+		Whitespace:  opts.Whitespace,
+		Removed:     CountRemovals(report.Removed),
+		Chapters:    report.Chapters,
+		PIIMasked:   report.PIIMasked,
+		Language:    DetectLanguage(text),
+		Quality:     MeasureQuality(text),
+		Repetition:  RepetitionRatio(text),
+		SHA256:      ContentHash(text),
+		ConvertedAt: time.Now().UTC(),
+		Provenance:  opts.Provenance.ForSource(""),
+	}
+	// the book page was recorded when the epub was downloaded
+	if downloaded := manifest.Get(outputFileName); downloaded != nil {
+		author := record.Author
+		record.ID = downloaded.ID
+		record.BookInfo = downloaded.BookInfo
+		record.DownloadedAt = downloaded.DownloadedAt
+		if downloaded.Provenance != nil && record.Provenance != nil {
+			record.Provenance.SourceURL = downloaded.Provenance.SourceURL
+		}
+		record.Title = book.Title
+		if record.Author == "" {
+			record.Author = author
+		}
+	}
+	// the package document often knows more than the book page
+	applyEpubMetadata(record, book)
+	countTokens(record, text, opts.Tokenizer)
+	record.LicenseType = ClassifyLicense(record.License, record.Rights, text)
+	if record.Identifiers["isbn"] == "" {
+		if isbn := FindISBN(text); isbn != "" {
+			if record.Identifiers == nil {
+				record.Identifiers = make(map[string]string)
+			}
+			record.Identifiers["isbn"] = isbn
+		}
+	}
+	if opts.OpenLibrary != nil {
+		opts.OpenLibrary.Enrich(record)
+	}
+
+	reason, err := AcceptBook(ctx, record, &text, opts, manifest)
+	if err != nil {
+		return 0, err
+	}
+	if reason != "" {
+		BookLogger(bookName, record.URL, LogPhaseFilter).Info("Rejected book, moved it to "+RejectsDirName,
+			"title", book.Title, "reason", reason)
+		opts.Report.Reject(book.Title, outputFileName, reason)
+		opts.Events.Emit(Event{Type: EventConvertComplete, BookID: bookName, Title: book.Title, URL: record.URL,
+			Format: "epub", File: outputFileName, Rejected: reason, Chars: len(text)})
+		opts.Callbacks.conversionComplete(record, text)
+		if err := WriteReject(inputdir, outputFileName, text); err != nil {
+			return 0, fmt.Errorf("writing the rejected %s: %w", outputFileName, err)
+		}
+	} else {
+		if err := manifest.LogRemovals(outputFileName, report.Removed); err != nil {
+			return 0, fmt.Errorf("logging the removals of %s: %w", outputFileName, err)
+		}
+		if err := sink.Write(record, text); err != nil {
+			return 0, fmt.Errorf("writing %s: %w", outputFileName, err)
+		}
+		opts.Report.Succeed(book.Title, outputFileName, len(text))
+		opts.Events.Emit(Event{Type: EventConvertComplete, BookID: bookName, Title: book.Title, URL: record.URL,
+			Format: "epub", File: outputFileName, Chars: len(text)})
+		opts.Callbacks.conversionComplete(record, text)
+		opts.Hooks.Book(record)
+	}
+
+	return charCount, nil
+}
+
+// EpubOptions are the options of the conversion of the epub of a book in
+// dataDir by epub2text, with its images extracted to the assets folder.
+func (opts ConvertOptions) EpubOptions(dataDir string, bookName string) epub2text.Options {
+	return epub2text.Options{
+		Notes:      opts.Notes,
+		Tables:     opts.Tables,
+		Images:     opts.Images,
+		Whitespace: opts.Whitespace,
+		Wrap:       opts.Wrap,
+		ImagesDir:  filepath.Join(dataDir, assetsDirName, bookName),
+		ImagesPath: path.Join(assetsDirName, bookName),
+	}
+}
+
+// applyEpubMetadata merges what an epub says about itself into the record
+// of the book. What the book page said is kept, and the rest is filled in
+// from the epub.
+func applyEpubMetadata(record *ManifestRecord, book epub2text.Document) {
+	if record.Author == "" {
+		record.Author = strings.Join(book.Creators, ", ")
+	}
+	if len(book.Languages) > 0 {
+		record.DeclaredLanguage = book.Languages[0]
+	}
+	record.Subjects = book.Subjects
+	if len(book.Publishers) > 0 {
+		record.Publisher = book.Publishers[0]
+	}
+	if len(book.Identifiers) > 0 {
+		record.Identifiers = book.Identifiers
+	}
+}
@@ -0,0 +1,71 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+func TestConvertErrors(t *testing.T) {
+	// the errors of a run are returned to the caller, not the end of the
+	// process
+	missing := filepath.Join(t.TempDir(), "missing")
+	ctx := context.Background()
+	if err := ConvertEpubGo(ctx, missing, ConvertOptions{}, nil, nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("converting a missing folder returned %v", err)
+	}
+	err := ConvertTextDownload(ctx, BookInfo{Title: "The Long Road"}, "1", "1.txt", missing+".part", "",
+		time.Now(), missing, nil, nil, ConvertOptions{})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("converting a missing download returned %v", err)
+	}
+}
+
+func TestConvertEntities(t *testing.T) {
+	// an epub about html spells its entities escaped, and they are its text
+	dir := t.TempDir()
+	epub := testEpub(t, "<p>Write &amp;amp; for an ampersand, not &amp;.</p>")
+	if err := os.WriteFile(filepath.Join(dir, "book.epub"), epub, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &TxtSink{store: &storage.Local{Dir: dir}}
+	if err := ConvertEpubGo(context.Background(), dir, ConvertOptions{}, manifest, sink); err != nil {
+		t.Fatal(err)
+	}
+	text, err := os.ReadFile(filepath.Join(dir, "book.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Write &amp; for an ampersand, not &."; !strings.Contains(string(text), want) {
+		t.Errorf("converted the epub to %q, want %q", text, want)
+	}
+
+	// plain text downloads are decoded once
+	download := filepath.Join(dir, "1.txt.part")
+	if err := os.WriteFile(download, []byte("Salt &amp; pepper, &amp;amp; and&nbsp;more.\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = ConvertTextDownload(context.Background(), BookInfo{Title: "Salt"}, "1", "1.txt", download, "",
+		time.Now(), dir, manifest, sink, ConvertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err = os.ReadFile(filepath.Join(dir, "1.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Salt & pepper, &amp; and more."; !strings.Contains(string(text), want) {
+		t.Errorf("converted the download to %q, want %q", text, want)
+	}
+}
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// Crawler downloads the books of a source into a dataset, and converts
+// them, like the scrape command.
+type Crawler struct {
+	Source  source.Source
+	Options Options
+	// leave the downloaded epubs in the data directory for a later
+	// conversion, instead of converting them once the crawl is done
+	DeferConversion bool
+
+	// how the run of Documents ended
+	err error
+}
+
+// Book is a book a crawl wrote to the dataset.
+type Book struct {
+	// the record of the book in the manifest, as it was written
+	Record ManifestRecord
+	Text   string
+}
+
+// Run crawls the source and finishes the dataset, until the crawl is done
+// or ctx is. A crawl the site stopped returns an error that wraps
+// source.ErrRateLimited, and a crawl with books that failed a
+// PartialFailure. Either way the next run picks up where it stopped.
+func (c *Crawler) Run(ctx context.Context) error {
+	return c.run(ctx, nil)
+}
+
+// Documents runs the crawl like Run, and yields every book as it is
+// written to the dataset: the txt downloads as they arrive, and the epubs
+// as they are converted once the crawl is done, or as they arrive too with
+// Options.Convert.Stream. The channel is closed when the run ends, and Err
+// returns how it did. The crawl waits for every book to be received, so a
+// caller that stops reading early calls stop, which ends the run like
+// cancelling ctx would and returns once it has. Calling stop after the
+// channel is closed does nothing.
+//
+//	documents, stop := crawler.Documents(ctx)
+//	defer stop()
+//	for doc := range documents {
+//		fmt.Println(doc.Record.Title, len(doc.Text))
+//	}
+//	if err := crawler.Err(); err != nil {
+//		...
+//	}
+func (c *Crawler) Documents(ctx context.Context) (documents <-chan Book, stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	books := make(chan Book)
+	go func() {
+		defer close(books)
+		c.err = c.run(ctx, func(sink Sink) Sink {
+			return &documentSink{Sink: sink, ctx: ctx, documents: books}
+		})
+	}()
+	stop = func() {
+		cancel()
+		for range books {
+		}
+	}
+	return books, stop
+}
+
+// Err returns the error of the run of Documents once its channel is
+// closed, as Run would have returned it.
+func (c *Crawler) Err() error {
+	return c.err
+}
+
+// run runs the crawl, with the sink of the session wrapped by wrap if it
+// isn't nil.
+func (c *Crawler) run(ctx context.Context, wrap func(Sink) Sink) error {
+	session, err := OpenSession(c.Options)
+	if err != nil {
+		return err
+	}
+	if wrap != nil {
+		session.Sink = wrap(session.Sink)
+	}
+	epubs, err := session.Crawl(ctx, c.Source)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return session.Abort(err)
+	}
+	return session.Finish(ctx, epubs && !c.DeferConversion)
+}
+
+// documentSink passes on the books written to a sink to the channel of
+// Documents.
+type documentSink struct {
+	Sink
+	ctx       context.Context
+	documents chan<- Book
+}
+
+func (s *documentSink) Write(record *ManifestRecord, text string) error {
+	if err := s.Sink.Write(record, text); err != nil {
+		return err
+	}
+	// the book is in the dataset either way, the caller just stopped
+	// listening
+	select {
+	case s.documents <- Book{Record: *record, Text: text}:
+	case <-s.ctx.Done():
+	}
+	return nil
+}
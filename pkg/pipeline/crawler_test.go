@@ -0,0 +1,80 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// testSource has count txt books, which it finds until ctx is done.
+type testSource struct {
+	count int
+}
+
+func (s *testSource) Discover(ctx context.Context, found func(*source.Book) error) error {
+	for i := 1; i <= s.count; i++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		book := &source.Book{ID: fmt.Sprintf("test-%d-txt", i), Format: "txt", Title: fmt.Sprintf("Book %d", i)}
+		if err := found(book); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *testSource) Fetch(ctx context.Context, book *source.Book) (io.ReadCloser, error) {
+	return io.NopCloser(strings.NewReader("Once upon a time, in " + book.Title + ", it rained.\n")), nil
+}
+
+func TestCrawlerDocuments(t *testing.T) {
+	crawler := &Crawler{
+		Source:  &testSource{count: 3},
+		Options: Options{DataDir: t.TempDir()},
+	}
+	documents, stop := crawler.Documents(context.Background())
+	defer stop()
+	var titles []string
+	for doc := range documents {
+		titles = append(titles, doc.Record.Title)
+	}
+	if err := crawler.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 3 {
+		t.Errorf("got the books %v, want 3", titles)
+	}
+}
+
+func TestCrawlerDocumentsStop(t *testing.T) {
+	crawler := &Crawler{
+		Source:  &testSource{count: 1000},
+		Options: Options{DataDir: t.TempDir()},
+	}
+	documents, stop := crawler.Documents(context.Background())
+	<-documents
+
+	// breaking out of the loop ends the crawl, instead of leaving it
+	// waiting for the next book to be received
+	done := make(chan struct{})
+	go func() {
+		stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the crawl didn't stop")
+	}
+	if err := crawler.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want the crawl canceled", err)
+	}
+	stop()
+}
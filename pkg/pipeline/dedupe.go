@@ -0,0 +1,16 @@
+package pipeline
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// ContentHash returns the SHA-256 of a text after lowercasing it and
+// collapsing its whitespace, so copies of a book that only differ in
+// formatting get the same hash.
+func ContentHash(text string) string {
+	normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
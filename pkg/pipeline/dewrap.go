@@ -0,0 +1,164 @@
+package pipeline
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// texts with fewer lines than this are left alone, there isn't enough to
+	// tell whether they are hard wrapped
+	minDewrapLines int = 20
+	// texts wrapped wider than this aren't hard wrapped, they just have long
+	// paragraphs
+	maxWrapWidth int = 100
+	// how far short of the wrap width a wrapped line may end
+	wrapSlack int = 2
+)
+
+// A word broken over two lines: a letter, a hyphen at the end of the line
+// and a lowercase letter at the start of the next.
+var lineHyphenRegex = regexp.MustCompile(`\pL-$`)
+
+var wordRegex = regexp.MustCompile(`[\pL]+(-[\pL]+)*`)
+
+// Dewrap rejoins the lines of hard wrapped plain text into paragraphs and
+// repairs words that were hyphenated at the end of a line. Lines are joined
+// when the first word of the next line would have fit on them, so short
+// lines (headings, the last line of a paragraph, poetry) keep their break.
+// Indented lines and headings get a blank line before them. Text that
+// doesn't look hard wrapped is returned as it is.
+func Dewrap(text string) string {
+	lines := strings.Split(text, "\n")
+	width := wrapWidth(lines)
+	if width == 0 {
+		return text
+	}
+	// words that show up with a hyphen inside a line are real compounds
+	compounds := make(map[string]bool)
+	for _, line := range lines {
+		for _, word := range wordRegex.FindAllString(line, -1) {
+			if strings.Contains(word, "-") {
+				compounds[strings.ToLower(word)] = true
+			}
+		}
+	}
+
+	// the paragraph being rejoined
+	current := lines[0]
+	var out []string
+	for i := 1; i < len(lines); i++ {
+		prev := strings.TrimRight(lines[i-1], " \t")
+		line := lines[i]
+		if !isWrapped(prev, line, width) {
+			out = append(out, current)
+			if startsParagraph(prev, line) {
+				out = append(out, "")
+			}
+			current = line
+			continue
+		}
+
+		next := strings.TrimSpace(line)
+		current = strings.TrimRight(current, " \t")
+		switch {
+		case lineHyphenRegex.MatchString(current) && startsLower(next) && !compounds[compoundKey(prev, next)]:
+			// "some-" + "thing" becomes "something"
+			current = strings.TrimSuffix(current, "-") + next
+		case strings.HasSuffix(current, "-"):
+			// an em dash written as -- or a real compound, no space
+			current += next
+		default:
+			current += " " + next
+		}
+	}
+	out = append(out, current)
+	return strings.Join(out, "\n")
+}
+
+// wrapWidth guesses the column text was hard wrapped at, or returns 0 if it
+// doesn't look hard wrapped: most lines have to end close to the same column.
+func wrapWidth(lines []string) int {
+	var lengths []int
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		if line != "" {
+			lengths = append(lengths, utf8.RuneCountInString(line))
+		}
+	}
+	if len(lengths) < minDewrapLines {
+		return 0
+	}
+	sort.Ints(lengths)
+	width := lengths[len(lengths)*9/10]
+	if width > maxWrapWidth {
+		return 0
+	}
+
+	near := 0
+	for _, l := range lengths {
+		if l >= width*3/4 && l <= width {
+			near++
+		}
+	}
+	if near < len(lengths)/2 {
+		return 0
+	}
+	return width
+}
+
+// isWrapped reports whether line continues the paragraph of prev, that is
+// whether the wrapping broke the line rather than the author.
+func isWrapped(prev string, line string, width int) bool {
+	if prev == "" || strings.TrimSpace(line) == "" || isIndented(line) {
+		return false
+	}
+	if IsChapterHeading(prev) || IsChapterHeading(line) {
+		return false
+	}
+	return utf8.RuneCountInString(prev)+1+utf8.RuneCountInString(firstWord(line)) > width-wrapSlack
+}
+
+// startsParagraph reports whether a line break the wrapping didn't make
+// should become a blank line: before indented lines and around headings.
+func startsParagraph(prev string, line string) bool {
+	if strings.TrimSpace(prev) == "" || strings.TrimSpace(line) == "" {
+		return false
+	}
+	return isIndented(line) || IsChapterHeading(prev) || IsChapterHeading(line)
+}
+
+func startsLower(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.IsLower(r)
+}
+
+// compoundKey joins the word broken at the end of prev with its rest on the
+// next line, keeping the hyphen.
+func compoundKey(prev string, next string) string {
+	notLetter := func(r rune) bool { return !unicode.IsLetter(r) }
+	return strings.ToLower(strings.TrimFunc(lastWord(prev), notLetter) + "-" + strings.TrimFunc(firstWord(next), notLetter))
+}
+
+func isIndented(line string) bool {
+	return strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+func firstWord(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func lastWord(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
@@ -0,0 +1,158 @@
+package pipeline
+
+import (
+	"strings"
+	"testing"
+)
+
+// hardWrap wraps every paragraph of text at width, like a plain text
+// download of a book.
+func hardWrap(text string, width int) string {
+	var out []string
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		line := ""
+		for _, word := range strings.Fields(paragraph) {
+			if line != "" && len(line)+1+len(word) > width {
+				out = append(out, line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		out = append(out, line, "")
+	}
+	return strings.Join(out, "\n")
+}
+
+const dewrapStory = "It was the kind of morning that made the whole town want to stay in bed, and most of it" +
+	" did. The rain came down on the roofs and the harbour and the boats, and nobody went out to look at it." +
+	" By noon it had stopped, and she walked down to the water to watch the boats come in, one after another," +
+	" with their nets hanging off the sides and the gulls following them in."
+
+// a paragraph wrapped at 60 with lines that start like headings
+var dewrapHeadingLines = []string{
+	"She knew then that it was over, and that she had lost him.",
+	"Part of me wanted to scream, and part of me wanted to laugh",
+	"at the whole thing, she wrote to her sister that night. The",
+	"Book of the Dead was on the table where he had left it, open",
+	"at the last page. He had learned every word of it by heart.",
+	"Chapter and verse, he would say, whenever anyone asked him.",
+}
+
+func TestDewrapHeadings(t *testing.T) {
+	tests := []struct {
+		name string
+		// the book, wrapped at 60
+		text string
+		want []string
+	}{
+		{
+			name: "chapter headings keep their own paragraph",
+			text: hardWrap(strings.Join([]string{"Chapter 1", dewrapStory, dewrapStory, "Chapter Two", dewrapStory,
+				"Part III", dewrapStory}, "\n\n"), 60),
+			want: []string{"Chapter 1", dewrapStory, dewrapStory, "Chapter Two", dewrapStory, "Part III", dewrapStory},
+		},
+		{
+			name: "lines that start like a heading are joined",
+			text: hardWrap("Chapter 1\n\n"+dewrapStory+"\n\n"+dewrapStory, 60) + "\n" +
+				strings.Join(dewrapHeadingLines, "\n") + "\n",
+			want: []string{"Chapter 1", dewrapStory, dewrapStory, strings.Join(dewrapHeadingLines, " ")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := paragraphs(Dewrap(tt.text))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d paragraphs, want %d:\n%s", len(got), len(tt.want), strings.Join(got, "\n---\n"))
+			}
+			for i, p := range got {
+				if p != tt.want[i] {
+					t.Errorf("paragraph %d is %q, want %q", i, p, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDewrap(t *testing.T) {
+	// enough wrapped text before the lines under test for Dewrap to tell
+	// the width
+	story := hardWrap(dewrapStory+"\n\n"+dewrapStory+"\n\n"+dewrapStory, 60) + "\n"
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{
+			name: "words hyphenated at the end of a line are repaired",
+			lines: []string{
+				"The fishermen came in one after another, carrying every-",
+				"thing they had caught that morning up the hill to the inn.",
+			},
+			want: []string{"The fishermen came in one after another, carrying everything they had caught that morning up the hill to the inn."},
+		},
+		{
+			name: "compounds used elsewhere keep their hyphen",
+			lines: []string{
+				"It was a well-known story in the town, and she said it was",
+				"the kind of thing that everyone along the coast was well-",
+				"known for, from here all the way down to the south.",
+			},
+			want: []string{"It was a well-known story in the town, and she said it was the kind of thing that" +
+				" everyone along the coast was well-known for, from here all the way down to the south."},
+		},
+		{
+			name: "dashes join without a space",
+			lines: []string{
+				"She waited by the door for him until it was very late, and--",
+				"as she had known all along--he never came back to the inn.",
+			},
+			want: []string{"She waited by the door for him until it was very late, and--as she had known all along--he never came back to the inn."},
+		},
+		{
+			name: "indented lines start a paragraph",
+			lines: []string{
+				"She waited by the door for him until it was very late, and",
+				"then she went to bed.",
+				"    In the morning the harbour was empty and the boats gone.",
+			},
+			want: []string{"She waited by the door for him until it was very late, and then she went to bed.",
+				"In the morning the harbour was empty and the boats gone."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Dewrap(story + "\n" + strings.Join(tt.lines, "\n") + "\n")
+			ps := paragraphs(got)
+			if len(ps) < 3 {
+				t.Fatalf("the story was lost: %q", got)
+			}
+			// the lines under test, which may still be split into lines
+			var rest []string
+			for _, p := range ps[3:] {
+				rest = append(rest, strings.Split(p, "\n")...)
+			}
+			if strings.Join(rest, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("got %q, want %q", rest, tt.want)
+			}
+		})
+	}
+}
+
+func TestDewrapLeavesUnwrappedText(t *testing.T) {
+	tests := []string{
+		// too short to tell
+		"It rained.\nIt stopped.\n",
+		// paragraphs on one line each
+		strings.Repeat(dewrapStory+"\n\n", 30),
+		// poetry, short lines of every length
+		strings.Repeat("The rain\nfell on the roofs of the town\nand the sea\nwas grey.\n\n", 10),
+	}
+	for _, text := range tests {
+		if got := Dewrap(text); got != text {
+			t.Errorf("Dewrap(%q) = %q, want it unchanged", text, got)
+		}
+	}
+}
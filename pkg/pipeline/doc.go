@@ -0,0 +1,30 @@
+// Package pipeline is the downloader without its commands, for Go programs
+// that embed it: the download of the books a source finds, their
+// conversion and cleanup, the filters, and the manifest, catalog and sinks
+// of a dataset.
+//
+// A Crawler runs a whole crawl, like the scrape command:
+//
+//	// the sources register themselves, see pkg/smashwords
+//	factory, _ := source.Lookup("smashwords")
+//	newSource := factory(flag.NewFlagSet("smashwords", flag.ContinueOnError))
+//	src, err := newSource()
+//	crawler := &pipeline.Crawler{
+//		Source: src,
+//		Options: pipeline.Options{
+//			DataDir: "./data",
+//			Output:  pipeline.OutputJSONL,
+//			Convert: pipeline.DefaultConvertOptions(nil),
+//		},
+//	}
+//	err = crawler.Run(ctx)
+//
+// Run stops when ctx is done, and the next run picks up where it stopped.
+// Documents runs it the same way, and yields the books as they are written
+// to the dataset. The Callbacks of the Options are called as books are
+// found, downloaded, converted or fail, and OnBookDiscovered can leave
+// books out.
+// A Session gives the same steps one at a time, for programs that feed it
+// books of their own. Errors writing the data directory in the middle of a
+// book still stop the program, like they stop the commands.
+package pipeline
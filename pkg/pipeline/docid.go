@@ -0,0 +1,28 @@
+package pipeline
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/smashwords"
+)
+
+// DocumentID returns the id of the book: its stable id, or the name of its
+// file without the extension for books downloaded before there were ids.
+func (r *ManifestRecord) DocumentID() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return strings.TrimSuffix(r.File, filepath.Ext(r.File))
+}
+
+// assignDocumentID gives the record of a book downloaded before there were
+// ids its stable id, if the url of its book page has the book's id.
+func assignDocumentID(record *ManifestRecord) {
+	if record.ID != "" || record.Format == "" {
+		return
+	}
+	if bookID := smashwords.BookID(record.URL); bookID != "" {
+		record.ID = smashwords.DocumentID(bookID, record.Format)
+	}
+}
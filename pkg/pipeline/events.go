@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the types of events
+const (
+	EventBookDiscovered   string = "book_discovered"
+	EventDownloadStarted  string = "download_started"
+	EventDownloadComplete string = "download_complete"
+	EventConvertComplete  string = "convert_complete"
+	EventError            string = "error"
+)
+
+// Event is a line of the event stream of a run.
+type Event struct {
+	Type   string    `json:"event"`
+	Time   time.Time `json:"time"`
+	BookID string    `json:"book_id,omitempty"`
+	Title  string    `json:"title,omitempty"`
+	URL    string    `json:"url,omitempty"`
+	Format string    `json:"format,omitempty"`
+	File   string    `json:"file,omitempty"`
+	// the phase an error happened in, like LogPhaseDownload
+	Phase string `json:"phase,omitempty"`
+	Error string `json:"error,omitempty"`
+	// why a filter left a converted book out of the dataset
+	Rejected string `json:"rejected,omitempty"`
+	// the size of the text of a converted book
+	Chars int `json:"chars,omitempty"`
+}
+
+// Events writes the events of a run as NDJSON, a JSON object a line, for
+// orchestrators and dashboards to follow the run with. Its methods do
+// nothing on nil events, so they can be left out. If the other end goes
+// away the run goes on without events.
+type Events struct {
+	mu     sync.Mutex
+	w      io.WriteCloser
+	enc    *json.Encoder
+	failed bool
+}
+
+// OpenEvents opens the target of -events: fd:N for a file descriptor the
+// run inherited, like fd:3, or unix:PATH for a unix socket something
+// listens on. An empty target is no events.
+func OpenEvents(target string) (*Events, error) {
+	var w io.WriteCloser
+	switch {
+	case target == "":
+		return nil, nil
+	case strings.HasPrefix(target, "fd:"):
+		fd, err := strconv.Atoi(strings.TrimPrefix(target, "fd:"))
+		if err != nil || fd < 1 {
+			return nil, fmt.Errorf("invalid events file descriptor %s", target)
+		}
+		file := os.NewFile(uintptr(fd), target)
+		if _, err := file.Stat(); err != nil {
+			return nil, fmt.Errorf("invalid events file descriptor %s: %w", target, err)
+		}
+		w = file
+	case strings.HasPrefix(target, "unix:"):
+		conn, err := net.Dial("unix", strings.TrimPrefix(target, "unix:"))
+		if err != nil {
+			return nil, err
+		}
+		w = conn
+	default:
+		return nil, fmt.Errorf("unsupported events target %s, options are fd:N or unix:PATH", target)
+	}
+	return &Events{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// Emit writes an event, timed now.
+func (e *Events) Emit(event Event) {
+	if e == nil {
+		return
+	}
+	event.Time = time.Now().UTC()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failed {
+		return
+	}
+	if err := e.enc.Encode(event); err != nil {
+		slog.Warn("Could not write an event, leaving the rest out", "error", err)
+		e.failed = true
+	}
+}
+
+// Close closes the target of the events.
+func (e *Events) Close() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.w.Close()
+}
@@ -0,0 +1,68 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// CountWords counts the whitespace separated words of a text.
+func CountWords(text string) int {
+	return len(strings.Fields(text))
+}
+
+// RejectReason returns why a converted book doesn't belong in the dataset,
+// or "" if it does. The record has to be filled in with the measurements of
+// the book already.
+func RejectReason(record *ManifestRecord, opts ConvertOptions) string {
+	if !KeepLanguage(record.Language, opts.KeepLanguages) {
+		return fmt.Sprintf("language: detected %q", record.Language)
+	}
+	if opts.MinWords > 0 && record.Words < opts.MinWords {
+		return fmt.Sprintf("length: %d words below %d", record.Words, opts.MinWords)
+	}
+	if opts.MaxWords > 0 && record.Words > opts.MaxWords {
+		return fmt.Sprintf("length: %d words above %d", record.Words, opts.MaxWords)
+	}
+	if opts.MaxRepetition > 0 && record.Repetition > opts.MaxRepetition {
+		return fmt.Sprintf("repetition: %.2f above %.2f", record.Repetition, opts.MaxRepetition)
+	}
+	if opts.QualityFilter && record.Quality != nil {
+		if failure := record.Quality.Failure(); failure != "" {
+			return "quality: " + failure
+		}
+	}
+	if !KeepLicense(record.LicenseType, opts.Licenses) {
+		return "license: " + record.LicenseType
+	}
+	if opts.ExcludeHashes[record.SHA256] {
+		return "duplicate: in an excluded corpus"
+	}
+	return ""
+}
+
+// AcceptBook puts a converted book through the post-processing, which may
+// change its text, decides whether it goes in the dataset and adds its
+// record to the manifest. It returns why the book was rejected, or "" if it
+// wasn't. Duplicates are checked last, so a rejected book never keeps a copy
+// of itself out of the dataset. If ctx is done before the book is decided
+// its error is returned, and the manifest is left as it was.
+func AcceptBook(ctx context.Context, record *ManifestRecord, text *string, opts ConvertOptions, manifest *Manifest) (string, error) {
+	rejected, err := opts.PostProcessors.Apply(ctx, record, text, opts.Tokenizer)
+	if err != nil {
+		return "", err
+	}
+	record.Rejected = rejected
+	if record.Rejected == "" {
+		record.Rejected = RejectReason(record, opts)
+	}
+	if record.Rejected == "" && opts.Dedupe {
+		original := manifest.PutUnique(record)
+		if original == "" {
+			return "", nil
+		}
+		record.Rejected = "duplicate: " + original
+	}
+	manifest.Put(record)
+	return record.Rejected, nil
+}
@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	// front matter is only looked for in this many paragraphs at the start
+	maxFrontMatterParagraphs int = 80
+	// paragraphs shorter than this can be title page lines
+	shortParagraph int = 120
+	// headings are never longer than this
+	maxHeadingLength int = 80
+)
+
+// the numbers of chapters: digits, Roman numerals up to 399, and numbers and
+// ordinals up to ninety-nine in words
+const (
+	// the hundreds, or else the tens, or else the units can't be empty
+	headingRoman   = `c{1,3}(xc|xl|l?x{0,3})(ix|iv|v?i{0,3})|(xc|xl|l?x{1,3}|l)(ix|iv|v?i{0,3})|ix|iv|v?i{1,3}|v`
+	headingUnits   = `one|two|three|four|five|six|seven|eight|nine`
+	headingOrdinal = `first|second|third|fourth|fifth|sixth|seventh|eighth|ninth`
+	headingTeens   = `ten|eleven|twelve|thirteen|fourteen|fifteen|sixteen|seventeen|eighteen|nineteen|` +
+		`tenth|eleventh|twelfth|thirteenth|fourteenth|fifteenth|sixteenth|seventeenth|eighteenth|nineteenth`
+	headingTens = `twenty|thirty|forty|fifty|sixty|seventy|eighty|ninety|` +
+		`twentieth|thirtieth|fortieth|fiftieth|sixtieth|seventieth|eightieth|ninetieth`
+	headingWords = `(twenty|thirty|forty|fifty|sixty|seventy|eighty|ninety)[- ](` + headingUnits + `|` + headingOrdinal + `)|` +
+		headingTens + `|` + headingTeens + `|` + headingUnits + `|` + headingOrdinal
+	headingNumber = `[0-9]{1,4}|` + headingRoman + `|` + headingWords
+	// what may follow the number: nothing, or a title after a colon, period
+	// or dash
+	headingTitle = `(\s*[:.\-–—]\s*\S.*|\s*[:.\-–—]?)$`
+)
+
+// "Chapter 12", "PART TWO: The Return", "Prologue", "XIV". The whole
+// paragraph has to be the heading, so prose like "Part of me wanted to
+// scream." isn't one
+var chapterHeadingRegex = regexp.MustCompile(`(?i)` +
+	`^(chapter|ch\.|part|book)\s+(` + headingNumber + `)` + headingTitle +
+	`|^(prologue|epilogue|introduction|preface|foreword|afterword|interlude)(\s+(` + headingNumber + `))?` + headingTitle +
+	`|^([0-9]{1,3}|` + headingRoman + `|` + headingWords + `)\.?$`)
+
+// Passages that only show up on copyright pages, however long the
+// paragraph they are in.
+var frontMatterNotices = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bISBN\b`),
+	regexp.MustCompile(`(?i)\ball rights reserved\b`),
+	regexp.MustCompile(`(?i)\bno part of this (book|publication|work)\b`),
+	regexp.MustCompile(`(?i)\b(is|are) (a )?works? of fiction\b|\bany resemblance to (actual|real)\b`),
+}
+
+// Short paragraphs that only show up on title, copyright and dedication
+// pages. A long one starting like these ("By the time the sun rose…") is
+// prose.
+var frontMatterLines = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)\bcopyright\b|©|\(c\)\s*[0-9]{4}`),
+	regexp.MustCompile(`(?i)^(dedicat(ed|ion)|in memory of)\b`),
+	regexp.MustCompile(`(?i)^(by|written by|edited by)\s+\S`),
+	regexp.MustCompile(`(?i)^(cover (art|design|image|photo)|published by|first (edition|published|printing)|printed in|editor)\b`),
+	regexp.MustCompile(`(?i)^(table of )?contents\W*$`),
+}
+
+// IsChapterHeading reports whether a paragraph looks like a chapter heading
+// ("Chapter 12", "PART TWO", "Prologue", "XIV").
+func IsChapterHeading(paragraph string) bool {
+	paragraph = strings.TrimSpace(paragraph)
+	return len(paragraph) <= maxHeadingLength && chapterHeadingRegex.MatchString(paragraph)
+}
+
+// StripFrontMatter removes the title page, copyright page, dedication and
+// table of contents from the start of a book. The book is taken to start at
+// the first chapter heading that is followed by prose, or at the first
+// paragraph of prose. If neither shows up near the start, nothing is removed.
+func StripFrontMatter(text string) (string, []Removal) {
+	paragraphs := splitParagraphs(text)
+	limit := len(paragraphs)
+	if limit > maxFrontMatterParagraphs {
+		limit = maxFrontMatterParagraphs
+	}
+
+	start := -1
+	for i := 0; i < limit; i++ {
+		p := strings.TrimSpace(text[paragraphs[i][0]:paragraphs[i][1]])
+		if IsChapterHeading(p) {
+			// chapter headings in a table of contents are followed by more
+			// headings, not by the chapter
+			if i+1 < len(paragraphs) && isProse(strings.TrimSpace(text[paragraphs[i+1][0]:paragraphs[i+1][1]])) {
+				start = i
+				break
+			}
+			continue
+		}
+		if isFrontMatter(p) {
+			continue
+		}
+		if isProse(p) {
+			start = i
+			break
+		}
+	}
+	if start <= 0 {
+		return text, nil
+	}
+
+	var removed []Removal
+	for _, p := range paragraphs[:start] {
+		removed = append(removed, Removal{Stage: "front_matter", Text: strings.TrimSpace(text[p[0]:p[1]])})
+	}
+	return text[paragraphs[start][0]:], removed
+}
+
+func isFrontMatter(paragraph string) bool {
+	for _, r := range frontMatterNotices {
+		if r.MatchString(paragraph) {
+			return true
+		}
+	}
+	if len(paragraph) >= shortParagraph {
+		return false
+	}
+	for _, r := range frontMatterLines {
+		if r.MatchString(paragraph) {
+			return true
+		}
+	}
+	// title page lines are short and aren't sentences
+	return len(strings.Fields(paragraph)) <= 8 && !strings.ContainsAny(paragraph[len(paragraph)-1:], `.!?"”’'`)
+}
+
+// isProse reports whether a paragraph reads like the text of the book rather
+// than a title page line or a heading.
+func isProse(paragraph string) bool {
+	if IsChapterHeading(paragraph) {
+		return false
+	}
+	if len(paragraph) >= shortParagraph {
+		return true
+	}
+	return !isFrontMatter(paragraph) && len(strings.Fields(paragraph)) > 3
+}
+
+var paragraphBreakRegex = regexp.MustCompile(`\n[ \t]*\n\s*`)
+
+// splitParagraphs returns the start and end offsets of every paragraph of
+// text, paragraphs being separated by blank lines.
+func splitParagraphs(text string) [][2]int {
+	var paragraphs [][2]int
+	start := 0
+	for _, br := range paragraphBreakRegex.FindAllStringIndex(text, -1) {
+		if strings.TrimSpace(text[start:br[0]]) != "" {
+			paragraphs = append(paragraphs, [2]int{start, br[0]})
+		}
+		start = br[1]
+	}
+	if strings.TrimSpace(text[start:]) != "" {
+		paragraphs = append(paragraphs, [2]int{start, len(text)})
+	}
+	return paragraphs
+}
@@ -0,0 +1,117 @@
+package pipeline
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestIsChapterHeading(t *testing.T) {
+	tests := []struct {
+		paragraph string
+		want      bool
+	}{
+		{"Chapter 12", true},
+		{"CHAPTER TWELVE", true},
+		{"Chapter Twenty-One", true},
+		{"Chapter twenty one", true},
+		{"Chapter XIV", true},
+		{"Chapter 3: The Return", true},
+		{"Chapter One. A Storm", true},
+		{"Chapter 7 - Home", true},
+		{"Ch. 4", true},
+		{"Part Two", true},
+		{"PART III", true},
+		{"Book the First", false},
+		{"Book Third", true},
+		{"Prologue", true},
+		{"Epilogue: Ten Years Later", true},
+		{"Interlude 2", true},
+		{"Introduction", true},
+		{"XIV", true},
+		{"12.", true},
+		{"Seven", true},
+
+		{"Part of me wanted to scream.", false},
+		{"Book of the dead.", false},
+		{"Chapter and verse, he said.", false},
+		{"Introduction over, she sat down.", false},
+		{"Prologues are for other books.", false},
+		{"Part civil war, part romance.", false},
+		{"Book one of the series was better.", false},
+		{"Chapter", false},
+		{".", false},
+		{"2012", false},
+		{"Civil.", false},
+		{"Chapter 1: " + strings.Repeat("a very long title ", 5), false},
+	}
+	for _, tt := range tests {
+		if got := IsChapterHeading(tt.paragraph); got != tt.want {
+			t.Errorf("IsChapterHeading(%q) = %v, want %v", tt.paragraph, got, tt.want)
+		}
+	}
+}
+
+func TestStripFrontMatter(t *testing.T) {
+	prose := "It was the kind of morning that made the whole town want to stay in bed, and most of it did."
+	longBy := "By the time the sun rose over the hills, the whole valley had heard about the wedding, and" +
+		" not one of the neighbours had been invited to it."
+	tests := []struct {
+		name string
+		text string
+		// the paragraphs that are left, and how many were removed
+		want    []string
+		removed int
+	}{
+		{
+			name: "title and copyright pages",
+			text: "The Long Road\n\nby Jane Doe\n\nCopyright 2012 Jane Doe\n\n" +
+				"This is a work of fiction. Names, characters, places and incidents are the product of the" +
+				" author's imagination, and any resemblance to actual persons is coincidental.\n\n" +
+				"For my mother\n\nChapter 1\n\n" + prose,
+			want:    []string{"Chapter 1", prose},
+			removed: 5,
+		},
+		{
+			name:    "a table of contents is skipped to the chapter followed by prose",
+			text:    "Contents\n\nChapter 1\n\nChapter 2\n\nChapter 3\n\nChapter 1\n\n" + prose,
+			want:    []string{"Chapter 1", prose},
+			removed: 4,
+		},
+		{
+			name:    "a long paragraph starting with by is prose",
+			text:    longBy + "\n\n" + prose,
+			want:    []string{longBy, prose},
+			removed: 0,
+		},
+		{
+			name:    "a long paragraph mentioning copyright is prose",
+			text:    "The Long Road\n\nShe had never cared about copyright, or about anything her lawyer told her, until the day the letter came from New York.\n\n" + prose,
+			want:    []string{"She had never cared about copyright, or about anything her lawyer told her, until the day the letter came from New York.", prose},
+			removed: 1,
+		},
+		{
+			name:    "prose that starts like a heading",
+			text:    "The Long Road\n\nPart of me wanted to scream, and part of me wanted to laugh.\n\n" + prose,
+			want:    []string{"Part of me wanted to scream, and part of me wanted to laugh.", prose},
+			removed: 1,
+		},
+		{
+			name:    "a book that starts with its text",
+			text:    prose + "\n\n" + prose,
+			want:    []string{prose, prose},
+			removed: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed := StripFrontMatter(tt.text)
+			if !slices.Equal(paragraphs(got), tt.want) {
+				t.Errorf("got %q, want %q", paragraphs(got), tt.want)
+			}
+			if len(removed) != tt.removed {
+				t.Errorf("removed %d paragraphs, want %d: %q", len(removed), tt.removed, removed)
+			}
+		})
+	}
+}
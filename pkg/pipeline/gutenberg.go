@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+)
+
+// The header and the license Project Gutenberg wraps every book in. The
+// header ends at the START OF THE PROJECT GUTENBERG EBOOK line, or at the
+// small print of the oldest books, and the license starts at the END OF line.
+// Dewrap may have joined the lines around them, hence \s+ between the words.
+var (
+	gutenbergStartRegex = regexp.MustCompile(`(?is)\*{3}\s*START\s+OF\s+(THE|THIS)\s+PROJECT\s+GUTENBERG\s+E-?BOOK.{0,300}?\*{3}|\*END\*\s*THE\s+SMALL\s+PRINT!.{0,200}?\*END\*`)
+	gutenbergEndRegex   = regexp.MustCompile(`(?is)\*{3}\s*END\s+OF\s+(THE|THIS)\s+PROJECT\s+GUTENBERG\s+E-?BOOK|(^|\n)[ \t]*End\s+of\s+(the\s+)?Project\s+Gutenberg('?s)?\s`)
+)
+
+// StripGutenbergBoilerplate removes the Project Gutenberg header before the
+// book and the license after it.
+func StripGutenbergBoilerplate(text string) (string, []Removal) {
+	var removed []Removal
+	if loc := gutenbergStartRegex.FindStringIndex(text); loc != nil {
+		removed = append(removed, Removal{Stage: "gutenberg", Text: strings.TrimSpace(text[:loc[1]])})
+		text = text[loc[1]:]
+	}
+	if loc := gutenbergEndRegex.FindStringIndex(text); loc != nil {
+		removed = append(removed, Removal{Stage: "gutenberg", Text: strings.TrimSpace(text[loc[0]:])})
+		text = text[:loc[0]]
+	}
+	return text, removed
+}
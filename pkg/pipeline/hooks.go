@@ -0,0 +1,84 @@
+package pipeline
+
+import (
+	"cmp"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+// Hooks run the commands of -exec-per-book and -exec-end, to hand the
+// books of a run to other tools as they are written. Its methods do nothing
+// on nil hooks, so they can be left out. A command that fails is logged and
+// the run goes on.
+type Hooks struct {
+	// the command run for every book that makes it into the dataset, with
+	// {path}, {id} and {title} filled in
+	PerBook string
+	// the command run when the run ends, with {status} and {data_dir}
+	// filled in
+	End string
+
+	dataDir string
+	// where the dataset goes, to tell the path of a book by
+	store       storage.Writer
+	compression string
+}
+
+// NewHooks returns the hooks of a run that writes its dataset to store, with
+// the .txt files of the books compressed with compression.
+func NewHooks(perBook string, end string, dataDir string, store storage.Writer, compression string) *Hooks {
+	return &Hooks{PerBook: perBook, End: end, dataDir: dataDir, store: store, compression: compression}
+}
+
+// Book runs the per-book command for a book the sink wrote.
+func (h *Hooks) Book(record *ManifestRecord) {
+	if h == nil || h.PerBook == "" {
+		return
+	}
+	path := h.store.Location(record.File + CompressionExt(h.compression))
+	if dataset := cmp.Or(record.Dataset, record.shard); dataset != "" {
+		path = dataset
+		if !storage.IsURL(path) {
+			path = h.store.Location(path)
+		}
+	}
+	// epubs converted without a manifest record are known by their name
+	id := record.ID
+	if id == "" {
+		id = strings.TrimSuffix(record.File, ".txt")
+	}
+	h.run(h.PerBook, map[string]string{"path": path, "id": id, "title": record.Title}, "book_id", id)
+}
+
+// Finish runs the end of run command for a run that ended with a status
+// like NotifyCompleted.
+func (h *Hooks) Finish(status string) {
+	if h == nil || h.End == "" {
+		return
+	}
+	h.run(h.End, map[string]string{"status": status, "data_dir": h.dataDir}, "status", status)
+}
+
+// run runs a command through the shell with the placeholders of values
+// replaced by them, quoted so they stay one argument each.
+func (h *Hooks) run(command string, values map[string]string, logArgs ...any) {
+	replacements := make([]string, 0, 2*len(values))
+	for name, value := range values {
+		replacements = append(replacements, "{"+name+"}", shellQuote(value))
+	}
+	cmd := exec.Command("/bin/sh", "-c", strings.NewReplacer(replacements...).Replace(command))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		slog.Warn("The hook command failed", append(logArgs, "command", command, "error", err)...)
+	}
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,19 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// CheckResponse turns an unsuccessful response into an error with the
+// message the Hub sent along.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status,
+		strings.TrimSpace(string(message)))
+}
@@ -0,0 +1,181 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const JournalFileName string = "journal.jsonl"
+
+// the changes to the dataset a journal entry can record
+const (
+	JournalAdd    string = "add"
+	JournalRemove string = "remove"
+	// a book already in the dataset whose text changed, because it was
+	// downloaded or converted again
+	JournalRedownload string = "redownload"
+)
+
+// JournalEntry is a line of journal.jsonl: a book that went in or out of the
+// dataset in a version.
+type JournalEntry struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Op      string    `json:"op"`
+	// stable id of the book, see smashwords.DocumentID
+	ID     string `json:"id"`
+	File   string `json:"file"`
+	SHA256 string `json:"sha256,omitempty"`
+	// why a book was removed, like the reason it was rejected
+	Reason string `json:"reason,omitempty"`
+}
+
+// Journal is the append-only history of the dataset in journal.jsonl. Every
+// run that changes which books are in the dataset appends what changed as a
+// new version, so it can be told what moved when a corpus is regenerated.
+type Journal struct {
+	path    string
+	Entries []JournalEntry
+	// the books in the dataset as of the last entry, by id
+	books map[string]JournalEntry
+	// version the entries of this run go in, 0 until the first
+	version int
+}
+
+// LoadJournal reads the journal of a data directory, or starts an empty one
+// if there is none yet.
+func LoadJournal(dataDir string) (*Journal, error) {
+	j := &Journal{
+		path:  filepath.Join(dataDir, JournalFileName),
+		books: make(map[string]JournalEntry),
+	}
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return j, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, err
+		}
+		j.Entries = append(j.Entries, entry)
+		applyJournalEntry(j.books, entry)
+	}
+	return j, scanner.Err()
+}
+
+// applyJournalEntry updates the books in the dataset with an entry.
+func applyJournalEntry(books map[string]JournalEntry, entry JournalEntry) {
+	if entry.Op == JournalRemove {
+		delete(books, entry.ID)
+	} else {
+		books[entry.ID] = entry
+	}
+}
+
+// Latest returns the number of the last version, 0 if there is none.
+func (j *Journal) Latest() int {
+	if len(j.Entries) == 0 {
+		return 0
+	}
+	return j.Entries[len(j.Entries)-1].Version
+}
+
+// Books returns the books in the dataset as of a version, by id.
+func (j *Journal) Books(version int) map[string]JournalEntry {
+	books := make(map[string]JournalEntry)
+	for _, entry := range j.Entries {
+		if entry.Version > version {
+			break
+		}
+		applyJournalEntry(books, entry)
+	}
+	return books
+}
+
+// Record appends the changes between the books in the dataset as of the
+// last entry and the records of the manifest to the journal. The changes of
+// one run all go in the same new version.
+func (j *Journal) Record(records []*ManifestRecord) error {
+	// the books in the dataset now, and why the others were left out
+	current := make(map[string]*ManifestRecord)
+	reasons := make(map[string]string)
+	for _, record := range records {
+		id := record.DocumentID()
+		if BookStatus(record) == StatusConverted {
+			if _, ok := current[id]; !ok {
+				current[id] = record
+			}
+		} else if record.Rejected != "" {
+			reasons[id] = record.Rejected
+		}
+	}
+
+	now := time.Now().UTC()
+	var changes []JournalEntry
+	for id, record := range current {
+		entry := JournalEntry{Time: now, ID: id, File: record.File, SHA256: record.SHA256}
+		if old, ok := j.books[id]; !ok {
+			entry.Op = JournalAdd
+		} else if old.SHA256 != record.SHA256 {
+			entry.Op = JournalRedownload
+		} else {
+			continue
+		}
+		changes = append(changes, entry)
+	}
+	for id, old := range j.books {
+		if _, ok := current[id]; !ok {
+			reason := reasons[id]
+			if reason == "" {
+				reason = "no longer in the manifest"
+			}
+			changes = append(changes, JournalEntry{Time: now, Op: JournalRemove, ID: id, File: old.File, Reason: reason})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	sort.Slice(changes, func(i, k int) bool { return changes[i].ID < changes[k].ID })
+	if j.version == 0 {
+		j.version = j.Latest() + 1
+	}
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, entry := range changes {
+		entry.Version = j.version
+		if err := encoder.Encode(entry); err != nil {
+			f.Close()
+			return err
+		}
+		j.Entries = append(j.Entries, entry)
+		applyJournalEntry(j.books, entry)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,87 @@
+package pipeline
+
+import (
+	"strings"
+
+	"github.com/abadojack/whatlanggo"
+)
+
+const (
+	// the language is detected on this many samples spread over the book,
+	// so a foreign title page or quotation can't decide it
+	languageSamples int = 5
+	// length in bytes of every sample
+	languageSampleLength int = 2000
+)
+
+// DetectLanguage returns the ISO 639-1 code of the language a book is written
+// in, or "" if it could not be told reliably.
+func DetectLanguage(text string) string {
+	votes := make(map[string]int)
+	best := ""
+	for _, sample := range languageSamplesOf(text) {
+		info := whatlanggo.Detect(sample)
+		if !info.IsReliable() {
+			continue
+		}
+		lang := info.Lang.Iso6391()
+		if lang == "" {
+			continue
+		}
+		votes[lang]++
+		if votes[lang] > votes[best] {
+			best = lang
+		}
+	}
+	return best
+}
+
+// languageSamplesOf cuts samples out of the text at evenly spaced offsets,
+// starting and ending them at spaces.
+func languageSamplesOf(text string) []string {
+	if len(text) <= languageSampleLength*languageSamples {
+		return []string{text}
+	}
+
+	var samples []string
+	step := len(text) / languageSamples
+	for i := 0; i < languageSamples; i++ {
+		sample := text[i*step : i*step+languageSampleLength]
+		if i > 0 {
+			if space := strings.IndexByte(sample, ' '); space >= 0 {
+				sample = sample[space+1:]
+			}
+		}
+		if space := strings.LastIndexByte(sample, ' '); space >= 0 {
+			sample = sample[:space]
+		}
+		samples = append(samples, strings.ToValidUTF8(sample, ""))
+	}
+	return samples
+}
+
+// ParseLanguages splits a comma separated list of language codes.
+func ParseLanguages(list string) []string {
+	var languages []string
+	for _, lang := range strings.Split(list, ",") {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if lang != "" {
+			languages = append(languages, lang)
+		}
+	}
+	return languages
+}
+
+// KeepLanguage reports whether a book in lang belongs in the dataset. Every
+// book is kept if no languages were asked for.
+func KeepLanguage(lang string, keep []string) bool {
+	if len(keep) == 0 {
+		return true
+	}
+	for _, k := range keep {
+		if k == lang {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+)
+
+// the kinds of license a book can be under
+const (
+	LicenseCC0          string = "cc0"
+	LicensePublicDomain string = "public-domain"
+	// Creative Commons licenses are cc-by, cc-by-sa, cc-by-nc-nd and so on
+	LicenseCCPrefix string = "cc-"
+	// the Smashwords default, "licensed for your personal enjoyment only",
+	// is all rights reserved too
+	LicenseAllRightsReserved string = "all-rights-reserved"
+	LicenseUnknown           string = "unknown"
+
+	// the license statement is looked for in this many bytes at the start
+	// of the text, where the copyright page is
+	licenseTextLength int = 10000
+)
+
+var (
+	ccURLRegex        = regexp.MustCompile(`creativecommons\.org/licenses/(by(-nc)?(-sa|-nd)?)\b`)
+	ccShortRegex      = regexp.MustCompile(`\bcc[ -]by((?:[ -]nc)?(?:[ -](?:sa|nd))?)\b`)
+	ccNameRegex       = regexp.MustCompile(`creative\s+commons\s+attribution\b([a-z\s,-]*)`)
+	cc0Regex          = regexp.MustCompile(`\bcc0\b|creativecommons\.org/publicdomain/zero|creative\s+commons\s+zero`)
+	publicDomainRegex = regexp.MustCompile(`\b(in|into|to) the public domain\b|\bpublic domain (work|book|dedication)\b|creativecommons\.org/publicdomain/mark`)
+	allRightsRegex    = regexp.MustCompile(`\ball rights reserved\b|\bpersonal enjoyment only\b|\bcopyright\b|©|\(c\)\s*[0-9]{4}`)
+)
+
+// ClassifyLicense tells what license a book is under from its license
+// statements: the license notes on its book page, the rights in its epub
+// and its text, of which only the copyright page at the start is looked at.
+// An open license anywhere wins over a plain copyright notice, which open
+// licensed books have too.
+func ClassifyLicense(statements ...string) string {
+	lowered := make([]string, len(statements))
+	for i, statement := range statements {
+		if len(statement) > licenseTextLength {
+			statement = statement[:licenseTextLength]
+		}
+		lowered[i] = strings.ToLower(statement)
+	}
+	for _, s := range lowered {
+		if license := openLicense(s); license != "" {
+			return license
+		}
+	}
+	for _, s := range lowered {
+		if allRightsRegex.MatchString(s) {
+			return LicenseAllRightsReserved
+		}
+	}
+	return LicenseUnknown
+}
+
+// openLicense returns the Creative Commons or public domain license in a
+// lowercased statement, or "".
+func openLicense(s string) string {
+	if cc0Regex.MatchString(s) {
+		return LicenseCC0
+	}
+	if m := ccURLRegex.FindStringSubmatch(s); m != nil {
+		return LicenseCCPrefix + m[1]
+	}
+	if m := ccShortRegex.FindStringSubmatch(s); m != nil {
+		return LicenseCCPrefix + "by" + strings.ReplaceAll(m[1], " ", "-")
+	}
+	if m := ccNameRegex.FindStringSubmatch(s); m != nil {
+		license := LicenseCCPrefix + "by"
+		terms := strings.NewReplacer("-", "", " ", "").Replace(m[1])
+		if strings.Contains(terms, "noncommercial") {
+			license += "-nc"
+		}
+		if strings.Contains(terms, "sharealike") {
+			license += "-sa"
+		} else if strings.Contains(terms, "noderiv") {
+			license += "-nd"
+		}
+		return license
+	}
+	if publicDomainRegex.MatchString(s) {
+		return LicensePublicDomain
+	}
+	return ""
+}
+
+// ParseLicenses splits a comma separated list of license kinds.
+func ParseLicenses(list string) []string {
+	var licenses []string
+	for _, license := range strings.Split(list, ",") {
+		license = strings.ToLower(strings.TrimSpace(license))
+		if license != "" {
+			licenses = append(licenses, license)
+		}
+	}
+	return licenses
+}
+
+// KeepLicense reports whether a book under license belongs in the dataset.
+// A kind also takes the licenses that narrow it down, so cc-by takes
+// cc-by-sa, and cc takes every Creative Commons license including cc0.
+// Every book is kept if no licenses were asked for.
+func KeepLicense(license string, keep []string) bool {
+	if len(keep) == 0 {
+		return true
+	}
+	for _, k := range keep {
+		if license == k || strings.HasPrefix(license, k+"-") || (k == "cc" && license == LicenseCC0) {
+			return true
+		}
+	}
+	return false
+}
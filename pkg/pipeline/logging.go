@@ -0,0 +1,48 @@
+package pipeline
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// the phases of a crawl in the phase field of its log lines
+const (
+	LogPhaseList     string = "list"
+	LogPhaseDownload string = "download"
+	LogPhaseConvert  string = "convert"
+	LogPhaseFilter   string = "filter"
+)
+
+// logOutput is where the log lines go: stderr, or above the progress bars
+// while they are shown.
+type logOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (o *logOutput) Write(b []byte) (int, error) {
+	o.mu.Lock()
+	w := o.w
+	o.mu.Unlock()
+	return w.Write(b)
+}
+
+var LogOut = &logOutput{w: os.Stderr}
+
+// setLogOutput sends the log lines to w.
+func setLogOutput(w io.Writer) {
+	LogOut.mu.Lock()
+	defer LogOut.mu.Unlock()
+	LogOut.w = w
+}
+
+// BookLogger returns the logger of the log lines about a book, with its id,
+// its url if it is known and the phase of the crawl as fields.
+func BookLogger(id string, url string, phase string) *slog.Logger {
+	if url == "" {
+		return slog.With("book_id", id, "phase", phase)
+	}
+	return slog.With("book_id", id, "url", url, "phase", phase)
+}
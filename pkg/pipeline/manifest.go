@@ -0,0 +1,440 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ManifestFileName string = "manifest.jsonl"
+	RemovedFileName  string = "removed.jsonl"
+)
+
+// ManifestRecord describes one book in the data directory.
+type ManifestRecord struct {
+	// name of the text file in the data directory
+	File string `json:"file"`
+	// stable id of the book, see smashwords.DocumentID
+	ID string `json:"id,omitempty"`
+	// name of the file the text was converted from, if any
+	Source string `json:"source,omitempty"`
+	Format string `json:"format"`
+	// what the book page says about the book
+	BookInfo
+	// when the book was downloaded
+	DownloadedAt time.Time `json:"downloaded_at"`
+	// the build of the tool and the run that got the book, and the url it
+	// was downloaded from
+	Provenance *Provenance `json:"provenance,omitempty"`
+	// what the package document of the epub says about the book, see
+	// OPFMetadata
+	DeclaredLanguage string            `json:"declared_language,omitempty"`
+	Subjects         []string          `json:"subjects,omitempty"`
+	Publisher        string            `json:"publisher,omitempty"`
+	Identifiers      map[string]string `json:"identifiers,omitempty"`
+	// what Open Library knows about the book, with -openlibrary
+	OpenLibrary *OpenLibraryInfo `json:"openlibrary,omitempty"`
+	// the rights statement in the epub
+	Rights string `json:"rights,omitempty"`
+	// kind of license the book is under, see ClassifyLicense
+	LicenseType string `json:"license_type,omitempty"`
+	// the JSONL file the book was written to, if not a file of its own
+	Dataset string `json:"dataset,omitempty"`
+	Chars   int    `json:"chars"`
+	Words   int    `json:"words,omitempty"`
+	// approximate number of tokens, and the tokenizer that counted them
+	Tokens    int    `json:"tokens,omitempty"`
+	Tokenizer string `json:"tokenizer,omitempty"`
+	// hash of the text, see ContentHash
+	SHA256 string `json:"sha256,omitempty"`
+
+	// encoding of the downloaded text, and why it could not be decoded
+	Encoding      string `json:"encoding,omitempty"`
+	EncodingError string `json:"encoding_error,omitempty"`
+
+	// how the text was cleaned up
+	Unicode     string `json:"unicode,omitempty"`
+	Punctuation string `json:"punctuation,omitempty"`
+	Whitespace  string `json:"whitespace,omitempty"`
+	// number of pieces of text removed by each cleaning stage
+	Removed map[string]int `json:"removed,omitempty"`
+	// number of chapter headings that were marked
+	Chapters int `json:"chapters,omitempty"`
+	// number of email addresses, phone numbers and URLs that were masked
+	PIIMasked int `json:"pii_masked,omitempty"`
+	// ISO 639-1 code of the language the book is written in
+	Language string `json:"language,omitempty"`
+	// heuristics junk documents are filtered on
+	Quality *QualityMetrics `json:"quality,omitempty"`
+	// share of the text in repeated lines or paragraphs
+	Repetition float64 `json:"repetition"`
+
+	// why the book was left out of the dataset, empty if it wasn't. Rejected
+	// books are not downloaded again.
+	Rejected string `json:"rejected,omitempty"`
+
+	ConvertedAt time.Time `json:"converted_at"`
+
+	// the shard the book is being written to, until it is closed and
+	// becomes the Dataset
+	shard string
+}
+
+// Manifest keeps a record of every book in the data directory in
+// manifest.jsonl, one JSON object per line.
+type Manifest struct {
+	path    string
+	mu      sync.Mutex
+	records map[string]*ManifestRecord
+	// content hash of every book in the dataset to the file it is in
+	hashes map[string]string
+
+	// text removed by the cleaning stages is logged to removed.jsonl so
+	// the heuristics can be audited
+	removedPath string
+	removedMu   sync.Mutex
+
+	// the catalog kept in sync with the manifest, if any
+	catalog *Catalog
+	// the journal the changes to the dataset are appended to, if any
+	journal *Journal
+	// the books that failed since the manifest was loaded
+	failures int
+
+	// the names of the books being downloaded, claimed by Claim
+	claims map[string]bool
+	// the files of the books in the data directory by name, for the books
+	// without a record. Read once, by the first Claim
+	files     map[string]string
+	filesOnce sync.Once
+}
+
+// removedRecord is a line of removed.jsonl.
+type removedRecord struct {
+	File string `json:"file"`
+	Removal
+}
+
+// LoadManifest reads the manifest of a data directory, or starts an empty one
+// if there is none yet.
+func LoadManifest(dataDir string) (*Manifest, error) {
+	m := &Manifest{
+		path:        filepath.Join(dataDir, ManifestFileName),
+		records:     make(map[string]*ManifestRecord),
+		hashes:      make(map[string]string),
+		removedPath: filepath.Join(dataDir, RemovedFileName),
+	}
+
+	f, err := os.Open(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := &ManifestRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return nil, err
+		}
+		assignDocumentID(record)
+		m.put(record)
+	}
+	return m, scanner.Err()
+}
+
+// Put adds or replaces the record of a book.
+func (m *Manifest) Put(record *ManifestRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.put(record)
+}
+
+// PutUnique adds the record of a book unless another book in the dataset has
+// the same content hash. In that case it adds nothing and returns the file of
+// the other book.
+func (m *Manifest) PutUnique(record *ManifestRecord) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if original := m.duplicateOf(record); original != "" {
+		return original
+	}
+	m.put(record)
+	return ""
+}
+
+func (m *Manifest) put(record *ManifestRecord) {
+	m.records[record.File] = record
+	if record.SHA256 != "" && record.Rejected == "" && m.duplicateOf(record) == "" {
+		m.hashes[record.SHA256] = record.File
+	}
+}
+
+// duplicateOf returns the file of another book in the dataset with the same
+// content hash as record, or "".
+func (m *Manifest) duplicateOf(record *ManifestRecord) string {
+	file, ok := m.hashes[record.SHA256]
+	if !ok || file == record.File {
+		return ""
+	}
+	// the other book may have been replaced or rejected since
+	other := m.records[file]
+	if other == nil || other.Rejected != "" || other.SHA256 != record.SHA256 {
+		return ""
+	}
+	return file
+}
+
+// Get returns the record of a book, or nil if it has none.
+func (m *Manifest) Get(file string) *ManifestRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.records[file]
+}
+
+// Delete removes the record of a book.
+func (m *Manifest) Delete(file string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if record := m.records[file]; record != nil && m.hashes[record.SHA256] == file {
+		delete(m.hashes, record.SHA256)
+	}
+	delete(m.records, file)
+}
+
+// Claim looks up a book before it is downloaded, by the names it may be in
+// the data directory under: its id and aliases. If the data directory has
+// it already, or another download claimed it, Claim returns the file of the
+// book and why it is skipped. Otherwise it claims the names until Release
+// and returns "". The books are looked up in the records of the manifest,
+// and in the files the data directory had without a record, read once, so
+// nothing is looked up on disk for every book and the same book is never
+// downloaded twice at once.
+func (m *Manifest) Claim(names []string) (file string, reason string) {
+	m.filesOnce.Do(m.readFiles)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		if file, reason := m.lookup(name); reason != "" {
+			return file, reason
+		}
+	}
+	if m.claims == nil {
+		m.claims = make(map[string]bool)
+	}
+	for _, name := range names {
+		m.claims[name] = true
+	}
+	return "", ""
+}
+
+// Release gives up the names Claim claimed, once the book is downloaded
+// and its record put, or it failed.
+func (m *Manifest) Release(names []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range names {
+		delete(m.claims, name)
+	}
+}
+
+// lookup returns the file the data directory has of the book name and why
+// it is skipped, or "" if it has none.
+func (m *Manifest) lookup(name string) (string, string) {
+	text := name + ".txt"
+	if m.claims[name] {
+		return text, "downloading"
+	}
+	if record := m.records[text]; record != nil {
+		switch {
+		case record.Rejected != "":
+			return text, "rejected before: " + record.Rejected
+		case record.Dataset != "":
+			return text, "in dataset: " + record.Dataset
+		case BookStatus(record) == StatusDownloaded:
+			return record.Source, "exists: " + record.Format
+		}
+		return text, "exists: txt"
+	}
+	if m.records[text+UndecodableSuffix] != nil {
+		return text + UndecodableSuffix, "undecodable: downloaded before"
+	}
+	file := m.files[name]
+	switch {
+	case file == "":
+		return "", ""
+	case strings.HasSuffix(file, UndecodableSuffix):
+		return file, "undecodable: downloaded before"
+	case strings.HasSuffix(file, ".epub"):
+		return file, "exists: epub"
+	}
+	return file, "exists: txt"
+}
+
+// readFiles reads the files of the books in the data directory, for
+// lookup to find the books without a record, like the ones of runs from
+// before there was a manifest. An epub is taken over the text of a book.
+func (m *Manifest) readFiles() {
+	m.files = make(map[string]string)
+	entries, err := os.ReadDir(filepath.Dir(m.path))
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("Could not read the books of the data directory", "error", err)
+	}
+	for _, entry := range entries {
+		file := entry.Name()
+		var name string
+		switch {
+		case entry.IsDir():
+			continue
+		case strings.HasSuffix(file, ".epub"):
+			name = strings.TrimSuffix(file, ".epub")
+		case strings.HasSuffix(file, ".txt"+UndecodableSuffix):
+			name = strings.TrimSuffix(file, ".txt"+UndecodableSuffix)
+		case IsTextFile(file):
+			name = strings.TrimSuffix(TrimCompressionExt(file), ".txt")
+		default:
+			continue
+		}
+		if m.files[name] == "" || strings.HasSuffix(file, ".epub") {
+			m.files[name] = file
+		}
+	}
+}
+
+// Records returns the records of every book, sorted by file name.
+func (m *Manifest) Records() []*ManifestRecord {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	records := make([]*ManifestRecord, 0, len(m.records))
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].File < records[j].File })
+	return records
+}
+
+// UseCatalog keeps a catalog in sync with the manifest whenever it is saved,
+// and logs errors to it.
+func (m *Manifest) UseCatalog(catalog *Catalog) {
+	m.catalog = catalog
+}
+
+// UseJournal appends the changes to the dataset to a journal whenever the
+// manifest is saved.
+func (m *Manifest) UseJournal(journal *Journal) {
+	m.journal = journal
+}
+
+// LogError records a book that failed at a stage in the catalog, if there
+// is one.
+func (m *Manifest) LogError(file string, stage string, message string) error {
+	m.mu.Lock()
+	m.failures++
+	m.mu.Unlock()
+	if m.catalog == nil {
+		return nil
+	}
+	return m.catalog.LogError(file, stage, message)
+}
+
+// Failures is the number of books LogError recorded since the manifest was
+// loaded, the failures of the run.
+func (m *Manifest) Failures() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.failures
+}
+
+// Save writes the manifest back to the data directory, and the catalog and
+// journal if there are any. It writes to a temporary file first so a crash
+// never leaves a half written manifest.
+func (m *Manifest) Save() error {
+	if err := m.save(); err != nil {
+		return err
+	}
+	if m.catalog != nil {
+		if err := m.catalog.Sync(m.Records()); err != nil {
+			return err
+		}
+	}
+	if m.journal != nil {
+		return m.journal.Record(m.Records())
+	}
+	return nil
+}
+
+func (m *Manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]string, 0, len(m.records))
+	for file := range m.records {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		return err
+	}
+	tmpPath := m.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, file := range files {
+		if err := encoder.Encode(m.records[file]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.path)
+}
+
+// LogRemovals appends the text the cleaning stages took out of a book to
+// removed.jsonl.
+func (m *Manifest) LogRemovals(file string, removed []Removal) error {
+	if len(removed) == 0 {
+		return nil
+	}
+	m.removedMu.Lock()
+	defer m.removedMu.Unlock()
+
+	f, err := os.OpenFile(m.removedPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, r := range removed {
+		if err := encoder.Encode(removedRecord{File: file, Removal: r}); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
@@ -0,0 +1,201 @@
+package pipeline
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// the upper bounds of the buckets of the conversion durations, in seconds
+var conversionBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Metrics counts what a run does for Prometheus to scrape at /metrics: the
+// HTTP requests and bytes downloaded, the downloads by outcome, how long
+// conversions take and the quota of downloads left. Its methods do nothing
+// on nil metrics, so they can be left out.
+type Metrics struct {
+	registry *prometheus.Registry
+	// HTTP requests by status code, "error" when there was no response
+	requests    *prometheus.CounterVec
+	bytes       prometheus.Counter
+	downloads   *prometheus.CounterVec
+	conversions *prometheus.HistogramVec
+
+	mu sync.Mutex
+	// the daily quota of epub downloads of the source, 0 if it has none, and
+	// the epubs downloaded against it
+	quota int
+	epubs int
+
+	server *http.Server
+}
+
+// NewMetrics makes metrics registered with a registry of their own.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smashdl_http_requests_total",
+			Help: "HTTP requests by status code.",
+		}, []string{"code"}),
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "smashdl_downloaded_bytes_total",
+			Help: "Bytes of HTTP responses read.",
+		}),
+		downloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "smashdl_downloads_total",
+			Help: "Book downloads by outcome and format.",
+		}, []string{"status", "format"}),
+		conversions: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "smashdl_conversion_duration_seconds",
+			Help:    "How long converting a book took.",
+			Buckets: conversionBuckets,
+		}, []string{"format"}),
+	}
+	m.registry.MustRegister(m.requests, m.bytes, m.downloads, m.conversions)
+	return m
+}
+
+// StartMetrics serves the metrics of the run at http://addr/metrics, and
+// counts every request made with the default HTTP transport. An empty addr
+// is no metrics.
+func StartMetrics(addr string) (*Metrics, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	m := NewMetrics()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	m.server = &http.Server{Handler: mux}
+	go func() {
+		if err := m.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			slog.Error("Could not serve the metrics", "error", err)
+		}
+	}()
+	http.DefaultTransport = &metricsTransport{base: http.DefaultTransport, metrics: m}
+	slog.Info("Serving metrics", "url", "http://"+listener.Addr().String()+"/metrics")
+	return m, nil
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// SetQuota sets the daily quota of epub downloads of the source, which the
+// epub downloads of the run count against. The quota left is only exported
+// once there is one.
+func (m *Metrics) SetQuota(quota int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	first := m.quota == 0 && quota > 0
+	m.quota = quota
+	m.mu.Unlock()
+	if first {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "smashdl_quota_remaining",
+			Help: "Epub downloads left of the daily quota of the source.",
+		}, m.quotaRemaining))
+	}
+}
+
+// quotaRemaining is the epub downloads left of the quota.
+func (m *Metrics) quotaRemaining() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return float64(max(m.quota-m.epubs, 0))
+}
+
+// Request counts an HTTP request by the status code of its response, or as
+// an error without one.
+func (m *Metrics) Request(status int, err error) {
+	if m == nil {
+		return
+	}
+	code := strconv.Itoa(status)
+	if err != nil {
+		code = "error"
+	}
+	m.requests.WithLabelValues(code).Inc()
+}
+
+// Downloaded counts bytes of responses read.
+func (m *Metrics) Downloaded(bytes int) {
+	if m == nil {
+		return
+	}
+	m.bytes.Add(float64(bytes))
+}
+
+// Download counts a book by the outcome of its download, like
+// OutcomeSucceeded.
+func (m *Metrics) Download(outcome string, format string) {
+	if m == nil {
+		return
+	}
+	m.downloads.WithLabelValues(outcome, format).Inc()
+	if outcome == OutcomeSucceeded && format == "epub" {
+		m.mu.Lock()
+		m.epubs++
+		m.mu.Unlock()
+	}
+}
+
+// Converted records how long the conversion of a book in a format took.
+func (m *Metrics) Converted(format string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.conversions.WithLabelValues(format).Observe(duration.Seconds())
+}
+
+// Close stops serving the metrics.
+func (m *Metrics) Close() error {
+	if m == nil || m.server == nil {
+		return nil
+	}
+	return m.server.Close()
+}
+
+// metricsTransport counts the requests made through it and the bytes of
+// their responses.
+type metricsTransport struct {
+	base    http.RoundTripper
+	metrics *Metrics
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.metrics.Request(0, err)
+		return resp, err
+	}
+	t.metrics.Request(resp.StatusCode, nil)
+	resp.Body = &countingBody{ReadCloser: resp.Body, metrics: t.metrics}
+	return resp, nil
+}
+
+// countingBody counts the bytes read of a response.
+type countingBody struct {
+	io.ReadCloser
+	metrics *Metrics
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.metrics.Downloaded(n)
+	return n, err
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.Request(200, nil)
+	m.Request(200, nil)
+	m.Request(0, errors.New("connection refused"))
+	m.Downloaded(1024)
+	m.Download(OutcomeSucceeded, "epub")
+	m.Download(OutcomeSucceeded, "epub")
+	m.Download(OutcomeSucceeded, "txt")
+	m.Converted("epub", 300*time.Millisecond)
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		m.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+	body := scrape()
+	for _, line := range []string{
+		`smashdl_http_requests_total{code="200"} 2`,
+		`smashdl_http_requests_total{code="error"} 1`,
+		`smashdl_downloaded_bytes_total 1024`,
+		`smashdl_downloads_total{format="epub",status="succeeded"} 2`,
+		`smashdl_conversion_duration_seconds_bucket{format="epub",le="0.25"} 0`,
+		`smashdl_conversion_duration_seconds_bucket{format="epub",le="0.5"} 1`,
+		`smashdl_conversion_duration_seconds_count{format="epub"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("the metrics have no %s:\n%s", line, body)
+		}
+	}
+	// there is no quota until one is set
+	if strings.Contains(body, "smashdl_quota_remaining") {
+		t.Errorf("the metrics have a quota without one set:\n%s", body)
+	}
+	m.SetQuota(500)
+	m.SetQuota(3)
+	if body := scrape(); !strings.Contains(body, "smashdl_quota_remaining 1\n") {
+		t.Errorf("the metrics have the wrong quota left:\n%s", body)
+	}
+	m.Download(OutcomeSucceeded, "epub")
+	m.Download(OutcomeSucceeded, "epub")
+	if body := scrape(); !strings.Contains(body, "smashdl_quota_remaining 0\n") {
+		t.Errorf("the quota left is not floored at 0:\n%s", body)
+	}
+
+	// nil metrics do nothing
+	var none *Metrics
+	none.Request(200, nil)
+	none.Download(OutcomeSucceeded, "epub")
+	if err := none.Close(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,131 @@
+package pipeline
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	UnicodeNone string = "none"
+	UnicodeNFC  string = "nfc"
+	UnicodeNFKC string = "nfkc"
+)
+
+// NormalizeUnicode puts text in the given Unicode normalization form. Epubs
+// mix composed and decomposed characters (and compatibility characters like
+// ligatures and full-width letters), which trips up tokenizers and dedup.
+func NormalizeUnicode(text string, form string) string {
+	switch form {
+	case UnicodeNFC:
+		return norm.NFC.String(text)
+	case UnicodeNFKC:
+		return norm.NFKC.String(text)
+	}
+	return text
+}
+
+var (
+	newlineReplacer = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+	// only well formed entities, so things like "AT&T" are left alone
+	entityRegex = regexp.MustCompile(`&(#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+
+	spaceReplacer = strings.NewReplacer(
+		"\u00a0", " ", // non-breaking space
+		"\u00ad", "", // soft hyphen
+	)
+)
+
+// NormalizeEntities decodes the html entities of a plain text download,
+// which some are exported with. Entities are decoded once, so an escaped one
+// ("&amp;nbsp;") is left as the entity it spells. The text of epubs is
+// already unescaped by the html parser, and its entities are the book's.
+func NormalizeEntities(text string) string {
+	if strings.Contains(text, "&") {
+		text = entityRegex.ReplaceAllStringFunc(text, html.UnescapeString)
+	}
+	return normalizeSpaces(text)
+}
+
+// normalizeSpaces turns non-breaking spaces into regular spaces and removes
+// soft hyphens.
+func normalizeSpaces(text string) string {
+	return spaceReplacer.Replace(text)
+}
+
+// NormalizeControl turns CRLF and CR line endings into LF, and strips byte
+// order marks and C0/C1 control characters other than newlines and tabs.
+func NormalizeControl(text string) string {
+	text = newlineReplacer.Replace(text)
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\t':
+			return r
+		case r < 0x20, r >= 0x7f && r <= 0x9f, r == '\ufeff':
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// CleanReport describes what CleanText did to a book.
+type CleanReport struct {
+	// pieces of text that were taken out
+	Removed []Removal
+	// number of chapter headings found, when marking chapters
+	Chapters int
+	// number of email addresses, phone numbers and URLs masked
+	PIIMasked int
+}
+
+// CleanText runs the post-conversion passes over the text of a whole book.
+// It is also used on plain text downloads, so every book in the data
+// directory gets the same cleanup. headings are the keys of the paragraphs
+// that came from heading tags, or nil for plain text downloads.
+func CleanText(text string, opts ConvertOptions, headings map[string]bool) (string, CleanReport) {
+	var report CleanReport
+	text = NormalizeControl(text)
+	text = normalizeSpaces(text)
+	text = NormalizeUnicode(text, opts.Unicode)
+	text = NormalizePunctuation(text, opts.Punctuation)
+	if opts.StripBoilerplate {
+		var r []Removal
+		text, r = StripGutenbergBoilerplate(text)
+		report.Removed = append(report.Removed, r...)
+		text, r = StripBoilerplate(text)
+		report.Removed = append(report.Removed, r...)
+	}
+	if opts.StripFrontMatter {
+		var r []Removal
+		text, r = StripFrontMatter(text)
+		report.Removed = append(report.Removed, r...)
+	}
+	if opts.StripBackMatter {
+		var r []Removal
+		text, r = StripBackMatter(text)
+		report.Removed = append(report.Removed, r...)
+	}
+	if opts.ScrubPII {
+		text, report.PIIMasked = ScrubPII(text)
+	}
+	if opts.ChapterMarker != "" {
+		text, report.Chapters = MarkChapters(text, opts.ChapterMarker, headings)
+	}
+	text = NormalizeWhitespace(text, opts.Whitespace)
+	return text, report
+}
+
+// CountRemovals counts the removals of every stage, for the manifest.
+func CountRemovals(removed []Removal) map[string]int {
+	if len(removed) == 0 {
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, r := range removed {
+		counts[r.Stage]++
+	}
+	return counts
+}
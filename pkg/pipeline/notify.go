@@ -0,0 +1,275 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+)
+
+// how a run ended, in the status of its notification
+const (
+	NotifyCompleted string = "completed"
+	// completed, but some books failed
+	NotifyPartial     string = "completed_with_failures"
+	NotifyFailed      string = "failed"
+	NotifyRateLimited string = "rate_limited"
+	// a poll of watch that downloaded new books
+	NotifyNewBooks string = "new_books"
+)
+
+const (
+	// the failures a notification lists at most
+	notifyMaxErrors = 20
+	// Discord turns down messages longer than this
+	discordMaxLength = 2000
+)
+
+// Notification is the JSON summary of a run that -notify-url is sent.
+type Notification struct {
+	Status   string    `json:"status"`
+	Command  string    `json:"command"`
+	Host     string    `json:"host,omitempty"`
+	DataDir  string    `json:"data_dir,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	// the duration of the run in seconds
+	Duration float64 `json:"duration"`
+	// the books by outcome, like OutcomeSucceeded
+	Counts map[string]int `json:"counts"`
+	// the first books that failed
+	Errors []NotificationError `json:"errors,omitempty"`
+	// what ended a run that didn't complete
+	Error string `json:"error,omitempty"`
+	// a line saying all of the above, for chat webhooks that show text
+	Text string `json:"text"`
+}
+
+// NotificationError is a book that failed in a run.
+type NotificationError struct {
+	Title  string `json:"title,omitempty"`
+	File   string `json:"file,omitempty"`
+	Stage  string `json:"stage"`
+	Reason string `json:"reason"`
+}
+
+// NotifyTarget is where the summary of a run is sent: a webhook, a chat or
+// an email.
+type NotifyTarget interface {
+	// Name names the target in the log lines about it.
+	Name() string
+	Send(note *Notification) error
+}
+
+var notifyClient = &http.Client{Timeout: 30 * time.Second}
+
+// postJSON posts v as JSON to url.
+func postJSON(url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	resp, err := notifyClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+// WebhookTarget posts the whole Notification as JSON.
+type WebhookTarget struct {
+	URL string
+}
+
+func (t *WebhookTarget) Name() string { return "webhook" }
+
+func (t *WebhookTarget) Send(note *Notification) error {
+	return postJSON(t.URL, note)
+}
+
+// SlackTarget posts the text of the notification to a Slack incoming
+// webhook.
+type SlackTarget struct {
+	URL string
+}
+
+func (t *SlackTarget) Name() string { return "slack" }
+
+func (t *SlackTarget) Send(note *Notification) error {
+	return postJSON(t.URL, map[string]string{"text": note.Text})
+}
+
+// DiscordTarget posts the text of the notification to a Discord webhook.
+type DiscordTarget struct {
+	URL string
+}
+
+func (t *DiscordTarget) Name() string { return "discord" }
+
+func (t *DiscordTarget) Send(note *Notification) error {
+	content := note.Text
+	if len(content) > discordMaxLength {
+		content = content[:discordMaxLength-3] + "..."
+	}
+	return postJSON(t.URL, map[string]string{"content": content})
+}
+
+// EmailTarget mails the notification through an SMTP server, logging in if
+// it has a user.
+type EmailTarget struct {
+	// host:port of the server
+	Addr     string
+	From     string
+	To       []string
+	User     string
+	Password string
+}
+
+func (t *EmailTarget) Name() string { return "email" }
+
+func (t *EmailTarget) Send(note *Notification) error {
+	var body strings.Builder
+	fmt.Fprintf(&body, "From: %s\r\n", t.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(t.To, ", "))
+	fmt.Fprintf(&body, "Subject: %s run %s\r\n", note.Command, strings.ReplaceAll(note.Status, "_", " "))
+	fmt.Fprintf(&body, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&body, "%s\r\n", note.Text)
+	if note.DataDir != "" {
+		fmt.Fprintf(&body, "\r\nData directory: %s\r\n", note.DataDir)
+	}
+	if len(note.Errors) > 0 {
+		fmt.Fprintf(&body, "\r\nBooks that failed:\r\n")
+		for _, e := range note.Errors {
+			fmt.Fprintf(&body, "  %s (%s) at %s: %s\r\n", e.Title, e.File, e.Stage, e.Reason)
+		}
+	}
+	var auth smtp.Auth
+	if t.User != "" {
+		host, _, err := net.SplitHostPort(t.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", t.User, t.Password, host)
+	}
+	return smtp.SendMail(t.Addr, auth, t.From, t.To, []byte(body.String()))
+}
+
+// Notifier sends the summary of a run to its targets once it ends, so the
+// owners of unattended crawls hear about them. Its methods do nothing on a
+// nil notifier, so it can be left out.
+type Notifier struct {
+	targets []NotifyTarget
+	command string
+	dataDir string
+	report  *RunReport
+}
+
+// NewNotifier returns the notifier of a run of the command of flags to
+// targets, which counts its books in report. No targets is no
+// notifications.
+func NewNotifier(targets []NotifyTarget, flags *flag.FlagSet, report *RunReport) *Notifier {
+	if len(targets) == 0 {
+		return nil
+	}
+	n := &Notifier{
+		targets: targets,
+		command: flags.Name(),
+		report:  report,
+	}
+	if f := flags.Lookup("data_dir"); f != nil {
+		n.dataDir = f.Value.String()
+	}
+	return n
+}
+
+// summary is the notification of the books of a run since started, which
+// ended with status, and err if it didn't complete.
+func (n *Notifier) summary(status string, err error, started time.Time) *Notification {
+	finished := time.Now().UTC()
+	duration := finished.Sub(started)
+	note := &Notification{
+		Status:   status,
+		Command:  n.command,
+		DataDir:  n.dataDir,
+		Started:  started.UTC(),
+		Finished: finished,
+		Duration: duration.Seconds(),
+		Counts:   make(map[string]int),
+	}
+	note.Host, _ = os.Hostname()
+	for _, outcome := range []string{OutcomeSucceeded, OutcomeRejected, OutcomeSkipped, OutcomeFailed} {
+		note.Counts[outcome] = 0
+	}
+	for _, event := range n.report.Events() {
+		if event.Time.Before(started) {
+			continue
+		}
+		note.Counts[event.Outcome]++
+		if event.Outcome == OutcomeFailed && len(note.Errors) < notifyMaxErrors {
+			note.Errors = append(note.Errors, NotificationError{
+				Title:  event.Title,
+				File:   event.File,
+				Stage:  event.Stage,
+				Reason: event.Reason,
+			})
+		}
+	}
+	if err != nil {
+		note.Error = err.Error()
+	}
+	text := fmt.Sprintf("%s run %s", n.command, strings.ReplaceAll(status, "_", " "))
+	if note.Host != "" {
+		text += " on " + note.Host
+	}
+	text += fmt.Sprintf(" after %s: %d succeeded, %d rejected, %d skipped, %d failed",
+		duration.Round(time.Second), note.Counts[OutcomeSucceeded],
+		note.Counts[OutcomeRejected], note.Counts[OutcomeSkipped], note.Counts[OutcomeFailed])
+	if err != nil {
+		text += " (" + err.Error() + ")"
+	}
+	note.Text = text
+	return note
+}
+
+// Notify sends the summary of the run, which ended with status and err if
+// it didn't complete. A target that can't be reached is logged, it doesn't
+// fail the run.
+func (n *Notifier) Notify(status string, err error) {
+	if n == nil {
+		return
+	}
+	n.send(n.summary(status, err, n.report.Started))
+}
+
+// NotifyNewBooks sends the summary of the books since a poll started, if
+// any of them made it into the dataset.
+func (n *Notifier) NotifyNewBooks(since time.Time) {
+	if n == nil {
+		return
+	}
+	note := n.summary(NotifyNewBooks, nil, since)
+	if note.Counts[OutcomeSucceeded] == 0 {
+		return
+	}
+	n.send(note)
+}
+
+// send sends note to every target.
+func (n *Notifier) send(note *Notification) {
+	for _, target := range n.targets {
+		if sendErr := target.Send(note); sendErr != nil {
+			slog.Warn("Could not send the notification", "target", target.Name(), "error", sendErr)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOpenLibraryEndpoint string = "https://openlibrary.org"
+
+	// Open Library asks clients to keep to about one request a second
+	openLibraryInterval time.Duration = time.Second
+)
+
+var (
+	// ISBN-10 and ISBN-13 numbers, without hyphens
+	isbnRegex = regexp.MustCompile(`^(97[89])?[0-9]{9}[0-9xX]$`)
+	// an ISBN on a copyright page, like "ISBN: 978-1-234-56789-7"
+	isbnTextRegex = regexp.MustCompile(`(?i)\bISBN(?:-1[03])?:?\s*([0-9][0-9 -]{8,16}[0-9X])\b`)
+	yearRegex     = regexp.MustCompile(`\b(1[5-9]|20)[0-9]{2}\b`)
+)
+
+// FindISBN returns the first ISBN on the copyright page at the start of a
+// text, without hyphens, or "".
+func FindISBN(text string) string {
+	if len(text) > licenseTextLength {
+		text = text[:licenseTextLength]
+	}
+	for _, m := range isbnTextRegex.FindAllStringSubmatch(text, -1) {
+		isbn := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(m[1]))
+		if isbnRegex.MatchString(isbn) {
+			return isbn
+		}
+	}
+	return ""
+}
+
+// OpenLibraryInfo is what Open Library knows about an edition of a book.
+type OpenLibraryInfo struct {
+	// url of the edition on Open Library, the same for every copy of the
+	// book whatever it was downloaded from
+	URL string `json:"url"`
+	// canonical names of the authors
+	Authors     []string `json:"authors,omitempty"`
+	Subjects    []string `json:"subjects,omitempty"`
+	PublishYear int      `json:"publish_year,omitempty"`
+}
+
+// OpenLibraryClient looks books up on Open Library by their ISBN.
+type OpenLibraryClient struct {
+	Endpoint string
+	Client   *http.Client
+
+	mu   sync.Mutex
+	last time.Time
+}
+
+// NewOpenLibraryClient returns a client for Open Library at
+// $OPENLIBRARY_ENDPOINT, or openlibrary.org.
+func NewOpenLibraryClient() *OpenLibraryClient {
+	endpoint := os.Getenv("OPENLIBRARY_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultOpenLibraryEndpoint
+	}
+	return &OpenLibraryClient{Endpoint: strings.TrimSuffix(endpoint, "/"), Client: http.DefaultClient}
+}
+
+// wait keeps the requests at least openLibraryInterval apart.
+func (c *OpenLibraryClient) wait() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if wait := openLibraryInterval - time.Since(c.last); wait > 0 {
+		time.Sleep(wait)
+	}
+	c.last = time.Now()
+}
+
+// LookupISBN returns what Open Library knows about the edition with an
+// ISBN, or nil if it doesn't know the ISBN.
+func (c *OpenLibraryClient) LookupISBN(isbn string) (*OpenLibraryInfo, error) {
+	c.wait()
+	key := "ISBN:" + isbn
+	query := url.Values{"bibkeys": {key}, "format": {"json"}, "jscmd": {"data"}}
+	resp, err := c.Client.Get(c.Endpoint + "/api/books?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	type named struct {
+		Name string `json:"name"`
+	}
+	var books map[string]struct {
+		URL         string  `json:"url"`
+		Authors     []named `json:"authors"`
+		Subjects    []named `json:"subjects"`
+		PublishDate string  `json:"publish_date"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&books); err != nil {
+		return nil, fmt.Errorf("looking up %s: %w", key, err)
+	}
+	book, ok := books[key]
+	if !ok {
+		return nil, nil
+	}
+	info := &OpenLibraryInfo{URL: book.URL}
+	for _, author := range book.Authors {
+		info.Authors = append(info.Authors, author.Name)
+	}
+	for _, subject := range book.Subjects {
+		info.Subjects = append(info.Subjects, subject.Name)
+	}
+	if year := yearRegex.FindString(book.PublishDate); year != "" {
+		info.PublishYear, _ = strconv.Atoi(year)
+	}
+	return info, nil
+}
+
+// Enrich adds what Open Library knows about a book with an ISBN to its
+// record. Failed lookups are logged and otherwise ignored, the book goes in
+// the dataset either way.
+func (c *OpenLibraryClient) Enrich(record *ManifestRecord) {
+	isbn := record.Identifiers["isbn"]
+	if isbn == "" {
+		return
+	}
+	info, err := c.LookupISBN(isbn)
+	if err != nil {
+		slog.Warn("Could not look up book on Open Library", "file", record.File, "isbn", isbn, "error", err)
+		return
+	}
+	record.OpenLibrary = info
+}
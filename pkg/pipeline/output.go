@@ -0,0 +1,120 @@
+package pipeline
+
+import (
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+const (
+	// one .txt file per book in the data directory
+	OutputTxt string = "txt"
+	// one JSON object per book in dataset-NNNNN.jsonl shards in the data
+	// directory
+	OutputJSONL string = "jsonl"
+	// the same, compressed with zstd like The Pile
+	OutputJSONLZstd string = "jsonl.zst"
+
+	DatasetPrefix string = "dataset"
+)
+
+// Document is a book as it is written to a JSONL dataset.
+type Document struct {
+	ID       string `json:"id"`
+	Title    string `json:"title"`
+	Author   string `json:"author"`
+	URL      string `json:"url"`
+	Language string `json:"language"`
+	// how and when the book was obtained, if it was recorded
+	Provenance *Provenance `json:"provenance,omitempty"`
+	Text       string      `json:"text"`
+}
+
+// NewDocument puts the text of a book together with its metadata.
+func NewDocument(record *ManifestRecord, text string) *Document {
+	return &Document{
+		ID:         record.DocumentID(),
+		Title:      record.Title,
+		Author:     record.Author,
+		URL:        record.URL,
+		Language:   record.Language,
+		Provenance: record.Provenance,
+		Text:       text,
+	}
+}
+
+// Sink is where the text of the books that make it into the dataset goes.
+type Sink interface {
+	// Write adds a book to the dataset, noting where it went in its record.
+	Write(record *ManifestRecord, text string) error
+	Close() error
+}
+
+// SinkOptions controls how books are written to a sink.
+type SinkOptions struct {
+	// shards of JSONL, Parquet and archive datasets are kept under this
+	// many bytes, 0 for no limit
+	ShardSize int64
+	// compression of the .txt files of the txt format
+	Compression string
+	// write the metadata of every book to a .json file next to its .txt
+	// file, in the txt and archive formats
+	Sidecars bool
+	// start the .txt files of the txt and archive formats with a YAML
+	// header, see YAMLHeader
+	YAMLHeader bool
+}
+
+// NewSink opens the sink for an output format in a storage.
+func NewSink(format string, store storage.Writer, opts SinkOptions) (Sink, error) {
+	shardSize := opts.ShardSize
+	switch format {
+	case OutputJSONL:
+		return NewShardedSink(store, DatasetPrefix, ".jsonl", shardSize, newJSONLEncoder(false)), nil
+	case OutputJSONLZstd:
+		return NewShardedSink(store, DatasetPrefix, ".jsonl.zst", shardSize, newJSONLEncoder(true)), nil
+	case OutputParquet:
+		return NewShardedSink(store, DatasetPrefix, ".parquet", shardSize, newParquetEncoder), nil
+	case OutputTarGz:
+		return NewShardedSink(store, DatasetPrefix, ".tar.gz", shardSize, newTarEncoder(opts)), nil
+	case OutputZip:
+		return NewShardedSink(store, DatasetPrefix, ".zip", shardSize, newZipEncoder(opts)), nil
+	}
+	return &TxtSink{store: store, opts: opts}, nil
+}
+
+// TxtSink writes every book to a .txt file of its own, optionally compressed
+// to a .txt.gz or .txt.zst file, and optionally its metadata to a .json
+// file next to it.
+type TxtSink struct {
+	store storage.Writer
+	opts  SinkOptions
+}
+
+func (s *TxtSink) Write(record *ManifestRecord, text string) error {
+	name := record.File + CompressionExt(s.opts.Compression)
+	w, err := s.store.Create(name)
+	if err != nil {
+		return err
+	}
+	if s.opts.YAMLHeader {
+		text = YAMLHeader(record) + text
+	}
+	if err := WriteText(w, text, s.opts.Compression); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	// books that aren't in the data directory are found by where they went
+	if _, ok := s.store.(*storage.Local); !ok {
+		record.Dataset = s.store.Location(name)
+	}
+	if s.opts.Sidecars {
+		return writeSidecar(s.store, record)
+	}
+	return nil
+}
+
+func (s *TxtSink) Close() error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"io"
+	"time"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// OutputParquet writes the dataset to dataset-NNNNN.parquet shards.
+const OutputParquet string = "parquet"
+
+// ParquetRow is a book as it is written to a Parquet dataset: the text with
+// the metadata recorded in the manifest.
+type ParquetRow struct {
+	ID         string  `parquet:"name=id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Title      string  `parquet:"name=title, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Author     string  `parquet:"name=author, type=BYTE_ARRAY, convertedtype=UTF8"`
+	URL        string  `parquet:"name=url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Language   string  `parquet:"name=language, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Format     string  `parquet:"name=format, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Chars      int64   `parquet:"name=chars, type=INT64"`
+	Words      int64   `parquet:"name=words, type=INT64"`
+	Chapters   int64   `parquet:"name=chapters, type=INT64"`
+	Repetition float64 `parquet:"name=repetition, type=DOUBLE"`
+	SHA256     string  `parquet:"name=sha256, type=BYTE_ARRAY, convertedtype=UTF8"`
+	// provenance of the book, see Provenance
+	SourceURL    string `parquet:"name=source_url, type=BYTE_ARRAY, convertedtype=UTF8"`
+	DownloadedAt string `parquet:"name=downloaded_at, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ToolVersion  string `parquet:"name=tool_version, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Commit       string `parquet:"name=commit, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Text         string `parquet:"name=text, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// parquetEncoder writes every book as a ParquetRow.
+type parquetEncoder struct {
+	pw *writer.ParquetWriter
+}
+
+func newParquetEncoder(w io.Writer) (ShardEncoder, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(ParquetRow), 4)
+	if err != nil {
+		return nil, err
+	}
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	return &parquetEncoder{pw: pw}, nil
+}
+
+func (e *parquetEncoder) Encode(record *ManifestRecord, text string) (int, error) {
+	doc := NewDocument(record, text)
+	row := &ParquetRow{
+		ID:         doc.ID,
+		Title:      doc.Title,
+		Author:     doc.Author,
+		URL:        doc.URL,
+		Language:   doc.Language,
+		Format:     record.Format,
+		Chars:      int64(record.Chars),
+		Words:      int64(record.Words),
+		Chapters:   int64(record.Chapters),
+		Repetition: record.Repetition,
+		SHA256:     record.SHA256,
+		Text:       doc.Text,
+	}
+	if p := record.Provenance; p != nil {
+		row.SourceURL, row.ToolVersion, row.Commit = p.SourceURL, p.Version, p.Commit
+	}
+	if !record.DownloadedAt.IsZero() {
+		row.DownloadedAt = record.DownloadedAt.UTC().Format(time.RFC3339)
+	}
+	if err := e.pw.Write(row); err != nil {
+		return 0, err
+	}
+	return len(row.ID) + len(row.Title) + len(row.Author) + len(row.URL) + len(row.Language) +
+		len(row.Format) + len(row.SHA256) + len(row.SourceURL) + len(row.DownloadedAt) +
+		len(row.ToolVersion) + len(row.Commit) + len(row.Text) + 4*8, nil
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.pw.WriteStop()
+}
@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	emailRegex = regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`)
+	urlRegex   = regexp.MustCompile(`(?i)\b(https?://|www\.)[^\s<>"]*[^\s<>".,;:!?)\]'’”]`)
+	phoneRegex = regexp.MustCompile(`(\+[0-9]{1,3}[\s.-]?)?(\([0-9]{3}\)\s?|\b[0-9]{3}[\s.-])[0-9]{3}[\s.-][0-9]{4}\b|\+[0-9][0-9\s.-]{7,}[0-9]\b`)
+
+	// Paragraphs where authors give out their contact details.
+	contactRegex = regexp.MustCompile(`(?i)\b(contact|e-?mail|reach (me|the author)|write (to )?(me|the author)|get in touch|drop me a line|my (website|blog)|phone|call me)\b`)
+)
+
+// ScrubPII masks email addresses, phone numbers and URLs in the contact
+// sections of a book: everything after a back matter heading such as
+// "Connect with me", and paragraphs that plug the author or invite readers
+// to get in touch. It returns the text and the number of masked items.
+func ScrubPII(text string) (string, int) {
+	paragraphs := splitParagraphs(text)
+	first := len(paragraphs) - maxBackMatterParagraphs
+	if first < 1 {
+		first = 1
+	}
+	backMatter := len(paragraphs)
+	for i := first; i < len(paragraphs); i++ {
+		p := strings.TrimSpace(text[paragraphs[i][0]:paragraphs[i][1]])
+		if len(p) <= maxHeadingLength && backMatterHeadingRegex.MatchString(p) {
+			backMatter = i
+			break
+		}
+	}
+
+	var sb strings.Builder
+	last := 0
+	masked := 0
+	for i, p := range paragraphs {
+		paragraph := text[p[0]:p[1]]
+		if i < backMatter && !isPlug(paragraph) && !contactRegex.MatchString(paragraph) {
+			continue
+		}
+		scrubbed, n := maskPII(paragraph)
+		if n == 0 {
+			continue
+		}
+		masked += n
+		sb.WriteString(text[last:p[0]])
+		sb.WriteString(scrubbed)
+		last = p[1]
+	}
+	if masked == 0 {
+		return text, 0
+	}
+	sb.WriteString(text[last:])
+	return sb.String(), masked
+}
+
+func maskPII(paragraph string) (string, int) {
+	n := 0
+	mask := func(r *regexp.Regexp, placeholder string) {
+		paragraph = r.ReplaceAllStringFunc(paragraph, func(string) string {
+			n++
+			return placeholder
+		})
+	}
+	// emails first, so their domains aren't taken for URLs
+	mask(emailRegex, "[EMAIL]")
+	mask(urlRegex, "[URL]")
+	mask(phoneRegex, "[PHONE]")
+	return paragraph, n
+}
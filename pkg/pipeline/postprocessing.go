@@ -0,0 +1,97 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/coreweave/dataset-downloader/pkg/postprocess"
+)
+
+// PostProcessors are the stages of -postprocess a converted book goes
+// through before the filters, in order. Its methods do nothing on nil
+// post-processors, so they can be left out.
+type PostProcessors struct {
+	names      []string
+	processors []postprocess.Processor
+}
+
+// Add appends a stage to the post-processing.
+func (p *PostProcessors) Add(name string, processor postprocess.Processor) {
+	p.names = append(p.names, name)
+	p.processors = append(p.processors, processor)
+}
+
+// Apply puts the text of a book through the stages. It returns why a stage
+// rejected or failed the book, or "" with text and record updated to what
+// the stages made of them. A stage that fails once ctx is done returns the
+// error of ctx instead, since the run stopped rather than the book.
+func (p *PostProcessors) Apply(ctx context.Context, record *ManifestRecord, text *string, tokenizer Tokenizer) (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	language := record.Language
+	doc := &postprocess.Document{
+		ID:         record.ID,
+		Title:      record.Title,
+		Author:     record.Author,
+		URL:        record.URL,
+		Language:   record.Language,
+		Categories: record.Categories,
+		License:    record.License,
+		Text:       *text,
+	}
+	for i, processor := range p.processors {
+		processed, err := processor.Process(ctx, doc)
+		var rejection *postprocess.Rejection
+		if err != nil && ctx.Err() != nil {
+			return "", ctx.Err()
+		} else if errors.As(err, &rejection) {
+			return "postprocess " + p.names[i] + ": " + rejection.Reason, nil
+		} else if err != nil {
+			BookLogger(record.ID, record.URL, LogPhaseFilter).Warn("Post-processing failed",
+				"stage", p.names[i], "error", err)
+			return "postprocess " + p.names[i] + " failed: " + err.Error(), nil
+		}
+		doc = processed
+	}
+	if doc.Text != *text {
+		*text = doc.Text
+		measureText(record, *text, tokenizer)
+	}
+	record.Title = doc.Title
+	record.Author = doc.Author
+	record.Categories = doc.Categories
+	record.License = doc.License
+	// a stage that translated the book says so itself
+	if doc.Language != "" && doc.Language != language {
+		record.Language = doc.Language
+	}
+	return "", nil
+}
+
+// Close stops the stages that are programs of their own.
+func (p *PostProcessors) Close() {
+	if p == nil {
+		return
+	}
+	for i, processor := range p.processors {
+		if closer, ok := processor.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				slog.Warn("Post-processor exited with an error", "stage", p.names[i], "error", err)
+			}
+		}
+	}
+}
+
+// measureText measures the text of a book again once it changed.
+func measureText(record *ManifestRecord, text string, tokenizer Tokenizer) {
+	record.Chars = len(text)
+	record.Words = CountWords(text)
+	record.Language = DetectLanguage(text)
+	record.Quality = MeasureQuality(text)
+	record.Repetition = RepetitionRatio(text)
+	record.SHA256 = ContentHash(text)
+	countTokens(record, text, tokenizer)
+}
@@ -0,0 +1,316 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// the phases of a crawl, in the order the progress shows them
+const (
+	PhaseListed     string = "pages listed"
+	PhaseDiscovered string = "books discovered"
+	PhaseDownloaded string = "downloaded"
+	PhaseConverted  string = "converted"
+)
+
+// the modes of -progress
+const (
+	// bars on a terminal, log lines otherwise
+	ProgressAuto string = "auto"
+	ProgressBars string = "bars"
+	ProgressLog  string = "log"
+	ProgressNone string = "none"
+)
+
+const (
+	// how often the bars are redrawn
+	progressRedrawInterval = 200 * time.Millisecond
+	// how often the progress is logged without bars
+	progressLogInterval = 30 * time.Second
+	// width of the bar of a phase, without its brackets
+	progressBarWidth = 30
+)
+
+// progressPhase is how far a phase is: done of total, with total 0 when it
+// isn't known.
+type progressPhase struct {
+	name    string
+	done    int
+	total   int
+	started time.Time
+}
+
+// rate is how many a second the phase got done since it started.
+func (p *progressPhase) rate(now time.Time) float64 {
+	elapsed := now.Sub(p.started).Seconds()
+	if p.started.IsZero() || elapsed <= 0 {
+		return 0
+	}
+	return float64(p.done) / elapsed
+}
+
+// eta is how long the rest of the phase takes at its rate, 0 if that isn't
+// known.
+func (p *progressPhase) eta(now time.Time) time.Duration {
+	rate := p.rate(now)
+	if p.total <= p.done || rate == 0 {
+		return 0
+	}
+	return (time.Duration(float64(p.total-p.done)/rate) * time.Second).Round(time.Second)
+}
+
+// bar draws the phase as a line of the bars.
+func (p *progressPhase) bar(now time.Time) string {
+	line := fmt.Sprintf("%-16s ", p.name)
+	if p.total > 0 {
+		filled := progressBarWidth * p.done / p.total
+		if filled > progressBarWidth {
+			filled = progressBarWidth
+		}
+		line += "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "] "
+		line += fmt.Sprintf("%d/%d", p.done, p.total)
+	} else {
+		line += fmt.Sprintf("%d", p.done)
+	}
+	line += fmt.Sprintf("  %.1f/s", p.rate(now))
+	if eta := p.eta(now); eta > 0 {
+		line += fmt.Sprintf("  ETA %s", eta)
+	}
+	return line
+}
+
+// summary describes the phase in a field of a log line.
+func (p *progressPhase) summary(now time.Time) string {
+	s := fmt.Sprintf("%d", p.done)
+	if p.total > 0 {
+		s += fmt.Sprintf("/%d", p.total)
+	}
+	if p.done > 0 {
+		s += fmt.Sprintf(" (%.1f/s", p.rate(now))
+		if eta := p.eta(now); eta > 0 {
+			s += fmt.Sprintf(", ETA %s", eta)
+		}
+		s += ")"
+	}
+	return s
+}
+
+// Progress shows how far the phases of a run are, with their rates and
+// ETA: as bars redrawn in place on a terminal, with the log lines of the
+// run written above them, or as a log line every progressLogInterval
+// otherwise. Its methods do nothing on a nil progress, so it can be left
+// out.
+type Progress struct {
+	out  io.Writer
+	bars bool
+
+	mu     sync.Mutex
+	phases []*progressPhase
+	// what is left of the quota of the source, nil if it isn't known
+	quota *source.Quota
+	// lines of bars on the terminal, to draw over
+	drawn int
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// isTerminal tells whether a file is a terminal rather than a pipe or a
+// file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// NewProgress starts showing the progress of the phases on stderr in a
+// mode of -progress, or returns nil for ProgressNone.
+func NewProgress(mode string, phases ...string) (*Progress, error) {
+	bars := false
+	switch mode {
+	case ProgressNone:
+		return nil, nil
+	case ProgressAuto:
+		bars = isTerminal(os.Stderr)
+	case ProgressBars:
+		bars = true
+	case ProgressLog:
+	default:
+		return nil, fmt.Errorf("unsupported progress %s", mode)
+	}
+	p := &Progress{
+		out:  os.Stderr,
+		bars: bars,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	for _, name := range phases {
+		p.phases = append(p.phases, &progressPhase{name: name})
+	}
+	interval := progressLogInterval
+	if bars {
+		// log lines go above the bars
+		setLogOutput(p)
+		interval = progressRedrawInterval
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.show()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+// phase returns a phase by name, starting its clock.
+func (p *Progress) phase(name string) *progressPhase {
+	for _, phase := range p.phases {
+		if phase.name == name {
+			if phase.started.IsZero() {
+				phase.started = time.Now()
+			}
+			return phase
+		}
+	}
+	phase := &progressPhase{name: name, started: time.Now()}
+	p.phases = append(p.phases, phase)
+	return phase
+}
+
+// Add adds to the total of a phase, as more of it turns up.
+func (p *Progress) Add(name string, n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.phase(name).total += n
+}
+
+// Done counts n more of a phase as done.
+func (p *Progress) Done(name string, n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.phase(name).done += n
+}
+
+// PagesToList and PageListed report the pages a source lists, as a
+// source.Progress.
+func (p *Progress) PagesToList(n int) { p.Add(PhaseListed, n) }
+func (p *Progress) PageListed()       { p.Done(PhaseListed, 1) }
+
+// QuotaLeft shows what the source says is left of its quota, as a
+// source.QuotaProgress, or what a command knows is left of it.
+func (p *Progress) QuotaLeft(quota source.Quota) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.quota = &quota
+}
+
+// quotaUsed counts a download against the quota, until the source says
+// what is left again.
+func (p *Progress) quotaUsed() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.quota != nil && p.quota.Remaining > 0 {
+		p.quota.Remaining--
+	}
+}
+
+// quotaSummary describes what is left of the quota.
+func (p *Progress) quotaSummary(now time.Time) string {
+	s := fmt.Sprintf("%d", p.quota.Remaining)
+	if p.quota.Limit > 0 {
+		s += fmt.Sprintf(" of %d", p.quota.Limit)
+	}
+	s += " left"
+	if reset := p.quota.Reset.Sub(now).Round(time.Second); reset > 0 {
+		s += fmt.Sprintf(", resets in %s", reset)
+	}
+	return s
+}
+
+// clear moves back over the bars drawn last, so what comes next draws over
+// them.
+func (p *Progress) clear() {
+	for ; p.drawn > 0; p.drawn-- {
+		fmt.Fprint(p.out, "\x1b[1A\x1b[2K")
+	}
+}
+
+// draw draws the bars below the cursor.
+func (p *Progress) draw() {
+	now := time.Now()
+	for _, phase := range p.phases {
+		fmt.Fprintln(p.out, phase.bar(now))
+	}
+	p.drawn = len(p.phases)
+	if p.quota != nil {
+		fmt.Fprintf(p.out, "%-16s %s\n", "quota", p.quotaSummary(now))
+		p.drawn++
+	}
+}
+
+// show redraws the bars, or logs the progress.
+func (p *Progress) show() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.bars {
+		p.clear()
+		p.draw()
+		return
+	}
+	now := time.Now()
+	var fields []any
+	for _, phase := range p.phases {
+		fields = append(fields, strings.ReplaceAll(phase.name, " ", "_"), phase.summary(now))
+	}
+	if p.quota != nil {
+		fields = append(fields, "quota", p.quotaSummary(now))
+	}
+	slog.Info("Progress", fields...)
+}
+
+// Write writes a log line above the bars.
+func (p *Progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+	n, err := p.out.Write(b)
+	p.draw()
+	return n, err
+}
+
+// Stop shows the progress one last time and stops updating it.
+func (p *Progress) Stop() {
+	if p == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.show()
+	if p.bars {
+		setLogOutput(os.Stderr)
+	}
+}
@@ -0,0 +1,44 @@
+package pipeline
+
+import "strings"
+
+const (
+	// leave punctuation as the book has it
+	PunctuationKeep string = "keep"
+	// replace typographic punctuation with its plain ASCII equivalent
+	PunctuationASCII string = "ascii"
+	// map the many look-alike characters to one Unicode form each
+	PunctuationUnicode string = "unicode"
+)
+
+var asciiPunctuation = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "′", "'",
+	"“", `"`, "”", `"`, "„", `"`, "‟", `"`, "″", `"`,
+	"«", `"`, "»", `"`,
+	"—", "--", "―", "--", "⸺", "----",
+	"–", "-", "‒", "-", "‐", "-", "‑", "-", "−", "-",
+	"…", "...",
+	"\u00a0", " ", "\u202f", " ", "\u2007", " ", "\u2009", " ", "\u200a", " ",
+)
+
+var unicodePunctuation = strings.NewReplacer(
+	"‚", "‘", "‛", "‘",
+	"„", "“", "‟", "“",
+	"―", "—", "⸺", "——",
+	"‒", "–",
+	"‐", "-", "‑", "-",
+	"...", "…",
+	"\u00a0", " ", "\u202f", " ", "\u2007", " ", "\u2009", " ", "\u200a", " ",
+)
+
+// NormalizePunctuation converts curly quotes, dashes, ellipses and non-breaking
+// spaces either to ASCII or to a single consistent Unicode form.
+func NormalizePunctuation(text string, mode string) string {
+	switch mode {
+	case PunctuationASCII:
+		return asciiPunctuation.Replace(text)
+	case PunctuationUnicode:
+		return unicodePunctuation.Replace(text)
+	}
+	return text
+}
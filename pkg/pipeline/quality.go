@@ -0,0 +1,122 @@
+package pipeline
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	// share of the non-space characters that have to be letters
+	minAlphaRatio float64 = 0.6
+	// bounds of the mean word length of real text
+	minMeanWordLength float64 = 2.5
+	maxMeanWordLength float64 = 10
+	// share of the non-space characters that may be symbols
+	maxSymbolRatio float64 = 0.05
+	// share of the words that may look like OCR errors
+	maxOCRArtifactRatio float64 = 0.1
+
+	RejectsDirName string = "rejects"
+)
+
+var (
+	// ordinals, decades and the like mix letters and digits legitimately
+	ordinalRegex = regexp.MustCompile(`^[0-9]+(st|nd|rd|th|s|'s|’s)$`)
+	// a letter, a stray symbol or digit, and a letter again: "th1s", "w%rd"
+	brokenWordRegex = regexp.MustCompile(`\pL[^\pL\s'’.,\-/]\pL`)
+	// lowercase, uppercase, lowercase: "tHe"
+	mixedCaseRegex = regexp.MustCompile(`\p{Ll}\p{Lu}\p{Ll}`)
+)
+
+// QualityMetrics are the heuristics junk documents are told apart by.
+type QualityMetrics struct {
+	AlphaRatio       float64 `json:"alpha_ratio"`
+	MeanWordLength   float64 `json:"mean_word_length"`
+	SymbolRatio      float64 `json:"symbol_ratio"`
+	OCRArtifactRatio float64 `json:"ocr_artifact_ratio"`
+}
+
+// MeasureQuality computes the quality metrics of a converted book.
+func MeasureQuality(text string) *QualityMetrics {
+	chars, letters, symbols := 0, 0, 0
+	for _, r := range text {
+		switch {
+		case unicode.IsSpace(r):
+			continue
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsSymbol(r) || strings.ContainsRune(`#%&*@[]\_{}`, r):
+			symbols++
+		}
+		chars++
+	}
+
+	words, wordChars, artifacts := 0, 0, 0
+	for _, field := range strings.Fields(text) {
+		word := strings.TrimFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		if word == "" {
+			continue
+		}
+		words++
+		wordChars += len([]rune(word))
+		if isOCRArtifact(word) {
+			artifacts++
+		}
+	}
+
+	q := &QualityMetrics{}
+	if chars > 0 {
+		q.AlphaRatio = float64(letters) / float64(chars)
+		q.SymbolRatio = float64(symbols) / float64(chars)
+	}
+	if words > 0 {
+		q.MeanWordLength = float64(wordChars) / float64(words)
+		q.OCRArtifactRatio = float64(artifacts) / float64(words)
+	}
+	return q
+}
+
+func isOCRArtifact(word string) bool {
+	hasLetter := strings.IndexFunc(word, unicode.IsLetter) >= 0
+	hasDigit := strings.IndexFunc(word, unicode.IsDigit) >= 0
+	if hasLetter && hasDigit && !ordinalRegex.MatchString(word) {
+		return true
+	}
+	return brokenWordRegex.MatchString(word) || mixedCaseRegex.MatchString(word)
+}
+
+// Failure returns the first metric that is out of bounds, or "" if the book
+// passes.
+func (q *QualityMetrics) Failure() string {
+	switch {
+	case q.MeanWordLength == 0:
+		return "no words"
+	case q.AlphaRatio < minAlphaRatio:
+		return fmt.Sprintf("alpha_ratio %.2f below %.2f", q.AlphaRatio, minAlphaRatio)
+	case q.MeanWordLength < minMeanWordLength:
+		return fmt.Sprintf("mean_word_length %.2f below %.2f", q.MeanWordLength, minMeanWordLength)
+	case q.MeanWordLength > maxMeanWordLength:
+		return fmt.Sprintf("mean_word_length %.2f above %.2f", q.MeanWordLength, maxMeanWordLength)
+	case q.SymbolRatio > maxSymbolRatio:
+		return fmt.Sprintf("symbol_ratio %.3f above %.3f", q.SymbolRatio, maxSymbolRatio)
+	case q.OCRArtifactRatio > maxOCRArtifactRatio:
+		return fmt.Sprintf("ocr_artifact_ratio %.3f above %.3f", q.OCRArtifactRatio, maxOCRArtifactRatio)
+	}
+	return ""
+}
+
+// WriteReject saves the text of a rejected book to the rejects directory, so
+// the rejections can be looked over.
+func WriteReject(dataDir string, file string, text string) error {
+	dir := filepath.Join(dataDir, RejectsDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, file), []byte(text), 0600)
+}
@@ -0,0 +1,42 @@
+package pipeline
+
+import "strings"
+
+// RepetitionRatio measures how much of a text is repeated lines or
+// paragraphs: the share of the characters that belong to a line (or
+// paragraph) seen before, whichever is higher. Auto-generated books often
+// repeat the same passages over and over.
+func RepetitionRatio(text string) float64 {
+	var paragraphs []string
+	for _, p := range splitParagraphs(text) {
+		paragraphs = append(paragraphs, text[p[0]:p[1]])
+	}
+	lineRatio := duplicateRatio(strings.Split(text, "\n"))
+	paragraphRatio := duplicateRatio(paragraphs)
+	if paragraphRatio > lineRatio {
+		return paragraphRatio
+	}
+	return lineRatio
+}
+
+// duplicateRatio returns the share of the characters of pieces that are in
+// a piece that showed up earlier.
+func duplicateRatio(pieces []string) float64 {
+	seen := make(map[string]bool, len(pieces))
+	total, duplicate := 0, 0
+	for _, piece := range pieces {
+		piece = strings.Join(strings.Fields(piece), " ")
+		if piece == "" {
+			continue
+		}
+		total += len(piece)
+		if seen[piece] {
+			duplicate += len(piece)
+		}
+		seen[piece] = true
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(duplicate) / float64(total)
+}
@@ -0,0 +1,265 @@
+package pipeline
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the formats of the run report
+const (
+	ReportHTML string = "html"
+	ReportCSV  string = "csv"
+
+	reportsDirName string = "reports"
+)
+
+// what happened to a book in a run
+const (
+	// the book went in the dataset
+	OutcomeSucceeded string = "succeeded"
+	// a filter left the book out of the dataset
+	OutcomeRejected string = "rejected"
+	// the book was already downloaded or rejected before
+	OutcomeSkipped string = "skipped"
+	OutcomeFailed  string = "failed"
+)
+
+// ReportEvent is what happened to a book in a run.
+type ReportEvent struct {
+	Time    time.Time
+	Title   string
+	File    string
+	Outcome string
+	// the stage a book failed at, like download or decode
+	Stage  string
+	Reason string
+	// the text of the books that went in the dataset
+	Bytes int
+}
+
+// RunReport keeps track of what happened to every book in a run, for a report
+// to share with the people who didn't watch the logs. Its methods do nothing
+// on a nil report, so it can be left out.
+type RunReport struct {
+	Started time.Time
+
+	mu     sync.Mutex
+	events []ReportEvent
+}
+
+// NewRunReport starts the report of a run.
+func NewRunReport() *RunReport {
+	return &RunReport{Started: time.Now()}
+}
+
+func (r *RunReport) add(event ReportEvent) {
+	if r == nil {
+		return
+	}
+	event.Time = time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+// Succeed records a book that went in the dataset.
+func (r *RunReport) Succeed(title string, file string, bytes int) {
+	r.add(ReportEvent{Title: title, File: file, Outcome: OutcomeSucceeded, Bytes: bytes})
+}
+
+// Reject records a book a filter left out of the dataset.
+func (r *RunReport) Reject(title string, file string, reason string) {
+	r.add(ReportEvent{Title: title, File: file, Outcome: OutcomeRejected, Reason: reason})
+}
+
+// Skip records a book that wasn't downloaded or converted again.
+func (r *RunReport) Skip(title string, file string, reason string) {
+	r.add(ReportEvent{Title: title, File: file, Outcome: OutcomeSkipped, Reason: reason})
+}
+
+// Fail records a book that failed at a stage.
+func (r *RunReport) Fail(title string, file string, stage string, reason string) {
+	r.add(ReportEvent{Title: title, File: file, Outcome: OutcomeFailed, Stage: stage, Reason: reason})
+}
+
+// Events returns what happened to the books so far, in order.
+func (r *RunReport) Events() []ReportEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ReportEvent(nil), r.events...)
+}
+
+// ReportCount is a row of a table of the report.
+type ReportCount struct {
+	Name  string
+	Count int
+}
+
+// reportInterval is the books that went in the dataset in a minute of the
+// run.
+type reportInterval struct {
+	Start time.Time
+	Books int
+	Bytes int64
+}
+
+// reportData is what the HTML report is made from.
+type reportData struct {
+	Started   time.Time
+	Finished  time.Time
+	Duration  time.Duration
+	Attempted int
+	Outcomes  []ReportCount
+	Reasons   []ReportCount
+	Failures  []ReportEvent
+	// books and bytes per minute of the run
+	Throughput []reportInterval
+}
+
+// summarize counts the outcomes of a run, the reasons books were rejected or
+// skipped, and the throughput of every minute of the run.
+func (r *RunReport) summarize(finished time.Time) *reportData {
+	data := &reportData{Started: r.Started, Finished: finished, Duration: finished.Sub(r.Started).Round(time.Second)}
+	outcomes := make(map[string]int)
+	reasons := make(map[string]int)
+	minutes := make(map[int]*reportInterval)
+	for _, event := range r.Events() {
+		outcomes[event.Outcome]++
+		switch event.Outcome {
+		case OutcomeSucceeded:
+			minute := int(event.Time.Sub(r.Started) / time.Minute)
+			if minutes[minute] == nil {
+				minutes[minute] = &reportInterval{Start: r.Started.Add(time.Duration(minute) * time.Minute)}
+			}
+			minutes[minute].Books++
+			minutes[minute].Bytes += int64(event.Bytes)
+		case OutcomeRejected, OutcomeSkipped:
+			reasons[event.Outcome+": "+reportReason(event.Reason)]++
+		case OutcomeFailed:
+			reasons[event.Outcome+": "+event.Stage]++
+			data.Failures = append(data.Failures, event)
+		}
+	}
+	data.Attempted = outcomes[OutcomeSucceeded] + outcomes[OutcomeRejected] + outcomes[OutcomeFailed]
+	for _, outcome := range []string{OutcomeSucceeded, OutcomeRejected, OutcomeSkipped, OutcomeFailed} {
+		data.Outcomes = append(data.Outcomes, ReportCount{outcome, outcomes[outcome]})
+	}
+	for _, reason := range SortedByCount(reasons) {
+		data.Reasons = append(data.Reasons, ReportCount{reason, reasons[reason]})
+	}
+	var keys []int
+	for minute := range minutes {
+		keys = append(keys, minute)
+	}
+	sort.Ints(keys)
+	for _, minute := range keys {
+		data.Throughput = append(data.Throughput, *minutes[minute])
+	}
+	return data
+}
+
+// reportReason is the kind of a reason, like "language" for
+// "language: fr", so the reasons of different books add up.
+func reportReason(reason string) string {
+	kind, _, _ := strings.Cut(reason, ":")
+	return kind
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"size": FormatSize,
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>smashwords-downloader run {{time .Started}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; }
+td.n { text-align: right; }
+</style>
+</head>
+<body>
+<h1>smashwords-downloader run</h1>
+<p>Started {{time .Started}}, finished {{time .Finished}} ({{.Duration}}). {{.Attempted}} books attempted.</p>
+
+<h2>Books</h2>
+<table>
+<tr><th>outcome</th><th>books</th></tr>
+{{range .Outcomes}}<tr><td>{{.Name}}</td><td class="n">{{.Count}}</td></tr>
+{{end}}</table>
+
+{{if .Reasons}}<h2>Reasons</h2>
+<table>
+<tr><th>reason</th><th>books</th></tr>
+{{range .Reasons}}<tr><td>{{.Name}}</td><td class="n">{{.Count}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Throughput}}<h2>Throughput</h2>
+<table>
+<tr><th>minute</th><th>books</th><th>text</th></tr>
+{{range .Throughput}}<tr><td>{{time .Start}}</td><td class="n">{{.Books}}</td><td class="n">{{size .Bytes}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .Failures}}<h2>Failures</h2>
+<table>
+<tr><th>time</th><th>book</th><th>file</th><th>stage</th><th>reason</th></tr>
+{{range .Failures}}<tr><td>{{time .Time}}</td><td>{{.Title}}</td><td>{{.File}}</td><td>{{.Stage}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the report as an HTML page.
+func (r *RunReport) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r.summarize(time.Now()))
+}
+
+// WriteCSV writes what happened to every book as a row of CSV.
+func (r *RunReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"time", "title", "file", "outcome", "stage", "reason", "bytes"})
+	for _, event := range r.Events() {
+		cw.Write([]string{event.Time.UTC().Format(time.RFC3339), event.Title, event.File, event.Outcome,
+			event.Stage, event.Reason, strconv.Itoa(event.Bytes)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Save writes the report to <data_dir>/reports/run-<start time>.html or
+// .csv, and returns its path.
+func (r *RunReport) Save(dataDir string, format string) (string, error) {
+	if format != ReportHTML && format != ReportCSV {
+		return "", fmt.Errorf("unsupported report format %s", format)
+	}
+	dir := filepath.Join(dataDir, reportsDirName)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, "run-"+r.Started.UTC().Format("20060102-150405")+"."+format)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if format == ReportCSV {
+		err = r.WriteCSV(f)
+	} else {
+		err = r.WriteHTML(f)
+	}
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
@@ -0,0 +1,112 @@
+package pipeline
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+// CatalogBook is a book as the serve command shows it, read from the
+// catalog.
+type CatalogBook struct {
+	File        string   `json:"file"`
+	ID          string   `json:"id"`
+	Source      string   `json:"source,omitempty"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author"`
+	URL         string   `json:"url,omitempty"`
+	Categories  []string `json:"categories"`
+	Language    string   `json:"language,omitempty"`
+	License     string   `json:"license,omitempty"`
+	LicenseType string   `json:"license_type,omitempty"`
+	Published   string   `json:"published,omitempty"`
+	Words       int      `json:"words"`
+	Tokens      int      `json:"tokens,omitempty"`
+	Dataset     string   `json:"dataset,omitempty"`
+}
+
+// the columns scanBook reads, in order
+const catalogBookColumns string = `file, id, source, title, author, url, categories, language, license,
+	license_type, published, words, tokens, dataset`
+
+// scanBook reads a row of catalogBookColumns.
+func scanBook(scanner interface{ Scan(...interface{}) error }) (*CatalogBook, error) {
+	book := &CatalogBook{}
+	var categories string
+	err := scanner.Scan(&book.File, &book.ID, &book.Source, &book.Title, &book.Author, &book.URL, &categories,
+		&book.Language, &book.License, &book.LicenseType, &book.Published, &book.Words, &book.Tokens, &book.Dataset)
+	if err != nil {
+		return nil, err
+	}
+	if categories != "" {
+		if err := json.Unmarshal([]byte(categories), &book.Categories); err != nil {
+			return nil, err
+		}
+	}
+	return book, nil
+}
+
+// Search returns a page of the converted books whose title, author or
+// categories have the query in them, and how many there are in all. An empty
+// query or language matches every book.
+func (c *Catalog) Search(query string, language string, offset int, limit int) ([]*CatalogBook, int, error) {
+	where := "status = ? AND words > 0"
+	args := []interface{}{StatusConverted}
+	if query != "" {
+		where += " AND (title LIKE ? OR author LIKE ? OR categories LIKE ?)"
+		like := "%" + query + "%"
+		args = append(args, like, like, like)
+	}
+	if language != "" {
+		where += " AND language = ?"
+		args = append(args, language)
+	}
+	var total int
+	if err := c.DB.QueryRow("SELECT COUNT(*) FROM books WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := c.DB.Query("SELECT "+catalogBookColumns+" FROM books WHERE "+where+
+		" ORDER BY title COLLATE NOCASE, id LIMIT ? OFFSET ?", append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var books []*CatalogBook
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		books = append(books, book)
+	}
+	return books, total, rows.Err()
+}
+
+// Languages returns the languages of the converted books, with how many
+// books are in each.
+func (c *Catalog) Languages() (map[string]int, error) {
+	rows, err := c.DB.Query("SELECT language, COUNT(*) FROM books WHERE status = ? AND words > 0 AND language != ''"+
+		" GROUP BY language", StatusConverted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	languages := make(map[string]int)
+	for rows.Next() {
+		var language string
+		var count int
+		if err := rows.Scan(&language, &count); err != nil {
+			return nil, err
+		}
+		languages[language] = count
+	}
+	return languages, rows.Err()
+}
+
+// Book returns the book with a stable id, nil if there is none.
+func (c *Catalog) Book(id string) (*CatalogBook, error) {
+	book, err := scanBook(c.DB.QueryRow("SELECT "+catalogBookColumns+" FROM books WHERE id = ? LIMIT 1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return book, err
+}
@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// Shard is the slice of the books of a crawl a machine downloads when the
+// crawl is split over machines that don't talk to each other: the books
+// whose key hashes to Index out of Count. Every machine lists the same
+// pages and keeps only its own books, so together they download every book
+// once and their data directories merge without conflicts.
+type Shard struct {
+	// 1 to Count
+	Index int
+	Count int
+}
+
+// ParseShard reads a shard like 2/8, the second of eight. An empty spec is
+// no sharding, nil.
+func ParseShard(spec string) (*Shard, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	index, count, ok := strings.Cut(spec, "/")
+	shard := &Shard{}
+	var indexErr, countErr error
+	shard.Index, indexErr = strconv.Atoi(strings.TrimSpace(index))
+	shard.Count, countErr = strconv.Atoi(strings.TrimSpace(count))
+	if !ok || indexErr != nil || countErr != nil || shard.Count < 1 || shard.Index < 1 || shard.Index > shard.Count {
+		return nil, fmt.Errorf("invalid shard %s, it should be like 2/8 with 1 <= 2 <= 8", spec)
+	}
+	return shard, nil
+}
+
+// shardKey is what a book is sharded by: its stable id without the format,
+// so a book in txt and epub goes to the same machine.
+func shardKey(book *source.Book) string {
+	return strings.TrimSuffix(book.ID, "-"+book.Format)
+}
+
+// Has is whether a book is in the shard. Every book is in a nil shard.
+func (s *Shard) Has(book *source.Book) bool {
+	if s == nil {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(shardKey(book)))
+	return int(h.Sum64()%uint64(s.Count)) == s.Index-1
+}
+
+func (s *Shard) String() string {
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
@@ -0,0 +1,276 @@
+package pipeline
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ShardIndexFileName string = "shards.jsonl"
+
+	// rough size of the metadata that goes with the text of a book
+	documentOverhead int = 512
+)
+
+// ShardEncoder writes books to one shard of a dataset.
+type ShardEncoder interface {
+	// Encode writes a book and returns its uncompressed size in bytes.
+	Encode(record *ManifestRecord, text string) (int, error)
+	// Close flushes the shard. It doesn't close the file under it.
+	Close() error
+}
+
+// ShardInfo is a line of shards.jsonl, the index of the shards of a dataset.
+type ShardInfo struct {
+	File      string `json:"file"`
+	Documents int    `json:"documents"`
+	// uncompressed size of the documents
+	Bytes int64 `json:"bytes"`
+}
+
+// ShardedSink writes books to numbered shards, dataset-00000.jsonl.zst and
+// so on, starting a new shard when the current one would grow past maxBytes
+// of uncompressed data. Shards only show up under their name once they are
+// complete, and are listed in shards.jsonl along with the number of books in
+// them. The records of the books only get the shard as their Dataset then,
+// so a shard a crash left behind doesn't count their books as written.
+type ShardedSink struct {
+	store      storage.Writer
+	prefix     string
+	ext        string
+	maxBytes   int64
+	newEncoder func(w io.Writer) (ShardEncoder, error)
+
+	mu      sync.Mutex
+	next    int
+	w       io.WriteCloser
+	encoder ShardEncoder
+	info    ShardInfo
+	// the records of the books in the shard
+	records []*ManifestRecord
+	// the shards.jsonl so far, nil until the first shard is opened
+	index []ShardInfo
+}
+
+// NewShardedSink returns a sink writing shards named prefix-NNNNN+ext to
+// store. A maxBytes of 0 puts every book of a run in one shard.
+func NewShardedSink(store storage.Writer, prefix string, ext string, maxBytes int64, newEncoder func(w io.Writer) (ShardEncoder, error)) *ShardedSink {
+	return &ShardedSink{store: store, prefix: prefix, ext: ext, maxBytes: maxBytes, newEncoder: newEncoder}
+}
+
+func (s *ShardedSink) Write(record *ManifestRecord, text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	estimate := int64(len(text) + documentOverhead)
+	if s.encoder != nil && s.maxBytes > 0 && s.info.Documents > 0 && s.info.Bytes+estimate > s.maxBytes {
+		if err := s.closeShard(); err != nil {
+			return err
+		}
+	}
+	if s.encoder == nil {
+		if err := s.openShard(); err != nil {
+			return err
+		}
+	}
+
+	n, err := s.encoder.Encode(record, text)
+	if err != nil {
+		return err
+	}
+	s.info.Documents++
+	s.info.Bytes += int64(n)
+	record.shard = s.info.File
+	s.records = append(s.records, record)
+	return nil
+}
+
+func (s *ShardedSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.encoder == nil {
+		return nil
+	}
+	return s.closeShard()
+}
+
+// openShard starts the first shard whose number isn't taken yet, so a run
+// never overwrites the shards of the runs before it, nor the partial shard
+// of one that crashed.
+func (s *ShardedSink) openShard() error {
+	if s.index == nil {
+		index, err := ReadShardIndexFrom(s.store)
+		if err != nil {
+			return err
+		}
+		s.index = append(make([]ShardInfo, 0, len(index)), index...)
+	}
+	for ; ; s.next++ {
+		name := fmt.Sprintf("%s-%05d%s", s.prefix, s.next, s.ext)
+		exists, err := s.store.Exists(name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			// local shards are written to name.part until they are closed
+			if exists, err = s.store.Exists(name + ".part"); err != nil {
+				return err
+			}
+		}
+		if !exists {
+			s.info = ShardInfo{File: name}
+			break
+		}
+	}
+	s.next++
+
+	w, err := s.store.Create(s.info.File)
+	if err != nil {
+		return err
+	}
+	encoder, err := s.newEncoder(w)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	s.w = w
+	s.encoder = encoder
+	return nil
+}
+
+func (s *ShardedSink) closeShard() error {
+	err := s.encoder.Close()
+	if closeErr := s.w.Close(); err == nil {
+		err = closeErr
+	}
+	records := s.records
+	s.encoder = nil
+	s.records = nil
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		record.Dataset = s.info.File
+		record.shard = ""
+	}
+	s.index = append(s.index, s.info)
+	return writeShardIndex(s.store, s.index)
+}
+
+// ReadShardIndexFrom reads the shards.jsonl of a storage, if it has one.
+func ReadShardIndexFrom(store storage.Writer) ([]ShardInfo, error) {
+	r, err := store.Open(ShardIndexFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var shards []ShardInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var shard ShardInfo
+		if err := json.Unmarshal(scanner.Bytes(), &shard); err != nil {
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return shards, scanner.Err()
+}
+
+// writeShardIndex writes shards.jsonl over again, since object storage
+// can't append to files.
+func writeShardIndex(store storage.Writer, shards []ShardInfo) error {
+	w, err := store.Create(ShardIndexFileName)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	for _, shard := range shards {
+		if err := encoder.Encode(shard); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// jsonlEncoder writes every book as a Document on a line of its own,
+// optionally compressed with zstd.
+type jsonlEncoder struct {
+	w    *bufio.Writer
+	zw   *zstd.Encoder
+	line bytes.Buffer
+}
+
+func newJSONLEncoder(compress bool) func(w io.Writer) (ShardEncoder, error) {
+	return func(w io.Writer) (ShardEncoder, error) {
+		e := &jsonlEncoder{}
+		if compress {
+			zw, err := zstd.NewWriter(w)
+			if err != nil {
+				return nil, err
+			}
+			e.zw = zw
+			w = zw
+		}
+		e.w = bufio.NewWriter(w)
+		return e, nil
+	}
+}
+
+func (e *jsonlEncoder) Encode(record *ManifestRecord, text string) (int, error) {
+	e.line.Reset()
+	encoder := json.NewEncoder(&e.line)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(NewDocument(record, text)); err != nil {
+		return 0, err
+	}
+	return e.w.Write(e.line.Bytes())
+}
+
+func (e *jsonlEncoder) Close() error {
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if e.zw != nil {
+		return e.zw.Close()
+	}
+	return nil
+}
+
+// ParseSize parses a size like 1GB, 500MB or 1048576 (bytes).
+func ParseSize(size string) (int64, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(size, unit.suffix) {
+			size = strings.TrimSpace(strings.TrimSuffix(size, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(size, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return int64(n * float64(multiplier)), nil
+}
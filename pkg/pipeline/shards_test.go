@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+func TestShardedSink(t *testing.T) {
+	dir := t.TempDir()
+	// a shard a crashed run left behind
+	if err := os.WriteFile(filepath.Join(dir, "dataset-00000.jsonl.part"), []byte("{}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sink := NewShardedSink(&storage.Local{Dir: dir}, "dataset", ".jsonl", 1, newJSONLEncoder(false))
+	first := &ManifestRecord{File: "1.txt"}
+	second := &ManifestRecord{File: "2.txt"}
+	if err := sink.Write(first, "It rained."); err != nil {
+		t.Fatal(err)
+	}
+	if first.Dataset != "" {
+		t.Errorf("the book is in %s before the shard is closed", first.Dataset)
+	}
+	// the second book starts a shard of its own, which closes the first
+	if err := sink.Write(second, "It stopped."); err != nil {
+		t.Fatal(err)
+	}
+	if first.Dataset != "dataset-00001.jsonl" || second.Dataset != "" {
+		t.Errorf("the books are in %q and %q, want dataset-00001.jsonl and none yet", first.Dataset, second.Dataset)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if second.Dataset != "dataset-00002.jsonl" {
+		t.Errorf("the second book is in %q, want dataset-00002.jsonl", second.Dataset)
+	}
+	index, err := ReadShardIndexFrom(&storage.Local{Dir: dir})
+	if err != nil || len(index) != 2 || index[0].File != "dataset-00001.jsonl" {
+		t.Errorf("the index is %+v, %v", index, err)
+	}
+	// the partial shard is left alone
+	if data, _ := os.ReadFile(filepath.Join(dir, "dataset-00000.jsonl.part")); string(data) != "{}\n" {
+		t.Errorf("the partial shard was overwritten with %q", data)
+	}
+}
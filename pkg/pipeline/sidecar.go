@@ -0,0 +1,46 @@
+package pipeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+)
+
+// SidecarName is the name of the .json file with the metadata of a book,
+// next to its .txt file.
+func SidecarName(file string) string {
+	return strings.TrimSuffix(file, path.Ext(file)) + ".json"
+}
+
+// encodeSidecar is the manifest record of a book as an indented JSON
+// document, so a .txt file copied on its own still says what it is.
+func encodeSidecar(record *ManifestRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(record); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeSidecar writes the .json file of a book to a storage.
+func writeSidecar(store storage.Writer, record *ManifestRecord) error {
+	data, err := encodeSidecar(record)
+	if err != nil {
+		return err
+	}
+	w, err := store.Create(SidecarName(record.File))
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
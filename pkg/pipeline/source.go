@@ -0,0 +1,533 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+	"github.com/coreweave/dataset-downloader/pkg/storage"
+	"golang.org/x/sync/semaphore"
+)
+
+// Options are what a Session is opened with.
+type Options struct {
+	// the directory the books download to, with the manifest, catalog and
+	// journal of the dataset
+	DataDir string
+	// the format of the dataset, like OutputTxt
+	Output string
+	// a storage URL like s3://bucket/prefix to write the dataset to instead
+	// of the data directory, which then only holds the downloads until they
+	// are converted
+	OutputLocation string
+	Sink           SinkOptions
+	Convert        ConvertOptions
+	// the flags of the command, recorded in the dataset card, nil for none
+	Flags *flag.FlagSet
+	// the format of the run report written when the run finishes, like
+	// ReportHTML, "" for none. Convert.Report keeps track of the books
+	Report string
+	// called as the run goes
+	Callbacks
+}
+
+// Session is a run that downloads or converts books into a data directory.
+// The books get the manifest, catalog, journal and conversion of a
+// Smashwords run.
+type Session struct {
+	DataDir  string
+	Flags    *flag.FlagSet
+	Manifest *Manifest
+	Catalog  *Catalog
+	Sink     Sink
+	Opts     ConvertOptions
+	// where the dataset goes, and whether it is remote storage the
+	// manifest is copied to as well
+	Store  storage.Writer
+	Remote bool
+	// format of the run report, with Opts.Report set
+	Report string
+}
+
+// PartialFailure is the error of a run that completed with books that
+// failed, which are in the errors of the catalog.
+type PartialFailure struct {
+	Failed int
+}
+
+func (e *PartialFailure) Error() string {
+	return fmt.Sprintf("%d books failed", e.Failed)
+}
+
+// DefaultConvertOptions are the options of the flags of a Smashwords run
+// left at their defaults. The transforms that change or drop text of the
+// book are off, so the text is what the converter always gave.
+func DefaultConvertOptions(flags *flag.FlagSet) ConvertOptions {
+	return ConvertOptions{
+		OverwriteSource: true,
+		Notes:           NotesKeep,
+		Tables:          TablesText,
+		Images:          ImagesDrop,
+		Whitespace:      WhitespacePreserve,
+		Unicode:         UnicodeNone,
+		Punctuation:     PunctuationKeep,
+		Provenance:      NewProvenance(flags),
+		Tokenizer:       WhitespaceTokenizer{},
+	}
+}
+
+// OpenSession opens the manifest of a data directory, with its catalog and
+// journal, and the sink of the dataset. With remote storage a fresh data
+// directory picks up the manifest of the runs before it from there.
+func OpenSession(options Options) (*Session, error) {
+	location := options.OutputLocation
+	if location == "" {
+		location = options.DataDir
+	}
+	store, err := storage.Open(location)
+	if err != nil {
+		return nil, err
+	}
+	remote := storage.IsURL(location)
+	if err := os.MkdirAll(options.DataDir, 0700); err != nil {
+		return nil, err
+	}
+	for _, name := range []string{ManifestFileName, JournalFileName} {
+		path := filepath.Join(options.DataDir, name)
+		if _, err := os.Stat(path); remote && os.IsNotExist(err) {
+			if err := storage.CopyFrom(store, name, path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+	}
+	if options.Sink.Compression != "" && options.Sink.Compression != CompressNone &&
+		CompressionExt(options.Sink.Compression) == "" {
+		return nil, fmt.Errorf("unsupported compression %s", options.Sink.Compression)
+	}
+	manifest, catalog, err := openManifest(options.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	// the books that make it into the dataset go to the sink
+	sink, err := NewSink(options.Output, store, options.Sink)
+	if err != nil {
+		catalog.Close()
+		return nil, err
+	}
+	opts := options.Convert
+	opts.Callbacks = &options.Callbacks
+	return &Session{
+		DataDir:  options.DataDir,
+		Flags:    options.Flags,
+		Manifest: manifest,
+		Catalog:  catalog,
+		Sink:     sink,
+		Opts:     opts,
+		Store:    store,
+		Remote:   remote,
+		Report:   options.Report,
+	}, nil
+}
+
+// openManifest opens the manifest of a data directory, creating the
+// directory if need be. The manifest is mirrored in the catalog, which the
+// caller closes, and its changes are appended to the journal.
+func openManifest(dataDir string) (*Manifest, *Catalog, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, nil, err
+	}
+	manifest, err := LoadManifest(dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	catalog, err := OpenCatalog(dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifest.UseCatalog(catalog)
+	journal, err := LoadJournal(dataDir)
+	if err != nil {
+		catalog.Close()
+		return nil, nil, err
+	}
+	manifest.UseJournal(journal)
+	return manifest, catalog, nil
+}
+
+// Crawl downloads the books a source finds, and reports whether any of them
+// are epubs to convert once the crawl is done. With Opts.Stream the epubs
+// are converted as they are downloaded, and the conversion at the end only
+// picks up the ones an earlier run stopped at.
+func (s *Session) Crawl(ctx context.Context, src source.Source) (bool, error) {
+	return crawlSource(ctx, src, s.DataDir, s.Manifest, s.Sink, s.Opts)
+}
+
+// Fetch downloads a book a source found, like Crawl does for every book.
+func (s *Session) Fetch(ctx context.Context, src source.Source, book *source.Book) error {
+	if s.Opts.Progress != nil {
+		ctx = source.WithProgress(ctx, s.Opts.Progress)
+	}
+	return fetchBook(ctx, src, book, s.DataDir, s.Manifest, s.Sink, s.Opts)
+}
+
+// Finish converts the epubs downloaded in the run, if any, and saves the
+// manifest, the dataset card, the run report and the checksums of the data
+// directory. With remote storage the card, manifest and journal are copied
+// next to the dataset. A run with books that failed returns a
+// PartialFailure, and one ctx stopped while converting its error.
+func (s *Session) Finish(ctx context.Context, convertEpubs bool) error {
+	defer s.Catalog.Close()
+	if err := s.Manifest.Save(); err != nil {
+		return err
+	}
+	if convertEpubs {
+		if err := ConvertEpubGo(ctx, s.DataDir, s.Opts, s.Manifest, s.Sink); err != nil {
+			return s.stop(err)
+		}
+	}
+	s.Opts.PostProcessors.Close()
+	s.Opts.Progress.Stop()
+	s.Opts.Tracer.Shutdown()
+	if err := s.Opts.Metrics.Close(); err != nil {
+		slog.Warn("Could not stop serving the metrics", "error", err)
+	}
+	if err := s.Opts.Events.Close(); err != nil {
+		slog.Warn("Could not close the events", "error", err)
+	}
+	if err := s.Sink.Close(); err != nil {
+		return err
+	}
+	if err := s.Manifest.Save(); err != nil {
+		return err
+	}
+	summary := SummarizeDataset(s.Manifest, FlagParameters(s.Flags))
+	if err := WriteDatasetCard(&storage.Local{Dir: s.DataDir}, summary); err != nil {
+		return err
+	}
+	if s.Remote {
+		if err := WriteDatasetCard(s.Store, summary); err != nil {
+			return err
+		}
+		manifestPath := filepath.Join(s.DataDir, ManifestFileName)
+		if err := storage.CopyTo(manifestPath, s.Store, ManifestFileName); err != nil {
+			return err
+		}
+		journalPath := filepath.Join(s.DataDir, JournalFileName)
+		if _, err := os.Stat(journalPath); err == nil {
+			if err := storage.CopyTo(journalPath, s.Store, JournalFileName); err != nil {
+				return err
+			}
+		}
+	}
+	if s.Report != "" {
+		path, err := s.Opts.Report.Save(s.DataDir, s.Report)
+		if err != nil {
+			return err
+		}
+		slog.Info("Wrote the report of the run", "path", path)
+	}
+	if err := WriteChecksums(s.DataDir); err != nil {
+		return err
+	}
+	if failed := s.Manifest.Failures(); failed > 0 {
+		s.Opts.Hooks.Finish(NotifyPartial)
+		s.Opts.Notifier.Notify(NotifyPartial, nil)
+		return &PartialFailure{Failed: failed}
+	}
+	s.Opts.Hooks.Finish(NotifyCompleted)
+	s.Opts.Notifier.Notify(NotifyCompleted, nil)
+	return nil
+}
+
+// Abort ends a run that a crawl error stopped: the dataset file being
+// written is closed and the manifest saved so the next run picks up where
+// it left off, and the error goes to the event stream and the
+// notification. It returns err.
+func (s *Session) Abort(err error) error {
+	defer s.Catalog.Close()
+	return s.stop(err)
+}
+
+// stop is Abort without closing the catalog.
+func (s *Session) stop(err error) error {
+	s.Opts.Events.Emit(Event{Type: EventError, Error: err.Error()})
+	s.Opts.Callbacks.failed("", "", err)
+	s.Opts.PostProcessors.Close()
+	// the books of the shard being written are only in the dataset once it
+	// is closed
+	if closeErr := s.Sink.Close(); closeErr != nil {
+		slog.Error("Could not close the dataset", "error", closeErr)
+	}
+	if saveErr := s.Manifest.Save(); saveErr != nil {
+		slog.Error("Could not save the manifest", "error", saveErr)
+	}
+	s.Opts.Progress.Stop()
+	s.Opts.Tracer.Shutdown()
+	if closeErr := s.Opts.Metrics.Close(); closeErr != nil {
+		slog.Warn("Could not stop serving the metrics", "error", closeErr)
+	}
+	status := NotifyFailed
+	if errors.Is(err, source.ErrRateLimited) {
+		status = NotifyRateLimited
+	}
+	s.Opts.Hooks.Finish(status)
+	s.Opts.Notifier.Notify(status, err)
+	if closeErr := s.Opts.Events.Close(); closeErr != nil {
+		slog.Warn("Could not close the events", "error", closeErr)
+	}
+	return err
+}
+
+// bookInfo is what a source says about a book, as the manifest records it.
+func bookInfo(book *source.Book) BookInfo {
+	return BookInfo{
+		BookID:     book.BookID,
+		Title:      book.Title,
+		Author:     book.Author,
+		URL:        book.URL,
+		Categories: book.Categories,
+		Price:      book.Price,
+		License:    book.License,
+		Published:  book.Published,
+	}
+}
+
+// fetchBook downloads a book a source found to the data directory, unless
+// it is there under one of its names already, was rejected before or went
+// into a dataset file. Plain text is converted right away, epubs once they
+// are all downloaded, or right away too with opts.Stream. Books that fail to
+// download are logged and reported; the errors are source.ErrRateLimited and
+// the error of ctx once it is done, which stop the run.
+func fetchBook(ctx context.Context, src source.Source, book *source.Book, dataDir string, manifest *Manifest, sink Sink, opts ConvertOptions) error {
+	title := book.Title
+	logger := BookLogger(book.ID, book.DownloadURL, LogPhaseDownload)
+	outcome := OutcomeSkipped
+	ctx, span := opts.Tracer.Start(ctx, "download")
+	span.SetAttribute("book.id", book.ID)
+	span.SetAttribute("book.url", book.DownloadURL)
+	span.SetAttribute("book.format", book.Format)
+	defer func() {
+		opts.Metrics.Download(outcome, book.Format)
+		// the quotas the commands know of are of epubs
+		if outcome == OutcomeSucceeded && book.Format == "epub" {
+			opts.Progress.quotaUsed()
+		}
+		span.SetAttribute("outcome", outcome)
+		span.End()
+	}()
+	if book.ID == "" {
+		logger.Debug("Skipping book without an id", "title", title)
+		opts.Report.Skip(title, "", "no id")
+		return nil
+	}
+	if book.Format != "epub" && book.Format != "txt" {
+		logger.Debug("Skipping book in a format that can't be converted", "title", title, "format", book.Format)
+		opts.Report.Skip(title, "", "format: "+book.Format)
+		return nil
+	}
+	textFileName := book.ID + ".txt"
+	fileName := book.ID + "." + book.Format
+	filePath := filepath.Join(dataDir, fileName)
+
+	// We check if the book is in the data directory already before
+	// downloading it (in either format, or under the names of books
+	// downloaded before there were ids), or was rejected or written to a
+	// dataset file, and claim it so no other download fetches it meanwhile
+	names := append([]string{book.ID}, book.Aliases...)
+	if file, reason := manifest.Claim(names); reason != "" {
+		logger.Debug("Skipping book the data directory has already", "title", title, "file", file,
+			"reason", reason)
+		opts.Report.Skip(title, file, reason)
+		return nil
+	}
+	defer manifest.Release(names)
+
+	// We download to a partial file first so an interrupted download never
+	// looks like a finished book
+	partialFilePath := filePath + ".part"
+	fail := func(err error) error {
+		outcome = OutcomeFailed
+		span.SetError(err)
+		os.Remove(partialFilePath)
+		opts.Report.Fail(title, fileName, "download", err.Error())
+		opts.Events.Emit(Event{Type: EventError, BookID: book.ID, Title: title, URL: book.DownloadURL,
+			Format: book.Format, File: fileName, Phase: LogPhaseDownload, Error: err.Error()})
+		opts.Callbacks.failed(book.ID, LogPhaseDownload, err)
+		if err := manifest.LogError(fileName, "download", err.Error()); err != nil {
+			return fmt.Errorf("logging the error of %s: %w", fileName, err)
+		}
+		return nil
+	}
+	opts.Events.Emit(Event{Type: EventDownloadStarted, BookID: book.ID, Title: title, URL: book.DownloadURL,
+		Format: book.Format, File: fileName})
+	// the epub, when it is converted from memory
+	var epub []byte
+	inMemory := book.Format == "epub" && opts.Stream == StreamMemory
+	var err error
+	if inMemory {
+		epub, err = fetchBytes(ctx, src, book)
+	} else {
+		err = fetchFile(ctx, src, book, partialFilePath)
+	}
+	if errors.Is(err, source.ErrRateLimited) {
+		outcome = "rate_limited"
+		os.Remove(partialFilePath)
+		return err
+	} else if err != nil && ctx.Err() != nil {
+		// the run stopped, the book didn't fail
+		os.Remove(partialFilePath)
+		return ctx.Err()
+	} else if err != nil {
+		logger.Warn("Could not download book", "title", title, "error", err)
+		return fail(err)
+	}
+	// Truncated epubs are thrown away right away so they get downloaded
+	// again on the next run
+	if inMemory {
+		if err := validateEpub(bytes.NewReader(epub), int64(len(epub))); err != nil {
+			logger.Warn("Invalid epub, flagged for re-download", "title", title, "error", err)
+			return fail(err)
+		}
+	} else if book.Format == "epub" {
+		if err := ValidateEpub(partialFilePath); err != nil {
+			logger.Warn("Invalid epub, flagged for re-download", "title", title, "error", err)
+			return fail(err)
+		}
+	}
+	downloadedAt := time.Now().UTC()
+	outcome = OutcomeSucceeded
+	opts.Events.Emit(Event{Type: EventDownloadComplete, BookID: book.ID, Title: title, URL: book.DownloadURL,
+		Format: book.Format, File: fileName})
+
+	if book.Format == "epub" {
+		// remember where the book came from until it is converted
+		manifest.Put(&ManifestRecord{
+			File:         textFileName,
+			ID:           book.ID,
+			Source:       fileName,
+			Format:       book.Format,
+			BookInfo:     bookInfo(book),
+			DownloadedAt: downloadedAt,
+			Provenance:   opts.Provenance.ForSource(book.DownloadURL),
+		})
+		if inMemory {
+			opts.Callbacks.downloadComplete(book, "")
+			logger.Info("Downloaded book", "title", title)
+			if err := streamEpub(ctx, bytes.NewReader(epub), int64(len(epub)), fileName, dataDir, manifest, sink, opts); err != nil {
+				// the epub is gone, so the next run downloads it again
+				manifest.Delete(textFileName)
+				return err
+			}
+			return nil
+		}
+		if err := os.Rename(partialFilePath, filePath); err != nil {
+			return fmt.Errorf("moving %s in place: %w", fileName, err)
+		}
+		opts.Callbacks.downloadComplete(book, filePath)
+		if opts.Stream == StreamFile {
+			logger.Info("Downloaded book", "title", title)
+			// a book the run stopped converting stays for the conversion at
+			// the end of the next run
+			if err := streamFile(ctx, filePath, dataDir, manifest, sink, opts); err != nil {
+				return err
+			}
+			if err := os.Remove(filePath); err != nil {
+				return fmt.Errorf("removing the converted %s: %w", fileName, err)
+			}
+			return nil
+		}
+	} else {
+		// Plain text downloads come in all sorts of encodings, so we convert
+		// them to UTF-8 and set aside the ones we can't make sense of. The
+		// rest get the same cleanup as converted epubs.
+		opts.Callbacks.downloadComplete(book, partialFilePath)
+		opts.Progress.Add(PhaseConverted, 1)
+		started := time.Now()
+		_, convertSpan := opts.Tracer.Start(ctx, "convert")
+		err := ConvertTextDownload(ctx, bookInfo(book), book.ID, fileName, partialFilePath, book.DownloadURL, downloadedAt, dataDir, manifest, sink, opts)
+		convertSpan.End()
+		if err != nil {
+			return err
+		}
+		opts.Metrics.Converted("txt", time.Since(started))
+		opts.Progress.Done(PhaseConverted, 1)
+	}
+
+	logger.Info("Downloaded book", "title", title)
+	return nil
+}
+
+// fetchFile writes what a source fetches for a book to path.
+func fetchFile(ctx context.Context, src source.Source, book *source.Book, path string) error {
+	r, err := src.Fetch(ctx, book)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// crawlSource downloads the books a source finds, and reports whether any
+// of them are epubs to convert once the crawl is done.
+func crawlSource(ctx context.Context, src source.Source, dataDir string, manifest *Manifest, sink Sink, opts ConvertOptions) (bool, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return false, err
+	}
+	if opts.Progress != nil {
+		ctx = source.WithProgress(ctx, opts.Progress)
+	}
+	ctx = source.WithConcurrency(ctx, opts.Concurrency.List)
+	ctx, span := opts.Tracer.Start(ctx, "discover")
+	defer span.End()
+	// found is called from the pages Discover lists at once, and downloads
+	// up to Concurrency.Download books between them
+	downloads := semaphore.NewWeighted(int64(opts.Concurrency.downloads()))
+	var mu sync.Mutex
+	epubs := false
+	err := src.Discover(ctx, func(book *source.Book) error {
+		if !opts.Shard.Has(book) {
+			BookLogger(book.ID, book.DownloadURL, LogPhaseList).Debug("Skipping book of another shard",
+				"title", book.Title)
+			return nil
+		}
+		if !opts.Callbacks.bookDiscovered(book) {
+			BookLogger(book.ID, book.DownloadURL, LogPhaseList).Debug("Skipping book OnBookDiscovered left out",
+				"title", book.Title)
+			return nil
+		}
+		if book.Format == "epub" {
+			mu.Lock()
+			epubs = true
+			mu.Unlock()
+		}
+		opts.Progress.Done(PhaseDiscovered, 1)
+		opts.Events.Emit(Event{Type: EventBookDiscovered, BookID: book.ID, Title: book.Title, URL: book.DownloadURL,
+			Format: book.Format})
+		opts.Progress.Add(PhaseDownloaded, 1)
+		defer opts.Progress.Done(PhaseDownloaded, 1)
+		if err := downloads.Acquire(ctx, 1); err != nil {
+			return err
+		}
+		defer downloads.Release(1)
+		return fetchBook(ctx, src, book, dataDir, manifest, sink, opts)
+	})
+	span.SetError(err)
+	return epubs, err
+}
@@ -0,0 +1,39 @@
+package pipeline
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+func TestSessionAbort(t *testing.T) {
+	dir := t.TempDir()
+	session, err := OpenSession(Options{DataDir: dir, Output: OutputJSONL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &ManifestRecord{File: "1.txt", ID: "1", Format: "txt"}
+	if err := session.Sink.Write(record, "It rained."); err != nil {
+		t.Fatal(err)
+	}
+	session.Manifest.Put(record)
+
+	// a rate limit in the middle of the shard
+	if err := session.Abort(source.ErrRateLimited); !errors.Is(err, source.ErrRateLimited) {
+		t.Fatalf("Abort returned %v", err)
+	}
+	shard := DatasetPrefix + "-00000.jsonl"
+	if _, err := os.Stat(filepath.Join(dir, shard)); err != nil {
+		t.Errorf("the shard wasn't closed: %v", err)
+	}
+	manifest, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved := manifest.Get("1.txt"); saved == nil || saved.Dataset != shard {
+		t.Errorf("the manifest has %+v, want the book in %s", saved, shard)
+	}
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+// when a crawl converts the epubs it downloads, see ConvertOptions.Stream
+const (
+	// once the crawl is done, all of them
+	StreamOff = "off"
+	// right after downloading each, from its file in the data directory,
+	// which is deleted once the book is converted
+	StreamFile = "file"
+	// right after downloading each, from memory, so the epub is never
+	// written to the data directory
+	StreamMemory = "memory"
+)
+
+// streamEpub converts an epub the crawl just downloaded, read from r, of
+// size bytes, like ConvertEpubGo does once the crawl is done.
+func streamEpub(ctx context.Context, r io.ReaderAt, size int64, fileName, dataDir string, manifest *Manifest, sink Sink, opts ConvertOptions) error {
+	opts.Progress.Add(PhaseConverted, 1)
+	started := time.Now()
+	_, span := opts.Tracer.Start(ctx, "convert")
+	span.SetAttribute("book.file", fileName)
+	_, err := convertEpub(ctx, r, size, fileName, dataDir, opts, manifest, sink)
+	span.End()
+	if err != nil {
+		return err
+	}
+	opts.Metrics.Converted("epub", time.Since(started))
+	opts.Progress.Done(PhaseConverted, 1)
+	return nil
+}
+
+// streamFile converts the epub the crawl just downloaded to path.
+func streamFile(ctx context.Context, path, dataDir string, manifest *Manifest, sink Sink, opts ConvertOptions) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return streamEpub(ctx, f, info.Size(), filepath.Base(path), dataDir, manifest, sink, opts)
+}
+
+// fetchBytes reads what a source fetches for a book into memory.
+func fetchBytes(ctx context.Context, src source.Source, book *source.Book) ([]byte, error) {
+	r, err := src.Fetch(ctx, book)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,178 @@
+package pipeline
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the tokenizers books can be counted with
+const (
+	TokenizerWhitespace string = "whitespace"
+	// byte pair encoding, named after its vocab file like bpe:cl100k_base
+	TokenizerBPEPrefix string = "bpe:"
+
+	// pieces of text whose token counts are kept, the cache is emptied when
+	// it grows past this
+	bpeCacheSize int = 1 << 16
+)
+
+// pretokenizeRegex splits text into the pieces tokenizers work on: words
+// with the space or punctuation mark before them, runs of up to three
+// digits, punctuation and whitespace. It is the pattern of cl100k_base
+// without the lookahead RE2 can't do.
+var pretokenizeRegex = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// Tokenizer counts the tokens of a text, which is what language models are
+// trained on, without needing the model.
+type Tokenizer interface {
+	// Name is recorded in the manifest along with the count
+	Name() string
+	Count(text string) int
+}
+
+// WhitespaceTokenizer estimates the tokens of a text as the pieces it splits
+// into at whitespace and punctuation. That is close to the tokens of a BPE
+// vocab for plain English, where most words are one token.
+type WhitespaceTokenizer struct{}
+
+func (WhitespaceTokenizer) Name() string {
+	return TokenizerWhitespace
+}
+
+func (WhitespaceTokenizer) Count(text string) int {
+	count := 0
+	for _, piece := range pretokenizeRegex.FindAllString(text, -1) {
+		// the whitespace between words is part of the next word
+		if strings.TrimSpace(piece) != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// BPETokenizer counts tokens with the byte pair encoding of a vocab file in
+// the format of tiktoken, like cl100k_base.tiktoken: a base64 token and its
+// rank per line. Pieces are merged by rank the way tiktoken does, so the
+// counts match it but for the rare pieces the pattern splits differently.
+type BPETokenizer struct {
+	name  string
+	ranks map[string]int
+
+	mu    sync.Mutex
+	cache map[string]int
+}
+
+// LoadBPETokenizer reads a vocab file in the format of tiktoken.
+func LoadBPETokenizer(path string) (*BPETokenizer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := &BPETokenizer{
+		name:  TokenizerBPEPrefix + strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)),
+		ranks: make(map[string]int),
+		cache: make(map[string]int),
+	}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed line in %s: %q", path, line)
+		}
+		b, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("malformed token in %s: %w", path, err)
+		}
+		r, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("malformed rank in %s: %w", path, err)
+		}
+		t.ranks[string(b)] = r
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.ranks) == 0 {
+		return nil, fmt.Errorf("no tokens in %s", path)
+	}
+	return t, nil
+}
+
+func (t *BPETokenizer) Name() string {
+	return t.name
+}
+
+func (t *BPETokenizer) Count(text string) int {
+	count := 0
+	for _, piece := range pretokenizeRegex.FindAllString(text, -1) {
+		count += t.countPiece(piece)
+	}
+	return count
+}
+
+// countPiece returns the number of tokens of a piece of text, looking it up
+// in the cache first since the same words come up over and over.
+func (t *BPETokenizer) countPiece(piece string) int {
+	if _, ok := t.ranks[piece]; ok {
+		return 1
+	}
+	t.mu.Lock()
+	count, ok := t.cache[piece]
+	t.mu.Unlock()
+	if ok {
+		return count
+	}
+	count = len(t.merge(piece))
+	t.mu.Lock()
+	if len(t.cache) >= bpeCacheSize {
+		t.cache = make(map[string]int)
+	}
+	t.cache[piece] = count
+	t.mu.Unlock()
+	return count
+}
+
+// merge splits a piece into its bytes and merges the adjacent pair with the
+// lowest rank until no pair is in the vocab.
+func (t *BPETokenizer) merge(piece string) []string {
+	parts := make([]string, len(piece))
+	for i := 0; i < len(piece); i++ {
+		parts[i] = piece[i : i+1]
+	}
+	for len(parts) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := t.ranks[parts[i]+parts[i+1]]; ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+	return parts
+}
+
+// countTokens records the tokens of a book in its record, if there is a
+// tokenizer.
+func countTokens(record *ManifestRecord, text string, tokenizer Tokenizer) {
+	if tokenizer == nil {
+		return
+	}
+	record.Tokens = tokenizer.Count(text)
+	record.Tokenizer = tokenizer.Name()
+}
@@ -0,0 +1,149 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// the service the spans are from, unless $OTEL_SERVICE_NAME says
+	// otherwise
+	tracingServiceName = "smashwords-downloader"
+	// how often the spans are sent, and how many are sent at once at most
+	tracingExportInterval = 5 * time.Second
+	tracingBatchSize      = 512
+)
+
+// Span is a timed step of a run, like the download of a book. Its methods
+// do nothing on a nil span, so it can be left out.
+type Span struct {
+	span trace.Span
+}
+
+// SetAttribute sets an attribute of the span to a string, int or bool.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	switch value := value.(type) {
+	case int:
+		s.span.SetAttributes(attribute.Int(key, value))
+	case bool:
+		s.span.SetAttributes(attribute.Bool(key, value))
+	default:
+		s.span.SetAttributes(attribute.String(key, fmt.Sprint(value)))
+	}
+}
+
+// SetError marks the span as failed with err.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End ends the span and queues it for export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.span.End()
+}
+
+// Tracer sends spans of the discovery, downloads and conversions of a run
+// to an OpenTelemetry collector, as OTLP over HTTP. The spans of a run are
+// in one trace, under a span of the whole run. Its methods do nothing on a
+// nil tracer, so it can be left out.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+	// the span of the whole run
+	root *Span
+}
+
+// NewTracer starts sending spans of a run of a command to the OTLP/HTTP
+// endpoint of a collector, like http://localhost:4318. An empty endpoint is
+// no tracing.
+func NewTracer(endpoint string, command string) (*Tracer, error) {
+	if endpoint == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		return nil, fmt.Errorf("unsupported OTLP endpoint %s, it should be an http or https url", endpoint)
+	}
+	url := strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	// the exporter has a transport of its own, so the exports aren't
+	// counted in the metrics or traced themselves
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(url),
+		otlptracehttp.WithTimeout(10*time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("creating the OTLP exporter: %w", err)
+	}
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = tracingServiceName
+	}
+	// a collector that can't be reached is logged once, and the run goes on
+	// without it
+	var once sync.Once
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		once.Do(func() {
+			slog.Warn("Could not export spans", "url", url, "error", err)
+		})
+	}))
+	t := &Tracer{
+		provider: sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter,
+				sdktrace.WithBatchTimeout(tracingExportInterval),
+				sdktrace.WithMaxExportBatchSize(tracingBatchSize)),
+			sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+		),
+	}
+	t.tracer = t.provider.Tracer(tracingServiceName)
+	_, t.root = t.Start(context.Background(), command)
+	return t, nil
+}
+
+// Start starts a span under the span of ctx, or that of the run, and
+// returns a context with the new span for its children.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := ctx
+	if !trace.SpanContextFromContext(ctx).IsValid() && t.root != nil {
+		parent = trace.ContextWithSpan(ctx, t.root.span)
+	}
+	ctx, span := t.tracer.Start(parent, name)
+	return ctx, &Span{span: span}
+}
+
+// Shutdown ends the span of the run, sends the spans that are left and
+// stops sending them.
+func (t *Tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	t.root.End()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := t.provider.Shutdown(ctx); err != nil {
+		slog.Warn("Could not export spans", "error", err)
+	}
+}
@@ -0,0 +1,77 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestTracer(t *testing.T) {
+	var mu sync.Mutex
+	var exported []*coltracepb.ExportTraceServiceRequest
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			t.Errorf("spans were sent to %s", r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		req := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		exported = append(exported, req)
+		mu.Unlock()
+	}))
+	defer collector.Close()
+
+	tracer, err := NewTracer(collector.URL, "smashwords")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, download := tracer.Start(context.Background(), "download")
+	download.SetAttribute("book_id", 42)
+	_, convert := tracer.Start(ctx, "convert")
+	convert.End()
+	download.End()
+	tracer.Shutdown()
+
+	// the spans by name, and the names of their parents
+	spans := make(map[string]string)
+	ids := make(map[string]string)
+	parents := make(map[string]string)
+	for _, req := range exported {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					ids[string(span.SpanId)] = span.Name
+					parents[span.Name] = string(span.ParentSpanId)
+				}
+			}
+		}
+	}
+	for name, parent := range parents {
+		spans[name] = ids[parent]
+	}
+	want := map[string]string{"smashwords": "", "download": "smashwords", "convert": "download"}
+	for name, parent := range want {
+		if got, ok := spans[name]; !ok || got != parent {
+			t.Errorf("span %s has parent %q, want %q", name, got, parent)
+		}
+	}
+
+	if _, err := NewTracer("localhost:4318", "smashwords"); err == nil {
+		t.Error("an endpoint without a scheme was accepted")
+	}
+	// a nil tracer does nothing
+	var none *Tracer
+	_, span := none.Start(context.Background(), "download")
+	span.SetAttribute("book_id", 42)
+	span.End()
+	none.Shutdown()
+}
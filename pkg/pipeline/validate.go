@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/taylorskalyo/goreader/epub"
+)
+
+const (
+	epubMimetype      string = "application/epub+zip"
+	epubContainerPath string = "META-INF/container.xml"
+)
+
+// ValidateEpub does a quick structural check of a downloaded epub: it has to be
+// a readable zip with a mimetype entry, a container pointing at a parsable OPF
+// and a spine with at least one item. Truncated or throttled downloads fail one
+// of these checks, so we can catch them right after downloading instead of when
+// converting.
+func ValidateEpub(filepath string) error {
+	f, err := os.Open(filepath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return validateEpub(f, info.Size())
+}
+
+// validateEpub is ValidateEpub for an epub read from r, of size bytes.
+func validateEpub(r io.ReaderAt, size int64) error {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("not a valid zip archive: %w", err)
+	}
+
+	var mimetype, container *zip.File
+	for _, f := range z.File {
+		switch f.Name {
+		case "mimetype":
+			mimetype = f
+		case epubContainerPath:
+			container = f
+		}
+	}
+
+	if mimetype == nil {
+		return errors.New("missing mimetype entry")
+	}
+	m, err := mimetype.Open()
+	if err != nil {
+		return fmt.Errorf("unreadable mimetype entry: %w", err)
+	}
+	content, err := io.ReadAll(m)
+	m.Close()
+	if err != nil {
+		return fmt.Errorf("unreadable mimetype entry: %w", err)
+	}
+	if strings.TrimSpace(string(content)) != epubMimetype {
+		return fmt.Errorf("unexpected mimetype %q", content)
+	}
+
+	// goreader panics instead of erroring when the container is missing
+	if container == nil {
+		return fmt.Errorf("missing %s", epubContainerPath)
+	}
+
+	// goreader parses the container and the OPF, and checks that the spine
+	// has itemrefs that point at manifest items
+	rc, err := epub.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("unparsable package: %w", err)
+	}
+
+	for _, itemref := range rc.Rootfiles[0].Spine.Itemrefs {
+		f, err := itemref.Open()
+		if err != nil {
+			return fmt.Errorf("spine item %s: %w", itemref.HREF, err)
+		}
+		f.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+package pipeline
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+// testPackage returns an OPF with the manifest and spine elements given.
+func testPackage(manifest string, spine string) string {
+	return `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Test</dc:title></metadata>
+` + manifest + "\n" + spine + `
+</package>`
+}
+
+const (
+	testManifest = `<manifest><item id="ch1" href="ch1.html" media-type="application/xhtml+xml"/></manifest>`
+	testSpine    = `<spine><itemref idref="ch1"/></spine>`
+)
+
+// testZip returns a zip of files, pairs of names and contents, in order.
+func testZip(t *testing.T, files ...[2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	z := zip.NewWriter(&buf)
+	for _, file := range files {
+		w, err := z.Create(file[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(file[1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testEpub returns an epub of a single chapter with body.
+func testEpub(t *testing.T, body string) []byte {
+	return testZip(t,
+		[2]string{"mimetype", epubMimetype},
+		[2]string{epubContainerPath, testContainer},
+		[2]string{"OEBPS/content.opf", testPackage(testManifest, testSpine)},
+		[2]string{"OEBPS/ch1.html", `<html xmlns="http://www.w3.org/1999/xhtml"><body>` + body + `</body></html>`},
+	)
+}
+
+func TestValidateEpub(t *testing.T) {
+	chapter := [2]string{"OEBPS/ch1.html", `<html xmlns="http://www.w3.org/1999/xhtml"><body><p>Hi.</p></body></html>`}
+	tests := []struct {
+		name  string
+		epub  []byte
+		error string
+	}{
+		{"valid", testEpub(t, "<p>Hi.</p>"), ""},
+		{"not a zip", []byte("<html><body>Too many downloads</body></html>"), "not a valid zip archive"},
+		{"missing mimetype", testZip(t,
+			[2]string{epubContainerPath, testContainer},
+			[2]string{"OEBPS/content.opf", testPackage(testManifest, testSpine)},
+			chapter,
+		), "missing mimetype"},
+		{"wrong mimetype", testZip(t,
+			[2]string{"mimetype", "application/zip"},
+			[2]string{epubContainerPath, testContainer},
+			[2]string{"OEBPS/content.opf", testPackage(testManifest, testSpine)},
+			chapter,
+		), "unexpected mimetype"},
+		{"missing container", testZip(t,
+			[2]string{"mimetype", epubMimetype},
+			[2]string{"OEBPS/content.opf", testPackage(testManifest, testSpine)},
+			chapter,
+		), "missing " + epubContainerPath},
+		{"missing package", testZip(t,
+			[2]string{"mimetype", epubMimetype},
+			[2]string{epubContainerPath, testContainer},
+			chapter,
+		), "unparsable package"},
+		{"missing spine", testZip(t,
+			[2]string{"mimetype", epubMimetype},
+			[2]string{epubContainerPath, testContainer},
+			[2]string{"OEBPS/content.opf", testPackage(testManifest, "")},
+			chapter,
+		), "unparsable package"},
+		{"no itemrefs", testZip(t,
+			[2]string{"mimetype", epubMimetype},
+			[2]string{epubContainerPath, testContainer},
+			[2]string{"OEBPS/content.opf", testPackage(testManifest, "<spine></spine>")},
+			chapter,
+		), "unparsable package"},
+		{"missing content file", testZip(t,
+			[2]string{"mimetype", epubMimetype},
+			[2]string{epubContainerPath, testContainer},
+			[2]string{"OEBPS/content.opf", testPackage(testManifest, testSpine)},
+		), "spine item ch1.html"},
+		{"truncated", testEpub(t, "<p>Hi.</p>")[:100], "not a valid zip archive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "book.epub")
+			if err := os.WriteFile(path, tt.epub, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			err := ValidateEpub(path)
+			if tt.error == "" {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.error) {
+				t.Errorf("got %v, want an error about %q", err, tt.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package pipeline
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/coreweave/dataset-downloader/pkg/epub2text"
+)
+
+// the whitespace policies, see epub2text
+const (
+	WhitespaceCollapse = epub2text.WhitespaceCollapse
+	WhitespaceTrim     = epub2text.WhitespaceTrim
+	WhitespacePreserve = epub2text.WhitespacePreserve
+)
+
+var blankLinesRegex = regexp.MustCompile(`\n{3,}`)
+
+// NormalizeWhitespace applies a whitespace policy to a converted document.
+// Runs of whitespace inside lines are already collapsed by the parser in
+// collapse mode, so tables and preformatted text keep their alignment.
+func NormalizeWhitespace(text string, policy string) string {
+	if policy == WhitespacePreserve {
+		return text
+	}
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\f\v\r")
+	}
+	text = strings.Join(lines, "\n")
+	if policy == WhitespaceTrim {
+		return text
+	}
+
+	text = blankLinesRegex.ReplaceAllString(text, "\n\n")
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return ""
+	}
+	return text + "\n"
+}
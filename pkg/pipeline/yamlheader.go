@@ -0,0 +1,59 @@
+package pipeline
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const yamlHeaderDelimiter string = "---\n"
+
+// the lines of a header written by YAMLHeader
+var yamlHeaderLineRegex = regexp.MustCompile(`^[a-z_]+: `)
+
+// YAMLHeader is the front matter -yaml-header puts at the top of the .txt
+// file of a book: its id, title, author, book page, license and the date it was
+// crawled, followed by a blank line.
+func YAMLHeader(record *ManifestRecord) string {
+	var header strings.Builder
+	header.WriteString(yamlHeaderDelimiter)
+	field := func(key string, value string) {
+		if value != "" {
+			// a double quoted YAML string takes the escapes of a Go string
+			header.WriteString(key + ": " + strconv.Quote(value) + "\n")
+		}
+	}
+	field("id", record.DocumentID())
+	field("title", record.Title)
+	field("author", record.Author)
+	field("url", record.URL)
+	field("license", record.License)
+	crawled := record.DownloadedAt
+	if crawled.IsZero() {
+		crawled = record.ConvertedAt
+	}
+	if !crawled.IsZero() {
+		field("crawled", crawled.UTC().Format("2006-01-02"))
+	}
+	header.WriteString(yamlHeaderDelimiter + "\n")
+	return header.String()
+}
+
+// StripYAMLHeader takes the header written by YAMLHeader off the text of a
+// book, if it has one.
+func StripYAMLHeader(text string) string {
+	if !strings.HasPrefix(text, yamlHeaderDelimiter) {
+		return text
+	}
+	end := strings.Index(text[len(yamlHeaderDelimiter):], "\n"+yamlHeaderDelimiter)
+	if end < 0 {
+		return text
+	}
+	end += len(yamlHeaderDelimiter)
+	for _, line := range strings.Split(text[len(yamlHeaderDelimiter):end], "\n") {
+		if !yamlHeaderLineRegex.MatchString(line) {
+			return text
+		}
+	}
+	return strings.TrimPrefix(text[end+1+len(yamlHeaderDelimiter):], "\n")
+}
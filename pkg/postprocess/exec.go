@@ -0,0 +1,115 @@
+package postprocess
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// the longest reply line of an external processor, a book and then some
+const maxReplySize = 256 << 20
+
+// External is a Processor that is a program of its own, started once and
+// sent the books one at a time. Every book is a line of JSON, a Document,
+// on its standard input, and it answers each with a line on its standard
+// output: the Document as it goes into the dataset, with "rejected" set to
+// a reason to leave it out, or "error" to fail it. What it writes to its
+// standard error goes to ours.
+type External struct {
+	command string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Scanner
+	// why the program can't take books any more
+	broken error
+}
+
+// externalReply is a line an external processor answers with.
+type externalReply struct {
+	*Document
+	Rejected string `json:"rejected,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Exec starts an external processor, a shell command like
+// './clean.py --strict'.
+func Exec(command string) (*External, error) {
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start post-processor %s: %w", command, err)
+	}
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(nil, maxReplySize)
+	return &External{command: command, cmd: cmd, stdin: stdin, stdout: scanner}, nil
+}
+
+func (e *External) Process(ctx context.Context, doc *Document) (*Document, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.broken != nil {
+		return nil, e.broken
+	}
+	reply, err := e.exchange(doc)
+	if err != nil {
+		// the program is out of step with the books, so none after can
+		// trust its answers
+		e.broken = fmt.Errorf("post-processor %s: %w", e.command, err)
+		return nil, e.broken
+	}
+	switch {
+	case reply.Error != "":
+		return nil, errors.New(reply.Error)
+	case reply.Rejected != "":
+		return nil, Reject(reply.Rejected)
+	case reply.Document == nil:
+		return nil, errors.New("empty reply")
+	}
+	return reply.Document, nil
+}
+
+// exchange sends a book and reads the answer to it.
+func (e *External) exchange(doc *Document) (*externalReply, error) {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := e.stdin.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	if !e.stdout.Scan() {
+		if err := e.stdout.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	reply := &externalReply{}
+	if err := json.Unmarshal(e.stdout.Bytes(), reply); err != nil {
+		return nil, fmt.Errorf("invalid reply: %w", err)
+	}
+	return reply, nil
+}
+
+// Close closes the standard input of the program and waits for it to exit.
+func (e *External) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stdin.Close()
+	return e.cmd.Wait()
+}
@@ -0,0 +1,97 @@
+// Package postprocess is the last stage of the conversion: the cleaning and
+// filtering of a team's own that a converted book goes through before the
+// filters of the dataset. New stages implement Processor in a package of
+// their own and Register it, or run as a program of their own that speaks
+// the JSON of Exec.
+package postprocess
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Document is a converted book and what is known of it, as a Processor
+// gets it.
+type Document struct {
+	// the stable id of the book, which a Processor can't change
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	// the url of the book page, which a Processor can't change
+	URL string `json:"url"`
+	// the ISO 639-1 code of the language of the text
+	Language   string   `json:"language"`
+	Categories []string `json:"categories,omitempty"`
+	// the license notes of the book
+	License string `json:"license,omitempty"`
+	Text    string `json:"text"`
+}
+
+// Rejection is the error of a Processor that leaves a book out of the
+// dataset.
+type Rejection struct {
+	Reason string
+}
+
+func (r *Rejection) Error() string {
+	return "rejected: " + r.Reason
+}
+
+// Reject returns the error that leaves a book out of the dataset for a
+// reason, which is recorded in the manifest.
+func Reject(reason string) error {
+	return &Rejection{Reason: reason}
+}
+
+// Processor is a stage of the post-processing.
+type Processor interface {
+	// Process returns the document as it goes into the dataset, which may
+	// be doc changed in place, or a Rejection to leave it out. Any other
+	// error is logged and leaves the book out as well. Process may be
+	// called from more than one goroutine at once.
+	Process(ctx context.Context, doc *Document) (*Document, error)
+}
+
+// Factory adds the flags of a processor to a flag set, and returns what
+// makes the processor once the flags are parsed. The flags of every
+// registered processor are added, so they are named after it.
+type Factory func(flags *flag.FlagSet) func() (Processor, error)
+
+var (
+	mu        sync.Mutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a processor available by name, usually from the init
+// function of its package. Registering a name twice panics.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("post-processor %s registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// Lookup returns the factory of a registered processor.
+func Lookup(name string) (Factory, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Names returns the names of the registered processors, sorted.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,509 @@
+// Package smashwords is the source of the free books of Smashwords: it
+// crawls the pages of a category for the books listed on them and downloads
+// them in plain text, epub or both.
+package smashwords
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+	"github.com/gocolly/colly"
+	"golang.org/x/net/html"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	Host string = "www.smashwords.com"
+	// where the pages of Smashwords are cached between runs
+	CacheDir string = "/tmp/smashwords_cache"
+
+	// what Smashwords serves instead of an epub once it throttles a user
+	ThrottleMessage string = "We are currently throttling downloads for users who download more than 500 per day,"
+
+	// the requests a second made to Smashwords by default, and how many
+	// can go at once after a quiet while
+	DefaultRate  float64 = 5
+	DefaultBurst int     = 10
+)
+
+// the Smashwords id of a book, in the url of its page and its download links
+var bookIDRegex = regexp.MustCompile(`/books/(?:view|download)/([0-9]+)\b`)
+
+// BookID returns the id of a book in a Smashwords url, or "".
+func BookID(url string) string {
+	if m := bookIDRegex.FindStringSubmatch(url); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// DocumentID returns the stable id of a document: the Smashwords id of the
+// book and the format it was downloaded in, like smashwords-123456-epub.
+// Titles change and clash, the id doesn't, so re-crawls and merges can tell
+// the documents apart by it.
+func DocumentID(bookID string, format string) string {
+	return fmt.Sprintf("smashwords-%s-%s", bookID, format)
+}
+
+// legacyName is what books were named before there were ids: their title
+// without any non-alphanumeric characters.
+func legacyName(title string) string {
+	re := regexp.MustCompile(`[^\w]`)
+	return re.ReplaceAllString(title, "")
+}
+
+// Category is a category of Smashwords, as listed on the page of the
+// category it is in.
+type Category struct {
+	ID   int
+	Name string
+	// the number of books the listing says are in it, 0 if it doesn't
+	Books int
+}
+
+var (
+	// the id of a category in the url of its page
+	categoryIDRegex = regexp.MustCompile(`/books/category/([0-9]+)\b`)
+	// the number of books after the name of a category, like (1,234)
+	categoryBooksRegex = regexp.MustCompile(`\(([0-9][0-9,]*)\)`)
+)
+
+// Categories lists the categories linked from the page of the free books of
+// a category, like the subcategories of Fiction (1), with the number of
+// books in them when the page has it.
+func Categories(ctx context.Context, parentID int) ([]Category, error) {
+	c := NewCollector()
+	c.WithTransport(source.ContextTransport(ctx, nil))
+	var categories []Category
+	seen := map[int]bool{parentID: true}
+	c.OnHTML("a[href*='/books/category/']", func(e *colly.HTMLElement) {
+		m := categoryIDRegex.FindStringSubmatch(e.Attr("href"))
+		if m == nil {
+			return
+		}
+		id, err := strconv.Atoi(m[1])
+		if err != nil || seen[id] {
+			return
+		}
+		name := strings.TrimSpace(categoryBooksRegex.ReplaceAllString(e.Text, ""))
+		if name == "" {
+			return
+		}
+		seen[id] = true
+		category := Category{ID: id, Name: name}
+		// the count is in the link or right after it
+		count := categoryBooksRegex.FindStringSubmatch(e.Text)
+		if next := e.DOM.Get(0).NextSibling; count == nil && next != nil && next.Type == html.TextNode {
+			count = categoryBooksRegex.FindStringSubmatch(next.Data)
+		}
+		if count != nil {
+			category.Books, _ = strconv.Atoi(strings.ReplaceAll(count[1], ",", ""))
+		}
+		categories = append(categories, category)
+	})
+	var visitErr error
+	c.OnError(func(r *colly.Response, err error) {
+		visitErr = err
+	})
+	url := fmt.Sprintf("https://%s/books/category/%d/downloads/0/free/any/0", Host, parentID)
+	if err := c.Visit(url); err != nil {
+		return nil, err
+	}
+	if visitErr != nil {
+		return nil, visitErr
+	}
+	return categories, ctx.Err()
+}
+
+// the links to the downloads of a book on its page, by format
+var downloadLinks = map[string]string{
+	"txt":  "a[title='Plain text; contains no formatting']",
+	"epub": "a[title='Supported by many apps and devices (e.g., Apple Books, Barnes and Noble Nook, Kobo, Google Play, etc.)']",
+}
+
+// the orders the books of a category are listed in
+const (
+	// most downloaded first
+	SortDownloads string = "downloads"
+	// most recently published first
+	SortNewest string = "newest"
+)
+
+// Smashwords crawls the pages of a category of Smashwords.
+type Smashwords struct {
+	// the category, as in https://www.smashwords.com/books/category/1245
+	CategoryID int
+	// books listed on a page, and how many pages to crawl
+	ItemsPerPage int
+	Pages        int
+	// txt, epub or all
+	Format string
+	// the order of the books, SortDownloads if empty. The pages of
+	// SortNewest change all the time, so they aren't cached
+	Sort string
+
+	// makes the collectors the pages are crawled with, one for every page
+	// of the category, NewCollector if nil. Its own callbacks are called
+	// before the ones of the crawl
+	Collector func() *colly.Collector
+	// makes the requests of the crawl and the downloads, for custom
+	// authentication, recording or stubbed responses.
+	// http.DefaultTransport if nil
+	Transport http.RoundTripper
+	// paces the requests of the crawl and the downloads, a token bucket of
+	// DefaultRate if nil. source.NoLimit doesn't
+	RateLimiter source.RateLimiter
+
+	defaultLimiter     source.RateLimiter
+	defaultLimiterOnce sync.Once
+}
+
+// NewCollector returns a collector for the pages of Smashwords, which are
+// cached in CacheDir.
+func NewCollector() *colly.Collector {
+	return colly.NewCollector(
+		colly.AllowedDomains(Host),
+		colly.CacheDir(CacheDir),
+	)
+}
+
+// collector returns a collector for a page of the category, whose requests
+// stop once ctx is done.
+func (s *Smashwords) collector(ctx context.Context) *colly.Collector {
+	newCollector := s.Collector
+	if newCollector == nil {
+		newCollector = NewCollector
+	}
+	c := newCollector()
+	c.WithTransport(source.ContextTransport(ctx, s.transport()))
+	return c
+}
+
+// transport returns the transport of the requests to Smashwords, which
+// waits for the rate limiter.
+func (s *Smashwords) transport() http.RoundTripper {
+	limiter := s.RateLimiter
+	if limiter == nil {
+		s.defaultLimiterOnce.Do(func() {
+			s.defaultLimiter = source.NewTokenBucket(DefaultRate, DefaultBurst)
+		})
+		limiter = s.defaultLimiter
+	}
+	return source.LimitTransport(limiter, s.Transport)
+}
+
+func init() {
+	source.Register("smashwords", func(flags *flag.FlagSet) func() (source.Source, error) {
+		urlIDPtr := flags.Int("id", 1245,
+			"The cooresponding ID for the smashswords url you want to scrape"+
+				" (in https://www.smashwords.com/books/category/1245)")
+
+		itemsPerPagePtr := flags.Int("pageitems", 20,
+			"The number of items per page on the smashwords list page")
+
+		pagesPtr := flags.Int("pages", 7,
+			"The number of pages to scrape")
+
+		textFormatPtr := flags.String("format", "txt",
+
+			"The format of the book to download. Options are 'all', 'txt' or 'epub'"+
+				" (default is 'all' for getting all formats avaliable)")
+
+		sortPtr := flags.String("sort", SortDownloads,
+			"The order the books of the category are crawled in: 'downloads' (most downloaded first) or"+
+				" 'newest' (most recently published first)")
+
+		ratePtr := flags.Float64("rate", DefaultRate,
+			"Requests a second to Smashwords at most, for the pages and the downloads. 0 for no limit")
+
+		return func() (source.Source, error) {
+			if _, ok := downloadLinks[*textFormatPtr]; !ok && *textFormatPtr != "all" {
+				return nil, fmt.Errorf("unsupported format %s", *textFormatPtr)
+			}
+			if *sortPtr != SortDownloads && *sortPtr != SortNewest {
+				return nil, fmt.Errorf("unsupported sort %s", *sortPtr)
+			}
+			if *ratePtr < 0 {
+				return nil, fmt.Errorf("invalid rate %g", *ratePtr)
+			}
+			s := &Smashwords{
+				CategoryID:   *urlIDPtr,
+				ItemsPerPage: *itemsPerPagePtr,
+				Pages:        *pagesPtr,
+				Format:       *textFormatPtr,
+				Sort:         *sortPtr,
+				RateLimiter:  source.NoLimit,
+			}
+			if *ratePtr > 0 {
+				s.RateLimiter = source.NewTokenBucket(*ratePtr, DefaultBurst)
+			}
+			return s, nil
+		}
+	})
+}
+
+// formats returns the formats the books are downloaded in.
+func (s *Smashwords) formats() []string {
+	if s.Format == "all" {
+		return []string{"txt", "epub"}
+	}
+	return []string{s.Format}
+}
+
+// scrapeBook reads the details of a book off its book page, once for every
+// format it is downloaded in.
+func (s *Smashwords) scrapeBook(e *colly.HTMLElement) []*source.Book {
+	info := source.Book{
+		BookID:    BookID(e.Request.URL.Path),
+		Title:     e.ChildText("h1"),
+		Author:    e.ChildText("[itemprop=author]"),
+		URL:       e.Request.URL.String(),
+		Price:     e.ChildAttr("[itemprop=price]", "content"),
+		License:   strings.TrimSpace(e.ChildText("#licenseNotes")),
+		Published: e.ChildAttr("[itemprop=datePublished]", "content"),
+	}
+	if info.Price == "" {
+		info.Price = e.ChildText("[itemprop=price]")
+	}
+	if info.Published == "" {
+		info.Published = e.ChildText("[itemprop=datePublished]")
+	}
+	seen := map[string]bool{}
+	e.ForEach("a[href*='/books/category/']", func(_ int, e *colly.HTMLElement) {
+		category := strings.TrimSpace(e.Text)
+		if category != "" && !seen[category] {
+			seen[category] = true
+			info.Categories = append(info.Categories, category)
+		}
+	})
+
+	// a book saved in one format isn't downloaded in the other, under its
+	// name from before there were ids neither
+	var aliases []string
+	for _, format := range []string{"epub", "txt"} {
+		if info.BookID != "" {
+			aliases = append(aliases, DocumentID(info.BookID, format))
+		}
+	}
+	if name := legacyName(info.Title); name != "" {
+		aliases = append(aliases, name)
+	}
+
+	var books []*source.Book
+	for _, format := range s.formats() {
+		e.ForEach(downloadLinks[format], func(_ int, e *colly.HTMLElement) {
+			book := info
+			book.Format = format
+			book.DownloadURL = fmt.Sprintf("https://%s%s", Host, e.Attr("href"))
+			if book.BookID == "" {
+				book.BookID = BookID(e.Attr("href"))
+			}
+			// books are named by their stable id, books without one by
+			// their title
+			if book.BookID != "" {
+				book.ID = DocumentID(book.BookID, format)
+			} else {
+				book.ID = legacyName(book.Title)
+			}
+			book.Aliases = aliases
+			books = append(books, &book)
+		})
+	}
+	return books
+}
+
+// Discover crawls the pages of the category, as many at once as ctx says
+// (see source.ConcurrencyOf), and the book pages listed on each of them.
+func (s *Smashwords) Discover(ctx context.Context, found func(*source.Book) error) error {
+	slog.Info("Scraping Smashwords", "category_id", s.CategoryID, "pages", s.Pages,
+		"page_items", s.ItemsPerPage, "books", s.ItemsPerPage*s.Pages, "format", s.Format)
+	sort := s.Sort
+	if sort == "" {
+		sort = SortDownloads
+	}
+	progress := source.ProgressOf(ctx)
+	progress.PagesToList(s.Pages)
+
+	// the first error of a page stops the others, through pageCtx
+	g, pageCtx := errgroup.WithContext(ctx)
+	g.SetLimit(source.ConcurrencyOf(ctx))
+	// Each list page only shows `bookListSize` books so scrape each one in parallel
+	for i := 0; i < s.ItemsPerPage*s.Pages && pageCtx.Err() == nil; i = i + s.ItemsPerPage {
+		pageId := i
+		source.Go(g, func() error {
+			return s.listPage(pageCtx, sort, pageId, found)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// listPage crawls a page that lists the books of the category, and the book
+// pages listed on it. It returns the first error of found, or the error of
+// ctx once it is done.
+func (s *Smashwords) listPage(ctx context.Context, sort string, pageId int, found func(*source.Book) error) error {
+	// the first error of found or of a throttled request, which the
+	// callbacks of both collectors set
+	var mu sync.Mutex
+	var foundErr error
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if foundErr == nil {
+			foundErr = err
+		}
+	}
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return foundErr
+	}
+	stopped := func() bool {
+		return failed() != nil || ctx.Err() != nil
+	}
+	progress := source.ProgressOf(ctx)
+
+	// Create a collector for the page that lists all books
+	listCollector := s.collector(ctx)
+
+	// Create another collector to scrape the book pages
+	bookCollector := listCollector.Clone()
+	if sort == SortNewest {
+		listCollector.CacheDir = ""
+	}
+
+	// Before making a request print "Visiting ..."
+	listCollector.OnRequest(func(r *colly.Request) {
+		slog.Debug("Getting book links", "url", r.URL.String(), "phase", "list")
+	})
+
+	// throttled pages stop the crawl like throttled downloads
+	throttled := func(r *colly.Response) {
+		if r.Headers != nil {
+			fail(source.Throttled(r.Request.URL.String(), r.StatusCode, *r.Headers))
+		}
+	}
+	listCollector.OnError(func(r *colly.Response, err error) {
+		slog.Warn("Request failed", "url", r.Request.URL.String(), "phase", "list", "status", r.StatusCode, "error", err)
+		throttled(r)
+	})
+	bookCollector.OnError(func(r *colly.Response, err error) {
+		throttled(r)
+	})
+
+	listCollector.OnResponse(func(r *colly.Response) {
+		progress.PageListed()
+	})
+
+	// Send all the individual book links through the book collector
+	listCollector.OnHTML("a[class=library-title]", func(e *colly.HTMLElement) {
+		if !stopped() {
+			bookCollector.Visit(e.Attr("href"))
+		}
+	})
+
+	// Pass on the book in every format it can be downloaded in
+	bookCollector.OnHTML("div[id=pageContentFull]", func(e *colly.HTMLElement) {
+		for _, book := range s.scrapeBook(e) {
+			if stopped() {
+				return
+			}
+			fail(found(book))
+		}
+	})
+
+	smashwordsCategoryURL := fmt.Sprintf("https://%s/books/category/%d/%s/0/free/any/%d", Host, s.CategoryID, sort, pageId)
+	listCollector.Visit(smashwordsCategoryURL)
+	if err := failed(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// Fetch downloads a book. Once Smashwords throttles the user it answers
+// with a 429, or serves a page saying so, or nothing, instead of epubs and
+// txt files, which is ErrRateLimited. The quota its responses say is left
+// goes to the Progress of ctx. The book is passed on as it downloads, with
+// only its start read ahead to tell it from a page.
+func (s *Smashwords) Fetch(ctx context.Context, book *source.Book) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, book.DownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{
+		Transport: s.transport(),
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			r.URL.Opaque = r.URL.Path
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	source.ReportQuota(ctx, resp.Header)
+	if err := source.Throttled(book.DownloadURL, resp.StatusCode, resp.Header); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("getting %s: %s", book.DownloadURL, resp.Status)
+	}
+	body := bufio.NewReaderSize(resp.Body, sniffSize)
+	start, err := body.Peek(sniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(start) == 0 {
+		resp.Body.Close()
+		return nil, &source.RateLimitError{URL: book.DownloadURL}
+	}
+	if isPage(resp.Header, start) {
+		defer resp.Body.Close()
+		page, err := io.ReadAll(io.LimitReader(body, maxPageSize))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(page, []byte(ThrottleMessage)) {
+			return nil, &source.RateLimitError{URL: book.DownloadURL}
+		}
+		return nil, fmt.Errorf("getting %s: a page instead of the %s", book.DownloadURL, book.Format)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{body, resp.Body}, nil
+}
+
+const (
+	// how much of a download is read ahead to tell a book from a page
+	sniffSize = 512
+	// how much of a page served instead of a book is read for the
+	// throttling message
+	maxPageSize = 1 << 20
+)
+
+// isPage tells whether a download that starts with start is an html page
+// rather than a book, by its Content-Type or, since Smashwords doesn't
+// always set one, its first bytes.
+func isPage(header http.Header, start []byte) bool {
+	if strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(start), "text/html")
+}
@@ -0,0 +1,64 @@
+package smashwords
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coreweave/dataset-downloader/pkg/source"
+)
+
+func TestFetchThrottled(t *testing.T) {
+	page := "<!DOCTYPE html><html><body><p>" + ThrottleMessage + " try again tomorrow.</p></body></html>"
+	tests := []struct {
+		name      string
+		format    string
+		body      string
+		throttled bool
+		fails     bool
+	}{
+		{"epub", "epub", "PK\x03\x04mimetypeapplication/epub+zip", false, false},
+		{"throttled epub", "epub", page, true, true},
+		{"empty epub", "epub", "", true, true},
+		{"other page instead of an epub", "epub", "<html><body>Not found</body></html>", false, true},
+		{"txt", "txt", "Chapter 1\n\nIt was a dark and stormy night.\n", false, false},
+		{"throttled txt", "txt", page, true, true},
+		{"empty txt", "txt", "", true, true},
+		{"other page instead of a txt", "txt", "<html><body>Not found</body></html>", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+			s := &Smashwords{RateLimiter: source.NoLimit}
+			book := &source.Book{DownloadURL: server.URL + "/books/download/1/" + tt.format, Format: tt.format}
+
+			r, err := s.Fetch(context.Background(), book)
+			if tt.throttled != errors.Is(err, source.ErrRateLimited) {
+				t.Fatalf("got %v, want throttled %v", err, tt.throttled)
+			}
+			if tt.fails {
+				if err == nil {
+					t.Fatal("got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.body {
+				t.Errorf("got %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
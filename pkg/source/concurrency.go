@@ -0,0 +1,44 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// DefaultConcurrency is how many pages a source lists at once unless its
+// context says otherwise.
+const DefaultConcurrency = 4
+
+type concurrencyKey struct{}
+
+// WithConcurrency returns a context that tells Discover to list up to n
+// pages at once.
+func WithConcurrency(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, concurrencyKey{}, n)
+}
+
+// ConcurrencyOf returns how many pages Discover lists at once with ctx,
+// DefaultConcurrency if it doesn't say.
+func ConcurrencyOf(ctx context.Context) int {
+	if n, ok := ctx.Value(concurrencyKey{}).(int); ok && n > 0 {
+		return n
+	}
+	return DefaultConcurrency
+}
+
+// Go runs f in g like g.Go, with a panic of f returned as its error, so the
+// group stops the rest of its work and Wait returns the panic instead of it
+// taking down the run.
+func Go(g *errgroup.Group, f func() error) {
+	g.Go(func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+			}
+		}()
+		return f()
+	})
+}
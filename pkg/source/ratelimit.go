@@ -0,0 +1,123 @@
+package source
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter paces the requests of a source: the pages it crawls and the
+// books it downloads. Policies of its own, like one that keeps to a daily
+// quota or only crawls at night, implement it in place of a TokenBucket.
+type RateLimiter interface {
+	// Wait blocks until req may be made, or returns the error of ctx if it
+	// is done first. It may be called from more than one goroutine at once.
+	Wait(ctx context.Context, req *http.Request) error
+}
+
+// NoLimit is a RateLimiter that never waits.
+var NoLimit RateLimiter = noLimit{}
+
+type noLimit struct{}
+
+func (noLimit) Wait(ctx context.Context, req *http.Request) error {
+	return nil
+}
+
+// TokenBucket is a RateLimiter that lets through requests at a rate, and
+// bursts of up to a number of them after a quiet while.
+type TokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a TokenBucket of rate requests a second, which
+// starts full with burst requests.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (b *TokenBucket) Wait(ctx context.Context, req *http.Request) error {
+	b.mu.Lock()
+	now := time.Now()
+	if !b.last.IsZero() {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+	// the token is taken now, so the requests waiting line up behind each
+	// other
+	b.tokens--
+	wait := time.Duration(0)
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// the request isn't made, so its token goes back
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// LimitTransport returns a transport that waits for limiter before every
+// request it makes through base, http.DefaultTransport if nil.
+func LimitTransport(limiter RateLimiter, base http.RoundTripper) http.RoundTripper {
+	return &limitedTransport{limiter: limiter, base: base}
+}
+
+type limitedTransport struct {
+	limiter RateLimiter
+	base    http.RoundTripper
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.limiter.Wait(req.Context(), req); err != nil {
+		return nil, err
+	}
+	base := t.base
+	if base == nil {
+		// looked up now, so the transports the logging and metrics wrap it
+		// in are used
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// ContextTransport returns a transport that makes the requests through base,
+// http.DefaultTransport if nil, with ctx, so they stop once it is done. It is
+// for clients that make their requests without a context, like colly.
+func ContextTransport(ctx context.Context, base http.RoundTripper) http.RoundTripper {
+	return &contextTransport{ctx: ctx, base: base}
+}
+
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req.WithContext(t.ctx))
+}
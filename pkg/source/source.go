@@ -0,0 +1,131 @@
+// Package source finds and downloads the books of the sites the dataset is
+// built from. New sites implement Source in a package of their own and
+// Register it, without changes to the download, the conversion or the
+// manifest.
+package source
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// ErrRateLimited is returned by Fetch when the site stops serving books for
+// a while. The run stops, since every book after would fail too.
+var ErrRateLimited = errors.New("rate limited")
+
+// Book is a book a Source found, and what its site says about it.
+type Book struct {
+	// stable id of the document, unique across sites, like
+	// smashwords-123456-epub. The files of the book are named after it
+	ID string
+	// other names the book may be saved under in a data directory, without
+	// their extension: its ids in other formats, or names from before there
+	// were ids. A book saved under any of them isn't downloaded again
+	Aliases []string
+	// format Fetch downloads the book in, epub or txt
+	Format string
+	// where Fetch downloads the book from
+	DownloadURL string
+
+	// id of the book on its site
+	BookID string
+	Title  string
+	Author string
+	// url of the book page
+	URL string
+	// the categories the book is listed in, broadest first
+	Categories []string
+	Price      string
+	// the license notes of the book
+	License string
+	// publication date, YYYY-MM-DD when the site gives one
+	Published string
+}
+
+// Source is a site books are downloaded from.
+type Source interface {
+	// Discover finds the books to download, passing every one to found as
+	// it goes, until there are no more, ctx is done or found returns an
+	// error, which Discover returns. found may be called from more than one
+	// goroutine at once.
+	Discover(ctx context.Context, found func(*Book) error) error
+	// Fetch downloads a book Discover found. The caller closes the reader.
+	Fetch(ctx context.Context, book *Book) (io.ReadCloser, error)
+}
+
+// Progress is told how far Discover is through the pages that list the
+// books of a source, for progress reports.
+type Progress interface {
+	// PagesToList is called with the number of pages Discover lists, once
+	// it knows
+	PagesToList(n int)
+	// PageListed is called once Discover has read a page
+	PageListed()
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context that Discover reports its progress to.
+func WithProgress(ctx context.Context, progress Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// noProgress is the Progress of a context without one.
+type noProgress struct{}
+
+func (noProgress) PagesToList(n int) {}
+func (noProgress) PageListed()       {}
+
+// ProgressOf returns the Progress of a context, which does nothing if it
+// has none.
+func ProgressOf(ctx context.Context) Progress {
+	if progress, ok := ctx.Value(progressKey{}).(Progress); ok {
+		return progress
+	}
+	return noProgress{}
+}
+
+// Factory adds the flags of a source to a flag set, and returns what makes
+// the source once the flags are parsed.
+type Factory func(flags *flag.FlagSet) func() (Source, error)
+
+var (
+	mu        sync.Mutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a source available by name, usually from the init function
+// of its package. Registering a name twice panics.
+func Register(name string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("source %s registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// Lookup returns the factory of a registered source.
+func Lookup(name string) (Factory, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	factory, ok := factories[name]
+	return factory, ok
+}
+
+// Names returns the names of the registered sources, sorted.
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,113 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitError is an ErrRateLimited with how long the site asked to be
+// left alone for, when it said.
+type RateLimitError struct {
+	URL string
+	// the Retry-After of the response, 0 if it had none
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("getting %s: %s, retry after %s", e.URL, ErrRateLimited, e.RetryAfter)
+	}
+	return fmt.Sprintf("getting %s: %s", e.URL, ErrRateLimited)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// Throttled returns a *RateLimitError if a response from url, with its
+// status and header, says the site is throttling the requests: a 429 Too
+// Many Requests, or a 503 Service Unavailable with a Retry-After. It
+// returns nil otherwise.
+func Throttled(url string, status int, header http.Header) error {
+	retryAfter := header.Get("Retry-After")
+	if status != http.StatusTooManyRequests && (status != http.StatusServiceUnavailable || retryAfter == "") {
+		return nil
+	}
+	return &RateLimitError{URL: url, RetryAfter: ParseRetryAfter(retryAfter, time.Now())}
+}
+
+// ParseRetryAfter parses a Retry-After header, a number of seconds or an
+// HTTP date, into how long to wait from now. It is 0 if the header is empty,
+// malformed or in the past.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(0, time.Duration(seconds)*time.Second)
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(0, t.Sub(now).Round(time.Second))
+	}
+	return 0
+}
+
+// Quota is what a site says is left of the requests it allows, in the
+// RateLimit-* or X-RateLimit-* headers of its responses.
+type Quota struct {
+	// requests left until Reset
+	Remaining int
+	// requests allowed in a window, 0 if the site doesn't say
+	Limit int
+	// when the quota fills up again, zero if the site doesn't say
+	Reset time.Time
+}
+
+// QuotaOf reads the quota a response says is left from its header, and
+// reports whether it says.
+func QuotaOf(header http.Header, now time.Time) (Quota, bool) {
+	field := func(name string) string {
+		if value := header.Get("RateLimit-" + name); value != "" {
+			return value
+		}
+		return header.Get("X-RateLimit-" + name)
+	}
+	remaining, err := strconv.Atoi(field("Remaining"))
+	if err != nil {
+		return Quota{}, false
+	}
+	quota := Quota{Remaining: max(0, remaining)}
+	quota.Limit, _ = strconv.Atoi(field("Limit"))
+	if reset, err := strconv.ParseInt(field("Reset"), 10, 64); err == nil {
+		// seconds from now in the standard header, and a unix time in most
+		// of the X- ones
+		if reset > 1e9 {
+			quota.Reset = time.Unix(reset, 0)
+		} else {
+			quota.Reset = now.Add(time.Duration(reset) * time.Second)
+		}
+	}
+	return quota, true
+}
+
+// QuotaProgress is a Progress that also shows the quota a site says is
+// left, which ReportQuota tells it about.
+type QuotaProgress interface {
+	Progress
+	QuotaLeft(quota Quota)
+}
+
+// ReportQuota tells the Progress of ctx the quota a response from the site
+// says is left, if it says and the Progress is a QuotaProgress.
+func ReportQuota(ctx context.Context, header http.Header) {
+	progress, ok := ProgressOf(ctx).(QuotaProgress)
+	if !ok {
+		return
+	}
+	if quota, ok := QuotaOf(header, time.Now()); ok {
+		progress.QuotaLeft(quota)
+	}
+}
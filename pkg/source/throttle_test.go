@@ -0,0 +1,113 @@
+package source
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestThrottled(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		throttled  bool
+		wait       time.Duration
+	}{
+		{"too many requests", http.StatusTooManyRequests, "", true, 0},
+		{"too many requests with a retry after", http.StatusTooManyRequests, "120", true, 2 * time.Minute},
+		{"unavailable with a retry after", http.StatusServiceUnavailable, "30", true, 30 * time.Second},
+		{"unavailable without one", http.StatusServiceUnavailable, "", false, 0},
+		{"not found", http.StatusNotFound, "30", false, 0},
+		{"ok", http.StatusOK, "", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.retryAfter != "" {
+				header.Set("Retry-After", tt.retryAfter)
+			}
+			err := Throttled("https://example.com/book", tt.status, header)
+			if !tt.throttled {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			var rateLimit *RateLimitError
+			if !errors.As(err, &rateLimit) || !errors.Is(err, ErrRateLimited) {
+				t.Fatalf("got %v, want a RateLimitError", err)
+			}
+			if rateLimit.RetryAfter != tt.wait || rateLimit.URL != "https://example.com/book" {
+				t.Errorf("got %+v, want a retry after %s", rateLimit, tt.wait)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"90", 90 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{now.Add(10 * time.Minute).Format(http.TimeFormat), 10 * time.Minute},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		if got := ParseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("ParseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestQuotaOf(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header map[string]string
+		want   Quota
+		ok     bool
+	}{
+		{
+			name:   "standard headers with a delta reset",
+			header: map[string]string{"RateLimit-Remaining": "40", "RateLimit-Limit": "100", "RateLimit-Reset": "60"},
+			want:   Quota{Remaining: 40, Limit: 100, Reset: now.Add(time.Minute)},
+			ok:     true,
+		},
+		{
+			name:   "X- headers with a unix reset",
+			header: map[string]string{"X-RateLimit-Remaining": "3", "X-RateLimit-Reset": "1709298000"},
+			want:   Quota{Remaining: 3, Reset: time.Unix(1709298000, 0)},
+			ok:     true,
+		},
+		{
+			name:   "a negative remaining is none left",
+			header: map[string]string{"X-RateLimit-Remaining": "-1"},
+			want:   Quota{},
+			ok:     true,
+		},
+		{
+			name:   "no quota",
+			header: map[string]string{"X-RateLimit-Limit": "100"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range tt.header {
+				header.Set(key, value)
+			}
+			got, ok := QuotaOf(header, now)
+			if ok != tt.ok || got.Remaining != tt.want.Remaining || got.Limit != tt.want.Limit || !got.Reset.Equal(tt.want.Reset) {
+				t.Errorf("got %+v, %v, want %+v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Archive writes files into a gzipped tarball or a zip archive that is
+// streamed to an io.Writer. Archives are written front to back, so files
+// can't be read back or renamed once written.
+type Archive struct {
+	// ModTime is the modification time of the files created next. It
+	// defaults to the earliest time a zip file can hold, so the same files
+	// give the same archive.
+	ModTime time.Time
+
+	mu    sync.Mutex
+	gw    *gzip.Writer
+	tw    *tar.Writer
+	zw    *zip.Writer
+	names map[string]bool
+}
+
+// errArchive is returned for reading or renaming files in an archive.
+var errArchive = errors.New("files in an archive can't be read or renamed")
+
+// NewTarGz starts a gzipped tarball on w.
+func NewTarGz(w io.Writer) *Archive {
+	gw := gzip.NewWriter(w)
+	return &Archive{ModTime: zipEpoch, gw: gw, tw: tar.NewWriter(gw), names: map[string]bool{}}
+}
+
+// NewZip starts a zip archive on w.
+func NewZip(w io.Writer) *Archive {
+	return &Archive{ModTime: zipEpoch, zw: zip.NewWriter(w), names: map[string]bool{}}
+}
+
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Create buffers a file, which is added to the archive when closed. Tar
+// headers need the size of the file up front.
+func (a *Archive) Create(name string) (io.WriteCloser, error) {
+	return &archiveFile{archive: a, name: name, modTime: a.ModTime}, nil
+}
+
+func (a *Archive) Open(name string) (io.ReadCloser, error) {
+	if ok, _ := a.Exists(name); !ok {
+		return nil, os.ErrNotExist
+	}
+	return nil, errArchive
+}
+
+func (a *Archive) Exists(name string) (bool, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.names[name], nil
+}
+
+func (a *Archive) Rename(from string, to string) error {
+	return errArchive
+}
+
+// Location is the path of a file in the archive.
+func (a *Archive) Location(name string) string {
+	return name
+}
+
+// Close finishes the archive. It doesn't close the writer under it.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tw != nil {
+		if err := a.tw.Close(); err != nil {
+			return err
+		}
+		return a.gw.Close()
+	}
+	return a.zw.Close()
+}
+
+func (a *Archive) add(name string, data []byte, modTime time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tw != nil {
+		header := &tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: modTime,
+			Format:  tar.FormatPAX,
+		}
+		if err := a.tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := a.tw.Write(data); err != nil {
+			return err
+		}
+	} else {
+		f, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			return err
+		}
+	}
+	a.names[name] = true
+	return nil
+}
+
+// archiveFile is a file that is added to its archive when closed.
+type archiveFile struct {
+	bytes.Buffer
+	archive *Archive
+	name    string
+	modTime time.Time
+}
+
+func (f *archiveFile) Close() error {
+	return f.archive.add(f.name, f.Bytes(), f.modTime)
+}
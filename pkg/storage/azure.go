@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+const (
+	azureScheme string = "az://"
+
+	// size of the blocks files are uploaded in, since their size isn't
+	// known up front
+	azureBlockSize int64 = 8 << 20
+)
+
+// Azure is a prefix in an Azure Blob Storage container.
+type Azure struct {
+	client    *azblob.Client
+	container string
+	prefix    string
+}
+
+// NewAzure connects to the container of an az://container/prefix
+// URL. The account and its credentials come from
+// $AZURE_STORAGE_CONNECTION_STRING, which also works for the Azurite
+// emulator, or $AZURE_STORAGE_ACCOUNT with $AZURE_STORAGE_KEY or
+// $AZURE_STORAGE_SAS_TOKEN.
+func NewAzure(location string) (*Azure, error) {
+	container, prefix, _ := strings.Cut(strings.TrimPrefix(location, azureScheme), "/")
+	if container == "" {
+		return nil, fmt.Errorf("no container in %s", location)
+	}
+
+	var client *azblob.Client
+	var err error
+	account := os.Getenv("AZURE_STORAGE_ACCOUNT")
+	serviceURL := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+	switch {
+	case os.Getenv("AZURE_STORAGE_CONNECTION_STRING") != "":
+		client, err = azblob.NewClientFromConnectionString(os.Getenv("AZURE_STORAGE_CONNECTION_STRING"), nil)
+	case account != "" && os.Getenv("AZURE_STORAGE_KEY") != "":
+		var cred *azblob.SharedKeyCredential
+		cred, err = azblob.NewSharedKeyCredential(account, os.Getenv("AZURE_STORAGE_KEY"))
+		if err == nil {
+			client, err = azblob.NewClientWithSharedKeyCredential(serviceURL, cred, nil)
+		}
+	case account != "" && os.Getenv("AZURE_STORAGE_SAS_TOKEN") != "":
+		sas := strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?")
+		client, err = azblob.NewClientWithNoCredential(serviceURL+"?"+sas, nil)
+	default:
+		return nil, fmt.Errorf("set AZURE_STORAGE_CONNECTION_STRING, or AZURE_STORAGE_ACCOUNT with" +
+			" AZURE_STORAGE_KEY or AZURE_STORAGE_SAS_TOKEN to write to Azure")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Azure{client: client, container: container, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+func (s *Azure) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func (s *Azure) blobClient(name string) *blob.Client {
+	return s.client.ServiceClient().NewContainerClient(s.container).NewBlobClient(s.key(name))
+}
+
+// Create streams a file to the container in blocks. The blob only shows up
+// once the list of blocks is committed at the end.
+func (s *Azure) Create(name string) (io.WriteCloser, error) {
+	return pipeUpload(func(r io.Reader) error {
+		_, err := s.client.UploadStream(context.Background(), s.container, s.key(name), r,
+			&azblob.UploadStreamOptions{BlockSize: azureBlockSize})
+		return err
+	}), nil
+}
+
+func (s *Azure) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.client.DownloadStream(context.Background(), s.container, s.key(name), nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *Azure) Exists(name string) (bool, error) {
+	_, err := s.blobClient(name).GetProperties(context.Background(), nil)
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Rename copies the blob to its new name, waiting for the copy to finish,
+// and deletes the old one.
+func (s *Azure) Rename(from string, to string) error {
+	ctx := context.Background()
+	dest := s.blobClient(to)
+	resp, err := dest.StartCopyFromURL(ctx, s.blobClient(from).URL(), nil)
+	if err != nil {
+		return err
+	}
+	status := resp.CopyStatus
+	for status != nil && *status == blob.CopyStatusTypePending {
+		time.Sleep(time.Second)
+		props, err := dest.GetProperties(ctx, nil)
+		if err != nil {
+			return err
+		}
+		status = props.CopyStatus
+	}
+	if status != nil && *status != blob.CopyStatusTypeSuccess {
+		return fmt.Errorf("copying %s to %s: %s", from, to, *status)
+	}
+	_, err = s.blobClient(from).Delete(ctx, nil)
+	return err
+}
+
+func (s *Azure) Location(name string) string {
+	return azureScheme + path.Join(s.container, s.key(name))
+}
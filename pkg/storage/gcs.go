@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	gcs "cloud.google.com/go/storage"
+)
+
+const (
+	gcsScheme string = "gs://"
+
+	// files are uploaded in chunks of this size, which are retried on
+	// their own
+	gcsChunkSize int = 16 << 20
+	// how long a call that doesn't stream a file may take, retries
+	// included
+	gcsTimeout = 5 * time.Minute
+)
+
+// GCS is a prefix in a Google Cloud Storage bucket.
+type GCS struct {
+	bucket *gcs.BucketHandle
+	name   string
+	prefix string
+}
+
+// NewGCS connects to the bucket of a gs://bucket/prefix URL with the
+// application default credentials: $GOOGLE_APPLICATION_CREDENTIALS, the
+// credentials of gcloud auth application-default login, or the service
+// account of the machine. With $STORAGE_EMULATOR_HOST set it talks to an
+// emulator without credentials instead.
+func NewGCS(location string) (*GCS, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, gcsScheme), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket in %s", location)
+	}
+	client, err := gcs.NewClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return newGCS(client, bucket, prefix), nil
+}
+
+func newGCS(client *gcs.Client, bucket string, prefix string) *GCS {
+	// the files of a run are only ever written whole, so writing one again
+	// is safe to retry too
+	handle := client.Bucket(bucket).Retryer(gcs.WithPolicy(gcs.RetryAlways))
+	return &GCS{bucket: handle, name: bucket, prefix: strings.Trim(prefix, "/")}
+}
+
+func (s *GCS) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func (s *GCS) object(name string) *gcs.ObjectHandle {
+	return s.bucket.Object(s.key(name))
+}
+
+// Create uploads a file in chunks with a resumable upload. Cloud Storage only
+// shows the object once the last chunk is uploaded.
+func (s *GCS) Create(name string) (io.WriteCloser, error) {
+	w := s.object(name).NewWriter(context.Background())
+	w.ChunkSize = gcsChunkSize
+	return w, nil
+}
+
+func (s *GCS) Open(name string) (io.ReadCloser, error) {
+	r, err := s.object(name).NewReader(context.Background())
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil, os.ErrNotExist
+	}
+	return r, err
+}
+
+func (s *GCS) Exists(name string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), gcsTimeout)
+	defer cancel()
+	_, err := s.object(name).Attrs(ctx)
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Rename copies the object to its new name and deletes the old one.
+func (s *GCS) Rename(from string, to string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), gcsTimeout)
+	defer cancel()
+	if _, err := s.object(to).CopierFrom(s.object(from)).Run(ctx); err != nil {
+		return err
+	}
+	return s.object(from).Delete(ctx)
+}
+
+func (s *GCS) Location(name string) string {
+	return gcsScheme + path.Join(s.name, s.key(name))
+}
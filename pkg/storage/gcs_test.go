@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+)
+
+func TestGCS(t *testing.T) {
+	server := fakestorage.NewServer(nil)
+	defer server.Stop()
+	server.CreateBucket("books")
+	s := newGCS(server.Client(), "books", "/runs/1/")
+
+	if got := s.Location("dataset.jsonl"); got != "gs://books/runs/1/dataset.jsonl" {
+		t.Errorf("the location is %s", got)
+	}
+	w, err := s.Create("dataset.jsonl.tmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "{\"text\":\"It rained.\"}\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rename("dataset.jsonl.tmp", "dataset.jsonl"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := s.Exists("dataset.jsonl.tmp"); exists || err != nil {
+		t.Errorf("the renamed file is still there: %v, %v", exists, err)
+	}
+	if exists, err := s.Exists("dataset.jsonl"); !exists || err != nil {
+		t.Errorf("the file isn't there after the rename: %v, %v", exists, err)
+	}
+	r, err := s.Open("dataset.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "{\"text\":\"It rained.\"}\n" {
+		t.Errorf("read back %q", data)
+	}
+	if _, err := s.Open("missing.jsonl"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening a missing file returned %v", err)
+	}
+}
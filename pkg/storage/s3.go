@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+const (
+	s3Scheme          string = "s3://"
+	defaultS3Endpoint string = "s3.amazonaws.com"
+
+	// size of the parts files are uploaded in, since their size isn't
+	// known up front
+	s3PartSize uint64 = 64 << 20
+)
+
+// S3 is a prefix in an S3 bucket, or a bucket of another S3
+// compatible service like MinIO.
+type S3 struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 connects to the bucket of an s3://bucket/prefix URL. The
+// endpoint is taken from $AWS_ENDPOINT_URL (e.g. http://localhost:9000 for
+// MinIO) and defaults to AWS. Credentials come from the environment
+// ($AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY), ~/.aws/credentials or
+// the IAM role of the machine.
+func NewS3(location string) (*S3, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, s3Scheme), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket in %s", location)
+	}
+
+	endpoint, secure := defaultS3Endpoint, true
+	if env := os.Getenv("AWS_ENDPOINT_URL"); env != "" {
+		u, err := url.Parse(env)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL %q", env)
+		}
+		endpoint, secure = u.Host, u.Scheme != "http"
+	}
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{},
+		}),
+		Secure: secure,
+		Region: os.Getenv("AWS_REGION"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &S3{client: client, bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+func (s *S3) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+// Create streams a file to the bucket in parts. S3 only shows the object
+// once the last part is uploaded.
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	return pipeUpload(func(r io.Reader) error {
+		_, err := s.client.PutObject(context.Background(), s.bucket, s.key(name), r, -1,
+			minio.PutObjectOptions{PartSize: s3PartSize})
+		return err
+	}), nil
+}
+
+func (s *S3) Open(name string) (io.ReadCloser, error) {
+	if ok, err := s.Exists(name); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, os.ErrNotExist
+	}
+	return s.client.GetObject(context.Background(), s.bucket, s.key(name), minio.GetObjectOptions{})
+}
+
+func (s *S3) Exists(name string) (bool, error) {
+	_, err := s.client.StatObject(context.Background(), s.bucket, s.key(name), minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Rename copies the object to its new key and removes the old one, S3 has
+// no renames.
+func (s *S3) Rename(from string, to string) error {
+	_, err := s.client.CopyObject(context.Background(),
+		minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(to)},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(from)})
+	if err != nil {
+		return err
+	}
+	return s.client.RemoveObject(context.Background(), s.bucket, s.key(from), minio.RemoveObjectOptions{})
+}
+
+func (s *S3) Location(name string) string {
+	return s3Scheme + path.Join(s.bucket, s.key(name))
+}
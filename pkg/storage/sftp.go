@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const sftpScheme string = "sftp://"
+
+// SFTP is a directory on a server reachable over SFTP, like a NAS or
+// a university file server.
+type SFTP struct {
+	client *sftp.Client
+	dir    string
+	host   string
+}
+
+// NewSFTP connects to the server of an sftp://user@host:port/path
+// URL. It authenticates with the password in the URL or $SFTP_PASSWORD, the
+// keys in the ssh agent, or the key file in $SFTP_KEY (~/.ssh/id_ed25519 or
+// ~/.ssh/id_rsa by default). The host key has to be in ~/.ssh/known_hosts,
+// or the file in $SSH_KNOWN_HOSTS.
+func NewSFTP(location string) (*SFTP, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" || u.User == nil {
+		return nil, fmt.Errorf("%s should be sftp://user@host/path", location)
+	}
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	home, _ := os.UserHomeDir()
+	knownHostsPath := os.Getenv("SSH_KNOWN_HOSTS")
+	if knownHostsPath == "" {
+		knownHostsPath = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts: %w", err)
+	}
+
+	var auth []ssh.AuthMethod
+	password, ok := u.User.Password()
+	if !ok {
+		password = os.Getenv("SFTP_PASSWORD")
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			// the agent only signs the authentication, which is over once
+			// the connection is up
+			defer conn.Close()
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	keyFiles := []string{filepath.Join(home, ".ssh", "id_ed25519"), filepath.Join(home, ".ssh", "id_rsa")}
+	if keyFile := os.Getenv("SFTP_KEY"); keyFile != "" {
+		keyFiles = []string{keyFile}
+	}
+	for _, keyFile := range keyFiles {
+		key, err := os.ReadFile(keyFile)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", keyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	conn, err := ssh.Dial("tcp", host, &ssh.ClientConfig{
+		User:            u.User.Username(),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	dir := u.Path
+	if dir == "" {
+		dir = "."
+	}
+	return &SFTP{client: client, dir: dir, host: u.Host}, nil
+}
+
+func (s *SFTP) path(name string) string {
+	return path.Join(s.dir, name)
+}
+
+// Create writes to a partial file on the server that is renamed to its
+// name when closed.
+func (s *SFTP) Create(name string) (io.WriteCloser, error) {
+	p := s.path(name)
+	if err := s.client.MkdirAll(path.Dir(p)); err != nil {
+		return nil, err
+	}
+	f, err := s.client.Create(p + ".part")
+	if err != nil {
+		return nil, err
+	}
+	return &sftpFile{File: f, storage: s, path: p}, nil
+}
+
+func (s *SFTP) Open(name string) (io.ReadCloser, error) {
+	return s.client.Open(s.path(name))
+}
+
+func (s *SFTP) Exists(name string) (bool, error) {
+	_, err := s.client.Stat(s.path(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SFTP) Rename(from string, to string) error {
+	if err := s.client.MkdirAll(path.Dir(s.path(to))); err != nil {
+		return err
+	}
+	return s.rename(s.path(from), s.path(to))
+}
+
+// rename replaces the file at to, like a local rename does. Servers without
+// the posix-rename extension only rename to names that are free.
+func (s *SFTP) rename(from string, to string) error {
+	if _, ok := s.client.HasExtension("posix-rename@openssh.com"); ok {
+		return s.client.PosixRename(from, to)
+	}
+	if err := s.client.Remove(to); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.client.Rename(from, to)
+}
+
+func (s *SFTP) Location(name string) string {
+	return sftpScheme + s.host + path.Join("/", s.path(name))
+}
+
+// sftpFile is a partial file that is renamed to its name when closed.
+type sftpFile struct {
+	*sftp.File
+	storage *SFTP
+	path    string
+}
+
+func (f *sftpFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return f.storage.rename(f.path+".part", f.path)
+}
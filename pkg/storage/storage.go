@@ -0,0 +1,194 @@
+// Package storage writes the files of a dataset to local disk, object
+// storage, file servers or archives. New targets implement Writer and are
+// added to Open, without changes to the crawl or the conversion.
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Writer is where the dataset is written: a local directory, a bucket, a
+// file server or an archive. Names are slash separated paths relative to
+// the root of the storage.
+type Writer interface {
+	// Create starts writing a file. The file only shows up under its name
+	// once the writer is closed, so readers never see half written files.
+	Create(name string) (io.WriteCloser, error)
+	// Open reads a file. The error is os.ErrNotExist if there is none.
+	Open(name string) (io.ReadCloser, error)
+	Exists(name string) (bool, error)
+	Rename(from string, to string) error
+	// Location is the path or URL of a file, for logs and the manifest.
+	Location(name string) string
+}
+
+// Open returns the storage at a location: an s3://, gs://, az://,
+// sftp://, dav:// or davs:// URL, or a local directory.
+func Open(location string) (Writer, error) {
+	switch {
+	case strings.HasPrefix(location, s3Scheme):
+		return NewS3(location)
+	case strings.HasPrefix(location, gcsScheme):
+		return NewGCS(location)
+	case strings.HasPrefix(location, azureScheme):
+		return NewAzure(location)
+	case strings.HasPrefix(location, sftpScheme):
+		return NewSFTP(location)
+	case strings.HasPrefix(location, webDAVScheme), strings.HasPrefix(location, webDAVSecureScheme):
+		return NewWebDAV(location)
+	}
+	if err := os.MkdirAll(location, 0700); err != nil {
+		return nil, err
+	}
+	return &Local{Dir: location}, nil
+}
+
+// IsURL tells whether a location is a URL of remote storage rather
+// than a local directory.
+func IsURL(location string) bool {
+	return strings.Contains(location, "://")
+}
+
+// Join appends a folder to a location.
+func Join(location string, elem string) string {
+	if elem == "" {
+		return location
+	}
+	if IsURL(location) {
+		return strings.TrimSuffix(location, "/") + "/" + elem
+	}
+	return filepath.Join(location, elem)
+}
+
+// Local is a directory on local disk.
+type Local struct {
+	Dir string
+}
+
+func (s *Local) Create(name string) (io.WriteCloser, error) {
+	path := s.Location(name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// written to a partial file first, so a crash never leaves a truncated
+	// file behind
+	f, err := os.Create(path + ".part")
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{File: f, path: path}, nil
+}
+
+func (s *Local) Open(name string) (io.ReadCloser, error) {
+	return os.Open(s.Location(name))
+}
+
+func (s *Local) Exists(name string) (bool, error) {
+	_, err := os.Stat(s.Location(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *Local) Rename(from string, to string) error {
+	if err := os.MkdirAll(filepath.Dir(s.Location(to)), 0700); err != nil {
+		return err
+	}
+	return os.Rename(s.Location(from), s.Location(to))
+}
+
+func (s *Local) Location(name string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(name))
+}
+
+// localFile is a partial file that is renamed to its name when closed.
+type localFile struct {
+	*os.File
+	path string
+}
+
+func (f *localFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.File.Name(), f.path)
+}
+
+// pipeUpload runs an upload that reads from a stream in the background, and
+// returns the writer feeding it. Closing the writer waits for the upload.
+func pipeUpload(upload func(r io.Reader) error) io.WriteCloser {
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := upload(r)
+		r.CloseWithError(err)
+		done <- err
+	}()
+	return &pipeUploadWriter{PipeWriter: w, done: done}
+}
+
+// pipeUploadWriter finishes a pipeUpload when closed.
+type pipeUploadWriter struct {
+	*io.PipeWriter
+	done chan error
+}
+
+func (w *pipeUploadWriter) Close() error {
+	w.PipeWriter.Close()
+	return <-w.done
+}
+
+// CopyTo copies a local file to a storage.
+func CopyTo(path string, store Writer, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := store.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// CopyFrom copies a file from a storage to a local file. The error is
+// os.ErrNotExist if the storage doesn't have it.
+func CopyFrom(store Writer, name string, path string) error {
+	r, err := store.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	local := &Local{Dir: filepath.Dir(path)}
+	w, err := local.Create(filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// checkResponse turns an unsuccessful response into an error with the
+// message the server sent along.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status,
+		strings.TrimSpace(string(message)))
+}
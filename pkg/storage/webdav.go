@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// WebDAV over http and https
+	webDAVScheme       string = "dav://"
+	webDAVSecureScheme string = "davs://"
+)
+
+// WebDAV is a folder on a WebDAV server, like a NAS or a Nextcloud
+// instance.
+type WebDAV struct {
+	client   *http.Client
+	base     *url.URL
+	user     string
+	password string
+	location string
+}
+
+// NewWebDAV connects to the folder of a davs://host/path (https) or
+// dav://host/path (http) URL. The user and password are taken from the URL,
+// or $WEBDAV_USER and $WEBDAV_PASSWORD.
+func NewWebDAV(location string) (*WebDAV, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	s := &WebDAV{
+		client:   http.DefaultClient,
+		user:     os.Getenv("WEBDAV_USER"),
+		password: os.Getenv("WEBDAV_PASSWORD"),
+	}
+	if u.User != nil {
+		s.user = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			s.password = password
+		}
+		u.User = nil
+	}
+	if strings.HasPrefix(location, webDAVSecureScheme) {
+		u.Scheme = "https"
+	} else {
+		u.Scheme = "http"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	// the location is kept without the password, it ends up in the manifest
+	s.location = strings.TrimSuffix(location[:strings.Index(location, "://")+3]+u.Host+u.Path, "/")
+
+	// the folder itself is created like a local data directory
+	s.base = &url.URL{Scheme: u.Scheme, Host: u.Host}
+	if err := s.mkdirAll(u.Path); err != nil {
+		return nil, err
+	}
+	s.base = u
+	return s, nil
+}
+
+// url is the http url of a file.
+func (s *WebDAV) url(name string) string {
+	u := *s.base
+	u.Path = path.Join(u.Path, name)
+	return u.String()
+}
+
+func (s *WebDAV) do(method string, name string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.user != "" {
+		req.SetBasicAuth(s.user, s.password)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	return s.client.Do(req)
+}
+
+// mkdirAll creates a folder and its parents, since WebDAV servers don't
+// create the folders of a file on their own.
+func (s *WebDAV) mkdirAll(dir string) error {
+	dirs := []string{}
+	for dir = path.Clean(dir); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		dirs = append(dirs, dir)
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		resp, err := s.do("MKCOL", dirs[i], nil, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		// 405 means the folder is there already
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			if err := checkResponse(resp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Create uploads a file to a partial file that is moved to its name when
+// the upload is complete.
+func (s *WebDAV) Create(name string) (io.WriteCloser, error) {
+	if err := s.mkdirAll(path.Dir(name)); err != nil {
+		return nil, err
+	}
+	return pipeUpload(func(r io.Reader) error {
+		resp, err := s.do("PUT", name+".part", r, nil)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if err := checkResponse(resp); err != nil {
+			return err
+		}
+		return s.move(name+".part", name)
+	}), nil
+}
+
+func (s *WebDAV) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *WebDAV) Exists(name string) (bool, error) {
+	resp, err := s.do("HEAD", name, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("HEAD %s: %s", s.url(name), resp.Status)
+	}
+	return true, nil
+}
+
+func (s *WebDAV) Rename(from string, to string) error {
+	if err := s.mkdirAll(path.Dir(to)); err != nil {
+		return err
+	}
+	return s.move(from, to)
+}
+
+func (s *WebDAV) move(from string, to string) error {
+	resp, err := s.do("MOVE", from, nil, map[string]string{"Destination": s.url(to), "Overwrite": "T"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+func (s *WebDAV) Location(name string) string {
+	return s.location + "/" + name
+}